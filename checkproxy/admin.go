@@ -0,0 +1,227 @@
+package checkproxy
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// adminRoutes registers the /v1/admin/ endpoints on mux. They're served only
+// when an admin token is configured and every request must present it.
+func adminRoutes(mux *http.ServeMux, cfg *Config, s *server) {
+	token := cfg.GetAdminToken()
+	if token == "" {
+		return
+	}
+	handle := func(pattern string, h http.HandlerFunc) {
+		mux.Handle(pattern, requireAdmin(token, h))
+	}
+
+	path := RevocationsPath()
+	handle("GET /v1/admin/revocations", func(w http.ResponseWriter, r *http.Request) {
+		list, err := loadRevocations(path)
+		if err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		if list == nil {
+			list = []revocation{}
+		}
+		writeJSON(w, http.StatusOK, list)
+	})
+	handle("POST /v1/admin/revocations", func(w http.ResponseWriter, r *http.Request) {
+		var req revocation
+		if err := json.NewDecoder(io.LimitReader(r.Body, 4<<10)).Decode(&req); err != nil {
+			http.Error(w, "bad request: invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		fp := strings.ToLower(req.Fingerprint)
+		if !fingerprintPattern.MatchString(fp) {
+			http.Error(w, "bad request: fingerprint must be the hex SHA-256 of the token", http.StatusBadRequest)
+			return
+		}
+		added, err := addRevocation(path, fp, req.Reason)
+		if err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		status := http.StatusOK
+		if added {
+			status = http.StatusCreated
+		}
+		writeJSON(w, status, map[string]string{"fingerprint": fp})
+	})
+	handle("DELETE /v1/admin/revocations/{fingerprint}", func(w http.ResponseWriter, r *http.Request) {
+		removed, err := removeRevocation(path, strings.ToLower(r.PathValue("fingerprint")))
+		if err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		if !removed {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	allowlistRoutes(handle, cfg, s)
+	handle("GET /v1/admin/stats", statsHandler(s))
+	handle("GET /v1/admin/traffic", trafficHandler(s.traffic))
+	handle("POST /v1/admin/cache/flush", func(w http.ResponseWriter, r *http.Request) {
+		var req cacheFlushRequest
+		// An empty body flushes everything.
+		if err := json.NewDecoder(io.LimitReader(r.Body, 4<<10)).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, "bad request: invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if err := req.validate(); err != nil {
+			http.Error(w, "bad request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		result := map[string]int{"flushed": s.validator.FlushCache(req.TokenFingerprint, req.Repo)}
+		// Responses are fetched with the server's tokens, not the client's,
+		// so flushing one token's results leaves them.
+		if c := s.responses; c != nil && req.TokenFingerprint == "" {
+			result["responses"] = c.flush(req.Repo)
+		}
+		writeJSON(w, http.StatusOK, result)
+	})
+}
+
+// cacheFlushRequest selects the cached validation results to drop, and
+// unless it names a token, the cached responses. Empty fields match
+// everything.
+type cacheFlushRequest struct {
+	TokenFingerprint string `json:"token_fingerprint,omitempty"`
+	Repo             string `json:"repo,omitempty"`
+}
+
+// fingerprintPrefixPattern accepts a whole fingerprint or a prefix as long as
+// the one the audit log records.
+var fingerprintPrefixPattern = regexp.MustCompile(`^[0-9a-fA-F]{16,64}$`)
+
+func (req cacheFlushRequest) validate() error {
+	if req.TokenFingerprint != "" && !fingerprintPrefixPattern.MatchString(req.TokenFingerprint) {
+		return errors.New("token_fingerprint must be at least 16 hex digits of the token's SHA-256")
+	}
+	if req.Repo != "" {
+		if owner, repo, ok := strings.Cut(req.Repo, "/"); !ok || owner == "" || repo == "" || strings.Contains(repo, "/") {
+			return errors.New("repo must be owner/repo")
+		}
+	}
+	return nil
+}
+
+// adminRequest sends an admin API request to the server running on this
+// host, decoding a JSON response into out when it's not nil. It returns the
+// response status, and an error for anything but 2xx.
+func adminRequest(cfg *Config, method, path string, body, out any) (int, error) {
+	adminToken := cfg.GetAdminToken()
+	if adminToken == "" {
+		return 0, errors.New("no admin token configured: set admin_token or GH_CHECKPROXY_ADMIN_TOKEN")
+	}
+	local, client, err := cfg.localServer(30 * time.Second)
+	if err != nil {
+		return 0, err
+	}
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, local+path, reqBody)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("contacting the local server: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return resp.StatusCode, fmt.Errorf("server returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, err
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// requireAdmin rejects requests that don't carry the admin bearer token.
+// Requests that change something must also come from no page or a page on
+// the proxy itself, and send their body as JSON, so a cross-site form can't
+// make them even if the browser holds credentials for the proxy.
+func requireAdmin(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r, token) {
+			http.Error(w, "unauthorized: admin token required", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			if !sameOrigin(r) {
+				http.Error(w, "forbidden: cross-origin admin request", http.StatusForbidden)
+				return
+			}
+			if r.Method == http.MethodPost && !isJSONRequest(r) {
+				http.Error(w, "unsupported media type: admin requests must be application/json", http.StatusUnsupportedMediaType)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isAdmin reports whether r carries token as a bearer token. An empty token
+// admits no one.
+func isAdmin(r *http.Request, token string) bool {
+	if token == "" {
+		return false
+	}
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// isDashboardAdmin is isAdmin that also takes token as a Basic auth password,
+// which is how a browser sends it. Browsers resend Basic credentials on
+// their own, so only the read-only dashboard accepts them.
+func isDashboardAdmin(r *http.Request, token string) bool {
+	if _, password, ok := r.BasicAuth(); ok {
+		return token != "" && subtle.ConstantTimeCompare([]byte(password), []byte(token)) == 1
+	}
+	return isAdmin(r, token)
+}
+
+// sameOrigin reports whether r has no Origin, as from the CLI, or one naming
+// the host it was sent to.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host)
+}
+
+// isJSONRequest reports whether r declares a JSON body.
+func isJSONRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
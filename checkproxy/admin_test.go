@@ -0,0 +1,69 @@
+package checkproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestAdminAuth checks that Basic auth only opens the read-only dashboard
+// and that admin changes can't be made by a cross-site form.
+func TestAdminAuth(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("GH_CHECKPROXY_ADMIN_TOKEN", "")
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-OAuth-Scopes", "repo")
+		w.Write([]byte(`{"login":"bot"}`))
+	}))
+	defer upstream.Close()
+	h, err := NewHandler(&Config{ClassicToken: "ghp_test", APIBaseURL: upstream.URL, AdminToken: "adm"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		basic       bool // send the token as a Basic auth password
+		contentType string
+		origin      string
+		status      int
+	}{
+		{name: "dashboard with Basic", method: "GET", target: "/admin", basic: true, status: http.StatusOK},
+		{name: "dashboard data with Basic", method: "GET", target: "/admin/data", basic: true, status: http.StatusOK},
+		{name: "dashboard with bearer", method: "GET", target: "/admin/data", status: http.StatusOK},
+		{name: "admin API with Basic", method: "GET", target: "/v1/admin/stats", basic: true, status: http.StatusUnauthorized},
+		{name: "mutation with Basic", method: "POST", target: "/v1/admin/cache/flush", basic: true, contentType: "application/json", status: http.StatusUnauthorized},
+		{name: "admin API with bearer", method: "GET", target: "/v1/admin/stats", status: http.StatusOK},
+		{name: "mutation", method: "POST", target: "/v1/admin/cache/flush", contentType: "application/json", status: http.StatusOK},
+		{name: "mutation from the proxy's own page", method: "POST", target: "/v1/admin/cache/flush", contentType: "application/json; charset=utf-8", origin: "http://example.com", status: http.StatusOK},
+		{name: "mutation as text/plain", method: "POST", target: "/v1/admin/cache/flush", contentType: "text/plain", status: http.StatusUnsupportedMediaType},
+		{name: "mutation without a content type", method: "POST", target: "/v1/admin/revocations", status: http.StatusUnsupportedMediaType},
+		{name: "mutation from another origin", method: "POST", target: "/v1/admin/cache/flush", contentType: "application/json", origin: "https://evil.example", status: http.StatusForbidden},
+		{name: "delete from another origin", method: "DELETE", target: "/v1/admin/revocations/" + strings.Repeat("a", 64), origin: "https://evil.example", status: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader("{}"))
+			if tt.basic {
+				r.SetBasicAuth("admin", "adm")
+			} else {
+				r.Header.Set("Authorization", "Bearer adm")
+			}
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d (%s)", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,322 @@
+package checkproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// AlertsConfig notifies the operator when the proxy's upstream or
+// validation error rate, or a token's remaining rate limit, crosses a
+// threshold. Each check is enabled by setting its threshold.
+type AlertsConfig struct {
+	// WebhookURL receives a JSON POST per alert; its "text" field suits
+	// Slack-compatible incoming webhooks.
+	WebhookURL string `json:"webhook_url,omitempty"`
+	// Command is run per alert, with the same JSON on stdin.
+	Command []string `json:"command,omitempty"`
+	// Window is the period error rates are measured over (default 5m).
+	Window string `json:"window,omitempty"`
+	// MinRequests is how many requests a window needs before its error rate
+	// counts (default 20).
+	MinRequests int `json:"min_requests,omitempty"`
+	// Upstream5xxRate is the share of requests to GitHub that failed (5xx,
+	// timeouts, connection errors) above which an alert fires, e.g. 0.1.
+	Upstream5xxRate float64 `json:"upstream_5xx_rate,omitempty"`
+	// ValidationErrorRate is the share of token validations GitHub couldn't
+	// answer above which an alert fires. Denials aren't errors.
+	ValidationErrorRate float64 `json:"validation_error_rate,omitempty"`
+	// RateLimitFloor fires an alert when one of the server's tokens has
+	// fewer requests left than this; 1 alerts on exhaustion only.
+	RateLimitFloor int `json:"rate_limit_floor,omitempty"`
+}
+
+const (
+	defaultAlertWindow      = 5 * time.Minute
+	defaultAlertMinRequests = 20
+	alertCheckInterval      = 15 * time.Second
+	alertDeliveryTimeout    = 30 * time.Second
+	// alertQueueSize bounds the alerts waiting to be delivered; more are
+	// logged and dropped.
+	alertQueueSize = 64
+)
+
+// outcomeCounter counts events and how many of them failed.
+type outcomeCounter struct {
+	total, failed atomic.Uint64
+}
+
+func (c *outcomeCounter) add(failed bool) {
+	if c == nil {
+		return
+	}
+	c.total.Add(1)
+	if failed {
+		c.failed.Add(1)
+	}
+}
+
+type alerter struct {
+	cfg         AlertsConfig
+	window      time.Duration
+	minRequests uint64
+	client      *http.Client
+	queue       chan alertEvent // delivered in order, off the check loop
+
+	upstream, validations *outcomeCounter
+
+	samples []alertSample // oldest first, spanning at least the window
+	firing  map[string]bool
+}
+
+// alertSample is the outcome counters at one time.
+type alertSample struct {
+	at                          time.Time
+	upstream, upstreamFailed    uint64
+	validations, validationErrs uint64
+}
+
+// alertEvent is the JSON sent to the webhook and the command.
+type alertEvent struct {
+	Text      string    `json:"text"`
+	Alert     string    `json:"alert"`
+	State     string    `json:"state"` // "firing" or "resolved"
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Host      string    `json:"host"`
+	Time      time.Time `json:"time"`
+}
+
+// newAlerter returns an alerter for cfg, or nil when cfg is nil.
+func newAlerter(cfg *AlertsConfig) (*alerter, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if cfg.WebhookURL == "" && len(cfg.Command) == 0 {
+		return nil, errors.New("set webhook_url, command, or both")
+	}
+	if cfg.WebhookURL != "" {
+		if u, err := url.Parse(cfg.WebhookURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf("webhook_url %q is not an http(s) URL", cfg.WebhookURL)
+		}
+	}
+	if cfg.Upstream5xxRate == 0 && cfg.ValidationErrorRate == 0 && cfg.RateLimitFloor == 0 {
+		return nil, errors.New("set at least one of upstream_5xx_rate, validation_error_rate, and rate_limit_floor")
+	}
+	for name, rate := range map[string]float64{"upstream_5xx_rate": cfg.Upstream5xxRate, "validation_error_rate": cfg.ValidationErrorRate} {
+		if rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("%s must be between 0 and 1", name)
+		}
+	}
+	if cfg.RateLimitFloor < 0 || cfg.MinRequests < 0 {
+		return nil, errors.New("rate_limit_floor and min_requests must not be negative")
+	}
+	a := &alerter{
+		cfg:         *cfg,
+		window:      defaultAlertWindow,
+		minRequests: defaultAlertMinRequests,
+		client:      &http.Client{Timeout: alertDeliveryTimeout},
+		queue:       make(chan alertEvent, alertQueueSize),
+		firing:      make(map[string]bool),
+	}
+	if cfg.Window != "" {
+		d, err := time.ParseDuration(cfg.Window)
+		if err != nil || d < alertCheckInterval {
+			return nil, fmt.Errorf("window %q is not a duration of at least %s", cfg.Window, alertCheckInterval)
+		}
+		a.window = d
+	}
+	if cfg.MinRequests > 0 {
+		a.minRequests = uint64(cfg.MinRequests)
+	}
+	return a, nil
+}
+
+// String lists the enabled checks for the startup summary.
+func (a *alerter) String() string {
+	var checks []string
+	if a.cfg.Upstream5xxRate > 0 {
+		checks = append(checks, fmt.Sprintf("upstream failures > %g%%", 100*a.cfg.Upstream5xxRate))
+	}
+	if a.cfg.ValidationErrorRate > 0 {
+		checks = append(checks, fmt.Sprintf("validation errors > %g%%", 100*a.cfg.ValidationErrorRate))
+	}
+	if a.cfg.RateLimitFloor > 0 {
+		checks = append(checks, fmt.Sprintf("rate limit < %d left", a.cfg.RateLimitFloor))
+	}
+	return strings.Join(checks, ", ") + " (over " + a.window.String() + ")"
+}
+
+// run checks the thresholds every alertCheckInterval until done is closed,
+// with the error rates taken from the upstream and validation outcomes.
+// Alerts are delivered by another goroutine, so a slow webhook or command
+// never delays a check.
+func (a *alerter) run(done <-chan struct{}, tokens *classicTokenMonitor, upstream, validations *outcomeCounter) {
+	a.upstream, a.validations = upstream, validations
+	go func() {
+		for {
+			select {
+			case ev := <-a.queue:
+				a.deliver(ev)
+			case <-done:
+				return
+			}
+		}
+	}()
+	a.samples = []alertSample{a.sample()}
+	for range ticks(done, alertCheckInterval) {
+		a.check(tokens)
+	}
+}
+
+func (a *alerter) sample() alertSample {
+	return alertSample{
+		at:             time.Now(),
+		upstream:       a.upstream.total.Load(),
+		upstreamFailed: a.upstream.failed.Load(),
+		validations:    a.validations.total.Load(),
+		validationErrs: a.validations.failed.Load(),
+	}
+}
+
+func (a *alerter) check(tokens *classicTokenMonitor) {
+	now := a.sample()
+	a.samples = append(a.samples, now)
+	// Keep the newest sample at least a window old as the baseline.
+	for len(a.samples) > 2 && now.at.Sub(a.samples[1].at) >= a.window {
+		a.samples = a.samples[1:]
+	}
+	base := a.samples[0]
+	window := now.at.Sub(base.at).Round(time.Second)
+
+	if a.cfg.Upstream5xxRate > 0 {
+		total, failed := now.upstream-base.upstream, now.upstreamFailed-base.upstreamFailed
+		rate := a.rate(total, failed)
+		a.update("upstream_5xx_rate", rate > a.cfg.Upstream5xxRate, rate, a.cfg.Upstream5xxRate,
+			fmt.Sprintf("%.1f%% of %d requests to GitHub failed in the last %s (threshold %.1f%%)",
+				100*rate, total, window, 100*a.cfg.Upstream5xxRate))
+	}
+	if a.cfg.ValidationErrorRate > 0 {
+		total, failed := now.validations-base.validations, now.validationErrs-base.validationErrs
+		rate := a.rate(total, failed)
+		a.update("validation_error_rate", rate > a.cfg.ValidationErrorRate, rate, a.cfg.ValidationErrorRate,
+			fmt.Sprintf("%.1f%% of %d token validations failed in the last %s (threshold %.1f%%)",
+				100*rate, total, window, 100*a.cfg.ValidationErrorRate))
+	}
+	if a.cfg.RateLimitFloor > 0 {
+		seen := make(map[string]bool)
+		for _, l := range currentRateLimits(tokens) {
+			name := "rate_limit:" + l.token + ":" + l.resource
+			seen[name] = true
+			a.update(name, l.status.Remaining < a.cfg.RateLimitFloor, float64(l.status.Remaining), float64(a.cfg.RateLimitFloor),
+				fmt.Sprintf("token %s (%s) has %d of %d requests left (threshold %d), resets %s",
+					l.token, l.resource, l.status.Remaining, l.status.Limit, a.cfg.RateLimitFloor, l.status.Reset.Local().Format("15:04")))
+		}
+		// A window that reset has no series until GitHub reports the new
+		// one, which starts full.
+		for name := range a.firing {
+			if strings.HasPrefix(name, "rate_limit:") && !seen[name] {
+				a.update(name, false, 0, float64(a.cfg.RateLimitFloor), "the rate limit window reset")
+			}
+		}
+	}
+}
+
+// rate is failed/total, or 0 while total is below the minimum.
+func (a *alerter) rate(total, failed uint64) float64 {
+	if total < a.minRequests {
+		return 0
+	}
+	return float64(failed) / float64(total)
+}
+
+// update notifies when the alert called name starts or stops firing.
+func (a *alerter) update(name string, firing bool, value, threshold float64, detail string) {
+	if firing == a.firing[name] {
+		return
+	}
+	if firing {
+		a.firing[name] = true
+	} else {
+		delete(a.firing, name)
+	}
+	host, _ := os.Hostname()
+	ev := alertEvent{Alert: name, State: "firing", Value: value, Threshold: threshold, Host: host, Time: time.Now().UTC()}
+	if !firing {
+		ev.State = "resolved"
+	}
+	ev.Text = fmt.Sprintf("gh-checkproxy on %s: %s %s: %s", host, name, ev.State, detail)
+	if firing {
+		slog.Warn("alert firing", "alert", name, "detail", detail)
+	} else {
+		slog.Info("alert resolved", "alert", name, "detail", detail)
+	}
+	select {
+	case a.queue <- ev:
+	default:
+		slog.Warn("alert not delivered: too many alerts are waiting", "alert", name)
+	}
+}
+
+// deliver sends ev to the webhook and the command, logging failures. Each
+// gets alertDeliveryTimeout.
+func (a *alerter) deliver(ev alertEvent) {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	if a.cfg.WebhookURL != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), alertDeliveryTimeout)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.cfg.WebhookURL, bytes.NewReader(body))
+		if err != nil {
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := a.client.Do(req)
+		if err != nil {
+			slog.Warn("alert webhook failed", "alert", ev.Alert, "error", err)
+		} else {
+			resp.Body.Close()
+			if resp.StatusCode/100 != 2 {
+				slog.Warn("alert webhook failed", "alert", ev.Alert, "status", resp.StatusCode)
+			}
+		}
+	}
+	if len(a.cfg.Command) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), alertDeliveryTimeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, a.cfg.Command[0], a.cfg.Command[1:]...)
+		cmd.Stdin = bytes.NewReader(body)
+		cmd.Env = alertEnv(ev)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			slog.Warn("alert command failed", "alert", ev.Alert, "error", err, "output", strings.TrimSpace(string(out)))
+		}
+	}
+}
+
+// alertEnv is the environment the alert command runs with: PATH and HOME,
+// and the alert itself. The rest of the proxy's environment, which may hold
+// tokens and secrets, is left out.
+func alertEnv(ev alertEvent) []string {
+	var env []string
+	for _, name := range []string{"PATH", "HOME"} {
+		if v, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+v)
+		}
+	}
+	return append(env,
+		"GH_CHECKPROXY_ALERT="+ev.Alert,
+		"GH_CHECKPROXY_ALERT_STATE="+ev.State,
+		"GH_CHECKPROXY_ALERT_TEXT="+ev.Text)
+}
@@ -0,0 +1,237 @@
+package checkproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// allowlist is one of the access lists admins can edit on a running server:
+// allowed_orgs or allowed_repos.
+type allowlist struct {
+	noun  string // URL and CLI name: "orgs" or "repos"
+	field string // JSON request field naming the entry
+	env   string // variable that overrides the file's list, if any
+	list  func(*Config) *[]string
+	check func(string) error
+}
+
+var allowlists = []allowlist{
+	{
+		noun:  "orgs",
+		field: "org",
+		env:   "GH_CHECKPROXY_ALLOWED_ORGS",
+		list:  func(c *Config) *[]string { return &c.AllowedOrgs },
+		check: validateOrgName,
+	},
+	{
+		noun:  "repos",
+		field: "pattern",
+		list:  func(c *Config) *[]string { return &c.AllowedRepos },
+		check: validateRepoPattern,
+	},
+}
+
+// orgNamePattern matches a GitHub user or organization login.
+var orgNamePattern = regexp.MustCompile(`^[A-Za-z0-9](?:[A-Za-z0-9-]{0,37}[A-Za-z0-9])?$`)
+
+func validateOrgName(org string) error {
+	if !orgNamePattern.MatchString(org) {
+		return fmt.Errorf("invalid organization name %q", org)
+	}
+	return nil
+}
+
+// errAllowlistConflict marks edits the server refuses as they stand; the
+// admin API answers them with 409.
+var errAllowlistConflict = errors.New("conflict")
+
+// edit adds entry to the list in the config file, or removes it, and has s
+// reload the file. It reports false if there was nothing to change. If the
+// reload is refused, or s has been closed, the file is put back as it was.
+func (a allowlist) edit(s *server, entry string, add bool) (bool, error) {
+	s.allowlistMu.Lock()
+	defer s.allowlistMu.Unlock()
+	if a.env != "" && os.Getenv(a.env) != "" {
+		return false, fmt.Errorf("%w: allowed %s come from %s", errAllowlistConflict, a.noun, a.env)
+	}
+	path := ConfigPath()
+	orig, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, fmt.Errorf("%w: no config file; the server runs from the environment", errAllowlistConflict)
+	}
+	if err != nil {
+		return false, err
+	}
+	cfg, err := loadConfigFile()
+	if err != nil {
+		return false, err
+	}
+	list := a.list(cfg)
+	i := slices.IndexFunc(*list, func(e string) bool { return strings.EqualFold(e, entry) })
+	switch {
+	case add && i >= 0, !add && i < 0:
+		return false, nil
+	case add:
+		*list = append(*list, entry)
+	case len(*list) == 1:
+		// An empty list lifts the restriction altogether.
+		return false, fmt.Errorf("%w: %s is the last allowed entry; removing it would allow all %s (clear the list with 'gh-checkproxy config' instead)",
+			errAllowlistConflict, entry, a.noun)
+	default:
+		*list = slices.Delete(*list, i, i+1)
+	}
+	if err := SaveConfig(cfg); err != nil {
+		return false, err
+	}
+	done := make(chan error, 1)
+	select {
+	case s.reloads <- done:
+		err = <-done
+	case <-s.done:
+		err = errServerClosed
+	}
+	if err != nil {
+		if restoreErr := os.WriteFile(path, orig, 0600); restoreErr != nil {
+			return false, fmt.Errorf("reload failed: %v; restoring %s also failed: %w", err, path, restoreErr)
+		}
+		return false, fmt.Errorf("reload failed, config unchanged: %w", err)
+	}
+	return true, nil
+}
+
+// allowlistRoutes registers GET, POST, and DELETE /v1/admin/{orgs,repos}
+// with handle, which wraps them in the admin check. Edits are reloaded by s.
+func allowlistRoutes(handle func(string, http.HandlerFunc), cfg *Config, s *server) {
+	for _, a := range allowlists {
+		handle("GET /v1/admin/"+a.noun, func(w http.ResponseWriter, r *http.Request) {
+			list := *a.list(cfg)
+			if list == nil {
+				list = []string{}
+			}
+			writeJSON(w, http.StatusOK, list)
+		})
+		handle("POST /v1/admin/"+a.noun, func(w http.ResponseWriter, r *http.Request) {
+			var req map[string]string
+			if err := json.NewDecoder(io.LimitReader(r.Body, 4<<10)).Decode(&req); err != nil {
+				http.Error(w, "bad request: invalid JSON body", http.StatusBadRequest)
+				return
+			}
+			entry := strings.TrimSpace(req[a.field])
+			if err := a.check(entry); err != nil {
+				http.Error(w, "bad request: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			added, err := a.edit(s, entry, true)
+			if err != nil {
+				allowlistError(w, err)
+				return
+			}
+			status := http.StatusOK
+			if added {
+				status = http.StatusCreated
+			}
+			writeJSON(w, status, map[string]string{a.field: entry})
+		})
+		handle("DELETE /v1/admin/"+a.noun+"/{entry...}", func(w http.ResponseWriter, r *http.Request) {
+			removed, err := a.edit(s, r.PathValue("entry"), false)
+			if err != nil {
+				allowlistError(w, err)
+				return
+			}
+			if !removed {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+func allowlistError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errAllowlistConflict) {
+		http.Error(w, strings.TrimPrefix(err.Error(), errAllowlistConflict.Error()+": "), http.StatusConflict)
+		return
+	}
+	http.Error(w, "internal server error: "+err.Error(), http.StatusInternalServerError)
+}
+
+// runAdmin implements `gh-checkproxy admin orgs|repos list|add|remove`,
+// which edits the allowlist of the server running on this host.
+func runAdmin(args []string) error {
+	const usage = "usage: gh-checkproxy admin orgs|repos list|add|remove [<entry>...]"
+	if len(args) < 2 {
+		return errors.New(usage)
+	}
+	i := slices.IndexFunc(allowlists, func(a allowlist) bool { return a.noun == args[0] })
+	if i < 0 {
+		return errors.New(usage)
+	}
+	a := allowlists[i]
+	fs := flag.NewFlagSet("admin "+a.noun, flag.ContinueOnError)
+	entries, err := parseInterspersed(fs, args[2:])
+	if err != nil {
+		return err
+	}
+	cfg, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	switch args[1] {
+	case "list":
+		var list []string
+		if _, err := adminRequest(cfg, "GET", "/v1/admin/"+a.noun, nil, &list); err != nil {
+			return err
+		}
+		if len(list) == 0 {
+			fmt.Printf("No allowed %s: the server accepts any.\n", a.noun)
+		}
+		for _, e := range list {
+			fmt.Println(e)
+		}
+	case "add":
+		if len(entries) == 0 {
+			return errors.New(usage)
+		}
+		for _, e := range entries {
+			if err := a.check(e); err != nil {
+				return err
+			}
+			status, err := adminRequest(cfg, "POST", "/v1/admin/"+a.noun, map[string]string{a.field: e}, nil)
+			if err != nil {
+				return err
+			}
+			if status == http.StatusCreated {
+				fmt.Printf("Allowed %s.\n", e)
+			} else {
+				fmt.Printf("%s was already allowed.\n", e)
+			}
+		}
+	case "remove":
+		if len(entries) == 0 {
+			return errors.New(usage)
+		}
+		for _, e := range entries {
+			status, err := adminRequest(cfg, "DELETE", "/v1/admin/"+a.noun+"/"+url.PathEscape(e), nil, nil)
+			if status == http.StatusNotFound {
+				return fmt.Errorf("%s is not in allowed %s", e, a.noun)
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Removed %s.\n", e)
+		}
+	default:
+		return errors.New(usage)
+	}
+	return nil
+}
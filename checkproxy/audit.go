@@ -0,0 +1,98 @@
+package checkproxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditRecord is one authorization decision. Credentials appear only as a
+// SHA-256 prefix (tokens, API keys) or a key ID (HMAC clients).
+type auditRecord struct {
+	Time    time.Time `json:"time"`
+	Token   string    `json:"token,omitempty"`
+	HMACKey string    `json:"hmac_key,omitempty"`
+	Source  string    `json:"source,omitempty"`
+	Owner   string    `json:"owner,omitempty"`
+	Repo    string    `json:"repo,omitempty"`
+	Method  string    `json:"method"`
+	Route   string    `json:"route"`
+	Result  string    `json:"result"` // "allow" or "deny"
+	Status  int       `json:"status,omitempty"`
+}
+
+// auditFingerprintLen is how much of the token's SHA-256 the audit log keeps:
+// enough to match a revocation fingerprint, useless for recovering a token.
+const auditFingerprintLen = 16
+
+// auditLog appends auditRecords to a file as JSON lines.
+type auditLog struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	redact *logRedactor
+}
+
+// openAuditLog opens path for appending; "-" writes to stdout. Records are
+// redacted by redact.
+func openAuditLog(path string, redact *logRedactor) (*auditLog, error) {
+	var w io.Writer = os.Stdout
+	if path != "-" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	}
+	return &auditLog{enc: json.NewEncoder(w), redact: redact}, nil
+}
+
+func (a *auditLog) log(rec auditRecord) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_ = a.enc.Encode(a.redact.record(rec))
+}
+
+// auditRequest starts a record for r; the caller fills in the result.
+func auditRequest(r *http.Request, owner, repo string) auditRecord {
+	rec := auditRecord{
+		Time:    time.Now().UTC(),
+		HMACKey: r.Header.Get(hmacKeyIDHeader),
+		Source:  requestSource(r),
+		Owner:   owner,
+		Repo:    repo,
+		Method:  r.Method,
+		Route:   r.URL.Path,
+	}
+	if token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); token != "" {
+		rec.Token = tokenFingerprint(token)[:auditFingerprintLen]
+	}
+	return rec
+}
+
+// statusRecorder remembers the status code written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer to flush
+// or hijack.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
@@ -0,0 +1,537 @@
+package checkproxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type cacheEntry struct {
+	allowed       bool
+	defaultBranch string
+	accepted      string            // permissions GitHub named when a probe was refused
+	repos         map[string]string // installation tokens: lower-case full name → default branch
+	tokenExpires  time.Time         // from GitHub-Authentication-Token-Expiration; zero if none
+	expires       time.Time
+}
+
+// tokenExpirationHeader tells clients their token expires soon. GitHub only
+// reports expiry to the token's holder, so the proxy relays it.
+const tokenExpirationHeader = "X-Checkproxy-Token-Expiration"
+
+// parseTokenExpiration parses GitHub's GitHub-Authentication-Token-Expiration
+// header, e.g. "2024-05-01 12:00:00 UTC" or "2024-05-01 12:00:00 -0700".
+func parseTokenExpiration(s string) time.Time {
+	for _, layout := range []string{"2006-01-02 15:04:05 MST", "2006-01-02 15:04:05 -0700"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// isInstallationToken reports whether token is a GitHub App installation token.
+func isInstallationToken(token string) bool {
+	return strings.HasPrefix(token, "ghs_")
+}
+
+// resourceProbe names the route groups a request reads and, when the path
+// has one, the commit or ref it reads them for. Strict validation checks the
+// client token against the permissions those groups require rather than just
+// the repository.
+type resourceProbe struct {
+	groups []string // keys of defaultRequiredPermissions; none means metadata only
+	ref    string
+}
+
+// permissionProbes maps fine-grained permissions (GitHub's names, as in the
+// X-Accepted-GitHub-Permissions header) to a cheap upstream read that only
+// succeeds if the token holds that permission.
+var permissionProbes = map[string]string{
+	"checks":        "commits/%s/check-runs?per_page=1",
+	"statuses":      "commits/%s/status?per_page=1",
+	"actions":       "actions/runs?per_page=1",
+	"deployments":   "deployments?per_page=1",
+	"pull_requests": "pulls?per_page=1",
+}
+
+// defaultRequiredPermissions lists what each route group needs under strict
+// validation. Check routes require statuses rather than checks because
+// fine-grained PATs can't be granted Checks at all; deployments that only
+// admit tokens which can (e.g. installation tokens) can require checks:read
+// via required_permissions.
+var defaultRequiredPermissions = map[string][]string{
+	"checks":      {"statuses:read"},
+	"statuses":    {"statuses:read"},
+	"actions":     {"actions:read"},
+	"deployments": {"deployments:read"},
+	"pulls":       {"pull_requests:read"},
+}
+
+// parsePermission splits "name:read" (or GitHub's "name=read") and checks
+// that the proxy knows how to probe it. Only read access can be verified.
+func parsePermission(p string) (string, error) {
+	name, level, ok := strings.Cut(p, ":")
+	if !ok {
+		name, level, ok = strings.Cut(p, "=")
+	}
+	if !ok || level != "read" {
+		return "", fmt.Errorf("permission %q must have the form name:read", p)
+	}
+	if _, known := permissionProbes[name]; !known {
+		return "", fmt.Errorf("unknown permission %q", name)
+	}
+	return name, nil
+}
+
+// permissionError means the token lacks a permission a route group requires.
+type permissionError struct {
+	Permission string
+	Accepted   string // X-Accepted-GitHub-Permissions from the failed probe
+}
+
+func (e *permissionError) Error() string {
+	msg := fmt.Sprintf("token lacks %s:read on this repository", e.Permission)
+	if e.Accepted != "" {
+		msg += fmt.Sprintf(" (GitHub accepts: %s)", e.Accepted)
+	}
+	return msg
+}
+
+// Validator checks whether a fine-grained token has read access to a repository.
+// Results are cached in a bounded in-memory LRU to avoid repeated GitHub API
+// calls.
+type Validator struct {
+	cache      *validationCache
+	httpClient *http.Client
+	keys       *keyStore       // proxy-issued API keys; nil disables them
+	revoked    *revocationList // blocked credentials; nil disables the check
+	backoff    *failureBackoff // per-source backoff after rejected credentials
+	audit      *auditLog       // authorization decisions; nil disables logging
+	shared     *redisClient    // results shared with other replicas; nil keeps them local
+	budget     *rateBudget     // lengthens TTLs while tokens run low; nil never does
+	outcomes   *outcomeCounter // validations sent to GitHub, for alerts; nil counts nothing
+	redact     *logRedactor
+	refreshing sync.Map // cache keys with a background refresh in flight
+
+	// ttl is how long an allowed result is cached; negativeTTL a denied
+	// one. negativeTTL is usually shorter so a token that has just been
+	// granted access recovers quickly. Both change on config reload.
+	ttlMu       sync.RWMutex
+	ttl         time.Duration
+	negativeTTL time.Duration
+}
+
+// defaultNegativeTTL caps how long denied validation results are cached
+// unless validation_negative_ttl says otherwise.
+const defaultNegativeTTL = time.Minute
+
+// recordFailure notes a rejected credential from source for backoff.
+func (v *Validator) recordFailure(source string) {
+	if v.backoff != nil {
+		v.backoff.fail(source)
+	}
+}
+
+// expiry returns when a result should leave the cache.
+func (v *Validator) expiry(allowed bool) time.Time {
+	return time.Now().Add(v.ttlFor(allowed))
+}
+
+// ttlFor returns how long an allowed or denied result is cached now.
+func (v *Validator) ttlFor(allowed bool) time.Duration {
+	v.ttlMu.RLock()
+	defer v.ttlMu.RUnlock()
+	// While the upstream budget is low, results are kept longer.
+	factor := time.Duration(v.budget.cacheTTLFactor())
+	if allowed {
+		return v.ttl * factor
+	}
+	return v.negativeTTL * factor
+}
+
+// SetTTLs changes the cache TTLs for results stored from now on; cached
+// results keep their expiry.
+func (v *Validator) SetTTLs(ttl, negativeTTL time.Duration) {
+	v.ttlMu.Lock()
+	v.ttl, v.negativeTTL = ttl, negativeTTL
+	v.ttlMu.Unlock()
+}
+
+// NewValidator caches allowed results for ttl and denied ones for negativeTTL,
+// keeping at most maxEntries results (0 means defaultValidationCacheSize).
+func NewValidator(ttl, negativeTTL time.Duration, maxEntries int) *Validator {
+	return &Validator{
+		cache:       newValidationCache(maxEntries),
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		httpClient:  &http.Client{Transport: breakerTransport{traceTransport{http.DefaultTransport, nil, nil, nil}, nil}, Timeout: 10 * time.Second},
+		backoff:     newFailureBackoff(),
+	}
+}
+
+func (v *Validator) cacheKey(apiBase, token, owner, repo string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s/%s/%s/%s", apiBase, token, owner, repo)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Validate returns true if the fine-grained token can read the given repository
+// on the GitHub instance at apiBase.
+func (v *Validator) Validate(ctx context.Context, apiBase, token, owner, repo string) (bool, error) {
+	entry, err := v.repoEntry(ctx, apiBase, token, owner, repo)
+	return entry.allowed, err
+}
+
+// ValidatePermissions is the strict form of Validate: besides repository
+// access it requires the token to hold each permission (e.g. "statuses"),
+// probed with the client token itself. A missing permission is reported as a
+// *permissionError. Results are cached per token, repository, and permission.
+func (v *Validator) ValidatePermissions(ctx context.Context, apiBase, token, owner, repo, ref string, perms []string) (bool, error) {
+	entry, err := v.repoEntry(ctx, apiBase, token, owner, repo)
+	if err != nil || !entry.allowed {
+		return false, err
+	}
+	if ref == "" {
+		ref = entry.defaultBranch
+	}
+
+	for _, perm := range perms {
+		key := v.cacheKey(apiBase, token, owner, repo+"#"+perm)
+		probeEntry, err := v.lookup(ctx, key, repoTags(token, owner, repo), func(ctx context.Context) (cacheEntry, error) {
+			probe := permissionProbes[perm]
+			if strings.Contains(probe, "%s") {
+				probe = fmt.Sprintf(probe, url.PathEscape(ref))
+			}
+			allowed, resp, err := v.get(ctx, fmt.Sprintf("%s/repos/%s/%s/%s", apiBase, owner, repo, probe), token, nil)
+			if err != nil {
+				return cacheEntry{}, err
+			}
+			probeEntry := cacheEntry{allowed: allowed}
+			if !allowed && resp != nil {
+				probeEntry.accepted = resp.Header.Get("X-Accepted-GitHub-Permissions")
+			}
+			return probeEntry, nil
+		})
+		if err != nil {
+			return false, err
+		}
+		if !probeEntry.allowed {
+			return false, &permissionError{Permission: perm, Accepted: probeEntry.accepted}
+		}
+	}
+	return true, nil
+}
+
+// ValidateMembership reports whether the user owning token is a member of
+// any of orgs. The user is resolved with the client token; membership is
+// looked up with classicToken, which can see private members. Results are
+// cached per token like repository access.
+func (v *Validator) ValidateMembership(ctx context.Context, apiBase, token, classicToken string, orgs []string) (bool, error) {
+	key := v.cacheKey(apiBase, token, strings.ToLower(strings.Join(orgs, ",")), "#member")
+	entry, err := v.lookup(ctx, key, cacheTags{token: tokenFingerprint(token)}, func(ctx context.Context) (cacheEntry, error) {
+		var user struct {
+			Login string `json:"login"`
+		}
+		found, _, err := v.get(ctx, apiBase+"/user", token, &user)
+		if err != nil || !found || user.Login == "" {
+			return cacheEntry{}, err
+		}
+		for _, org := range orgs {
+			// 204 for members; 404 (or a redirect to public_members that
+			// ends in 404) otherwise. get only treats 200 as success.
+			rawURL := fmt.Sprintf("%s/orgs/%s/members/%s", apiBase, url.PathEscape(org), url.PathEscape(user.Login))
+			req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+			if err != nil {
+				return cacheEntry{}, err
+			}
+			setGitHubHeaders(req, classicToken)
+			resp, err := v.httpClient.Do(req)
+			if err != nil {
+				return cacheEntry{}, fmt.Errorf("checking org membership: %w", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusNoContent {
+				return cacheEntry{allowed: true}, nil
+			}
+		}
+		return cacheEntry{}, nil
+	})
+	return entry.allowed, err
+}
+
+// repoEntry returns the cached or freshly checked repository access for token.
+func (v *Validator) repoEntry(ctx context.Context, apiBase, token, owner, repo string) (cacheEntry, error) {
+	return v.lookup(ctx, v.cacheKey(apiBase, token, owner, repo), repoTags(token, owner, repo), func(ctx context.Context) (cacheEntry, error) {
+		return v.checkGitHub(ctx, apiBase, token, owner, repo)
+	})
+}
+
+// refreshFraction is the final share of an entry's TTL during which a hit
+// also starts a background refresh (stale-while-revalidate).
+const refreshFraction = 5
+
+// lookup returns the cached entry for key, or calls fetch and caches its
+// result. A hit in the last 1/refreshFraction of the entry's TTL is served
+// as-is while a background fetch replaces it, so callers polling steadily
+// never wait on GitHub when the TTL rolls over.
+func (v *Validator) lookup(ctx context.Context, key string, tags cacheTags, fetch func(context.Context) (cacheEntry, error)) (entry cacheEntry, err error) {
+	ctx, s := startSpan(ctx, "validate", spanInternal)
+	s.set("checkproxy.repo", tags.repo)
+	defer func() {
+		s.set("checkproxy.allowed", entry.allowed)
+		s.fail(err)
+		s.finish()
+	}()
+	if entry, ok := v.cached(key); ok {
+		s.set("checkproxy.cache", "hit")
+		refresh := time.Until(entry.expires) < v.ttlFor(entry.allowed)/refreshFraction
+		slog.Debug("validation cache hit", append(tags.logAttrs(v.redact), "allowed", entry.allowed,
+			"expires_in", time.Until(entry.expires).Round(time.Second).String(), "refresh", refresh)...)
+		if refresh {
+			v.refresh(key, tags, fetch)
+		}
+		return entry, nil
+	}
+	if entry, ok := v.sharedEntry(key, tags); ok {
+		s.set("checkproxy.cache", "shared")
+		slog.Debug("validation shared cache hit", append(tags.logAttrs(v.redact), "allowed", entry.allowed)...)
+		return entry, nil
+	}
+	s.set("checkproxy.cache", "miss")
+	entry, err = fetch(ctx)
+	if ctx.Err() == nil {
+		v.outcomes.add(err != nil)
+	}
+	var open *circuitOpenError
+	if errors.As(err, &open) {
+		// GitHub is down: an expired answer beats none until it is back, as
+		// long as it expired no more than one TTL ago. Older ones may have
+		// been revoked on GitHub since, so the request is refused.
+		if stale, ok := v.cache.peek(key); ok && time.Since(stale.expires) <= v.ttlFor(stale.allowed) {
+			s.set("checkproxy.cache", "stale")
+			slog.Debug("validation served from expired cache", append(tags.logAttrs(v.redact), "allowed", stale.allowed)...)
+			return stale, nil
+		}
+	}
+	if err != nil {
+		slog.Debug("validation failed", append(tags.logAttrs(v.redact), "error", err)...)
+		return cacheEntry{}, err
+	}
+	entry.expires = v.expiry(entry.allowed)
+	v.cache.add(key, tags, entry)
+	v.share(key, tags, entry)
+	slog.Debug("validation cache miss", append(tags.logAttrs(v.redact), "allowed", entry.allowed, "ttl", v.ttlFor(entry.allowed).String())...)
+	return entry, nil
+}
+
+// refresh replaces key's entry in the background, at most once at a time
+// per key. On failure the current entry stays until it expires.
+func (v *Validator) refresh(key string, tags cacheTags, fetch func(context.Context) (cacheEntry, error)) {
+	if _, busy := v.refreshing.LoadOrStore(key, struct{}{}); busy {
+		return
+	}
+	go func() {
+		defer v.refreshing.Delete(key)
+		entry, err := fetch(context.Background())
+		v.outcomes.add(err != nil)
+		if err != nil {
+			slog.Debug("validation refresh failed", append(tags.logAttrs(v.redact), "error", err)...)
+			return
+		}
+		entry.expires = v.expiry(entry.allowed)
+		v.cache.add(key, tags, entry)
+		v.share(key, tags, entry)
+	}()
+}
+
+// sharedEntry returns the unexpired result another replica stored in Redis
+// for key, adding it to the local cache.
+func (v *Validator) sharedEntry(key string, tags cacheTags) (cacheEntry, bool) {
+	if v.shared == nil {
+		return cacheEntry{}, false
+	}
+	data, ok := v.shared.get(tags.sharedKey(key))
+	if !ok {
+		return cacheEntry{}, false
+	}
+	var e persistedEntry
+	if json.Unmarshal(data, &e) != nil || !time.Now().Before(e.Expires) {
+		return cacheEntry{}, false
+	}
+	entry := e.entry()
+	v.cache.add(key, tags, entry)
+	return entry, true
+}
+
+// share stores a fresh result in Redis for the other replicas, until it
+// expires.
+func (v *Validator) share(key string, tags cacheTags, entry cacheEntry) {
+	if v.shared == nil {
+		return
+	}
+	if data, err := json.Marshal(newPersistedEntry(key, tags, entry)); err == nil {
+		v.shared.set(tags.sharedKey(key), data, time.Until(entry.expires))
+	}
+}
+
+// CacheStats reports validation cache usage.
+func (v *Validator) CacheStats() cacheStats {
+	return v.cache.stats()
+}
+
+// FlushCache drops cached results for tokens whose fingerprint starts with
+// tokenFP and for owner/repo repo (either may be empty), returning how many
+// were removed. The next request for them asks GitHub again. With Redis the
+// shared copies go too, and are counted separately; other replicas keep
+// their local copies until they expire.
+func (v *Validator) FlushCache(tokenFP, repo string) int {
+	tokenFP, repo = strings.ToLower(tokenFP), strings.ToLower(repo)
+	n := v.cache.flush(tokenFP, repo)
+	if v.shared != nil {
+		shared, err := v.shared.deleteMatching(sharedFlushPattern(tokenFP, repo))
+		if err != nil {
+			slog.Warn("flushing shared validation results failed", "error", err)
+		}
+		n += shared
+	}
+	return n
+}
+
+// repoTags tags a result for token's access to owner/repo.
+func repoTags(token, owner, repo string) cacheTags {
+	return cacheTags{token: tokenFingerprint(token), repo: strings.ToLower(owner + "/" + repo)}
+}
+
+// TokenExpiration returns when GitHub said token expires, as seen by the last
+// validation of owner/repo, or the zero time if it didn't say.
+func (v *Validator) TokenExpiration(apiBase, token, owner, repo string) time.Time {
+	entry, _ := v.cache.peek(v.cacheKey(apiBase, token, owner, repo))
+	return entry.tokenExpires
+}
+
+// cached returns an unexpired cache entry.
+func (v *Validator) cached(key string) (cacheEntry, bool) {
+	return v.cache.get(key)
+}
+
+func (v *Validator) checkGitHub(ctx context.Context, apiBase, token, owner, repo string) (cacheEntry, error) {
+	if isInstallationToken(token) {
+		return v.checkInstallation(ctx, apiBase, token, owner, repo)
+	}
+	var meta struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	allowed, resp, err := v.get(ctx, fmt.Sprintf("%s/repos/%s/%s", apiBase, owner, repo), token, &meta)
+	if err != nil {
+		return cacheEntry{}, err
+	}
+	entry := cacheEntry{allowed: allowed, defaultBranch: meta.DefaultBranch}
+	if resp != nil {
+		entry.tokenExpires = parseTokenExpiration(resp.Header.Get("GitHub-Authentication-Token-Expiration"))
+	}
+	return entry, nil
+}
+
+// checkInstallation validates a GitHub App installation token by looking the
+// repository up in the installation's accessible repositories. The list is
+// fetched once per token and cached for the validation TTL, so validating
+// further repositories for the same installation is free.
+func (v *Validator) checkInstallation(ctx context.Context, apiBase, token, owner, repo string) (cacheEntry, error) {
+	list, err := v.lookup(ctx, v.cacheKey(apiBase, token, "", "#installation"), cacheTags{token: tokenFingerprint(token)}, func(ctx context.Context) (cacheEntry, error) {
+		list := cacheEntry{repos: make(map[string]string)}
+		for page := 1; ; page++ {
+			var result struct {
+				TotalCount   int `json:"total_count"`
+				Repositories []struct {
+					FullName      string `json:"full_name"`
+					DefaultBranch string `json:"default_branch"`
+				} `json:"repositories"`
+			}
+			rawURL := fmt.Sprintf("%s/installation/repositories?per_page=100&page=%d", apiBase, page)
+			found, _, err := v.get(ctx, rawURL, token, &result)
+			if err != nil {
+				return cacheEntry{}, err
+			}
+			if !found {
+				break // not a valid installation token
+			}
+			for _, r := range result.Repositories {
+				list.repos[strings.ToLower(r.FullName)] = r.DefaultBranch
+			}
+			if len(result.Repositories) < 100 || len(list.repos) >= result.TotalCount {
+				break
+			}
+		}
+		list.allowed = len(list.repos) > 0
+		return list, nil
+	})
+	if err != nil {
+		return cacheEntry{}, err
+	}
+
+	branch, allowed := list.repos[strings.ToLower(owner+"/"+repo)]
+	return cacheEntry{allowed: allowed, defaultBranch: branch}, nil
+}
+
+// clientRateLimitError is returned for a validation GitHub refused because
+// the client's own token is rate limited. It says nothing about access, so
+// it is never cached; the client is told to come back after retry.
+type clientRateLimitError struct {
+	retry time.Duration
+}
+
+func (e *clientRateLimitError) Error() string {
+	return "GitHub rate-limited this token; retry in " + e.retry.Round(time.Second).String()
+}
+
+func (e *clientRateLimitError) refusal() (int, string, time.Duration) {
+	return http.StatusTooManyRequests, "client-rate-limited", e.retry
+}
+
+// get requests rawURL with the client token and reports whether it returned
+// 200, decoding the body into out when non-nil. Only a 4xx is an answer
+// about the token; a 5xx or a rate limit is an error, so it is never cached
+// as a denial.
+func (v *Validator) get(ctx context.Context, rawURL, token string, out any) (bool, *http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return false, nil, err
+	}
+	setGitHubHeaders(req, token)
+
+	resp, err := v.httpClient.Do(req)
+	if err != nil {
+		return false, nil, fmt.Errorf("validating token against GitHub: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return false, resp, fmt.Errorf("validating token against GitHub: GitHub returned %d", resp.StatusCode)
+	}
+	if until, _, limited := tokenRejection(resp); limited && resp.StatusCode != http.StatusUnauthorized {
+		return false, resp, &clientRateLimitError{retry: max(time.Until(until), time.Second)}
+	}
+	if retry, limited := secondaryRateLimit(resp); limited {
+		return false, resp, &clientRateLimitError{retry: retry}
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return false, resp, &clientRateLimitError{retry: defaultSecondaryBackoff}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, resp, nil
+	}
+	if out != nil {
+		_ = json.NewDecoder(resp.Body).Decode(out)
+	}
+	return true, resp, nil
+}
@@ -0,0 +1,121 @@
+package checkproxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// TestValidateUpstreamFailures checks that GitHub errors and rate limits
+// fail the validation without caching a denial, so the next request is
+// checked again.
+func TestValidateUpstreamFailures(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		header      map[string]string
+		body        string
+		wantErr     bool
+		wantLimited bool
+	}{
+		{name: "server error", status: http.StatusBadGateway, wantErr: true},
+		{name: "unavailable", status: http.StatusServiceUnavailable, wantErr: true},
+		{name: "too many requests", status: http.StatusTooManyRequests, wantErr: true, wantLimited: true},
+		{
+			name:        "primary rate limit",
+			status:      http.StatusForbidden,
+			header:      map[string]string{"X-RateLimit-Remaining": "0"},
+			wantErr:     true,
+			wantLimited: true,
+		},
+		{
+			name:        "secondary rate limit",
+			status:      http.StatusForbidden,
+			body:        `{"message":"You have exceeded a secondary rate limit."}`,
+			wantErr:     true,
+			wantLimited: true,
+		},
+		{name: "not found", status: http.StatusNotFound},
+		{name: "forbidden", status: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fail := true
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if fail {
+					for k, v := range tt.header {
+						w.Header().Set(k, v)
+					}
+					w.WriteHeader(tt.status)
+					w.Write([]byte(tt.body))
+					return
+				}
+				w.Write([]byte(`{"default_branch":"main"}`))
+			}))
+			defer upstream.Close()
+			v := NewValidator(time.Minute, time.Minute, 10)
+
+			allowed, err := v.Validate(context.Background(), upstream.URL, "github_pat_test", "o", "r")
+			if allowed {
+				t.Fatal("allowed while GitHub failed")
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			var limited *clientRateLimitError
+			if errors.As(err, &limited) != tt.wantLimited {
+				t.Fatalf("err = %v, want rate limit %v", err, tt.wantLimited)
+			}
+
+			fail = false
+			allowed, err = v.Validate(context.Background(), upstream.URL, "github_pat_test", "o", "r")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if allowed != tt.wantErr {
+				t.Errorf("second validation allowed = %v, want %v", allowed, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestValidateStaleWhileCircuitOpen checks that an open breaker falls back to
+// an expired result only while it expired less than a TTL ago.
+func TestValidateStaleWhileCircuitOpen(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer upstream.Close()
+	u, _ := url.Parse(upstream.URL)
+	tests := []struct {
+		name    string
+		expired time.Duration
+		want    bool
+	}{
+		{"expired recently", 30 * time.Second, true},
+		{"expired a TTL ago", 2 * time.Minute, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			breakers, _ := newBreakerSet(&CircuitBreakerConfig{Failures: 1, Cooldown: "1h"})
+			breakers.forHost(u.Host).record(true)
+			v := NewValidator(time.Minute, time.Minute, 10)
+			v.httpClient.Transport = breakerTransport{http.DefaultTransport, breakers}
+			v.cache.add(v.cacheKey(upstream.URL, "github_pat_test", "o", "r"), repoTags("github_pat_test", "o", "r"),
+				cacheEntry{allowed: true, expires: time.Now().Add(-tt.expired)})
+
+			allowed, err := v.Validate(context.Background(), upstream.URL, "github_pat_test", "o", "r")
+			if allowed != tt.want {
+				t.Errorf("allowed = %v, want %v (err %v)", allowed, tt.want, err)
+			}
+			var open *circuitOpenError
+			if !tt.want && !errors.As(err, &open) {
+				t.Errorf("err = %v, want the open circuit", err)
+			}
+		})
+	}
+}
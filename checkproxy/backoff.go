@@ -0,0 +1,91 @@
+package checkproxy
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Exponential backoff for sources that keep presenting credentials the proxy
+// rejects: the first failure costs nothing, each further one doubles the wait.
+const (
+	backoffBase  = time.Second
+	backoffMax   = 5 * time.Minute
+	backoffReset = 15 * time.Minute // failures are forgotten after this long without one
+)
+
+type backoffState struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// failureBackoff tracks rejected credentials per source address. It protects
+// the upstream rate limit from clients looping on a bad token and slows down
+// brute-forcing tokens through the proxy.
+type failureBackoff struct {
+	mu      sync.Mutex
+	sources map[string]*backoffState
+	swept   time.Time
+}
+
+func newFailureBackoff() *failureBackoff {
+	return &failureBackoff{sources: make(map[string]*backoffState), swept: time.Now()}
+}
+
+// requestSource identifies the client for backoff purposes. RemoteAddr has
+// already been rewritten from X-Forwarded-For for trusted proxies.
+func requestSource(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// blocked reports whether source must wait, and for how long.
+func (b *failureBackoff) blocked(source string) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s, ok := b.sources[source]
+	if !ok {
+		return false, 0
+	}
+	if wait := time.Until(s.blockedUntil); wait > 0 {
+		return true, wait
+	}
+	return false, 0
+}
+
+// fail records a rejected credential from source.
+func (b *failureBackoff) fail(source string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if now.Sub(b.swept) > time.Minute {
+		b.sweep(now)
+	}
+	s, ok := b.sources[source]
+	if !ok || now.Sub(s.lastFailure) > backoffReset {
+		s = &backoffState{}
+		b.sources[source] = s
+	}
+	s.failures++
+	s.lastFailure = now
+	if s.failures > 1 {
+		wait := backoffBase << min(s.failures-2, 20)
+		s.blockedUntil = now.Add(min(wait, backoffMax))
+	}
+}
+
+// sweep forgets sources whose failures are old enough not to count. It is
+// called with b.mu held, at most once a minute, as failures come in.
+func (b *failureBackoff) sweep(now time.Time) {
+	for source, s := range b.sources {
+		if now.Sub(s.lastFailure) > backoffReset {
+			delete(b.sources, source)
+		}
+	}
+	b.swept = now
+}
@@ -0,0 +1,217 @@
+package checkproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CircuitBreakerConfig tunes the breaker that stops calling GitHub while it
+// is failing. It is on by default.
+type CircuitBreakerConfig struct {
+	Disabled bool `json:"disabled,omitempty"`
+	// Failures is how many consecutive failed upstream requests open it.
+	Failures int `json:"failures,omitempty"`
+	// Cooldown is how long it stays open before letting one request probe.
+	Cooldown string `json:"cooldown,omitempty"`
+}
+
+const (
+	defaultBreakerFailures = 5
+	defaultBreakerCooldown = 30 * time.Second
+)
+
+// upstreamStatusHeader tells clients why a request failed fast, so they can
+// tell a GitHub incident or an exhausted quota from a problem with the proxy
+// or their token.
+const upstreamStatusHeader = "X-Checkproxy-Upstream-Status"
+
+func newBreakerSet(cfg *CircuitBreakerConfig) (*breakerSet, error) {
+	s := &breakerSet{failures: defaultBreakerFailures, cooldown: defaultBreakerCooldown, hosts: make(map[string]*circuitBreaker)}
+	if cfg == nil {
+		return s, nil
+	}
+	if cfg.Disabled {
+		return nil, nil
+	}
+	if cfg.Failures < 0 {
+		return nil, errors.New("failures must not be negative")
+	}
+	if cfg.Failures > 0 {
+		s.failures = cfg.Failures
+	}
+	if cfg.Cooldown != "" {
+		d, err := time.ParseDuration(cfg.Cooldown)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("cooldown %q is not a positive duration", cfg.Cooldown)
+		}
+		s.cooldown = d
+	}
+	return s, nil
+}
+
+type breakerSet struct {
+	failures int
+	cooldown time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*circuitBreaker
+}
+
+func (s *breakerSet) forHost(host string) *circuitBreaker {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.hosts[host]
+	if !ok {
+		b = &circuitBreaker{host: host, failures: s.failures, cooldown: s.cooldown}
+		s.hosts[host] = b
+	}
+	return b
+}
+
+// circuitBreaker opens after a run of failed requests to one host. While
+// open, requests fail at once; after the cooldown a single request is let
+// through, and its outcome closes or reopens the breaker.
+type circuitBreaker struct {
+	host     string
+	failures int
+	cooldown time.Duration
+
+	mu        sync.Mutex
+	failed    int       // consecutive failures
+	openUntil time.Time // zero while closed
+	probing   bool
+}
+
+// circuitOpenError is returned, wrapped in an *url.Error, for requests refused
+// by an open breaker.
+type circuitOpenError struct {
+	host  string
+	retry time.Duration
+}
+
+func (e *circuitOpenError) Error() string {
+	return e.host + " is failing; not retrying for " + e.retry.Round(time.Second).String()
+}
+
+func (e *circuitOpenError) refusal() (int, string, time.Duration) {
+	return http.StatusServiceUnavailable, "unavailable", e.retry
+}
+
+// upstreamRefusal is implemented by the errors for requests the proxy
+// declined to send upstream, or GitHub declined to answer for a while. They
+// are answered with code; the status goes in upstreamStatusHeader.
+type upstreamRefusal interface {
+	error
+	refusal() (code int, status string, retry time.Duration)
+}
+
+// allow reports whether a request may go upstream now.
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openUntil.IsZero() {
+		return nil
+	}
+	if wait := time.Until(b.openUntil); wait > 0 || b.probing {
+		return &circuitOpenError{host: b.host, retry: max(wait, time.Second)}
+	}
+	b.probing = true
+	return nil
+}
+
+// record counts the outcome of a request that allow let through.
+func (b *circuitBreaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wasOpen := !b.openUntil.IsZero()
+	b.probing = false
+	if !failed {
+		b.failed = 0
+		b.openUntil = time.Time{}
+		if wasOpen {
+			slog.Info("upstream recovered; circuit closed", "host", b.host)
+		}
+		return
+	}
+	b.failed++
+	if wasOpen || b.failed >= b.failures {
+		b.openUntil = time.Now().Add(b.cooldown)
+		if !wasOpen {
+			slog.Warn("upstream failing; circuit open", "host", b.host, "failures", b.failed, "cooldown", b.cooldown.String())
+		}
+	}
+}
+
+// abandon gives up a probe whose outcome says nothing, so another request
+// can probe instead.
+func (b *circuitBreaker) abandon() {
+	b.mu.Lock()
+	b.probing = false
+	b.mu.Unlock()
+}
+
+// upstreamFailed reports whether a request's outcome says the upstream is
+// unhealthy: it couldn't be reached or answered with a server error. A
+// request the client abandoned, or that was held back for rate limiting,
+// says nothing either way.
+func upstreamFailed(req *http.Request, resp *http.Response, err error) (failed, counts bool) {
+	if err != nil {
+		var refused upstreamRefusal
+		if errors.Is(req.Context().Err(), context.Canceled) || errors.As(err, &refused) {
+			return false, false
+		}
+		return true, true
+	}
+	switch resp.StatusCode {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true, true
+	}
+	return false, true
+}
+
+// breakerTransport sends requests through the breaker for their host. With
+// no breakers, requests always go through.
+type breakerTransport struct {
+	next     http.RoundTripper
+	breakers *breakerSet
+}
+
+func (t breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.breakers == nil {
+		return t.next.RoundTrip(req)
+	}
+	b := t.breakers.forHost(req.URL.Host)
+	if err := b.allow(); err != nil {
+		return nil, err
+	}
+	resp, err := t.next.RoundTrip(req)
+	if failed, counts := upstreamFailed(req, resp, err); counts {
+		b.record(failed)
+	} else {
+		b.abandon()
+	}
+	return resp, err
+}
+
+// writeUpstreamRefused answers for err if it is an upstreamRefusal,
+// reporting whether it did. Clients that see upstreamStatusHeader should back
+// off for Retry-After.
+func writeUpstreamRefused(w http.ResponseWriter, err error) bool {
+	var refused upstreamRefusal
+	if !errors.As(err, &refused) {
+		return false
+	}
+	code, status, retry := refused.refusal()
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+	w.Header().Set(upstreamStatusHeader, status)
+	http.Error(w, strings.ToLower(http.StatusText(code))+": "+refused.Error(), code)
+	return true
+}
@@ -0,0 +1,87 @@
+package checkproxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// roundTripFunc is an http.RoundTripper that calls itself.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestBreakerTransport(t *testing.T) {
+	breakers, err := newBreakerSet(&CircuitBreakerConfig{Failures: 2, Cooldown: "50ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent int
+	status := http.StatusServiceUnavailable
+	transport := breakerTransport{roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		sent++
+		return &http.Response{StatusCode: status, Body: http.NoBody, Request: req}, nil
+	}), breakers}
+	get := func(host string) error {
+		resp, err := transport.RoundTrip(httptest.NewRequest(http.MethodGet, "https://"+host+"/repos/o/r", nil))
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	steps := []struct {
+		name     string
+		host     string
+		status   int
+		wait     time.Duration
+		wantOpen bool
+		wantSent int
+	}{
+		{name: "first failure", host: "api.github.com", status: http.StatusServiceUnavailable, wantSent: 1},
+		{name: "client errors don't count", host: "api.github.com", status: http.StatusNotFound, wantSent: 2},
+		{name: "failure after a success", host: "api.github.com", status: http.StatusBadGateway, wantSent: 3},
+		{name: "second failure in a row opens", host: "api.github.com", status: http.StatusGatewayTimeout, wantSent: 4},
+		{name: "open breaker fails fast", host: "api.github.com", wantOpen: true, wantSent: 4},
+		{name: "other hosts unaffected", host: "ghe.example.com", status: http.StatusOK, wantSent: 5},
+		{name: "failed probe reopens", host: "api.github.com", status: http.StatusInternalServerError, wait: 60 * time.Millisecond, wantSent: 6},
+		{name: "reopened", host: "api.github.com", wantOpen: true, wantSent: 6},
+		{name: "probe after cooldown closes", host: "api.github.com", status: http.StatusOK, wait: 60 * time.Millisecond, wantSent: 7},
+		{name: "closed", host: "api.github.com", status: http.StatusOK, wantSent: 8},
+	}
+	for _, st := range steps {
+		time.Sleep(st.wait)
+		status = st.status
+		err := get(st.host)
+		var open *circuitOpenError
+		if errors.As(err, &open) != st.wantOpen {
+			t.Fatalf("%s: err = %v, want open %v", st.name, err, st.wantOpen)
+		}
+		if sent != st.wantSent {
+			t.Fatalf("%s: %d requests sent, want %d", st.name, sent, st.wantSent)
+		}
+	}
+}
+
+// TestBreakerSingleProbe checks that only one request probes a breaker whose
+// cooldown has passed.
+func TestBreakerSingleProbe(t *testing.T) {
+	breakers, _ := newBreakerSet(&CircuitBreakerConfig{Failures: 1, Cooldown: "10ms"})
+	b := breakers.forHost("api.github.com")
+	b.record(true)
+	time.Sleep(20 * time.Millisecond)
+	if err := b.allow(); err != nil {
+		t.Fatalf("probe refused: %v", err)
+	}
+	if err := b.allow(); err == nil {
+		t.Fatal("second request let through while probing")
+	}
+	b.abandon()
+	if err := b.allow(); err != nil {
+		t.Fatalf("probe refused after the first was abandoned: %v", err)
+	}
+}
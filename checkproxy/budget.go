@@ -0,0 +1,185 @@
+package checkproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateBudgetConfig makes the proxy ration a server token's remaining GitHub
+// quota once it runs low, instead of spending it and then failing every
+// request until the limit resets.
+type RateBudgetConfig struct {
+	// Floor is the remaining request count below which requests are paced.
+	Floor int `json:"floor"`
+	// MaxWait is the longest a request is held for its turn (default 10s);
+	// requests that would wait longer are refused with 503.
+	MaxWait string `json:"max_wait,omitempty"`
+	// CacheTTLFactor multiplies validation cache lifetimes while any token
+	// is below the floor (default 4).
+	CacheTTLFactor int `json:"cache_ttl_factor,omitempty"`
+}
+
+const (
+	defaultBudgetMaxWait        = 10 * time.Second
+	defaultBudgetCacheTTLFactor = 4
+)
+
+func newRateBudget(cfg *RateBudgetConfig, limits *rateLimitTracker) (*rateBudget, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if cfg.Floor <= 0 {
+		return nil, errors.New("floor must be positive")
+	}
+	b := &rateBudget{limits: limits, floor: cfg.Floor, maxWait: defaultBudgetMaxWait, ttlFactor: defaultBudgetCacheTTLFactor,
+		next: make(map[rateLimitKey]time.Time), low: make(map[rateLimitKey]bool)}
+	if cfg.MaxWait != "" {
+		d, err := time.ParseDuration(cfg.MaxWait)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("max_wait %q is not a valid duration", cfg.MaxWait)
+		}
+		b.maxWait = d
+	}
+	if cfg.CacheTTLFactor < 0 {
+		return nil, errors.New("cache_ttl_factor must not be negative")
+	}
+	if cfg.CacheTTLFactor > 0 {
+		b.ttlFactor = cfg.CacheTTLFactor
+	}
+	return b, nil
+}
+
+// rateBudget spreads a low token's remaining requests evenly over the time
+// left until its limit resets.
+type rateBudget struct {
+	limits    *rateLimitTracker // what GitHub reported for the server's tokens
+	floor     int
+	maxWait   time.Duration
+	ttlFactor int
+
+	mu   sync.Mutex
+	next map[rateLimitKey]time.Time // earliest start of the next paced request
+	low  map[rateLimitKey]bool      // tokens currently below the floor, for logging
+}
+
+// budgetExceededError is returned, wrapped in an *url.Error, for requests
+// that would have to wait too long for their share of the budget.
+type budgetExceededError struct {
+	retry time.Duration
+}
+
+func (e *budgetExceededError) Error() string {
+	return "upstream rate limit nearly exhausted; retry in " + e.retry.Round(time.Second).String()
+}
+
+func (e *budgetExceededError) refusal() (int, string, time.Duration) {
+	return http.StatusServiceUnavailable, "rate-limited", e.retry
+}
+
+// wait holds a request made with token until its turn, or returns a
+// *budgetExceededError if that is more than maxWait away. Tokens whose budget
+// isn't known or is above the floor go straight through.
+func (b *rateBudget) wait(ctx context.Context, token, resource string) error {
+	key := rateLimitKey{token: tokenFingerprint(token), resource: resource}
+	st, ok := b.limits.get(key)
+	now := time.Now()
+	if !ok || st.Remaining >= b.floor || !st.Reset.After(now) {
+		b.setLow(key, false, st)
+		return nil
+	}
+	b.setLow(key, true, st)
+	interval := time.Until(st.Reset) / time.Duration(max(st.Remaining, 1))
+	if st.Remaining == 0 {
+		interval = time.Until(st.Reset)
+	}
+
+	b.mu.Lock()
+	start := now
+	if next := b.next[key]; next.After(now) {
+		start = next
+	}
+	delay := start.Sub(now)
+	if delay > b.maxWait {
+		b.mu.Unlock()
+		return &budgetExceededError{retry: delay}
+	}
+	b.next[key] = start.Add(interval)
+	b.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// setLow tracks which tokens are below the floor, logging the transitions.
+func (b *rateBudget) setLow(key rateLimitKey, low bool, st rateLimitStatus) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.low[key] == low {
+		return
+	}
+	if low {
+		b.low[key] = true
+		slog.Warn("upstream rate limit running low; pacing requests", "token", key.token[:16], "resource", key.resource,
+			"remaining", st.Remaining, "resets", st.Reset.Local().Format("15:04:05"))
+	} else {
+		delete(b.low, key)
+		delete(b.next, key)
+		slog.Info("upstream rate limit recovered", "token", key.token[:16], "resource", key.resource)
+	}
+}
+
+// cacheTTLFactor is how much longer validation results are kept right now:
+// ttlFactor while any token is below the floor, else 1.
+func (b *rateBudget) cacheTTLFactor() int {
+	if b == nil {
+		return 1
+	}
+	for _, st := range b.limits.snapshot() {
+		if st.Remaining < b.floor && st.Reset.After(time.Now()) {
+			return b.ttlFactor
+		}
+	}
+	return 1
+}
+
+// rateLimitResource guesses which of GitHub's rate limits a request to path
+// counts against.
+func rateLimitResource(path string) string {
+	if strings.HasSuffix(path, "/graphql") {
+		return "graphql"
+	}
+	return "core"
+}
+
+// budgetTransport holds requests made with a low-budget token until their
+// turn. With no budget, nothing is paced.
+type budgetTransport struct {
+	next   http.RoundTripper
+	budget *rateBudget
+}
+
+func (t budgetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.budget != nil {
+		if token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer "); ok {
+			if err := t.budget.wait(req.Context(), token, rateLimitResource(req.URL.Path)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return t.next.RoundTrip(req)
+}
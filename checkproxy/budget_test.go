@@ -0,0 +1,103 @@
+package checkproxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestBudgetTransport(t *testing.T) {
+	limits := newRateLimitTracker()
+	reset := time.Now().Add(3 * time.Second)
+	report := func(token string, remaining int) {
+		req := httptest.NewRequest(http.MethodGet, "https://api.github.com/user", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := &http.Response{Header: http.Header{}}
+		resp.Header.Set("X-RateLimit-Limit", "5000")
+		resp.Header.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		resp.Header.Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		limits.record(req, resp)
+	}
+	report("ghp_low", 4)
+	report("ghp_plenty", 4000)
+
+	budget, err := newRateBudget(&RateBudgetConfig{Floor: 100, MaxWait: "1ms", CacheTTLFactor: 3}, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent int
+	transport := budgetTransport{roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		sent++
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+	}), budget}
+	get := func(token, path string) error {
+		req := httptest.NewRequest(http.MethodGet, "https://api.github.com"+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		_, err := transport.RoundTrip(req)
+		return err
+	}
+
+	// With four requests left until the reset, a low token gets one every
+	// quarter of the time left; the next is further away than max_wait.
+	steps := []struct {
+		name    string
+		token   string
+		path    string
+		refused bool
+	}{
+		{name: "low token's first request", token: "ghp_low", path: "/repos/o/r/check-runs/1"},
+		{name: "low token paced", token: "ghp_low", path: "/repos/o/r/check-runs/1", refused: true},
+		{name: "other resource", token: "ghp_low", path: "/graphql"},
+		{name: "token above the floor", token: "ghp_plenty", path: "/repos/o/r/check-runs/1"},
+		{name: "token not seen yet", token: "ghp_new", path: "/repos/o/r/check-runs/1"},
+	}
+	for _, st := range steps {
+		before := sent
+		err := get(st.token, st.path)
+		var exceeded *budgetExceededError
+		if errors.As(err, &exceeded) != st.refused {
+			t.Errorf("%s: err = %v, want refused %v", st.name, err, st.refused)
+		}
+		if st.refused && exceeded.retry <= 0 {
+			t.Errorf("%s: retry = %v, want a wait", st.name, exceeded.retry)
+		}
+		if wantSent := !st.refused; (sent > before) != wantSent {
+			t.Errorf("%s: sent = %v, want %v", st.name, sent > before, wantSent)
+		}
+	}
+	if got := budget.cacheTTLFactor(); got != 3 {
+		t.Errorf("cacheTTLFactor with a low token = %d, want 3", got)
+	}
+	report("ghp_low", 4000)
+	if got := budget.cacheTTLFactor(); got != 1 {
+		t.Errorf("cacheTTLFactor after recovery = %d, want 1", got)
+	}
+	if err := get("ghp_low", "/repos/o/r/check-runs/1"); err != nil {
+		t.Errorf("recovered token: %v", err)
+	}
+}
+
+func TestBudgetWaitsForTurn(t *testing.T) {
+	limits := newRateLimitTracker()
+	req := httptest.NewRequest(http.MethodGet, "https://api.github.com/user", nil)
+	req.Header.Set("Authorization", "Bearer ghp_low")
+	resp := &http.Response{Header: http.Header{}}
+	resp.Header.Set("X-RateLimit-Limit", "5000")
+	resp.Header.Set("X-RateLimit-Remaining", "10")
+	resp.Header.Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(2*time.Second).Unix(), 10))
+	limits.record(req, resp)
+	budget, _ := newRateBudget(&RateBudgetConfig{Floor: 100, MaxWait: "1s"}, limits)
+
+	start := time.Now()
+	for range 2 {
+		if err := budget.wait(t.Context(), "ghp_low", "core"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("second request went out after %v, want it paced", elapsed)
+	}
+}
@@ -0,0 +1,322 @@
+package checkproxy
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultValidationCacheSize bounds the validation cache when
+// validation_cache_size is unset. Each entry is one (token, repository) or
+// (token, repository, permission) result, so this covers thousands of agents.
+const defaultValidationCacheSize = 10000
+
+// validationCache is a size-bounded LRU of validation results. Expired
+// entries are dropped lazily when looked up; the least recently used entry
+// is evicted when the cache is full.
+type validationCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	order      *list.List // front is most recently used
+	items      map[string]*list.Element
+
+	hits, misses, evictions uint64
+}
+
+// cacheStats is a snapshot of validationCache counters.
+type cacheStats struct {
+	Entries   int    `json:"entries"`
+	Capacity  int    `json:"capacity"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+}
+
+type cacheItem struct {
+	key   string
+	tags  cacheTags
+	entry cacheEntry
+}
+
+// cacheTags record what an entry is about so it can be flushed by token or
+// repository, since keys are opaque hashes.
+type cacheTags struct {
+	token string // tokenFingerprint of the client token
+	repo  string // lower-case owner/repo; empty for per-token entries
+}
+
+// logAttrs identifies an entry in debug logs, by the same token fingerprint
+// prefix the audit log uses.
+func (t cacheTags) logAttrs(redact *logRedactor) []any {
+	return []any{"token", t.token[:min(len(t.token), auditFingerprintLen)], "repo", redact.value(t.repo)}
+}
+
+func newValidationCache(maxEntries int) *validationCache {
+	if maxEntries <= 0 {
+		maxEntries = defaultValidationCacheSize
+	}
+	return &validationCache{
+		maxEntries: maxEntries,
+		order:      list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+// get returns the unexpired entry for key, marking it recently used.
+// Expired entries stay until they are replaced or evicted, so peek can still
+// fall back on them while GitHub is unreachable.
+func (c *validationCache) get(key string) (cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		c.misses++
+		return cacheEntry{}, false
+	}
+	item := el.Value.(*cacheItem)
+	if !time.Now().Before(item.entry.expires) {
+		c.misses++
+		return cacheEntry{}, false
+	}
+	c.order.MoveToFront(el)
+	c.hits++
+	return item.entry, true
+}
+
+// peek returns the entry for key, expired or not, without counting a lookup
+// or changing its recency.
+func (c *validationCache) peek(key string) (cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		return el.Value.(*cacheItem).entry, true
+	}
+	return cacheEntry{}, false
+}
+
+// add stores entry under key, evicting the least recently used entry if the
+// cache is full.
+func (c *validationCache) add(key string, tags cacheTags, entry cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		el.Value.(*cacheItem).entry = entry
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(&cacheItem{key: key, tags: tags, entry: entry})
+	for c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheItem).key)
+		c.evictions++
+	}
+}
+
+// flush removes the entries for tokens whose fingerprint starts with
+// tokenFP and for repository repo; an empty filter matches everything. A
+// token's per-token entries (installation lists, org membership) go with
+// it, but not with a repository flush. It returns how many were removed.
+func (c *validationCache) flush(tokenFP, repo string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for key, el := range c.items {
+		tags := el.Value.(*cacheItem).tags
+		if !strings.HasPrefix(tags.token, tokenFP) || (repo != "" && tags.repo != repo) {
+			continue
+		}
+		c.order.Remove(el)
+		delete(c.items, key)
+		n++
+	}
+	return n
+}
+
+// stats returns the current entry count and counters. Evictions count only
+// entries pushed out by the size bound, not expired ones.
+func (c *validationCache) stats() cacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return cacheStats{
+		Entries:   c.order.Len(),
+		Capacity:  c.maxEntries,
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+	}
+}
+
+// ValidationCachePath returns the file the validation cache is persisted to
+// when persist_validation_cache is enabled.
+func ValidationCachePath() string {
+	return filepath.Join(filepath.Dir(ConfigPath()), "validation-cache.json")
+}
+
+// persistedEntry is the on-disk form of a cache entry. Keys and token tags
+// are SHA-256 hashes, so the file holds no tokens.
+type persistedEntry struct {
+	Key           string            `json:"key"`
+	Token         string            `json:"token"`
+	Repo          string            `json:"repo,omitempty"`
+	Allowed       bool              `json:"allowed"`
+	DefaultBranch string            `json:"default_branch,omitempty"`
+	Accepted      string            `json:"accepted,omitempty"`
+	Repos         map[string]string `json:"repos,omitempty"`
+	TokenExpires  time.Time         `json:"token_expires,omitzero"`
+	Expires       time.Time         `json:"expires"`
+}
+
+func newPersistedEntry(key string, tags cacheTags, entry cacheEntry) persistedEntry {
+	return persistedEntry{
+		Key:           key,
+		Token:         tags.token,
+		Repo:          tags.repo,
+		Allowed:       entry.allowed,
+		DefaultBranch: entry.defaultBranch,
+		Accepted:      entry.accepted,
+		Repos:         entry.repos,
+		TokenExpires:  entry.tokenExpires,
+		Expires:       entry.expires,
+	}
+}
+
+func (e persistedEntry) entry() cacheEntry {
+	return cacheEntry{
+		allowed:       e.Allowed,
+		defaultBranch: e.DefaultBranch,
+		accepted:      e.Accepted,
+		repos:         e.Repos,
+		tokenExpires:  e.TokenExpires,
+		expires:       e.Expires,
+	}
+}
+
+// sharedKey names an entry in Redis. The tags are part of the name so that
+// a flush can find entries by pattern.
+func (t cacheTags) sharedKey(key string) string {
+	return "v:" + t.token + ":" + t.repo + ":" + key
+}
+
+// sharedFlushPattern matches the Redis keys flush(tokenFP, repo) removes.
+func sharedFlushPattern(tokenFP, repo string) string {
+	if repo == "" {
+		return "v:" + tokenFP + "*"
+	}
+	return "v:" + tokenFP + "*:" + repo + ":*"
+}
+
+// save writes the unexpired entries to path, least recently used first, so
+// that load restores their order.
+func (c *validationCache) save(path string) error {
+	now := time.Now()
+	c.mu.Lock()
+	entries := make([]persistedEntry, 0, c.order.Len())
+	for el := c.order.Back(); el != nil; el = el.Prev() {
+		item := el.Value.(*cacheItem)
+		if !now.Before(item.entry.expires) {
+			continue
+		}
+		entries = append(entries, newPersistedEntry(item.key, item.tags, item.entry))
+	}
+	c.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	// Write and rename so a crash mid-save never leaves a truncated file.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// load adds the unexpired entries saved at path and returns how many it
+// restored. A missing file is not an error.
+func (c *validationCache) load(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var entries []persistedEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	n := 0
+	for _, e := range entries {
+		if !now.Before(e.Expires) {
+			continue
+		}
+		c.add(e.Key, cacheTags{token: e.Token, repo: e.Repo}, e.entry())
+		n++
+	}
+	return n, nil
+}
+
+// persistEvery saves the cache to path at each interval, until done is
+// closed, so a crash loses little; a clean shutdown saves once more.
+func (c *validationCache) persistEvery(done <-chan struct{}, path string, interval time.Duration) {
+	for range ticks(done, interval) {
+		if err := c.save(path); err != nil {
+			slog.Warn("saving validation cache failed", "error", err)
+		}
+	}
+}
+
+// runCache implements `gh-checkproxy cache flush`, which asks the server
+// running on this host to drop cached validation results and responses.
+func runCache(args []string) error {
+	const usage = "usage: gh-checkproxy cache flush [--token-fp <fingerprint>] [--repo <owner/repo>]"
+	if len(args) == 0 || args[0] != "flush" {
+		return errors.New(usage)
+	}
+	fs := flag.NewFlagSet("cache flush", flag.ContinueOnError)
+	var req cacheFlushRequest
+	fs.StringVar(&req.TokenFingerprint, "token-fp", "", "Flush results for this token fingerprint (or 16+ digit prefix)")
+	fs.StringVar(&req.Repo, "repo", "", "Flush results for this repository")
+	positional, err := parseInterspersed(fs, args[1:])
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return errors.New(usage)
+	}
+	if err := req.validate(); err != nil {
+		return err
+	}
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	var result struct {
+		Flushed   int  `json:"flushed"`
+		Responses *int `json:"responses"`
+	}
+	if _, err := adminRequest(cfg, "POST", "/v1/admin/cache/flush", req, &result); err != nil {
+		return err
+	}
+	if result.Responses != nil {
+		fmt.Printf("Flushed %d cached validation results and %d cached responses.\n", result.Flushed, *result.Responses)
+	} else {
+		fmt.Printf("Flushed %d cached validation results.\n", result.Flushed)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package checkproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// commitChecksRoute is a /v1/repos/{owner}/{repo}/commits/{sha}/{leaf} endpoint.
+type commitChecksRoute func(w http.ResponseWriter, r *http.Request, up upstreamTarget, owner, repo, sha string)
+
+// commitChecksHandler serves the /v1/repos/{owner}/{repo}/commits/{sha}/...
+// endpoints. Access is checked exactly as for passthrough requests before the
+// matching endpoint runs.
+func (s *server) commitChecksHandler(cfg *Config) http.HandlerFunc {
+	client := s.upstreamClient(s.upstream, s.timeouts.def)
+	routes := map[string]commitChecksRoute{
+		"all-checks":     allChecks(client),
+		"checks-summary": checksSummary(client),
+		"checks":         longPollChecks(s.hub, s.listenTimeouts),
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		i := strings.LastIndex(r.URL.Path, "/")
+		endpoint, ok := routes[r.URL.Path[i+1:]]
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		owner, repo, sha, ok := parseCommitStreamPath(r.URL.Path[:i], "/v1", "")
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		up, ok := s.selectUpstream(w, r, cfg, owner)
+		if !ok {
+			return
+		}
+		if !s.authorizeRepo(w, r, cfg, up, owner, repo, resourceProbe{groups: []string{"checks", "statuses"}, ref: sha}) {
+			return
+		}
+		endpoint(w, r, up, owner, repo, sha)
+	}
+}
+
+// allChecks returns every check run and the combined status for the commit in
+// one response, fetched upstream concurrently. GitHub's JSON is passed
+// through as-is.
+func allChecks(client *http.Client) commitChecksRoute {
+	return func(w http.ResponseWriter, r *http.Request, up upstreamTarget, owner, repo, sha string) {
+		runs, combined, err := fetchCommitChecks[json.RawMessage, json.RawMessage](client, up.token, up.apiBase, owner, repo, sha)
+		if err != nil {
+			writeUpstreamError(w, err)
+			return
+		}
+		if runs == nil {
+			runs = []json.RawMessage{}
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"total_count":     len(runs),
+			"check_runs":      runs,
+			"combined_status": combined,
+		})
+	}
+}
+
+// checksSummary returns the commit's checks already deduplicated, bucketed,
+// and counted — the same model pr checks displays — so thin clients don't
+// have to reimplement the aggregation. The state's etag doubles as the
+// response ETag.
+func checksSummary(client *http.Client) commitChecksRoute {
+	return func(w http.ResponseWriter, r *http.Request, up upstreamTarget, owner, repo, sha string) {
+		checks, counts, err := fetchUpstreamChecks(client, up, owner, repo, sha)
+		if err != nil {
+			writeUpstreamError(w, err)
+			return
+		}
+		writeCheckState(w, newCheckState(owner, repo, sha, checks, counts, ""), r.Header.Get("If-None-Match"))
+	}
+}
+
+// maxLongPollWait caps how long a long-poll request may be held open.
+const maxLongPollWait = 5 * time.Minute
+
+// longPollChecks returns the aggregated check state, optionally holding the
+// request open (?wait=60s) until it differs from the caller's etag
+// (?etag=... or If-None-Match). If nothing changes before the wait expires
+// the response is 304. State comes from the same shared watch as the
+// streaming endpoints, so many waiting clients cost one upstream poll. The
+// write deadline is extended by the wait past the one in timeouts.
+func longPollChecks(hub *watchHub, timeouts serverTimeoutPolicy) commitChecksRoute {
+	return func(w http.ResponseWriter, r *http.Request, up upstreamTarget, owner, repo, sha string) {
+		q := r.URL.Query()
+		var wait time.Duration
+		if s := q.Get("wait"); s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil || d < 0 {
+				http.Error(w, fmt.Sprintf("bad request: invalid wait %q", s), http.StatusBadRequest)
+				return
+			}
+			wait = min(d, maxLongPollWait)
+		}
+		etag := firstNonEmpty(q.Get("etag"), r.Header.Get("If-None-Match"))
+		if etag != "" && !strings.HasPrefix(etag, `"`) {
+			etag = `"` + etag + `"`
+		}
+
+		timeouts.extendWriteDeadline(w, wait)
+		states, unsubscribe := hub.subscribe(up, owner, repo, sha)
+		defer unsubscribe()
+		var deadline <-chan time.Time // nil without wait: answer with the first state
+		if wait > 0 {
+			t := time.NewTimer(wait)
+			defer t.Stop()
+			deadline = t.C
+		}
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-deadline:
+				w.Header().Set("ETag", etag)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			case state := <-states:
+				if wait > 0 && state.ETag == etag {
+					continue
+				}
+				if state.Error != "" {
+					http.Error(w, "upstream error: "+state.Error, http.StatusBadGateway)
+					return
+				}
+				writeCheckState(w, state, etag)
+				return
+			}
+		}
+	}
+}
+
+// writeCheckState writes state as JSON, answering 304 if the client's etag
+// shows it already has it.
+func writeCheckState(w http.ResponseWriter, state checkState, clientETag string) {
+	w.Header().Set("ETag", state.ETag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if clientETag == state.ETag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSON(w, http.StatusOK, state)
+}
+
+// fetchUpstreamChecks aggregates a commit's checks straight from upstream.
+func fetchUpstreamChecks(client *http.Client, up upstreamTarget, owner, repo, sha string) ([]check, checkCounts, error) {
+	runs, combined, err := fetchCommitChecks[checkRun, combinedStatus](client, up.token, up.apiBase, owner, repo, sha)
+	if err != nil {
+		return nil, checkCounts{}, err
+	}
+	return aggregateChecks(client, up.token, up.apiBase, owner, repo, sha, runs, combined)
+}
+
+// writeUpstreamError maps a failed upstream fetch to a response: GitHub's
+// 403/404/422 pass through, anything else is a bad gateway.
+func writeUpstreamError(w http.ResponseWriter, err error) {
+	if writeUpstreamRefused(w, err) {
+		return
+	}
+	var se *statusError
+	if errors.As(err, &se) {
+		switch se.Code {
+		case http.StatusForbidden, http.StatusNotFound, http.StatusUnprocessableEntity:
+			http.Error(w, fmt.Sprintf("upstream returned %d for %s", se.Code, se.Resource), se.Code)
+			return
+		}
+		http.Error(w, fmt.Sprintf("upstream error: upstream returned %d for %s", se.Code, se.Resource), http.StatusBadGateway)
+		return
+	}
+	http.Error(w, fmt.Sprintf("upstream error: %v", err), http.StatusBadGateway)
+}
@@ -1,10 +1,11 @@
-package main
+package checkproxy
 
 import (
 	"fmt"
 	"io"
 	"os"
 	"sort"
+	"strings"
 	"text/tabwriter"
 	"time"
 )
@@ -28,26 +29,28 @@ func isTTY() bool {
 	return (fi.Mode() & os.ModeCharDevice) != 0
 }
 
-// check mirrors the fields from the gh CLI aggregate.go check struct.
+// check mirrors the fields from the gh CLI aggregate.go check struct. JSON
+// names match `gh pr checks --json` so streamed state looks familiar.
 type check struct {
-	Name        string
-	State       string
-	StartedAt   time.Time
-	CompletedAt time.Time
-	Link        string
-	Bucket      string // "pass", "fail", "pending", "skipping", "cancel"
-	Event       string
-	Workflow    string
-	Description string
+	Name        string    `json:"name"`
+	State       string    `json:"state"`
+	StartedAt   time.Time `json:"startedAt"`
+	CompletedAt time.Time `json:"completedAt"`
+	Link        string    `json:"link"`
+	Bucket      string    `json:"bucket"` // "pass", "fail", "pending", "skipping", "cancel"
+	Event       string    `json:"event"`
+	Workflow    string    `json:"workflow"`
+	Description string    `json:"description"`
+	Required    bool      `json:"required,omitempty"`
 }
 
 // checkCounts tallies check states.
 type checkCounts struct {
-	Failed   int
-	Passed   int
-	Pending  int
-	Skipping int
-	Canceled int
+	Failed   int `json:"failed"`
+	Passed   int `json:"passed"`
+	Pending  int `json:"pending"`
+	Skipping int `json:"skipping"`
+	Canceled int `json:"canceled"`
 }
 
 // sortChecks sorts checks: fail first, then pending, then pass/skip/cancel, then by name.
@@ -94,6 +97,22 @@ func printSummary(out io.Writer, counts checkCounts, tty bool) {
 	fmt.Fprintf(out, "%s\n%s\n\n", headline, tallies)
 }
 
+// printMergeQueue writes the PR's merge queue position and the queue entry's
+// check state (only in TTY mode, and only when the PR is queued).
+func printMergeQueue(out io.Writer, entry *mergeQueueEntry, tty bool) {
+	if !tty || entry == nil {
+		return
+	}
+	line := fmt.Sprintf("Merge queue: %s (position %d)", strings.ToLower(entry.State), entry.Position)
+	if entry.HeadCommit.StatusCheckRollup != nil {
+		line += ", queue checks " + strings.ToLower(entry.HeadCommit.StatusCheckRollup.State)
+	}
+	if entry.EstimatedTimeToMerge != nil {
+		line += fmt.Sprintf(", estimated merge in %s", (time.Duration(*entry.EstimatedTimeToMerge) * time.Second).String())
+	}
+	fmt.Fprintf(out, "%s%s%s\n\n", ansiBold, line, ansiReset)
+}
+
 // printTable renders the checks as a table. TTY output uses colors and symbols;
 // non-TTY output uses plain tab-separated columns suitable for scripting.
 func printTable(out io.Writer, checks []check, tty bool) {
@@ -114,6 +133,9 @@ func printTable(out io.Writer, checks []check, tty bool) {
 			if c.Event != "" {
 				name += " (" + c.Event + ")"
 			}
+			if c.Required {
+				name += " (required)"
+			}
 
 			fmt.Fprintf(tw, "%s%s%s\t%s\t%s\t%s\t%s\n",
 				color, mark, ansiReset,
@@ -0,0 +1,178 @@
+package checkproxy
+
+import (
+	"fmt"
+	"os"
+)
+
+// Main runs the gh-checkproxy command line. ver and rev are the release
+// version and commit stamped into the binary, or empty for source builds.
+func Main(ver, rev string) {
+	version, commit = ver, rev
+
+	// Started by the Windows service manager, as `serve` with its flags.
+	if isWindowsService() {
+		runAsService(os.Args[2:])
+		return
+	}
+	if len(os.Args) < 2 {
+		runServe(nil, nil)
+		return
+	}
+
+	switch os.Args[1] {
+	case "config":
+		if err := runConfig(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+	case "serve":
+		runServe(os.Args[2:], nil)
+	case "stop":
+		if err := runStop(); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+	case "restart":
+		if err := runRestart(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+	case "service":
+		if err := runService(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+	case "keys":
+		if err := runKeys(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+	case "revoke":
+		if err := runRevoke(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+	case "admin":
+		if err := runAdmin(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+	case "cache":
+		if err := runCache(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+	case "status":
+		if err := runStatus(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+	case "pr":
+		if len(os.Args) < 3 || os.Args[2] != "checks" {
+			fmt.Fprintln(os.Stderr, "usage: gh-checkproxy pr checks [<number>|<url>|<branch>] [flags]")
+			os.Exit(1)
+		}
+		code, err := runPrChecks(os.Args[3:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			if code == 0 {
+				code = 1
+			}
+		}
+		os.Exit(code)
+	case "doctor":
+		if err := runDoctor(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+	case "upgrade":
+		if err := runUpgrade(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+	case "version", "--version", "-v":
+		ver, rev := buildVersion()
+		if rev != "" {
+			fmt.Printf("gh-checkproxy %s (%s)\n", ver, rev)
+		} else {
+			fmt.Printf("gh-checkproxy %s\n", ver)
+		}
+	case "help", "--help", "-h":
+		printHelp()
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n\n", os.Args[1])
+		printHelp()
+		os.Exit(1)
+	}
+}
+
+func printHelp() {
+	fmt.Print(`gh-checkproxy — GitHub Checks API proxy for fine-grained tokens
+
+SERVER COMMANDS (run on trusted host):
+  gh-checkproxy config [flags]     Configure the proxy (interactive)
+    --org <org>                      Restrict to this organization (optional)
+    --repos <patterns>               Restrict to owner/repo patterns, e.g. myorg/api-* (optional)
+    --deny-repos <patterns>          Always refuse these owner/repo patterns (optional)
+    --port <port>                    HTTP listen port (default: 8080)
+    --api-url <url|host>             GitHub API URL or GHES hostname (default: api.github.com)
+    --cache-ttl <duration>           Validation cache TTL (default: 5m)
+    --negative-cache-ttl <duration>  Cache TTL for denied validations (default: 1m)
+    --cache-size <n>                 Maximum cached validation results (default: 10000)
+    --allow-writes <groups>          Enable write routes, e.g. rerequest ("none" to disable)
+  Token: $GH_CHECKPROXY_CLASSIC_TOKEN, reuse $GH_TOKEN (when classic), or enter interactively (masked)
+  gh-checkproxy serve              Start the proxy server
+    --background                     Detach; PID and log go next to the config file
+    --log-level <level>              debug, info, warn, or error (default: info)
+    --log-format <format>            text or json (default: text)
+    --check                          Validate config, tokens, routes, and listen addresses, then exit 0 or 1
+  gh-checkproxy stop               Stop the background server
+  gh-checkproxy restart            Restart the background server (or start one)
+  gh-checkproxy service install [serve flags]
+                                   Install as a Windows service (elevated prompt)
+  gh-checkproxy service start|stop|uninstall
+                                   Control the Windows service
+  gh-checkproxy status             Show current configuration
+    --live                           Uptime, requests, cache, rate limits, and watches of the running server (needs admin token)
+    --traffic [--top <n>]            Requests, bytes, and rate limit use of the running server per org and repository
+  gh-checkproxy keys create --repo <patterns> [--org <orgs>] [--name <label>]
+                                   Mint a proxy API key (shown once) for agents
+  gh-checkproxy keys list          List API keys
+  gh-checkproxy keys delete <id>   Delete an API key
+  gh-checkproxy revoke <fingerprint> [--reason <text>]
+                                   Block a client token by SHA-256 fingerprint
+    --list                           List revoked fingerprints
+    --undo                           Lift a revocation
+  gh-checkproxy cache flush        Drop cached validations and responses on the running server (needs admin token)
+    --token-fp <fingerprint>         Only this token's results (16+ hex digits of its SHA-256)
+    --repo <owner/repo>              Only this repository's results
+  gh-checkproxy admin orgs|repos list|add|remove [<entry>...]
+                                   Edit the running server's allowed orgs or repo patterns (needs admin token)
+  gh-checkproxy doctor             Diagnose the server and/or client setup on this host, with fixes
+    --server, --client               Run those checks even if nothing is configured for them
+    --repo <owner/repo>              Repository to validate the client token against
+  gh-checkproxy --version          Print the version and git commit
+  gh-checkproxy upgrade            Replace this binary with the latest release (checksum-verified)
+    --check                          Only report whether a newer release exists
+    --version <tag>                  Install this release instead of the latest
+    --force                          Also replace development and package-managed binaries
+
+CLIENT COMMANDS (run on agent machine):
+  gh-checkproxy pr checks [<number>|<url>|<branch>] [flags]
+    --repo <owner/repo>              Repository (auto-detected from git remote)
+    --hostname <host>                GitHub Enterprise Server hostname (or $GH_HOST)
+    --upstream <name>                Named proxy upstream (or $GH_CHECKPROXY_UPSTREAM)
+    --proxy-url <url>                Proxy URL (or $GH_CHECKPROXY_URL)
+    --token <token>                  Fine-grained token (or $GH_TOKEN / $GITHUB_TOKEN)
+    --watch                          Watch until checks complete
+    --fail-fast                      Exit on first failure (requires --watch)
+    --interval <duration>            Refresh interval in watch mode (default: 10s)
+    --required                       Only show required checks
+
+  Exit codes:
+    0   All checks passed
+    1   Some checks failed
+    8   Checks still pending
+`)
+}
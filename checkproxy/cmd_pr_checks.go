@@ -0,0 +1,975 @@
+package checkproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pendingExitCode matches the gh CLI convention: exit 8 when checks are still pending.
+const pendingExitCode = 8
+
+// prInfo holds the fields we need from the GitHub PR API response.
+type prInfo struct {
+	Number int `json:"number"`
+	Head   struct {
+		SHA string `json:"sha"`
+		Ref string `json:"ref"`
+	} `json:"head"`
+	Base struct {
+		Ref string `json:"ref"`
+	} `json:"base"`
+	HeadRefName string `json:"head_ref"`
+}
+
+// GitHub REST API response types for checks and statuses.
+
+type checkRun struct {
+	Name        string    `json:"name"`
+	Status      string    `json:"status"`
+	Conclusion  string    `json:"conclusion"`
+	StartedAt   time.Time `json:"started_at"`
+	CompletedAt time.Time `json:"completed_at"`
+	HTMLURL     string    `json:"html_url"`
+	Output      struct {
+		Title   string `json:"title"`
+		Summary string `json:"summary"`
+	} `json:"output"`
+	CheckSuite struct {
+		App struct {
+			Slug string `json:"slug"`
+		} `json:"app"`
+	} `json:"check_suite"`
+}
+
+type requiredStatusChecks struct {
+	Contexts []string `json:"contexts"`
+	Checks   []struct {
+		Context string `json:"context"`
+	} `json:"checks"`
+}
+
+type deployment struct {
+	ID          int64  `json:"id"`
+	Environment string `json:"environment"`
+}
+
+type deploymentStatus struct {
+	State       string    `json:"state"`
+	Description string    `json:"description"`
+	LogURL      string    `json:"log_url"`
+	TargetURL   string    `json:"target_url"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// mergeQueueEntry is the PR's entry in the base branch merge queue, if any.
+type mergeQueueEntry struct {
+	State                string `json:"state"`
+	Position             int    `json:"position"`
+	EstimatedTimeToMerge *int   `json:"estimatedTimeToMerge"`
+	HeadCommit           struct {
+		OID               string `json:"oid"`
+		StatusCheckRollup *struct {
+			State string `json:"state"`
+		} `json:"statusCheckRollup"`
+	} `json:"headCommit"`
+}
+
+// branchRule is one entry from GET /repos/{owner}/{repo}/rules/branches/{branch}.
+type branchRule struct {
+	Type       string `json:"type"`
+	Parameters struct {
+		RequiredStatusChecks []struct {
+			Context string `json:"context"`
+		} `json:"required_status_checks"`
+	} `json:"parameters"`
+}
+
+type combinedStatus struct {
+	State    string         `json:"state"`
+	Statuses []commitStatus `json:"statuses"`
+}
+
+type commitStatus struct {
+	State       string    `json:"state"`
+	Context     string    `json:"context"`
+	Description string    `json:"description"`
+	TargetURL   string    `json:"target_url"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// runPrChecks is the entry point for `gh-checkproxy pr checks`.
+// Returns (exitCode, error). exitCode 0=pass, 1=fail, 8=pending.
+func runPrChecks(args []string) (int, error) {
+	fs := flag.NewFlagSet("pr checks", flag.ContinueOnError)
+	repo := fs.String("repo", "", "Repository in owner/repo format (auto-detected from git remote)")
+	proxyURL := fs.String("proxy-url", "", "Proxy server base URL (or $GH_CHECKPROXY_URL)")
+	hostname := fs.String("hostname", "", "GitHub hostname for Enterprise Server (or $GH_HOST; default: github.com)")
+	upstream := fs.String("upstream", "", "Named proxy upstream to use (or $GH_CHECKPROXY_UPSTREAM)")
+	token := fs.String("token", "", "Fine-grained GitHub token (or $GH_TOKEN / $GITHUB_TOKEN)")
+	watch := fs.Bool("watch", false, "Watch checks until they finish")
+	failFast := fs.Bool("fail-fast", false, "Exit on first failure in watch mode (requires --watch)")
+	interval := fs.Duration("interval", 10*time.Second, "Refresh interval in watch mode")
+	requiredOnly := fs.Bool("required", false, "Only show required checks")
+
+	// parseInterspersed allows flags and positional args in any order.
+	// Go's flag package stops at the first non-flag arg, so we loop: parse
+	// flags, consume one positional arg, repeat.
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return 1, err
+	}
+
+	if *failFast && !*watch {
+		return 1, fmt.Errorf("--fail-fast requires --watch")
+	}
+
+	// Resolve fine-grained token. Agents with an HMAC secret don't need one.
+	hmacID, hmacSecret := os.Getenv("GH_CHECKPROXY_HMAC_ID"), os.Getenv("GH_CHECKPROXY_HMAC_SECRET")
+	fgToken := firstNonEmpty(*token, os.Getenv("GH_TOKEN"), os.Getenv("GITHUB_TOKEN"))
+	if fgToken == "" && (hmacID == "" || hmacSecret == "") {
+		return 1, fmt.Errorf("no token: set GH_TOKEN, GITHUB_TOKEN, or use --token")
+	}
+
+	// Resolve proxy URL.
+	pURL := firstNonEmpty(*proxyURL, os.Getenv("GH_CHECKPROXY_URL"))
+	if pURL == "" {
+		return 1, fmt.Errorf("no proxy URL: set GH_CHECKPROXY_URL or use --proxy-url")
+	}
+	pURL = strings.TrimRight(pURL, "/")
+
+	// Resolve GitHub host (github.com or an Enterprise Server) for remote detection.
+	host := firstNonEmpty(*hostname, os.Getenv("GH_HOST"), "github.com")
+
+	// Resolve owner/repo.
+	repoStr := *repo
+	if repoStr == "" {
+		repoStr = detectRepo(host)
+	}
+	if repoStr == "" {
+		return 1, fmt.Errorf("could not detect repository: use --repo owner/repo")
+	}
+	repoParts := strings.SplitN(repoStr, "/", 2)
+	if len(repoParts) != 2 || repoParts[0] == "" || repoParts[1] == "" {
+		return 1, fmt.Errorf("invalid repo format %q: use owner/repo", repoStr)
+	}
+	owner, repoName := repoParts[0], repoParts[1]
+
+	selector := ""
+	if len(positional) > 0 {
+		selector = positional[0]
+	}
+
+	httpClient := &http.Client{Timeout: 15 * time.Second}
+	proxyHost := ""
+	if u, err := url.Parse(pURL); err == nil {
+		proxyHost = u.Host
+	}
+	var transport http.RoundTripper = http.DefaultTransport
+	if name := firstNonEmpty(*upstream, os.Getenv("GH_CHECKPROXY_UPSTREAM")); name != "" {
+		transport = &upstreamTransport{proxyHost: proxyHost, name: name, next: transport}
+	}
+	if fgToken == "" {
+		transport = &hmacTransport{proxyHost: proxyHost, id: hmacID, secret: hmacSecret, next: transport}
+	}
+	transport = &expiryNoticeTransport{next: transport}
+	httpClient.Transport = transport
+
+	// PR lookup goes through the proxy too, so the client never needs direct
+	// access to the GitHub API.
+	pr, err := findPR(httpClient, pURL, fgToken, owner, repoName, selector)
+	if err != nil {
+		return 1, fmt.Errorf("finding PR: %w", err)
+	}
+
+	tty := isTTY()
+	out := os.Stdout
+
+	// Required checks come from the base branch protection. They rarely change,
+	// so fetch them once rather than on every watch refresh.
+	required, err := fetchRequiredChecks(httpClient, fgToken, pURL, owner, repoName, pr.Base.Ref)
+	if err != nil && *requiredOnly {
+		return 1, fmt.Errorf("fetching required checks: %w", err)
+	}
+	if *requiredOnly && len(required) == 0 {
+		return 1, fmt.Errorf("no required checks reported on the '%s' branch", pr.Base.Ref)
+	}
+
+	checks, counts, err := fetchAndAggregateChecks(httpClient, fgToken, pURL, owner, repoName, pr.Head.SHA)
+	if err != nil {
+		return 1, err
+	}
+	checks, counts = applyRequired(checks, counts, required, *requiredOnly)
+	queue := fetchMergeQueueEntry(httpClient, fgToken, pURL, owner, repoName, pr.Number)
+
+	if *watch {
+		// Prefer the proxy's event stream; it pushes changes instead of us
+		// re-fetching every check each interval. Fall back to polling when the
+		// proxy doesn't offer it or the stream drops.
+		streamClient := &http.Client{Transport: httpClient.Transport}
+		updates, err := subscribeCheckEvents(streamClient, fgToken, pURL, owner, repoName, pr.Head.SHA)
+		if err != nil {
+			updates = nil
+		}
+
+		for {
+			if tty {
+				// Clear screen and move cursor to top.
+				fmt.Fprint(out, "\033[2J\033[H")
+				if updates != nil {
+					fmt.Fprint(out, "Streaming check updates from the proxy. Press Ctrl+C to quit.\n\n")
+				} else {
+					fmt.Fprintf(out, "Refreshing checks status every %.0fs. Press Ctrl+C to quit.\n\n",
+						interval.Seconds())
+				}
+			}
+
+			printMergeQueue(out, queue, tty)
+			printSummary(out, counts, tty)
+			printTable(out, checks, tty)
+
+			if counts.Pending == 0 {
+				break
+			}
+			if *failFast && counts.Failed > 0 {
+				break
+			}
+
+			var state checkState
+			ok := false
+			if updates != nil {
+				state, ok = <-updates
+			}
+			switch {
+			case ok && state.Error != "":
+				return 1, fmt.Errorf("proxy event stream: %s", state.Error)
+			case ok:
+				checks, counts = state.Checks, state.Counts
+			default:
+				updates = nil
+				time.Sleep(*interval)
+				for {
+					checks, counts, err = fetchAndAggregateChecks(httpClient, fgToken, pURL, owner, repoName, pr.Head.SHA)
+					var se *statusError
+					if !errors.As(err, &se) || se.RetryAfter == 0 {
+						break
+					}
+					fmt.Fprintf(os.Stderr, "Proxy returned %d (GitHub is unavailable or rate-limited); retrying in %s\n", se.Code, se.RetryAfter)
+					time.Sleep(se.RetryAfter)
+				}
+				if err != nil {
+					return 1, err
+				}
+			}
+			checks, counts = applyRequired(checks, counts, required, *requiredOnly)
+			queue = fetchMergeQueueEntry(httpClient, fgToken, pURL, owner, repoName, pr.Number)
+		}
+
+		// Print final result after watch ends.
+		if tty {
+			fmt.Fprint(out, "\033[2J\033[H")
+		}
+		printMergeQueue(out, queue, tty)
+		printSummary(out, counts, tty)
+		printTable(out, checks, tty)
+	} else {
+		printMergeQueue(out, queue, tty)
+		printSummary(out, counts, tty)
+		printTable(out, checks, tty)
+	}
+
+	if counts.Failed > 0 {
+		return 1, nil
+	}
+	if counts.Pending > 0 {
+		return pendingExitCode, nil
+	}
+	return 0, nil
+}
+
+// upstreamTransport tags requests sent to the proxy with the selected upstream
+// name. Requests to other hosts (direct GitHub API calls) are left untouched.
+type upstreamTransport struct {
+	proxyHost string
+	name      string
+	next      http.RoundTripper
+}
+
+func (t *upstreamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host == t.proxyHost {
+		req = req.Clone(req.Context())
+		req.Header.Set(upstreamHeader, t.name)
+	}
+	return t.next.RoundTrip(req)
+}
+
+// expiryNoticeTransport prints a one-time notice on stderr when the proxy
+// reports that the client token expires soon.
+type expiryNoticeTransport struct {
+	once sync.Once
+	next http.RoundTripper
+}
+
+func (t *expiryNoticeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if exp, perr := time.Parse(time.RFC3339, resp.Header.Get(tokenExpirationHeader)); perr == nil {
+		t.once.Do(func() {
+			days := int(time.Until(exp).Hours() / 24)
+			fmt.Fprintf(os.Stderr, "notice: your GitHub token expires in %d day(s), on %s — rotate it soon\n", days, exp.Local().Format("2006-01-02 15:04 MST"))
+		})
+	}
+	return resp, nil
+}
+
+// findPR resolves a PR by number, URL, branch name, or current branch.
+func findPR(client *http.Client, proxyBase, token, owner, repo, selector string) (*prInfo, error) {
+	// No selector: use the current git branch.
+	if selector == "" {
+		branch, err := currentBranch()
+		if err != nil {
+			return nil, fmt.Errorf("no PR selector provided and could not detect current branch: %w", err)
+		}
+		return findPRByBranch(client, proxyBase, token, owner, repo, branch)
+	}
+
+	// Strip leading #.
+	selector = strings.TrimPrefix(selector, "#")
+
+	// PR number.
+	if n, err := strconv.Atoi(selector); err == nil {
+		apiURL := fmt.Sprintf("%s/repos/%s/%s/pulls/%d", proxyBase, owner, repo, n)
+		return fetchSinglePR(client, token, apiURL)
+	}
+
+	// PR URL: extract number.
+	if strings.HasPrefix(selector, "https://") || strings.HasPrefix(selector, "http://") {
+		prURLRe := regexp.MustCompile(`/pull/(\d+)`)
+		if m := prURLRe.FindStringSubmatch(selector); len(m) >= 2 {
+			n, _ := strconv.Atoi(m[1])
+			apiURL := fmt.Sprintf("%s/repos/%s/%s/pulls/%d", proxyBase, owner, repo, n)
+			return fetchSinglePR(client, token, apiURL)
+		}
+	}
+
+	// Treat as branch name.
+	return findPRByBranch(client, proxyBase, token, owner, repo, selector)
+}
+
+func findPRByBranch(client *http.Client, proxyBase, token, owner, repo, branch string) (*prInfo, error) {
+	apiURL := fmt.Sprintf(
+		"%s/repos/%s/%s/pulls?head=%s:%s&state=open&per_page=5",
+		proxyBase, owner, repo,
+		url.QueryEscape(owner), url.QueryEscape(branch),
+	)
+	prs, err := fetchPRList(client, token, apiURL)
+	if err != nil {
+		return nil, err
+	}
+	if len(prs) == 0 {
+		return nil, fmt.Errorf("no open pull request found for branch %q in %s/%s", branch, owner, repo)
+	}
+	return &prs[0], nil
+}
+
+func fetchSinglePR(client *http.Client, token, apiURL string) (*prInfo, error) {
+	req, err := http.NewRequest(http.MethodGet, apiURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	setGitHubHeaders(req, token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("pull request not found (verify the PR number and that the token has Metadata: read access to the repository)")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("proxy returned %d for pull request", resp.StatusCode)
+	}
+	var pr prInfo
+	if err := json.NewDecoder(resp.Body).Decode(&pr); err != nil {
+		return nil, err
+	}
+	return &pr, nil
+}
+
+func fetchPRList(client *http.Client, token, apiURL string) ([]prInfo, error) {
+	req, err := http.NewRequest(http.MethodGet, apiURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	setGitHubHeaders(req, token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("proxy returned %d for pull requests", resp.StatusCode)
+	}
+	var prs []prInfo
+	if err := json.NewDecoder(resp.Body).Decode(&prs); err != nil {
+		return nil, err
+	}
+	return prs, nil
+}
+
+// fetchAndAggregateChecks retrieves check runs and commit statuses via the proxy,
+// then aggregates them into the unified check slice used for display. It uses
+// the proxy's batch endpoint when available, so a refresh is one round trip.
+func fetchAndAggregateChecks(client *http.Client, token, proxyBase, owner, repo, sha string) ([]check, checkCounts, error) {
+	runs, combined, err := fetchAllChecks(client, token, proxyBase, owner, repo, sha)
+	var se *statusError
+	if errors.As(err, &se) && se.Code == http.StatusNotFound {
+		// Proxies that predate /v1/.../all-checks.
+		runs, combined, err = fetchCommitChecks[checkRun, combinedStatus](client, token, proxyBase, owner, repo, sha)
+	}
+	if err != nil {
+		return nil, checkCounts{}, err
+	}
+	return aggregateChecks(client, token, proxyBase, owner, repo, sha, runs, combined)
+}
+
+// fetchAllChecks uses the proxy's batch endpoint to get check runs and the
+// combined status in one request.
+func fetchAllChecks(client *http.Client, token, proxyBase, owner, repo, sha string) ([]checkRun, combinedStatus, error) {
+	var result struct {
+		CheckRuns      []checkRun     `json:"check_runs"`
+		CombinedStatus combinedStatus `json:"combined_status"`
+	}
+	reqURL := fmt.Sprintf("%s/v1/repos/%s/%s/commits/%s/all-checks", proxyBase, owner, repo, sha)
+	if err := getJSON(client, token, reqURL, "all-checks", &result); err != nil {
+		return nil, combinedStatus{}, err
+	}
+	return result.CheckRuns, result.CombinedStatus, nil
+}
+
+// fetchCommitChecks fetches every page of check runs and the combined status
+// for sha concurrently. R and S are the types to decode into, so the server
+// can pass GitHub's JSON through untouched.
+func fetchCommitChecks[R, S any](client *http.Client, token, base, owner, repo, sha string) ([]R, S, error) {
+	var (
+		runs             []R
+		combined         S
+		runsErr, statErr error
+		wg               sync.WaitGroup
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		checkRunsURL := fmt.Sprintf("%s/repos/%s/%s/commits/%s/check-runs?per_page=100", base, owner, repo, sha)
+		runs, runsErr = fetchCheckRunPages[R](client, token, checkRunsURL)
+	}()
+	statusURL := fmt.Sprintf("%s/repos/%s/%s/commits/%s/status?per_page=100", base, owner, repo, sha)
+	statErr = getJSON(client, token, statusURL, "commit status", &combined)
+	wg.Wait()
+
+	if runsErr != nil {
+		return nil, combined, fmt.Errorf("fetching check runs: %w", runsErr)
+	}
+	if statErr != nil {
+		return nil, combined, fmt.Errorf("fetching commit status: %w", statErr)
+	}
+	return runs, combined, nil
+}
+
+// aggregateChecks turns check runs, commit statuses, and deployments into
+// the unified check slice used for display.
+func aggregateChecks(client *http.Client, token, proxyBase, owner, repo, sha string, runs []checkRun, combined combinedStatus) ([]check, checkCounts, error) {
+	var checks []check
+	var counts checkCounts
+
+	// Deduplicate check runs by name: sort by StartedAt descending, keep first seen.
+	sortCheckRunsByTime(runs)
+	seenRuns := make(map[string]struct{})
+	for _, run := range runs {
+		if _, exists := seenRuns[run.Name]; exists {
+			continue
+		}
+		seenRuns[run.Name] = struct{}{}
+		c := checkFromRun(run)
+		incrementCounts(&counts, c.Bucket)
+		checks = append(checks, c)
+	}
+
+	// Add commit statuses (deduplicated by context name).
+	seenContexts := make(map[string]struct{})
+	for _, s := range combined.Statuses {
+		if _, exists := seenContexts[s.Context]; exists {
+			continue
+		}
+		seenContexts[s.Context] = struct{}{}
+		c := checkFromStatus(s)
+		incrementCounts(&counts, c.Bucket)
+		checks = append(checks, c)
+	}
+
+	deployments, err := fetchDeploymentChecks(client, token, proxyBase, owner, repo, sha)
+	if err != nil {
+		return nil, checkCounts{}, fmt.Errorf("fetching deployments: %w", err)
+	}
+	for _, c := range deployments {
+		incrementCounts(&counts, c.Bucket)
+		checks = append(checks, c)
+	}
+
+	return checks, counts, nil
+}
+
+// fetchDeploymentChecks returns one check per environment deployed from sha,
+// using the latest deployment and its latest status. Proxies that predate the
+// deployments routes answer 404, which yields no checks.
+func fetchDeploymentChecks(client *http.Client, token, proxyBase, owner, repo, sha string) ([]check, error) {
+	deploymentsURL := fmt.Sprintf("%s/repos/%s/%s/deployments?sha=%s&per_page=100", proxyBase, owner, repo, sha)
+	var deployments []deployment
+	found, err := fetchOptionalJSON(client, token, deploymentsURL, &deployments)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	// Deployments are returned newest first; keep the latest per environment.
+	var checks []check
+	seenEnvs := make(map[string]struct{})
+	for _, d := range deployments {
+		if _, exists := seenEnvs[d.Environment]; exists {
+			continue
+		}
+		seenEnvs[d.Environment] = struct{}{}
+
+		statusesURL := fmt.Sprintf("%s/repos/%s/%s/deployments/%d/statuses?per_page=1", proxyBase, owner, repo, d.ID)
+		var statuses []deploymentStatus
+		if _, err := fetchOptionalJSON(client, token, statusesURL, &statuses); err != nil {
+			return nil, err
+		}
+		var latest deploymentStatus
+		if len(statuses) > 0 {
+			latest = statuses[0]
+		}
+		checks = append(checks, checkFromDeployment(d, latest))
+	}
+	return checks, nil
+}
+
+// applyRequired marks checks named in required and, when only is set, drops
+// every other check and recomputes the counts.
+func applyRequired(checks []check, counts checkCounts, required map[string]struct{}, only bool) ([]check, checkCounts) {
+	if len(required) == 0 {
+		return checks, counts
+	}
+	var kept []check
+	var keptCounts checkCounts
+	for _, c := range checks {
+		_, c.Required = required[c.Name]
+		if only && !c.Required {
+			continue
+		}
+		incrementCounts(&keptCounts, c.Bucket)
+		kept = append(kept, c)
+	}
+	return kept, keptCounts
+}
+
+// fetchRequiredChecks returns the set of required status check contexts for a
+// branch, combining classic branch protection and repository rulesets. An
+// unprotected branch yields an empty set.
+func fetchRequiredChecks(client *http.Client, token, proxyBase, owner, repo, branch string) (map[string]struct{}, error) {
+	if branch == "" {
+		return nil, fmt.Errorf("pull request has no base branch")
+	}
+	required := make(map[string]struct{})
+
+	protectionURL := fmt.Sprintf("%s/repos/%s/%s/branches/%s/protection/required_status_checks",
+		proxyBase, owner, repo, url.PathEscape(branch))
+	var protection requiredStatusChecks
+	found, err := fetchOptionalJSON(client, token, protectionURL, &protection)
+	if err != nil {
+		return nil, fmt.Errorf("branch protection: %w", err)
+	}
+	if found {
+		for _, ctx := range protection.Contexts {
+			required[ctx] = struct{}{}
+		}
+		for _, c := range protection.Checks {
+			required[c.Context] = struct{}{}
+		}
+	}
+
+	rulesURL := fmt.Sprintf("%s/repos/%s/%s/rules/branches/%s?per_page=100", proxyBase, owner, repo, url.PathEscape(branch))
+	var rules []branchRule
+	found, err = fetchOptionalJSON(client, token, rulesURL, &rules)
+	if err != nil {
+		return nil, fmt.Errorf("rulesets: %w", err)
+	}
+	if found {
+		for _, rule := range rules {
+			if rule.Type != "required_status_checks" {
+				continue
+			}
+			for _, c := range rule.Parameters.RequiredStatusChecks {
+				required[c.Context] = struct{}{}
+			}
+		}
+	}
+	return required, nil
+}
+
+// fetchMergeQueueEntry looks up the PR's merge queue entry through the proxy's
+// GraphQL allowlist (GitHub exposes the merge queue only via GraphQL). It is
+// informational, so any failure — including a proxy without the query —
+// yields nil.
+func fetchMergeQueueEntry(client *http.Client, token, proxyBase, owner, repo string, number int) *mergeQueueEntry {
+	body, err := json.Marshal(map[string]any{
+		"operationName": "PullRequestMergeQueueEntry",
+		"variables":     map[string]any{"owner": owner, "repo": repo, "number": number},
+	})
+	if err != nil {
+		return nil
+	}
+	req, err := http.NewRequest(http.MethodPost, proxyBase+"/graphql", bytes.NewReader(body))
+	if err != nil {
+		return nil
+	}
+	setGitHubHeaders(req, token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+
+	var result struct {
+		Data struct {
+			Repository struct {
+				PullRequest struct {
+					MergeQueueEntry *mergeQueueEntry `json:"mergeQueueEntry"`
+				} `json:"pullRequest"`
+			} `json:"repository"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil
+	}
+	return result.Data.Repository.PullRequest.MergeQueueEntry
+}
+
+// fetchOptionalJSON decodes a proxied GET response into v. A 404 is not an
+// error: it reports found=false so callers can treat the resource as absent.
+func fetchOptionalJSON(client *http.Client, token, rawURL string, v any) (found bool, err error) {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return false, err
+	}
+	setGitHubHeaders(req, token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("proxy returned %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func checkFromRun(run checkRun) check {
+	state := run.Status
+	if strings.EqualFold(run.Status, "completed") {
+		state = run.Conclusion
+	}
+
+	c := check{
+		Name:        run.Name,
+		State:       strings.ToUpper(state),
+		Link:        run.HTMLURL,
+		StartedAt:   run.StartedAt,
+		CompletedAt: run.CompletedAt,
+		Description: run.Output.Title,
+	}
+
+	switch strings.ToUpper(state) {
+	case "SUCCESS":
+		c.Bucket = "pass"
+	case "SKIPPED", "NEUTRAL":
+		c.Bucket = "skipping"
+	case "FAILURE", "ERROR", "TIMED_OUT", "ACTION_REQUIRED":
+		c.Bucket = "fail"
+	case "CANCELLED":
+		c.Bucket = "cancel"
+	default: // in_progress, queued, waiting, pending, requested, stale
+		c.Bucket = "pending"
+	}
+	return c
+}
+
+func checkFromStatus(s commitStatus) check {
+	c := check{
+		Name:        s.Context,
+		State:       strings.ToUpper(s.State),
+		Link:        s.TargetURL,
+		Description: s.Description,
+		StartedAt:   s.CreatedAt,
+		CompletedAt: s.UpdatedAt,
+	}
+
+	switch strings.ToLower(s.State) {
+	case "success":
+		c.Bucket = "pass"
+	case "failure", "error":
+		c.Bucket = "fail"
+	default: // pending
+		c.Bucket = "pending"
+	}
+	return c
+}
+
+func checkFromDeployment(d deployment, s deploymentStatus) check {
+	state := s.State
+	if state == "" {
+		state = "pending"
+	}
+	c := check{
+		Name:        "deploy: " + d.Environment,
+		State:       strings.ToUpper(state),
+		Link:        firstNonEmpty(s.LogURL, s.TargetURL),
+		Description: s.Description,
+		StartedAt:   s.CreatedAt,
+		CompletedAt: s.UpdatedAt,
+	}
+
+	switch strings.ToLower(state) {
+	case "success":
+		c.Bucket = "pass"
+	case "failure", "error":
+		c.Bucket = "fail"
+	case "inactive":
+		c.Bucket = "skipping"
+	default: // pending, queued, in_progress
+		c.Bucket = "pending"
+	}
+	return c
+}
+
+func incrementCounts(counts *checkCounts, bucket string) {
+	switch bucket {
+	case "pass":
+		counts.Passed++
+	case "fail":
+		counts.Failed++
+	case "pending":
+		counts.Pending++
+	case "skipping":
+		counts.Skipping++
+	case "cancel":
+		counts.Canceled++
+	}
+}
+
+// statusError reports a non-200 response from the proxy.
+type statusError struct {
+	Code     int
+	Resource string
+	// RetryAfter is set when the proxy is holding off GitHub (an outage or
+	// a rate limit) and says how long to wait.
+	RetryAfter time.Duration
+}
+
+// responseError builds the statusError for a non-200 response.
+func responseError(resp *http.Response, resource string) *statusError {
+	se := &statusError{Code: resp.StatusCode, Resource: resource}
+	if resp.Header.Get(upstreamStatusHeader) != "" {
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+			se.RetryAfter = time.Duration(secs) * time.Second
+		}
+	}
+	return se
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("proxy returned %d for %s", e.Code, e.Resource)
+}
+
+// fetchCheckRunPages follows Link pagination to retrieve all check runs.
+func fetchCheckRunPages[T any](client *http.Client, token, rawURL string) ([]T, error) {
+	var all []T
+	nextURL := rawURL
+	for nextURL != "" {
+		req, err := http.NewRequest(http.MethodGet, nextURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Accept", "application/vnd.github+json")
+		req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			_ = resp.Body.Close()
+			return nil, responseError(resp, "check-runs")
+		}
+
+		var result struct {
+			CheckRuns []T `json:"check_runs"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			_ = resp.Body.Close()
+			return nil, err
+		}
+		_ = resp.Body.Close()
+
+		all = append(all, result.CheckRuns...)
+		nextURL = parseNextLink(resp.Header.Get("Link"))
+		// The proxy may return links relative to itself; resolve them.
+		if nextURL != "" {
+			if u, err := resp.Request.URL.Parse(nextURL); err == nil {
+				nextURL = u.String()
+			}
+		}
+	}
+	return all, nil
+}
+
+// getJSON fetches rawURL and decodes the JSON body into v.
+func getJSON(client *http.Client, token, rawURL, resource string, v any) error {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp, resource)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// parseNextLink extracts the URL for rel="next" from a Link header.
+func parseNextLink(linkHeader string) string {
+	if linkHeader == "" {
+		return ""
+	}
+	for _, part := range strings.Split(linkHeader, ",") {
+		part = strings.TrimSpace(part)
+		segments := strings.Split(part, ";")
+		if len(segments) < 2 {
+			continue
+		}
+		urlPart := strings.TrimSpace(segments[0])
+		relPart := strings.TrimSpace(segments[1])
+		if relPart == `rel="next"` && len(urlPart) > 2 {
+			return urlPart[1 : len(urlPart)-1]
+		}
+	}
+	return ""
+}
+
+// detectRepo infers owner/repo from the git remote URL for the given host.
+func detectRepo(host string) string {
+	out, err := exec.Command("git", "remote", "get-url", "origin").Output()
+	if err != nil {
+		return ""
+	}
+	return parseGitRemote(strings.TrimSpace(string(out)), host)
+}
+
+func parseGitRemote(remote, host string) string {
+	gitRemoteRe := regexp.MustCompile(regexp.QuoteMeta(host) + `[:/]([^/]+/[^/.]+?)(?:\.git)?$`)
+	m := gitRemoteRe.FindStringSubmatch(remote)
+	if len(m) < 2 {
+		return ""
+	}
+	return m[1]
+}
+
+func currentBranch() (string, error) {
+	out, err := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD").Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func sortCheckRunsByTime(runs []checkRun) {
+	// Sort descending by StartedAt so deduplication keeps the most recent.
+	for i := 0; i < len(runs); i++ {
+		for j := i + 1; j < len(runs); j++ {
+			if runs[j].StartedAt.After(runs[i].StartedAt) {
+				runs[i], runs[j] = runs[j], runs[i]
+			}
+		}
+	}
+}
+
+// parseInterspersed parses flags from args even when positional arguments
+// appear before or between flags. Go's flag package stops at the first
+// non-flag argument, so we loop: parse flags, save one positional arg, repeat.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		// fs.Args()[0] is the positional arg that stopped parsing; save it
+		// and continue parsing the rest.
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+	return positional, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
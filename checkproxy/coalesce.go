@@ -0,0 +1,131 @@
+package checkproxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// flightGroup tracks the GETs currently in flight to GitHub, so that
+// identical ones made meanwhile wait for the first instead of repeating it.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+
+	coalesced atomic.Uint64 // requests answered by another's flight
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{flights: make(map[string]*flight)}
+}
+
+// flight is one upstream request that others may be waiting on. Its result
+// is set before done is closed.
+type flight struct {
+	done chan struct{}
+
+	shared bool // status, header, and body hold the whole response
+	status int
+	header http.Header
+	body   []byte
+	err    error
+}
+
+// join returns the flight for key, and whether the caller leads it: the
+// leader makes the request and must call land.
+func (g *flightGroup) join(key string) (f *flight, leader bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if f, ok := g.flights[key]; ok {
+		return f, false
+	}
+	f = &flight{done: make(chan struct{})}
+	g.flights[key] = f
+	return f, true
+}
+
+// land releases the waiters of key's flight.
+func (g *flightGroup) land(key string, f *flight) {
+	g.mu.Lock()
+	delete(g.flights, key)
+	g.mu.Unlock()
+	close(f.done)
+}
+
+// response returns a copy of the flight's response for req.
+func (f *flight) response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", f.status, http.StatusText(f.status)),
+		StatusCode:    f.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        f.header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(f.body)),
+		ContentLength: int64(len(f.body)),
+		Request:       req,
+	}
+}
+
+// coalesceTransport lets concurrent identical GETs share one upstream
+// request: many agents watching the same pull request poll the same URLs at
+// once. The first request's response is buffered, up to the response cache's
+// body limit, and copied to the others. Log and artifact downloads, and
+// responses too large to buffer, are not shared; the waiters then make
+// their own requests.
+type coalesceTransport struct {
+	next    http.RoundTripper
+	flights *flightGroup
+	maxBody int64 // the response cache's body limit
+}
+
+func (t coalesceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet || matchesAny(streamingRoutes, apiPath(req.URL.EscapedPath())) {
+		return t.next.RoundTrip(req)
+	}
+	key := responseCacheKey(req) + "\n" + req.Header.Get("If-None-Match") + "\n" + req.Header.Get("If-Modified-Since")
+	f, leader := t.flights.join(key)
+	if !leader {
+		select {
+		case <-f.done:
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		if f.err != nil {
+			t.flights.coalesced.Add(1)
+			return nil, f.err
+		}
+		if !f.shared {
+			return t.next.RoundTrip(req)
+		}
+		t.flights.coalesced.Add(1)
+		return f.response(req), nil
+	}
+
+	// Waiters make their own requests unless told otherwise, e.g. when the
+	// leader's client went away and took its request down with it.
+	defer t.flights.land(key, f)
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		if req.Context().Err() == nil {
+			f.err = err
+		}
+		return nil, err
+	}
+	maxBody := t.maxBody
+	if resp.ContentLength > maxBody {
+		return resp, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBody+1))
+	if err != nil || int64(len(body)) > maxBody {
+		resp.Body = readCloser{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return resp, nil
+	}
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	f.shared, f.status, f.header, f.body = true, resp.StatusCode, resp.Header.Clone(), body
+	return resp, nil
+}
@@ -0,0 +1,129 @@
+package checkproxy
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestCoalesceTransport sends identical GETs while the first is still in
+// flight and checks how many reach GitHub and what the others get back.
+func TestCoalesceTransport(t *testing.T) {
+	upstreamErr := errors.New("connection reset")
+	tests := []struct {
+		name      string
+		method    string
+		body      string
+		err       error
+		maxBody   int64
+		wantCalls int64
+	}{
+		{name: "shared", method: http.MethodGet, body: `{"total_count":1}`, maxBody: 1 << 10, wantCalls: 1},
+		{name: "error shared", method: http.MethodGet, err: upstreamErr, maxBody: 1 << 10, wantCalls: 1},
+		{name: "too large to share", method: http.MethodGet, body: strings.Repeat("x", 100), maxBody: 10, wantCalls: 4},
+		{name: "writes not coalesced", method: http.MethodPost, body: "{}", maxBody: 1 << 10, wantCalls: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int64
+			entered, release := make(chan struct{}, 4), make(chan struct{})
+			transport := coalesceTransport{roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				calls.Add(1)
+				entered <- struct{}{}
+				<-release
+				if tt.err != nil {
+					return nil, tt.err
+				}
+				return &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Etag": {`"v1"`}},
+					Body: io.NopCloser(strings.NewReader(tt.body)), ContentLength: -1, Request: req}, nil
+			}), newFlightGroup(), tt.maxBody}
+
+			type result struct {
+				body string
+				err  error
+			}
+			results := make(chan result, 4)
+			send := func() {
+				resp, err := transport.RoundTrip(httptest.NewRequest(tt.method, "https://api.github.com/repos/o/r/commits/abc/check-runs", nil))
+				if err != nil {
+					results <- result{err: err}
+					return
+				}
+				defer resp.Body.Close()
+				b, err := io.ReadAll(resp.Body)
+				results <- result{string(b), err}
+			}
+			go send()
+			<-entered // the first request is in flight
+			var wg sync.WaitGroup
+			for range 3 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					send()
+				}()
+			}
+			time.Sleep(50 * time.Millisecond) // for the others to join the flight
+			close(release)
+			wg.Wait()
+
+			for range 4 {
+				r := <-results
+				if tt.err != nil {
+					if !errors.Is(r.err, tt.err) {
+						t.Errorf("err = %v, want %v", r.err, tt.err)
+					}
+					continue
+				}
+				if r.err != nil || r.body != tt.body {
+					t.Errorf("got %q, %v; want %q", r.body, r.err, tt.body)
+				}
+			}
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Errorf("%d requests reached GitHub, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+// TestCoalesceKey checks that only requests GitHub would answer alike share
+// a flight.
+func TestCoalesceKey(t *testing.T) {
+	var calls atomic.Int64
+	release := make(chan struct{})
+	transport := coalesceTransport{roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		calls.Add(1)
+		<-release
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: http.NoBody, Request: req}, nil
+	}), newFlightGroup(), 1 << 10}
+	requests := []func(*http.Request){
+		func(*http.Request) {},
+		func(r *http.Request) { r.Header.Set("Accept", "application/vnd.github.raw+json") },
+		func(r *http.Request) { r.Header.Set("If-None-Match", `"v1"`) },
+		func(r *http.Request) { r.URL.RawQuery = "page=2" },
+	}
+	var wg sync.WaitGroup
+	for _, set := range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "https://api.github.com/repos/o/r/commits/abc/check-runs", nil)
+			set(req)
+			if resp, err := transport.RoundTrip(req); err == nil {
+				resp.Body.Close()
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if got := calls.Load(); got != int64(len(requests)) {
+		t.Errorf("%d requests reached GitHub, want %d", got, len(requests))
+	}
+}
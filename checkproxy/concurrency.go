@@ -0,0 +1,82 @@
+package checkproxy
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// concurrencyRetryAfter is the Retry-After, in seconds, sent when a request
+// is refused for concurrency. Slots free up as soon as upstream answers, so
+// a short wait is usually enough.
+const concurrencyRetryAfter = 1
+
+// concurrencyLimiter caps the proxied requests in flight at once, overall
+// and per client credential. A full limiter sheds requests instead of
+// queueing them, so a stampede of agents refreshing together can't pile up
+// goroutines and upstream connections, and one parallel-happy agent can't
+// take every slot.
+type concurrencyLimiter struct {
+	slots     chan struct{} // nil when there is no global cap
+	perClient int
+
+	mu      sync.Mutex
+	clients map[string]int // client key → requests in flight
+}
+
+// newConcurrencyLimiter returns a limiter for max requests overall and
+// perClient per client, either of which may be 0 for no limit. It returns
+// nil when neither is set.
+func newConcurrencyLimiter(max, perClient int) *concurrencyLimiter {
+	if max <= 0 && perClient <= 0 {
+		return nil
+	}
+	l := &concurrencyLimiter{perClient: perClient, clients: make(map[string]int)}
+	if max > 0 {
+		l.slots = make(chan struct{}, max)
+	}
+	return l
+}
+
+// acquire takes one of the slots shared by every client, returning the
+// function that gives it back. When the proxy as a whole is saturated it
+// writes a 503 with Retry-After and returns false.
+func (l *concurrencyLimiter) acquire(w http.ResponseWriter) (release func(), ok bool) {
+	if l == nil || l.slots == nil {
+		return func() {}, true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, true
+	default:
+		w.Header().Set("Retry-After", strconv.Itoa(concurrencyRetryAfter))
+		http.Error(w, "service unavailable: too many requests in flight", http.StatusServiceUnavailable)
+		return nil, false
+	}
+}
+
+// acquireClient takes one of key's slots, returning the function that gives
+// it back. key is the authorizedClient of a request that has been
+// authorized, so a client can't be crowded out by requests claiming to be
+// it. When key already has its share in flight it writes a 429 with
+// Retry-After and returns false.
+func (l *concurrencyLimiter) acquireClient(w http.ResponseWriter, key string) (release func(), ok bool) {
+	if l == nil || l.perClient <= 0 {
+		return func() {}, true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.clients[key] >= l.perClient {
+		w.Header().Set("Retry-After", strconv.Itoa(concurrencyRetryAfter))
+		http.Error(w, "too many requests: client has too many requests in flight", http.StatusTooManyRequests)
+		return nil, false
+	}
+	l.clients[key]++
+	return func() {
+		l.mu.Lock()
+		if l.clients[key]--; l.clients[key] == 0 {
+			delete(l.clients, key)
+		}
+		l.mu.Unlock()
+	}, true
+}
@@ -0,0 +1,1215 @@
+package checkproxy
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// Config holds the persistent server configuration.
+type Config struct {
+	ClassicToken          string           `json:"classic_token"`
+	AllowedOrgs           []string         `json:"allowed_orgs,omitempty"`
+	AllowedRepos          []string         `json:"allowed_repos,omitempty"`
+	DeniedRepos           []string         `json:"denied_repos,omitempty"`
+	Port                  int              `json:"port"`
+	APIBaseURL            string           `json:"api_base_url,omitempty"`
+	BasePath              string           `json:"base_path,omitempty"`
+	PublicURL             string           `json:"public_url,omitempty"`
+	TrustedProxies        []string         `json:"trusted_proxies,omitempty"`
+	H2C                   bool             `json:"h2c,omitempty"`
+	TLSCertFile           string           `json:"tls_cert_file,omitempty"`
+	TLSKeyFile            string           `json:"tls_key_file,omitempty"`
+	MaxResponseBytes      int64            `json:"max_response_bytes,omitempty"`
+	MaxConcurrentRequests int              `json:"max_concurrent_requests,omitempty"`
+	MaxClientConcurrency  int              `json:"max_concurrent_per_client,omitempty"`
+	RewriteBodyURLs       bool             `json:"rewrite_body_urls,omitempty"`
+	CORS                  *CORSConfig      `json:"cors,omitempty"`
+	RateLimit             *RateLimitConfig `json:"rate_limit,omitempty"`
+	WatchInterval         string           `json:"watch_interval,omitempty"`
+	WebhookSecret         string           `json:"webhook_secret,omitempty"`
+	AdminToken            string           `json:"admin_token,omitempty"`
+	AuditLog              string           `json:"audit_log,omitempty"`
+	// LogPrivacy is "hash" or "omit" to keep repository names, commit SHAs,
+	// and client addresses out of the logs.
+	LogPrivacy            string `json:"log_privacy,omitempty"`
+	ValidationCacheTTL    string `json:"validation_cache_ttl"`
+	ValidationNegativeTTL string `json:"validation_negative_ttl,omitempty"`
+	ValidationCacheSize   int    `json:"validation_cache_size,omitempty"`
+	TokenExpiryWarnDays   int    `json:"token_expiry_warning_days,omitempty"`
+	// PersistValidationCache saves validation results across restarts.
+	PersistValidationCache bool `json:"persist_validation_cache,omitempty"`
+	// ValidationMode is "off", "repo" (the default), or "strict".
+	ValidationMode string `json:"validation_mode,omitempty"`
+	// StrictValidation is the older spelling of validation_mode "strict".
+	StrictValidation     bool `json:"strict_validation,omitempty"`
+	RequireOrgMembership bool `json:"require_org_membership,omitempty"`
+	// ClientTokenPrefixes, when set, is the list of token prefixes clients
+	// may present, e.g. ["github_pat_"] to refuse classic tokens.
+	ClientTokenPrefixes []string `json:"client_token_prefixes,omitempty"`
+	// HMACClients are shared-secret identities for agents without any token.
+	HMACClients []HMACClient `json:"hmac_clients,omitempty"`
+	// RequiredPermissions overrides defaultRequiredPermissions per route group.
+	RequiredPermissions map[string][]string   `json:"required_permissions,omitempty"`
+	GitHubApp           *GitHubAppConfig      `json:"github_app,omitempty"`
+	Tracing             *TracingConfig        `json:"tracing,omitempty"`
+	UpstreamTimeouts    *UpstreamTimeouts     `json:"upstream_timeouts,omitempty"`
+	ServerTimeouts      *ServerTimeouts       `json:"server_timeouts,omitempty"`
+	UpstreamConnections *UpstreamConnections  `json:"upstream_connections,omitempty"`
+	CircuitBreaker      *CircuitBreakerConfig `json:"circuit_breaker,omitempty"`
+	RateBudget          *RateBudgetConfig     `json:"rate_budget,omitempty"`
+	ResponseCache       *ResponseCacheConfig  `json:"response_cache,omitempty"`
+	Redis               *RedisConfig          `json:"redis,omitempty"`
+	Alerts              *AlertsConfig         `json:"alerts,omitempty"`
+	Listeners           []Listener            `json:"listeners,omitempty"`
+	ClassicTokenPool    []TokenRef            `json:"classic_token_pool,omitempty"`
+	OrgTokens           map[string]TokenRef   `json:"org_tokens,omitempty"`
+	Upstreams           []Upstream            `json:"upstreams,omitempty"`
+	AllowWrites         []string              `json:"allow_writes,omitempty"`
+	ExtraAllowedRoutes  []string              `json:"extra_allowed_routes,omitempty"`
+	DeniedRoutes        []string              `json:"denied_routes,omitempty"`
+
+	envOnly bool // no config file; every setting came from the environment
+}
+
+// defaultTokenExpiryWarnDays is how close to expiry a client token must be
+// before responses carry tokenExpirationHeader.
+const defaultTokenExpiryWarnDays = 7
+
+// tokenExpiryWarning returns how long before a client token expires the
+// proxy starts warning about it.
+func (c *Config) tokenExpiryWarning() time.Duration {
+	days := c.TokenExpiryWarnDays
+	if days <= 0 {
+		days = defaultTokenExpiryWarnDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// validationTTLs parses validation_cache_ttl (5m if unset or invalid) and
+// validation_negative_ttl (by default the shorter of the TTL and 1m).
+func (c *Config) validationTTLs() (ttl, negativeTTL time.Duration, err error) {
+	ttl, err = time.ParseDuration(c.ValidationCacheTTL)
+	if err != nil {
+		ttl = 5 * time.Minute
+	}
+	negativeTTL = min(ttl, defaultNegativeTTL)
+	if c.ValidationNegativeTTL != "" {
+		if negativeTTL, err = time.ParseDuration(c.ValidationNegativeTTL); err != nil || negativeTTL < 0 {
+			return 0, 0, fmt.Errorf("validation_negative_ttl: invalid duration %q", c.ValidationNegativeTTL)
+		}
+	}
+	return ttl, negativeTTL, nil
+}
+
+// tokenTargets lists every classic token the server holds, for checking and
+// expiry monitoring, along with the token pool when classic_token_pool
+// resolves to any token (nil otherwise).
+func (c *Config) tokenTargets() ([]upstreamTarget, *tokenPool, error) {
+	var targets []upstreamTarget
+	if token := c.GetClassicToken(); token != "" {
+		targets = append(targets, upstreamTarget{apiBase: c.APIBase(), token: token})
+	}
+	for _, u := range c.Upstreams {
+		if u.Name == "" {
+			return nil, nil, errors.New("every entry in upstreams needs a name")
+		}
+		up, _ := c.resolveUpstream(u.Name)
+		if up.token == "" {
+			slog.Warn("upstream has no classic token; its requests will fail", "upstream", u.Name)
+			continue
+		}
+		targets = append(targets, up)
+	}
+	for org := range c.OrgTokens {
+		token, _ := c.orgToken(org)
+		if token == "" {
+			return nil, nil, fmt.Errorf("org_tokens: no classic token for %s", org)
+		}
+		targets = append(targets, upstreamTarget{name: orgTokenPrefix + org, apiBase: c.APIBase(), token: token})
+	}
+	var pool *tokenPool
+	if len(c.ClassicTokenPool) > 0 {
+		tokens := c.poolTokens()
+		// The default classic token, when set, is already a target.
+		first := 0
+		if c.GetClassicToken() != "" {
+			first = 1
+		}
+		for i := first; i < len(tokens); i++ {
+			targets = append(targets, upstreamTarget{name: fmt.Sprintf("%s%d", poolTokenPrefix, i+1), apiBase: c.APIBase(), token: tokens[i]})
+		}
+		if len(tokens) > 0 {
+			pool = newTokenPool(tokens)
+		}
+	}
+	return targets, pool, nil
+}
+
+// TLSEnabled reports whether the server is configured to serve HTTPS.
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" || c.TLSKeyFile != ""
+}
+
+// loadTLSCertificate checks tls_cert_file and tls_key_file and loads the pair.
+func (c *Config) loadTLSCertificate() (tls.Certificate, error) {
+	if c.TLSCertFile == "" || c.TLSKeyFile == "" {
+		return tls.Certificate{}, errors.New("tls_cert_file and tls_key_file must be set together")
+	}
+	return tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+}
+
+// localServer returns the base URL of the server running on this host with
+// this config, and a client for it. With several listeners it picks the
+// first admin one. Over TLS the certificate names the public host rather
+// than the loopback address, so the client trusts exactly the configured
+// certificate and checks it against the first name it carries.
+func (c *Config) localServer(timeout time.Duration) (string, *http.Client, error) {
+	l := c.serveListeners()[0]
+	if i := slices.IndexFunc(c.Listeners, func(l Listener) bool { return l.Admin }); i >= 0 {
+		l = c.Listeners[i]
+	}
+	transport := &http.Transport{}
+	client := &http.Client{Timeout: timeout, Transport: transport}
+	host := "localhost"
+	if path, ok := l.unixPath(); ok {
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		}
+	} else {
+		h, port, err := net.SplitHostPort(l.Address)
+		if err != nil {
+			return "", nil, err
+		}
+		if ip := net.ParseIP(h); h == "" || ip != nil && ip.IsUnspecified() {
+			h = "127.0.0.1"
+		}
+		host = net.JoinHostPort(h, port)
+	}
+	if !l.TLS {
+		return "http://" + host + c.BasePath, client, nil
+	}
+	cert, err := c.loadTLSCertificate()
+	if err != nil {
+		return "", nil, err
+	}
+	leaf := cert.Leaf
+	if leaf == nil {
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return "", nil, err
+		}
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+	serverName := "127.0.0.1"
+	if len(leaf.DNSNames) > 0 {
+		serverName = leaf.DNSNames[0]
+	}
+	transport.TLSClientConfig = &tls.Config{RootCAs: roots, ServerName: serverName}
+	return "https://" + host + c.BasePath, client, nil
+}
+
+// Validation modes: how much of a client token the proxy checks with GitHub.
+const (
+	validationOff    = "off"    // none; the network is trusted
+	validationRepo   = "repo"   // repository read access
+	validationStrict = "strict" // repository access plus per-resource permissions
+)
+
+// validationMode returns the effective validation mode. Values were checked
+// by checkValidationMode at startup.
+func (c *Config) validationMode() string {
+	if c.ValidationMode != "" {
+		return strings.ToLower(c.ValidationMode)
+	}
+	if c.StrictValidation {
+		return validationStrict
+	}
+	return validationRepo
+}
+
+// checkValidationMode validates the validation_mode setting.
+func (c *Config) checkValidationMode() error {
+	switch mode := c.validationMode(); mode {
+	case validationRepo, validationStrict:
+		return nil
+	case validationOff:
+		if c.RequireOrgMembership {
+			return errors.New("off can't be combined with require_org_membership, which needs the client token")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown mode %q (want off, repo, or strict)", mode)
+	}
+}
+
+// requiredPermissions returns the fine-grained permissions strict validation
+// requires for the given route groups, without duplicates. Entries were
+// checked by checkRequiredPermissions at startup.
+func (c *Config) requiredPermissions(groups []string) []string {
+	var perms []string
+	for _, g := range groups {
+		list, ok := c.RequiredPermissions[g]
+		if !ok {
+			list = defaultRequiredPermissions[g]
+		}
+		for _, p := range list {
+			name, err := parsePermission(p)
+			if err == nil && !slices.Contains(perms, name) {
+				perms = append(perms, name)
+			}
+		}
+	}
+	return perms
+}
+
+// checkRequiredPermissions validates the required_permissions setting.
+func (c *Config) checkRequiredPermissions() error {
+	for group, perms := range c.RequiredPermissions {
+		if _, ok := defaultRequiredPermissions[group]; !ok {
+			return fmt.Errorf("unknown route group %q", group)
+		}
+		for _, p := range perms {
+			if _, err := parsePermission(p); err != nil {
+				return fmt.Errorf("%s: %w", group, err)
+			}
+		}
+	}
+	return nil
+}
+
+// clientTokenAccepted reports whether a client token has an accepted prefix.
+func (c *Config) clientTokenAccepted(token string) bool {
+	if len(c.ClientTokenPrefixes) == 0 {
+		return true
+	}
+	for _, p := range c.ClientTokenPrefixes {
+		if strings.HasPrefix(token, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeAllowed reports whether the named write group is enabled.
+func (c *Config) writeAllowed(group string) bool {
+	for _, g := range c.AllowWrites {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// isClassicToken returns true if the token has a prefix indicating it can access
+// the Checks API (classic PAT). GitHub token prefixes: ghp_=classic, gho_=OAuth.
+func isClassicToken(token string) bool {
+	return strings.HasPrefix(token, "ghp_") || strings.HasPrefix(token, "gho_")
+}
+
+// CORSConfig enables cross-origin requests from browser dashboards.
+type CORSConfig struct {
+	AllowedOrigins []string `json:"allowed_origins"`
+	AllowedHeaders []string `json:"allowed_headers,omitempty"`
+	MaxAge         string   `json:"max_age,omitempty"`
+}
+
+// Upstream is an additional named GitHub instance served by the same proxy.
+// Its classic token is read from TokenEnv when set, otherwise ClassicToken.
+type Upstream struct {
+	Name         string `json:"name"`
+	APIBaseURL   string `json:"api_base_url"`
+	ClassicToken string `json:"classic_token,omitempty"`
+	TokenEnv     string `json:"token_env,omitempty"`
+}
+
+// TokenRef is a classic token kept in the config file or, preferably, read
+// from the environment variable TokenEnv.
+type TokenRef struct {
+	ClassicToken string `json:"classic_token,omitempty"`
+	TokenEnv     string `json:"token_env,omitempty"`
+}
+
+func (t TokenRef) resolve() string {
+	if t.TokenEnv != "" {
+		return firstNonEmpty(strings.TrimSpace(os.Getenv(t.TokenEnv)), t.ClassicToken)
+	}
+	return t.ClassicToken
+}
+
+// orgToken returns the classic token configured for owner's repositories on
+// the default upstream, if any.
+func (c *Config) orgToken(owner string) (string, bool) {
+	for org, t := range c.OrgTokens {
+		if strings.EqualFold(org, owner) {
+			return t.resolve(), true
+		}
+	}
+	return "", false
+}
+
+// poolTokens returns the default classic token followed by the tokens in
+// classic_token_pool, skipping empty ones. With only github_app set there is
+// no default classic token, so the pool holds just its own entries; an empty
+// token must never be handed out, since it would send requests unauthenticated
+// and let failover attach a pooled token to them.
+func (c *Config) poolTokens() []string {
+	var tokens []string
+	if token := c.GetClassicToken(); token != "" {
+		tokens = append(tokens, token)
+	}
+	for _, t := range c.ClassicTokenPool {
+		if token := t.resolve(); token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// upstreamTarget is a resolved upstream: where to send requests and with which token.
+type upstreamTarget struct {
+	name    string
+	apiBase string
+	token   string
+}
+
+// resolveUpstream returns the upstream with the given name. The empty name is
+// the default upstream described by the top-level config fields.
+func (c *Config) resolveUpstream(name string) (upstreamTarget, bool) {
+	if name == "" {
+		return upstreamTarget{apiBase: c.APIBase(), token: c.GetClassicToken()}, true
+	}
+	for _, u := range c.Upstreams {
+		if !strings.EqualFold(u.Name, name) {
+			continue
+		}
+		token := u.ClassicToken
+		if u.TokenEnv != "" {
+			token = firstNonEmpty(strings.TrimSpace(os.Getenv(u.TokenEnv)), token)
+		}
+		return upstreamTarget{name: u.Name, apiBase: apiBaseURL(u.APIBaseURL), token: token}, true
+	}
+	return upstreamTarget{}, false
+}
+
+// APIBase returns the REST API base URL for upstream calls (github.com by default).
+func (c *Config) APIBase() string {
+	return apiBaseURL(c.APIBaseURL)
+}
+
+// apiBaseURL normalizes a GitHub hostname or API URL into a REST API base.
+// github.com maps to https://api.github.com; any other host without a path is
+// treated as GitHub Enterprise Server and gets the /api/v3 prefix.
+func apiBaseURL(raw string) string {
+	raw = strings.TrimRight(strings.TrimSpace(raw), "/")
+	if raw == "" {
+		return githubAPIBase
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	if host := strings.ToLower(u.Host); host == "github.com" || host == "api.github.com" {
+		return githubAPIBase
+	}
+	if u.Path == "" {
+		u.Path = "/api/v3"
+	}
+	return u.String()
+}
+
+// graphqlURL derives the GraphQL endpoint from a REST API base. GHES serves
+// GraphQL at /api/graphql rather than /api/v3/graphql.
+func graphqlURL(apiBase string) string {
+	if strings.HasSuffix(apiBase, "/api/v3") {
+		return strings.TrimSuffix(apiBase, "/v3") + "/graphql"
+	}
+	return apiBase + "/graphql"
+}
+
+// normalizeBasePath turns "checkproxy/" or "/checkproxy/" into "/checkproxy".
+// The root path normalizes to "".
+func normalizeBasePath(p string) string {
+	p = strings.Trim(strings.TrimSpace(p), "/")
+	if p == "" {
+		return ""
+	}
+	return "/" + p
+}
+
+// GetClassicToken returns the token to use for upstream GitHub API calls.
+// Preference order: GH_CHECKPROXY_CLASSIC_TOKEN → GH_TOKEN → config.
+func (c *Config) GetClassicToken() string {
+	return firstNonEmpty(
+		strings.TrimSpace(os.Getenv("GH_CHECKPROXY_CLASSIC_TOKEN")),
+		strings.TrimSpace(os.Getenv("GH_TOKEN")),
+		c.ClassicToken,
+	)
+}
+
+// classicTokenSource returns which source provides the token (for status display).
+func (c *Config) classicTokenSource() string {
+	t := c.GetClassicToken()
+	if t == "" {
+		return ""
+	}
+	if s := strings.TrimSpace(os.Getenv("GH_CHECKPROXY_CLASSIC_TOKEN")); s != "" && s == t {
+		return "GH_CHECKPROXY_CLASSIC_TOKEN"
+	}
+	if s := strings.TrimSpace(os.Getenv("GH_TOKEN")); s != "" && s == t {
+		return "GH_TOKEN"
+	}
+	return "config"
+}
+
+// GetWebhookSecret returns the secret used to verify GitHub webhook deliveries.
+// Preference: GH_CHECKPROXY_WEBHOOK_SECRET env var, then config file.
+func (c *Config) GetWebhookSecret() string {
+	return firstNonEmpty(strings.TrimSpace(os.Getenv("GH_CHECKPROXY_WEBHOOK_SECRET")), c.WebhookSecret)
+}
+
+// GetAdminToken returns the bearer token for the /v1/admin/ API.
+// Preference: GH_CHECKPROXY_ADMIN_TOKEN env var, then config file.
+func (c *Config) GetAdminToken() string {
+	return firstNonEmpty(strings.TrimSpace(os.Getenv("GH_CHECKPROXY_ADMIN_TOKEN")), c.AdminToken)
+}
+
+// ConfigPath returns the path to the config file.
+func ConfigPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = "."
+	}
+	return filepath.Join(home, ".config", "gh-checkproxy", "config.json")
+}
+
+// Settings that can come from the environment, over the config file or
+// without one. GH_CHECKPROXY_CLASSIC_TOKEN is read by GetClassicToken.
+var configEnvVars = []string{
+	"GH_CHECKPROXY_CLASSIC_TOKEN",
+	"GH_CHECKPROXY_PORT",
+	"GH_CHECKPROXY_ALLOWED_ORGS",
+	"GH_CHECKPROXY_CACHE_TTL",
+	"GH_CHECKPROXY_API_URL",
+}
+
+// LoadConfig reads and parses the config file, applying defaults and then
+// the GH_CHECKPROXY_ environment variables. Without a config file the server
+// runs from the environment alone, provided any of them is set.
+func LoadConfig() (*Config, error) {
+	cfg, err := loadConfigFile()
+	if errors.Is(err, os.ErrNotExist) && slices.ContainsFunc(configEnvVars, func(v string) bool { return os.Getenv(v) != "" }) {
+		cfg, err = &Config{envOnly: true}, nil
+		cfg.applyDefaults()
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("config not found — run 'gh-checkproxy config' to set up, or set GH_CHECKPROXY_CLASSIC_TOKEN")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyEnv overrides settings with their environment variables. The values
+// never reach the config file: runConfig saves what loadConfigFile read.
+func (c *Config) applyEnv() error {
+	if v := strings.TrimSpace(os.Getenv("GH_CHECKPROXY_PORT")); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil || port <= 0 || port > 65535 {
+			return fmt.Errorf("GH_CHECKPROXY_PORT: invalid port %q", v)
+		}
+		c.Port = port
+	}
+	if v := os.Getenv("GH_CHECKPROXY_ALLOWED_ORGS"); v != "" {
+		c.AllowedOrgs = splitComma(v)
+	}
+	if v := strings.TrimSpace(os.Getenv("GH_CHECKPROXY_CACHE_TTL")); v != "" {
+		if d, err := time.ParseDuration(v); err != nil || d <= 0 {
+			return fmt.Errorf("GH_CHECKPROXY_CACHE_TTL: invalid duration %q", v)
+		}
+		c.ValidationCacheTTL = v
+	}
+	if v := strings.TrimSpace(os.Getenv("GH_CHECKPROXY_API_URL")); v != "" {
+		c.APIBaseURL = apiBaseURL(v)
+	}
+	return nil
+}
+
+// loadConfigFile reads and parses the config file alone, applying defaults.
+// A missing file is reported as os.ErrNotExist.
+func loadConfigFile() (*Config, error) {
+	data, err := os.ReadFile(ConfigPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	cfg.applyDefaults()
+	return &cfg, nil
+}
+
+// applyDefaults fills in the settings every config needs.
+func (c *Config) applyDefaults() {
+	if c.Port == 0 {
+		c.Port = 8080
+	}
+	if c.ValidationCacheTTL == "" {
+		c.ValidationCacheTTL = "5m"
+	}
+	if c.WatchInterval == "" {
+		c.WatchInterval = "10s"
+	}
+	c.BasePath = normalizeBasePath(c.BasePath)
+}
+
+// SaveConfig writes the config to disk with 0600 permissions.
+func SaveConfig(cfg *Config) error {
+	path := ConfigPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// runConfig handles the `config` subcommand — interactive or flag-driven.
+func runConfig(args []string) error {
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	org := fs.String("org", "", "Restrict proxy to these organizations, comma-separated (optional)")
+	port := fs.Int("port", 0, "HTTP listen port (default: 8080)")
+	apiURL := fs.String("api-url", "", "GitHub API URL or GHES hostname (default: https://api.github.com)")
+	cacheTTL := fs.String("cache-ttl", "", "Token validation cache TTL (default: 5m)")
+	negativeTTL := fs.String("negative-cache-ttl", "", "Cache TTL for denied validations (default: 1m; \"default\" to reset)")
+	cacheSize := fs.Int("cache-size", -1, "Maximum cached validation results (default: 10000; 0 to reset)")
+	repos := fs.String("repos", "", "Restrict proxy to these owner/repo patterns, comma-separated, * wildcards allowed (\"none\" to clear)")
+	denyRepos := fs.String("deny-repos", "", "Always refuse these owner/repo patterns, comma-separated (\"none\" to clear)")
+	allowWrites := fs.String("allow-writes", "", "Enable write route groups, comma-separated (e.g. rerequest; \"none\" to disable)")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// Load existing config for partial updates; fall back to defaults.
+	cfg, err := loadConfigFile()
+	if err != nil {
+		cfg = &Config{Port: 8080, ValidationCacheTTL: "5m"}
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+
+	// --- Upstream API (flag only; needed before the org fetch below) ---
+	if *apiURL == "default" {
+		cfg.APIBaseURL = ""
+	} else if *apiURL != "" {
+		cfg.APIBaseURL = apiBaseURL(*apiURL)
+	}
+
+	// --- Classic token ---
+	// Preference: GH_CHECKPROXY_CLASSIC_TOKEN → GH_TOKEN → interactive. Env vars are never stored.
+	// Never accept tokens as CLI flags — they leak via ps, /proc, and shell history.
+	if envToken := os.Getenv("GH_CHECKPROXY_CLASSIC_TOKEN"); envToken != "" {
+		cfg.ClassicToken = "" // never stored; read from env at runtime
+	} else if cfg.ClassicToken == "" && cfg.GetClassicToken() != "" {
+		// Already using env (no stored token); keep it for partial config update
+	} else if ghToken := strings.TrimSpace(os.Getenv("GH_TOKEN")); ghToken != "" && isClassicToken(ghToken) {
+		fmt.Print("GH_TOKEN is set and appears to be a classic token (ghp_/gho_). Use it for the proxy? (no token stored) [y/n]: ")
+		line, _ := reader.ReadString('\n')
+		line = strings.TrimSpace(strings.ToLower(line))
+		if line == "y" || line == "yes" || line == "" {
+			cfg.ClassicToken = "" // never stored
+		} else {
+			fmt.Print("Enter classic token (input hidden): ")
+			tokenBytes, err := term.ReadPassword(int(os.Stdin.Fd()))
+			fmt.Println()
+			if err != nil {
+				return fmt.Errorf("reading token: %w", err)
+			}
+			token := strings.TrimSpace(string(tokenBytes))
+			if token == "" {
+				return fmt.Errorf("classic token is required — set GH_CHECKPROXY_CLASSIC_TOKEN, use GH_TOKEN, or enter interactively")
+			}
+			cfg.ClassicToken = token
+		}
+	} else {
+		fmt.Print("Enter classic token (input hidden): ")
+		tokenBytes, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Println()
+		if err != nil {
+			return fmt.Errorf("reading token: %w", err)
+		}
+		token := strings.TrimSpace(string(tokenBytes))
+		if token == "" {
+			return fmt.Errorf("classic token is required — set GH_CHECKPROXY_CLASSIC_TOKEN, use GH_TOKEN, or enter interactively")
+		}
+		cfg.ClassicToken = token
+	}
+
+	// --- Organizations ---
+	if *org != "" {
+		cfg.AllowedOrgs = splitComma(*org)
+	} else {
+		tokenForFetch := cfg.GetClassicToken()
+		if tokenForFetch == "" {
+			return fmt.Errorf("no token available for org fetch — set GH_TOKEN or GH_CHECKPROXY_CLASSIC_TOKEN")
+		}
+		fmt.Print("Fetching organizations...")
+		orgs, err := fetchUserOrgs(cfg.APIBase(), tokenForFetch)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, " (could not fetch: %v)\n", err)
+		} else {
+			fmt.Println()
+		}
+
+		if len(orgs) > 0 {
+			fmt.Printf("\nFound %d organization(s):\n", len(orgs))
+			for i, o := range orgs {
+				fmt.Printf("  %d. %s\n", i+1, o)
+			}
+			fmt.Print("\nSelect organizations (numbers or names, comma-separated) [leave blank for any]: ")
+			line, _ := reader.ReadString('\n')
+			line = strings.TrimSpace(line)
+			if line != "" {
+				cfg.AllowedOrgs = resolveOrgSelections(line, orgs)
+			} else {
+				cfg.AllowedOrgs = nil
+			}
+		} else {
+			fmt.Print("Enter organizations to restrict to (comma-separated, leave blank for any): ")
+			line, _ := reader.ReadString('\n')
+			cfg.AllowedOrgs = splitComma(strings.TrimSpace(line))
+		}
+	}
+
+	// --- Port ---
+	if *port != 0 {
+		cfg.Port = *port
+	} else {
+		fmt.Printf("Enter port [%d]: ", cfg.Port)
+		line, _ := reader.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if line != "" {
+			n, err := strconv.Atoi(line)
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid port: %s", line)
+			}
+			cfg.Port = n
+		}
+	}
+
+	// --- Cache TTL ---
+	if *cacheTTL != "" {
+		if _, err := time.ParseDuration(*cacheTTL); err != nil {
+			return fmt.Errorf("invalid cache-ttl %q: %w", *cacheTTL, err)
+		}
+		cfg.ValidationCacheTTL = *cacheTTL
+	} else {
+		fmt.Printf("Enter validation cache TTL [%s]: ", cfg.ValidationCacheTTL)
+		line, _ := reader.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if line != "" {
+			if _, err := time.ParseDuration(line); err != nil {
+				return fmt.Errorf("invalid TTL %q: %w", line, err)
+			}
+			cfg.ValidationCacheTTL = line
+		}
+	}
+
+	// --- Negative cache TTL (flag only) ---
+	if *negativeTTL == "default" {
+		cfg.ValidationNegativeTTL = ""
+	} else if *negativeTTL != "" {
+		if _, err := time.ParseDuration(*negativeTTL); err != nil {
+			return fmt.Errorf("invalid negative-cache-ttl %q: %w", *negativeTTL, err)
+		}
+		cfg.ValidationNegativeTTL = *negativeTTL
+	}
+
+	// --- Cache size (flag only) ---
+	if *cacheSize >= 0 {
+		cfg.ValidationCacheSize = *cacheSize
+	}
+
+	// --- Repositories (flag only) ---
+	if *repos == "none" {
+		cfg.AllowedRepos = nil
+	} else if *repos != "" {
+		patterns := splitComma(*repos)
+		for _, p := range patterns {
+			if err := validateRepoPattern(p); err != nil {
+				return err
+			}
+		}
+		cfg.AllowedRepos = patterns
+	}
+
+	if *denyRepos == "none" {
+		cfg.DeniedRepos = nil
+	} else if *denyRepos != "" {
+		patterns := splitComma(*denyRepos)
+		for _, p := range patterns {
+			if err := validateRepoPattern(p); err != nil {
+				return err
+			}
+		}
+		cfg.DeniedRepos = patterns
+	}
+
+	// --- Write routes (flag only; never enabled implicitly) ---
+	if *allowWrites == "none" {
+		cfg.AllowWrites = nil
+	} else if *allowWrites != "" {
+		groups := splitComma(*allowWrites)
+		for _, g := range groups {
+			if _, ok := writeRoutes[g]; !ok {
+				return fmt.Errorf("unknown write group %q (available: rerequest)", g)
+			}
+		}
+		cfg.AllowWrites = groups
+	}
+
+	if err := SaveConfig(cfg); err != nil {
+		return fmt.Errorf("saving config: %w", err)
+	}
+
+	fmt.Printf("\n✓ Config saved to %s\n", ConfigPath())
+	if len(cfg.AllowedOrgs) > 0 {
+		fmt.Printf("  Allowed orgs: %s\n", strings.Join(cfg.AllowedOrgs, ", "))
+	}
+	if len(cfg.AllowedRepos) > 0 {
+		fmt.Printf("  Allowed repos: %s\n", strings.Join(cfg.AllowedRepos, ", "))
+	}
+	if len(cfg.DeniedRepos) > 0 {
+		fmt.Printf("  Denied repos: %s\n", strings.Join(cfg.DeniedRepos, ", "))
+	}
+	fmt.Printf("  Port: %d\n", cfg.Port)
+	return nil
+}
+
+// runStatus prints the current config with the token masked, or with
+// --live the running server's own counters.
+func runStatus(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	live := fs.Bool("live", false, "Query the running server (needs admin token)")
+	traffic := fs.Bool("traffic", false, "Show the running server's traffic per org and repository (needs admin token)")
+	top := fs.Int("top", 20, "With --traffic, how many repositories to show (0 for all)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return errors.New("usage: gh-checkproxy status [--live | --traffic [--top <n>]]")
+	}
+	cfg, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	if *live {
+		return printLiveStatus(cfg)
+	}
+	if *traffic {
+		return printTraffic(cfg, *top)
+	}
+
+	if cfg.envOnly {
+		fmt.Printf("Config: environment only (no %s)\n\n", ConfigPath())
+	} else {
+		fmt.Printf("Config: %s\n\n", ConfigPath())
+	}
+	t := cfg.GetClassicToken()
+	src := cfg.classicTokenSource()
+	if t == "" {
+		fmt.Printf("  Classic token:  not set\n")
+	} else if src != "" && src != "config" {
+		fmt.Printf("  Classic token:  (from %s) %s\n", src, maskToken(t))
+	} else {
+		fmt.Printf("  Classic token:  %s\n", maskToken(t))
+	}
+	if t != "" {
+		if info, err := checkClassicToken(cfg.APIBase(), t, nil); err != nil {
+			fmt.Printf("  Token check:    FAILED — %v\n", err)
+		} else {
+			fmt.Printf("  Token check:    ok (%s; scopes: %s)\n", info.Login, strings.Join(info.Scopes, ", "))
+			if info.Expires.IsZero() {
+				fmt.Printf("  Token expires:  never\n")
+			} else {
+				fmt.Printf("  Token expires:  %s (in %s)\n", info.Expires.Local().Format("2006-01-02 15:04 MST"), formatLifetime(time.Until(info.Expires)))
+			}
+		}
+	}
+	if len(cfg.AllowedOrgs) > 0 {
+		fmt.Printf("  Allowed orgs:   %s\n", strings.Join(cfg.AllowedOrgs, ", "))
+	} else {
+		fmt.Printf("  Allowed orgs:   (any)\n")
+	}
+	if len(cfg.AllowedRepos) > 0 {
+		fmt.Printf("  Allowed repos:  %s\n", strings.Join(cfg.AllowedRepos, ", "))
+	}
+	if len(cfg.DeniedRepos) > 0 {
+		fmt.Printf("  Denied repos:   %s\n", strings.Join(cfg.DeniedRepos, ", "))
+	}
+	if len(cfg.Listeners) == 0 {
+		fmt.Printf("  Port:           %d\n", cfg.Port)
+	}
+	for _, l := range cfg.Listeners {
+		fmt.Printf("  Listener:       %s%s\n", l.Address, l.notes(len(cfg.Listeners) > 1))
+	}
+	if p, _ := readPIDFile(ServerPIDPath()); p != nil {
+		fmt.Printf("  Background:     running (pid %d)\n", p.Pid)
+	}
+	fmt.Printf("  GitHub API:     %s\n", cfg.APIBase())
+	if cfg.BasePath != "" {
+		fmt.Printf("  Base path:      %s\n", cfg.BasePath)
+	}
+	if cfg.PublicURL != "" {
+		fmt.Printf("  Public URL:     %s\n", cfg.PublicURL)
+	}
+	if len(cfg.TrustedProxies) > 0 {
+		fmt.Printf("  Trusted proxies: %s\n", strings.Join(cfg.TrustedProxies, ", "))
+	}
+	if cfg.H2C {
+		fmt.Printf("  HTTP/2:         h2c enabled\n")
+	}
+	if cfg.TLSEnabled() {
+		fmt.Printf("  TLS:            %s\n", cfg.TLSCertFile)
+	}
+	if cfg.RewriteBodyURLs {
+		fmt.Printf("  Body URLs:      rewritten to proxy\n")
+	}
+	if cfg.CORS != nil && len(cfg.CORS.AllowedOrigins) > 0 {
+		fmt.Printf("  CORS origins:   %s\n", strings.Join(cfg.CORS.AllowedOrigins, ", "))
+	}
+	if cfg.RateLimit != nil && cfg.RateLimit.RequestsPerMinute > 0 {
+		fmt.Printf("  Rate limit:     %g/min per client (burst %d)\n", cfg.RateLimit.RequestsPerMinute, cfg.RateLimit.Burst)
+	}
+	if cfg.MaxResponseBytes > 0 {
+		fmt.Printf("  Max response:   %d bytes\n", cfg.MaxResponseBytes)
+	}
+	if cfg.MaxConcurrentRequests > 0 {
+		fmt.Printf("  Concurrency:    %d proxied requests in flight\n", cfg.MaxConcurrentRequests)
+	}
+	if cfg.MaxClientConcurrency > 0 {
+		fmt.Printf("  Per client:     %d proxied requests in flight\n", cfg.MaxClientConcurrency)
+	}
+	if b := cfg.RateBudget; b != nil {
+		fmt.Printf("  Rate budget:    pace below %d remaining\n", b.Floor)
+	}
+	if a := cfg.Alerts; a != nil {
+		var to []string
+		if a.WebhookURL != "" {
+			to = append(to, "webhook")
+		}
+		if len(a.Command) > 0 {
+			to = append(to, "command "+a.Command[0])
+		}
+		fmt.Printf("  Alerts:         %s\n", strings.Join(to, " and "))
+	}
+	if c := cfg.ResponseCache; c != nil {
+		if c.Disabled {
+			fmt.Printf("  Response cache: off\n")
+		} else {
+			routes := len(c.Routes)
+			if routes == 0 {
+				routes = len(defaultCacheRoutes)
+			}
+			fmt.Printf("  Response cache: %d responses or %s, %d route TTLs\n", cmp.Or(c.MaxEntries, defaultResponseCacheEntries),
+				formatBytes(uint64(cmp.Or(c.MaxBytes, defaultResponseCacheBytes))), routes)
+			if c.Persist {
+				fmt.Printf("  Response files: %s\n", cmp.Or(c.Dir, ResponseCachePath()))
+			}
+		}
+	}
+	if cfg.Redis != nil {
+		if r, err := newRedisClient(cfg.Redis); err != nil {
+			fmt.Printf("  Redis:          %v\n", err)
+		} else {
+			fmt.Printf("  Redis:          %s\n", r)
+		}
+	}
+	if t := cfg.UpstreamTimeouts; t != nil {
+		fmt.Printf("  Timeouts:       %s (streaming %s, %d route overrides)\n",
+			cmp.Or(t.Default, defaultUpstreamTimeout.String()), cmp.Or(t.Streaming, defaultStreamingTimeout.String()), len(t.Routes))
+	}
+	if c := cfg.UpstreamConnections; c != nil {
+		var t http.Transport
+		if err := configureUpstreamConnections(&t, c); err != nil {
+			fmt.Printf("  Connections:    %v\n", err)
+		} else {
+			fmt.Printf("  Connections:    %d idle per host, %d in all, kept %s\n", t.MaxIdleConnsPerHost, t.MaxIdleConns, t.IdleConnTimeout)
+		}
+	}
+	if cfg.ServerTimeouts != nil {
+		if p, err := compileServerTimeouts(cfg.ServerTimeouts); err != nil {
+			fmt.Printf("  Server timeouts: %v\n", err)
+		} else {
+			fmt.Printf("  Server timeouts: %s\n", p)
+		}
+	}
+	for _, u := range cfg.Upstreams {
+		up, _ := cfg.resolveUpstream(u.Name)
+		token := "not set"
+		if up.token != "" {
+			token = maskToken(up.token)
+		}
+		fmt.Printf("  Upstream %-6s %s (token: %s)\n", u.Name+":", up.apiBase, token)
+	}
+	for _, org := range slices.Sorted(maps.Keys(cfg.OrgTokens)) {
+		token := "not set"
+		if t, _ := cfg.orgToken(org); t != "" {
+			token = maskToken(t)
+		}
+		fmt.Printf("  Org token:      %s (%s)\n", org, token)
+	}
+	if cfg.GitHubApp != nil {
+		fmt.Printf("  GitHub App:     %d\n", cfg.GitHubApp.AppID)
+	}
+	if len(cfg.ClassicTokenPool) > 0 {
+		fmt.Printf("  Token pool:     %d classic tokens\n", len(cfg.poolTokens()))
+	}
+	fmt.Printf("  Cache TTL:      %s\n", cfg.ValidationCacheTTL)
+	if cfg.ValidationNegativeTTL != "" {
+		fmt.Printf("  Negative TTL:   %s\n", cfg.ValidationNegativeTTL)
+	}
+	if cfg.ValidationCacheSize > 0 {
+		fmt.Printf("  Cache size:     %d entries\n", cfg.ValidationCacheSize)
+	}
+	if cfg.PersistValidationCache {
+		fmt.Printf("  Cache file:     %s\n", ValidationCachePath())
+	}
+	switch cfg.validationMode() {
+	case validationStrict:
+		fmt.Printf("  Validation:     strict (per resource)\n")
+	case validationOff:
+		fmt.Printf("  Validation:     off (client tokens are not checked)\n")
+	}
+	if cfg.RequireOrgMembership {
+		fmt.Printf("  Membership:     token owner must belong to an allowed org\n")
+	}
+	if len(cfg.ClientTokenPrefixes) > 0 {
+		fmt.Printf("  Client tokens:  %s\n", strings.Join(cfg.ClientTokenPrefixes, ", "))
+	}
+	if cfg.GetWebhookSecret() != "" {
+		fmt.Printf("  Webhook:        enabled (/v1/webhook)\n")
+	}
+	if cfg.GetAdminToken() != "" {
+		fmt.Printf("  Admin API:      enabled (/v1/admin/)\n")
+	}
+	if cfg.AuditLog != "" {
+		fmt.Printf("  Audit log:      %s\n", cfg.AuditLog)
+	}
+	if cfg.LogPrivacy != "" {
+		fmt.Printf("  Log privacy:    %s\n", cfg.LogPrivacy)
+	}
+	if len(cfg.AllowWrites) > 0 {
+		fmt.Printf("  Allowed writes: %s\n", strings.Join(cfg.AllowWrites, ", "))
+	}
+	if len(cfg.ExtraAllowedRoutes) > 0 {
+		fmt.Printf("  Extra routes:   %s\n", strings.Join(cfg.ExtraAllowedRoutes, ", "))
+	}
+	if len(cfg.DeniedRoutes) > 0 {
+		fmt.Printf("  Denied routes:  %s\n", strings.Join(cfg.DeniedRoutes, ", "))
+	}
+
+	// Cache counters only exist in a running server; skip them quietly if
+	// there isn't one on this host.
+	if local, client, err := cfg.localServer(2 * time.Second); err != nil {
+		fmt.Printf("  TLS:            %v\n", err)
+	} else if m, err := fetchMetrics(client, local); err == nil {
+		hits, misses := m["checkproxy_validation_cache_hits_total"], m["checkproxy_validation_cache_misses_total"]
+		rate := 0.0
+		if hits+misses > 0 {
+			rate = 100 * hits / (hits + misses)
+		}
+		fmt.Printf("\nRunning server (%s):\n\n", local)
+		fmt.Printf("  Cache entries:  %.0f / %.0f\n", m["checkproxy_validation_cache_entries"], m["checkproxy_validation_cache_capacity"])
+		fmt.Printf("  Cache hits:     %.0f (%.1f%% hit rate)\n", hits, rate)
+		fmt.Printf("  Cache misses:   %.0f\n", misses)
+		fmt.Printf("  Evictions:      %.0f\n", m["checkproxy_validation_cache_evictions_total"])
+		if entries, ok := m["checkproxy_response_cache_entries"]; ok {
+			fmt.Printf("  Responses:      %.0f cached (%s of %s), %.0f served after a 304, %.0f within their TTL\n",
+				entries, formatBytes(uint64(m["checkproxy_response_cache_bytes"])), formatBytes(uint64(m["checkproxy_response_cache_max_bytes"])),
+				m["checkproxy_response_cache_hits_total"], m["checkproxy_response_cache_fresh_hits_total"])
+			if stale := m["checkproxy_response_cache_stale_hits_total"]; stale > 0 {
+				fmt.Printf("  Stale served:   %.0f while refreshing\n", stale)
+			}
+		}
+	}
+	return nil
+}
+
+func maskToken(token string) string {
+	if len(token) < 8 {
+		return "***"
+	}
+	return token[:4] + "***..." + token[len(token)-4:]
+}
+
+type githubOrg struct {
+	Login string `json:"login"`
+}
+
+// fetchUserOrgs lists the organizations the classic token has access to.
+func fetchUserOrgs(apiBase, token string) ([]string, error) {
+	req, err := http.NewRequest("GET", apiBase+"/user/orgs?per_page=100", nil)
+	if err != nil {
+		return nil, err
+	}
+	setGitHubHeaders(req, token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("GitHub API returned %d", resp.StatusCode)
+	}
+
+	var orgs []githubOrg
+	if err := json.NewDecoder(resp.Body).Decode(&orgs); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(orgs))
+	for i, o := range orgs {
+		names[i] = o.Login
+	}
+	return names, nil
+}
+
+// classicTokenInfo is what GitHub reports about a classic token.
+type classicTokenInfo struct {
+	Login   string
+	Scopes  []string
+	Expires time.Time // zero if the token doesn't expire
+}
+
+// checkClassicToken asks GitHub who token belongs to and which OAuth scopes
+// it has, failing if GitHub rejects it or it lacks the repo scope. Transport
+// failures are returned as *url.Error so callers can tell "GitHub is
+// unreachable" from "the token is bad". The rate limit GitHub reports is
+// recorded in limits, if not nil.
+func checkClassicToken(apiBase, token string, limits *rateLimitTracker) (classicTokenInfo, error) {
+	var info classicTokenInfo
+	req, err := http.NewRequest("GET", apiBase+"/user", nil)
+	if err != nil {
+		return info, err
+	}
+	setGitHubHeaders(req, token)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return info, err
+	}
+	defer resp.Body.Close()
+	limits.record(req, resp)
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return info, fmt.Errorf("GitHub rejected the token (expired or revoked?)")
+	case resp.StatusCode != http.StatusOK:
+		return info, fmt.Errorf("GitHub API returned %d for /user", resp.StatusCode)
+	}
+	var user struct {
+		Login string `json:"login"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&user)
+	info.Login = user.Login
+	info.Scopes = splitComma(resp.Header.Get("X-OAuth-Scopes"))
+	info.Expires = parseTokenExpiration(resp.Header.Get("GitHub-Authentication-Token-Expiration"))
+
+	if !slices.Contains(info.Scopes, "repo") {
+		scopes := strings.Join(info.Scopes, ", ")
+		if scopes == "" {
+			scopes = "none"
+		}
+		return info, fmt.Errorf("token lacks the repo scope (has: %s)", scopes)
+	}
+	return info, nil
+}
+
+// splitComma splits a comma-separated string into trimmed, non-empty tokens.
+func splitComma(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if t := strings.TrimSpace(part); t != "" {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// validateRepoPattern checks that p is an owner/repo glob usable by repoMatches.
+func validateRepoPattern(p string) error {
+	parts := strings.Split(p, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("invalid repo pattern %q: use owner/repo (wildcards allowed, e.g. myorg/api-*)", p)
+	}
+	if _, err := path.Match(p, ""); err != nil {
+		return fmt.Errorf("invalid repo pattern %q: %w", p, err)
+	}
+	return nil
+}
+
+// resolveOrgSelections parses user input (numbers and/or names) against the
+// fetched org list, returning the resolved org names.
+func resolveOrgSelections(input string, orgs []string) []string {
+	var result []string
+	seen := make(map[string]struct{})
+	for _, token := range splitComma(input) {
+		var name string
+		if n, err := strconv.Atoi(token); err == nil && n >= 1 && n <= len(orgs) {
+			name = orgs[n-1]
+		} else {
+			name = token
+		}
+		if _, exists := seen[name]; !exists {
+			seen[name] = struct{}{}
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// setGitHubHeaders adds standard GitHub API headers to a request.
+func setGitHubHeaders(req *http.Request, token string) {
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+}
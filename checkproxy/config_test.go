@@ -0,0 +1,64 @@
+package checkproxy
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestTokenTargetsPool(t *testing.T) {
+	t.Setenv("GH_CHECKPROXY_CLASSIC_TOKEN", "")
+	t.Setenv("GH_TOKEN", "")
+	app := &GitHubAppConfig{AppID: 1}
+	tests := []struct {
+		name        string
+		cfg         Config
+		wantTargets []string
+		wantPool    []string // nil for no pool
+	}{
+		{
+			name:        "classic token and pool",
+			cfg:         Config{ClassicToken: "ghp_a", ClassicTokenPool: []TokenRef{{ClassicToken: "ghp_b"}}},
+			wantTargets: []string{"ghp_a", "ghp_b"},
+			wantPool:    []string{"ghp_a", "ghp_b"},
+		},
+		{
+			name:        "app and pool",
+			cfg:         Config{GitHubApp: app, ClassicTokenPool: []TokenRef{{ClassicToken: "ghp_b"}, {ClassicToken: "ghp_c"}}},
+			wantTargets: []string{"ghp_b", "ghp_c"},
+			wantPool:    []string{"ghp_b", "ghp_c"},
+		},
+		{
+			name: "app and empty pool entries",
+			cfg:  Config{GitHubApp: app, ClassicTokenPool: []TokenRef{{TokenEnv: "GH_CHECKPROXY_UNSET_TOKEN"}}},
+		},
+		{
+			name:        "classic token alone",
+			cfg:         Config{ClassicToken: "ghp_a"},
+			wantTargets: []string{"ghp_a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targets, pool, err := tt.cfg.tokenTargets()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, target := range targets {
+				got = append(got, target.token)
+			}
+			if !slices.Equal(got, tt.wantTargets) {
+				t.Errorf("targets = %q, want %q", got, tt.wantTargets)
+			}
+			if tt.wantPool == nil {
+				if pool != nil {
+					t.Errorf("pool = %q, want none", pool.tokens)
+				}
+				return
+			}
+			if pool == nil || !slices.Equal(pool.tokens, tt.wantPool) {
+				t.Errorf("pool = %v, want %q", pool, tt.wantPool)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package checkproxy
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// UpstreamConnections sizes the pool of keep-alive connections to GitHub.
+type UpstreamConnections struct {
+	// MaxIdle caps idle connections across all upstream hosts (default 100).
+	MaxIdle int `json:"max_idle,omitempty"`
+	// MaxIdlePerHost caps idle connections to one host (default 32).
+	MaxIdlePerHost int `json:"max_idle_per_host,omitempty"`
+	// IdleTimeout is how long an idle connection is kept open (default 90s).
+	IdleTimeout string `json:"idle_timeout,omitempty"`
+}
+
+// Go keeps only 2 idle connections per host by default, so a proxy with
+// more than 2 requests to GitHub in flight closes the rest afterwards and
+// opens new ones (with a TLS handshake each) for the next burst.
+const (
+	defaultMaxIdleConns        = 100
+	defaultMaxIdleConnsPerHost = 32
+	defaultIdleConnTimeout     = 90 * time.Second
+)
+
+// configureUpstreamConnections applies c to t, the defaults for what c
+// leaves unset. Each server applies it to its own clone of
+// http.DefaultTransport before any upstream client is created, so every
+// client (and the passthrough handler's clone of it) shares the settings
+// while the process's default transport is left alone.
+func configureUpstreamConnections(t *http.Transport, c *UpstreamConnections) error {
+	t.MaxIdleConns = defaultMaxIdleConns
+	t.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	t.IdleConnTimeout = defaultIdleConnTimeout
+	if c == nil {
+		return nil
+	}
+	if c.MaxIdle < 0 || c.MaxIdlePerHost < 0 {
+		return errors.New("max_idle and max_idle_per_host must not be negative")
+	}
+	if c.MaxIdle > 0 {
+		t.MaxIdleConns = c.MaxIdle
+	}
+	if c.MaxIdlePerHost > 0 {
+		t.MaxIdleConnsPerHost = c.MaxIdlePerHost
+	}
+	if t.MaxIdleConnsPerHost > t.MaxIdleConns {
+		return fmt.Errorf("max_idle_per_host (%d) is more than max_idle (%d)", t.MaxIdleConnsPerHost, t.MaxIdleConns)
+	}
+	if c.IdleTimeout != "" {
+		d, err := time.ParseDuration(c.IdleTimeout)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("idle_timeout: %q is not a positive duration", c.IdleTimeout)
+		}
+		t.IdleConnTimeout = d
+	}
+	return nil
+}
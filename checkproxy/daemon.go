@@ -0,0 +1,144 @@
+package checkproxy
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ServerPIDPath returns the PID file of a server started with
+// `serve --background`, next to the config file.
+func ServerPIDPath() string {
+	return filepath.Join(filepath.Dir(ConfigPath()), "serve.pid")
+}
+
+// ServerLogPath returns where a background server's output goes.
+func ServerLogPath() string {
+	return filepath.Join(filepath.Dir(ConfigPath()), "serve.log")
+}
+
+// readPIDFile returns the process recorded in path if it is still running.
+// A stale file is removed.
+func readPIDFile(path string) (*os.Process, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: not a PID file", path)
+	}
+	if p, ok := findRunning(pid); ok {
+		return p, nil
+	}
+	os.Remove(path)
+	return nil, nil
+}
+
+func writePIDFile(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0600)
+}
+
+// startBackground starts `serve` detached from the terminal with output
+// appended to ServerLogPath, and waits until it has written its PID file
+// (it's listening) or exited.
+func startBackground(serveArgs []string) error {
+	pidPath := ServerPIDPath()
+	if p, err := readPIDFile(pidPath); err != nil {
+		return err
+	} else if p != nil {
+		return fmt.Errorf("already running (pid %d); use 'gh-checkproxy restart'", p.Pid)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	logPath := ServerLogPath()
+	if err := os.MkdirAll(filepath.Dir(logPath), 0700); err != nil {
+		return err
+	}
+	logFile, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(exe, append([]string{"serve", "--pid-file", pidPath}, serveArgs...)...)
+	cmd.Stdout, cmd.Stderr = logFile, logFile
+	cmd.SysProcAttr = detachedProcAttr()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	deadline := time.After(10 * time.Second)
+	for {
+		select {
+		case err := <-exited:
+			return fmt.Errorf("server exited during startup (%v); see %s", err, logPath)
+		case <-deadline:
+			return fmt.Errorf("server (pid %d) did not come up within 10s; see %s", cmd.Process.Pid, logPath)
+		case <-time.After(100 * time.Millisecond):
+			if p, _ := readPIDFile(pidPath); p != nil {
+				fmt.Printf("gh-checkproxy serving in the background (pid %d)\n  Log: %s\n", p.Pid, logPath)
+				return nil
+			}
+		}
+	}
+}
+
+// stopBackground stops the server recorded in the PID file and waits for it
+// to exit. It reports false if no server was running.
+func stopBackground() (bool, error) {
+	pidPath := ServerPIDPath()
+	p, err := readPIDFile(pidPath)
+	if err != nil || p == nil {
+		return false, err
+	}
+	if err := terminate(p); err != nil {
+		return false, fmt.Errorf("stopping pid %d: %w", p.Pid, err)
+	}
+	// The server finishes in-flight requests for up to 5s on shutdown.
+	for range 100 {
+		if _, ok := findRunning(p.Pid); !ok {
+			os.Remove(pidPath)
+			return true, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return false, fmt.Errorf("pid %d did not exit within 10s", p.Pid)
+}
+
+// runStop implements `gh-checkproxy stop`.
+func runStop() error {
+	stopped, err := stopBackground()
+	if err != nil {
+		return err
+	}
+	if !stopped {
+		return errors.New("no background server is running")
+	}
+	fmt.Println("gh-checkproxy stopped")
+	return nil
+}
+
+// runRestart implements `gh-checkproxy restart`: stop the background server
+// if there is one, then start a new one with the given serve flags.
+func runRestart(args []string) error {
+	if _, err := stopBackground(); err != nil {
+		return err
+	}
+	return startBackground(args)
+}
@@ -0,0 +1,31 @@
+//go:build unix
+
+package checkproxy
+
+import (
+	"os"
+	"syscall"
+)
+
+// detachedProcAttr starts the background server in its own session, so it
+// outlives the terminal that started it.
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
+
+// findRunning returns the process with pid if it exists.
+func findRunning(pid int) (*os.Process, bool) {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return nil, false
+	}
+	if err := p.Signal(syscall.Signal(0)); err != nil && err != syscall.EPERM {
+		return nil, false
+	}
+	return p, true
+}
+
+// terminate asks the server to shut down cleanly.
+func terminate(p *os.Process) error {
+	return p.Signal(syscall.SIGTERM)
+}
@@ -0,0 +1,28 @@
+//go:build windows
+
+package checkproxy
+
+import (
+	"os"
+	"syscall"
+)
+
+// detachedProcAttr starts the background server without a console, so it
+// outlives the one that started it.
+func detachedProcAttr() *syscall.SysProcAttr {
+	const detachedProcess = 0x00000008 // DETACHED_PROCESS
+	return &syscall.SysProcAttr{CreationFlags: detachedProcess | syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// findRunning returns the process with pid if it exists; on Windows
+// FindProcess fails for processes that have exited.
+func findRunning(pid int) (*os.Process, bool) {
+	p, err := os.FindProcess(pid)
+	return p, err == nil
+}
+
+// terminate stops the server. Windows has no SIGTERM, so it doesn't get to
+// finish in-flight requests or save the validation cache.
+func terminate(p *os.Process) error {
+	return p.Kill()
+}
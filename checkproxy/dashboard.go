@@ -0,0 +1,61 @@
+package checkproxy
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// dashboardHTML is the admin dashboard page. It polls /admin/data and
+// renders everything client-side, so it needs no assets of its own.
+//
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// dashboardData is the body of GET /admin/data: the live stats plus what
+// only the dashboard shows.
+type dashboardData struct {
+	liveStats
+	RateBudgetFloor int            `json:"rate_budget_floor,omitempty"`
+	RecentDenials   []auditRecord  `json:"recent_denials"`
+	Orgs            []orgDecisions `json:"orgs"`
+}
+
+// dashboardRoutes serves the admin dashboard at /admin when an admin token
+// is configured. Browsers log in with HTTP Basic auth, using the admin token
+// as the password and any user name; these read-only routes are the only
+// ones that accept it.
+func dashboardRoutes(mux *http.ServeMux, cfg *Config, s *server) {
+	token := cfg.GetAdminToken()
+	if token == "" {
+		return
+	}
+	handle := func(pattern string, h http.HandlerFunc) {
+		mux.Handle(pattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="gh-checkproxy admin", charset="UTF-8"`)
+			w.Header().Set("Cache-Control", "no-store")
+			if !isDashboardAdmin(r, token) {
+				http.Error(w, "unauthorized: admin token required", http.StatusUnauthorized)
+				return
+			}
+			h(w, r)
+		}))
+	}
+
+	handle("GET /admin", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; connect-src 'self'")
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.Write(dashboardHTML)
+	})
+	handle("GET /admin/data", func(w http.ResponseWriter, r *http.Request) {
+		out := dashboardData{
+			liveStats:     collectLiveStats(s),
+			RecentDenials: s.decisions.recentDenials(),
+			Orgs:          s.decisions.byOrg(),
+		}
+		if s.budget != nil {
+			out.RateBudgetFloor = s.budget.floor
+		}
+		writeJSON(w, http.StatusOK, out)
+	})
+}
@@ -0,0 +1,311 @@
+package checkproxy
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxClockSkew is how far this host's clock may drift from GitHub's or the
+// proxy's before doctor warns. HMAC signatures fail at hmacMaxSkew; GitHub
+// App JWTs are rejected well before that.
+const maxClockSkew = 30 * time.Second
+
+// doctorReport prints check results and remembers whether any failed.
+type doctorReport struct {
+	failed bool
+}
+
+func (d *doctorReport) section(title string) {
+	fmt.Printf("\n%s\n", title)
+}
+
+func (d *doctorReport) ok(name, detail string) {
+	fmt.Printf("  ok    %s: %s\n", name, detail)
+}
+
+func (d *doctorReport) warn(name, problem, fix string) {
+	fmt.Printf("  WARN  %s: %s\n", name, problem)
+	if fix != "" {
+		fmt.Printf("        fix: %s\n", fix)
+	}
+}
+
+func (d *doctorReport) fail(name, problem, fix string) {
+	d.failed = true
+	fmt.Printf("  FAIL  %s: %s\n", name, problem)
+	if fix != "" {
+		fmt.Printf("        fix: %s\n", fix)
+	}
+}
+
+// runDoctor implements `gh-checkproxy doctor`: check this host's server
+// setup, its client setup, or both, and suggest a fix for each problem. The
+// server checks run when there is a config (file or environment), the
+// client checks when a proxy URL is set; --server and --client force them.
+func runDoctor(args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	server := fs.Bool("server", false, "Check the server setup even without a config")
+	client := fs.Bool("client", false, "Check the client setup even without a proxy URL")
+	proxyURL := fs.String("proxy-url", "", "Proxy URL to check (or $GH_CHECKPROXY_URL)")
+	repo := fs.String("repo", "", "Repository to validate the client token against (auto-detected from git remote)")
+	token := fs.String("token", "", "Fine-grained token to check (or $GH_TOKEN / $GITHUB_TOKEN)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return errors.New("usage: gh-checkproxy doctor [--server] [--client] [--proxy-url <url>] [--repo <owner/repo>]")
+	}
+	pURL := strings.TrimRight(firstNonEmpty(*proxyURL, os.Getenv("GH_CHECKPROXY_URL")), "/")
+	_, statErr := os.Stat(ConfigPath())
+	haveConfig := statErr == nil || slices.ContainsFunc(configEnvVars, func(v string) bool { return os.Getenv(v) != "" })
+	if !*server && !*client {
+		*server, *client = haveConfig, pURL != ""
+	}
+
+	var d doctorReport
+	if !*server && !*client {
+		d.fail("setup", "no server config and no proxy URL found",
+			"on the proxy host run 'gh-checkproxy config'; on an agent host set GH_CHECKPROXY_URL")
+	}
+	if *server {
+		d.section("Server")
+		doctorServer(&d)
+	}
+	if *client {
+		d.section("Client")
+		doctorClient(&d, pURL, firstNonEmpty(*token, os.Getenv("GH_TOKEN"), os.Getenv("GITHUB_TOKEN")), *repo)
+	}
+	fmt.Println()
+	if d.failed {
+		return errors.New("some checks failed")
+	}
+	fmt.Println("No problems found.")
+	return nil
+}
+
+func doctorServer(d *doctorReport) {
+	path := ConfigPath()
+	if fi, err := os.Stat(path); err == nil {
+		// Windows doesn't have Unix permission bits; its ACLs follow the
+		// user profile.
+		if perm := fi.Mode().Perm(); runtime.GOOS != "windows" && perm&0077 != 0 {
+			d.fail("config file", fmt.Sprintf("%s is readable by other users (mode %04o) and may hold tokens", path, perm),
+				fmt.Sprintf("chmod 600 %s", path))
+		} else {
+			d.ok("config file", path)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		d.fail("config file", err.Error(), "check the permissions of "+path)
+	}
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		d.fail("config", err.Error(), "fix the file or rerun 'gh-checkproxy config'")
+		return
+	}
+	if cfg.envOnly {
+		d.ok("config", "environment only")
+	}
+
+	targets, _, err := cfg.tokenTargets()
+	switch {
+	case err != nil:
+		d.fail("classic tokens", err.Error(), "set the missing token variables or store the tokens in the config file")
+	case cfg.GetClassicToken() == "" && cfg.GitHubApp == nil:
+		d.fail("classic token", "not set",
+			"set GH_CHECKPROXY_CLASSIC_TOKEN, or run 'gh-checkproxy config' to store one")
+	}
+	reached := make(map[string]bool)
+	for _, t := range targets {
+		label := tokenLabel(t.name)
+		info, err := checkClassicToken(t.apiBase, t.token, nil)
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			d.fail("GitHub API", fmt.Sprintf("can't reach %s: %v", t.apiBase, urlErr.Err),
+				"check DNS, firewall, and HTTPS_PROXY on this host, and api_base_url in the config")
+			continue
+		}
+		if !reached[t.apiBase] {
+			reached[t.apiBase] = true
+			d.ok("GitHub API", t.apiBase)
+			doctorClock(d, "clock", t.apiBase)
+		}
+		switch {
+		case err != nil && info.Login != "":
+			d.fail(label, err.Error(), "create a classic token with the repo scope at https://github.com/settings/tokens")
+		case err != nil:
+			d.fail(label, err.Error(), "replace it with a valid classic token with the repo scope")
+		default:
+			d.ok(label, fmt.Sprintf("%s, scopes: %s", info.Login, strings.Join(info.Scopes, ", ")))
+			if !info.Expires.IsZero() && time.Until(info.Expires) < defaultTokenExpiryWarnDays*24*time.Hour {
+				d.warn(label, "expires in "+formatLifetime(time.Until(info.Expires)), "rotate it before then")
+			}
+		}
+	}
+
+	if cfg.TLSEnabled() {
+		if _, err := cfg.loadTLSCertificate(); err != nil {
+			d.fail("TLS certificate", err.Error(), "check tls_cert_file and tls_key_file")
+		} else {
+			d.ok("TLS certificate", cfg.TLSCertFile)
+		}
+	}
+
+	running, _ := readPIDFile(ServerPIDPath())
+	for _, l := range cfg.serveListeners() {
+		name := "listen " + l.Address
+		if path, ok := l.unixPath(); ok {
+			if conn, err := net.Dial("unix", path); err == nil {
+				conn.Close()
+				d.ok(name, "a server is answering on the socket")
+			} else if _, err := os.Stat(filepath.Dir(path)); err != nil {
+				d.fail(name, err.Error(), "create the socket's directory")
+			} else {
+				d.ok(name, "available")
+			}
+			continue
+		}
+		ln, err := net.Listen("tcp", l.Address)
+		if err == nil {
+			ln.Close()
+			d.ok(name, "available")
+			continue
+		}
+		if serverAnswers(cfg) {
+			d.ok(name, "in use by the running gh-checkproxy")
+			continue
+		}
+		fix := "stop the process holding it or choose another port ('gh-checkproxy config --port')"
+		if running != nil {
+			fix = fmt.Sprintf("the background server (pid %d) isn't answering; run 'gh-checkproxy restart'", running.Pid)
+		}
+		d.fail(name, err.Error(), fix)
+	}
+}
+
+// serverAnswers reports whether a gh-checkproxy server answers on cfg's
+// local address.
+func serverAnswers(cfg *Config) bool {
+	local, client, err := cfg.localServer(2 * time.Second)
+	if err != nil {
+		return false
+	}
+	resp, err := client.Get(local + "/v1/health")
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// doctorClock compares this host's clock with the Date header of base.
+func doctorClock(d *doctorReport, name, base string) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	before := time.Now()
+	resp, err := client.Head(base)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+	remote, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return
+	}
+	// Date has one-second resolution; judge against the request midpoint.
+	local := before.Add(time.Since(before) / 2)
+	skew := local.Sub(remote).Round(time.Second)
+	if skew > maxClockSkew || skew < -maxClockSkew {
+		d.warn(name, fmt.Sprintf("this host is %s off from %s", skew.Abs(), resp.Request.URL.Host),
+			"enable time sync (NTP, systemd-timesyncd, or w32time)")
+		return
+	}
+	d.ok(name, fmt.Sprintf("within %s of %s", maxClockSkew, resp.Request.URL.Host))
+}
+
+func doctorClient(d *doctorReport, pURL, token, repo string) {
+	if pURL == "" {
+		d.fail("proxy URL", "not set", "export GH_CHECKPROXY_URL=https://<proxy host>")
+		return
+	}
+	if _, err := url.ParseRequestURI(pURL); err != nil {
+		d.fail("proxy URL", err.Error(), "set GH_CHECKPROXY_URL to the proxy's base URL, e.g. http://proxy:8080")
+		return
+	}
+	hmacID := os.Getenv("GH_CHECKPROXY_HMAC_ID")
+	switch {
+	case token == "" && hmacID != "":
+		d.ok("credential", "HMAC key "+hmacID)
+	case token == "":
+		d.fail("token", "not set", "export GH_TOKEN=github_pat_… (a fine-grained token with read access to your repositories)")
+	case isClassicToken(token):
+		d.warn("token", "a classic token; the proxy exists so agents can use fine-grained ones",
+			"create a fine-grained token with Metadata: read on the repositories agents check")
+	default:
+		d.ok("token", maskToken(token))
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(pURL + "/v1/health")
+	if err != nil {
+		d.fail("proxy", fmt.Sprintf("can't reach %s: %v", pURL, err),
+			"check that the server is running ('gh-checkproxy status' on the proxy host) and reachable from here")
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		d.fail("proxy", fmt.Sprintf("%s/v1/health returned %d", pURL, resp.StatusCode),
+			"check GH_CHECKPROXY_URL, including any base path the proxy is mounted under")
+		return
+	}
+	d.ok("proxy", pURL)
+	doctorClock(d, "clock", pURL+"/v1/health")
+
+	if token == "" {
+		return
+	}
+	if repo == "" {
+		repo = detectRepo(firstNonEmpty(os.Getenv("GH_HOST"), "github.com"))
+	}
+	if repo == "" {
+		d.warn("token validation", "skipped: no repository", "rerun in a git checkout or pass --repo owner/repo")
+		return
+	}
+	req, err := http.NewRequest("GET", pURL+"/repos/"+repo+"/pulls?per_page=1", nil)
+	if err != nil {
+		d.fail("token validation", err.Error(), "pass --repo owner/repo")
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if name := os.Getenv("GH_CHECKPROXY_UPSTREAM"); name != "" {
+		req.Header.Set(upstreamHeader, name)
+	}
+	resp, err = client.Do(req)
+	if err != nil {
+		d.fail("token validation", err.Error(), "")
+		return
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+	switch resp.StatusCode {
+	case http.StatusOK:
+		d.ok("token validation", "the proxy accepts the token for "+repo)
+	case http.StatusUnauthorized:
+		d.fail("token validation", strings.TrimSpace(string(msg)), "the token is invalid or expired; create a new one")
+	case http.StatusForbidden:
+		d.fail("token validation", strings.TrimSpace(string(msg)),
+			"give the token access to "+repo+", or ask the proxy operator to allow this org or repo")
+	default:
+		d.fail("token validation", fmt.Sprintf("%d %s", resp.StatusCode, strings.TrimSpace(string(msg))), "")
+	}
+}
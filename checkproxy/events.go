@@ -0,0 +1,120 @@
+package checkproxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const sseKeepAlive = 30 * time.Second
+
+// eventsHandler serves /v1/events/repos/{owner}/{repo}/commits/{sha} as a
+// Server-Sent Events stream. Each "checks" event carries a JSON checkState
+// and is sent whenever the commit's aggregated checks change; the event id is
+// the state's ETag.
+func (s *server) eventsHandler(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		owner, repo, sha, ok := parseCommitStreamPath(r.URL.Path, "/v1/events", "")
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		up, ok := s.selectUpstream(w, r, cfg, owner)
+		if !ok {
+			return
+		}
+		if !s.authorizeRepo(w, r, cfg, up, owner, repo, resourceProbe{groups: []string{"checks", "statuses"}, ref: sha}) {
+			return
+		}
+
+		rc := http.NewResponseController(w)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		states, unsubscribe := s.hub.subscribe(up, owner, repo, sha)
+		defer unsubscribe()
+
+		keepAlive := time.NewTicker(sseKeepAlive)
+		defer keepAlive.Stop()
+		for {
+			// The stream has no end; only each write is bounded.
+			s.listenTimeouts.extendWriteDeadline(w, sseKeepAlive)
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+			case state := <-states:
+				data, _ := json.Marshal(state)
+				fmt.Fprintf(w, "event: checks\nid: %s\ndata: %s\n\n", state.ETag, data)
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// subscribeCheckEvents opens the proxy's SSE stream for owner/repo@sha and
+// delivers each checks event on the returned channel, which is closed when
+// the stream ends. It fails if the proxy doesn't offer the stream (older
+// proxies answer 404), so callers can fall back to polling.
+func subscribeCheckEvents(client *http.Client, token, proxyBase, owner, repo, sha string) (<-chan checkState, error) {
+	reqURL := fmt.Sprintf("%s/v1/events/repos/%s/%s/commits/%s", proxyBase, owner, repo, sha)
+	req, err := http.NewRequest(http.MethodGet, reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set(apiVersionHeader, apiVersion)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		resp.Body.Close()
+		return nil, fmt.Errorf("event stream unavailable: HTTP %d", resp.StatusCode)
+	}
+
+	ch := make(chan checkState)
+	go func() {
+		defer close(ch)
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 64<<10), 4<<20)
+		var event, data string
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				if event == "checks" && data != "" {
+					var state checkState
+					if err := json.Unmarshal([]byte(data), &state); err == nil {
+						ch <- state
+					}
+				}
+				event, data = "", ""
+			case strings.HasPrefix(line, "event:"):
+				event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+			case strings.HasPrefix(line, "data:"):
+				data += strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")
+			}
+		}
+	}()
+	return ch, nil
+}
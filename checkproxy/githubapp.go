@@ -0,0 +1,184 @@
+package checkproxy
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GitHubAppConfig authenticates default-upstream requests as a GitHub App
+// instead of with a personal classic token. The private key is read from
+// PrivateKeyEnv (PEM contents) when set, otherwise from PrivateKeyPath.
+type GitHubAppConfig struct {
+	AppID          int64  `json:"app_id"`
+	PrivateKeyPath string `json:"private_key_path,omitempty"`
+	PrivateKeyEnv  string `json:"private_key_env,omitempty"`
+}
+
+// appTokenRefreshMargin is how long before expiry an installation token is
+// replaced, so a request never starts with a token about to lapse.
+const appTokenRefreshMargin = 5 * time.Minute
+
+type appToken struct {
+	token   string
+	expires time.Time
+}
+
+// appTokenSource mints and caches one installation token per account the
+// app is installed on, when github_app is configured.
+type appTokenSource struct {
+	appID   int64
+	key     *rsa.PrivateKey
+	apiBase string
+	client  *http.Client
+
+	mu            sync.Mutex
+	installations map[string]int64 // lower-case account login → installation ID
+	tokens        map[int64]appToken
+}
+
+func newAppTokenSource(app *GitHubAppConfig, apiBase string) (*appTokenSource, error) {
+	if app.AppID == 0 {
+		return nil, errors.New("app_id is required")
+	}
+	var data []byte
+	if app.PrivateKeyEnv != "" {
+		data = []byte(os.Getenv(app.PrivateKeyEnv))
+	}
+	if len(data) == 0 {
+		if app.PrivateKeyPath == "" {
+			return nil, errors.New("private_key_path or private_key_env is required")
+		}
+		var err error
+		if data, err = os.ReadFile(app.PrivateKeyPath); err != nil {
+			return nil, err
+		}
+	}
+	key, err := parseRSAPrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+	return &appTokenSource{
+		appID:         app.AppID,
+		key:           key,
+		apiBase:       apiBase,
+		client:        &http.Client{Timeout: 10 * time.Second},
+		installations: make(map[string]int64),
+		tokens:        make(map[int64]appToken),
+	}, nil
+}
+
+// parseRSAPrivateKey accepts the PKCS#1 PEM GitHub generates, or PKCS#8.
+func parseRSAPrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("private key is not PEM")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not RSA")
+	}
+	return key, nil
+}
+
+// jwt returns a short-lived app JWT (RS256) for the app endpoints.
+func (s *appTokenSource) jwt() (string, error) {
+	now := time.Now()
+	enc := base64.RawURLEncoding
+	header := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]any{
+		"iat": now.Add(-time.Minute).Unix(), // allow for clock drift
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": fmt.Sprint(s.appID),
+	})
+	if err != nil {
+		return "", err
+	}
+	signed := header + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + enc.EncodeToString(sig), nil
+}
+
+// token returns an installation token for owner's installation, minting a
+// new one when the cached token is missing or close to expiry.
+func (s *appTokenSource) token(ctx context.Context, owner string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account := strings.ToLower(owner)
+	id, ok := s.installations[account]
+	if !ok {
+		var inst struct {
+			ID int64 `json:"id"`
+		}
+		// /users/{login}/installation works for organizations and users alike.
+		if err := s.appRequest(ctx, "GET", fmt.Sprintf("%s/users/%s/installation", s.apiBase, url.PathEscape(owner)), &inst); err != nil {
+			return "", fmt.Errorf("finding installation for %s: %w", owner, err)
+		}
+		id = inst.ID
+		s.installations[account] = id
+	}
+	if t, ok := s.tokens[id]; ok && time.Until(t.expires) > appTokenRefreshMargin {
+		return t.token, nil
+	}
+
+	var minted struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := s.appRequest(ctx, "POST", fmt.Sprintf("%s/app/installations/%d/access_tokens", s.apiBase, id), &minted); err != nil {
+		return "", fmt.Errorf("minting installation token for %s: %w", owner, err)
+	}
+	s.tokens[id] = appToken{token: minted.Token, expires: minted.ExpiresAt}
+	return minted.Token, nil
+}
+
+// appRequest calls an app endpoint authenticated with a fresh JWT.
+func (s *appTokenSource) appRequest(ctx context.Context, method, rawURL string, out any) error {
+	jwt, err := s.jwt()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	setGitHubHeaders(req, jwt)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		if resp.StatusCode == http.StatusNotFound {
+			return errors.New("app is not installed there")
+		}
+		return fmt.Errorf("GitHub API returned %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,248 @@
+package checkproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxGraphQLBody caps the size of an incoming GraphQL request body.
+const maxGraphQLBody = 64 << 10
+
+// graphqlQuery is an allowlisted GraphQL document and the variables it accepts.
+// Every query must take $owner and $repo so the proxy can validate access.
+type graphqlQuery struct {
+	document  string
+	variables map[string]string // name → JSON kind: "string" or "number"
+	required  []string
+	resource  resourceProbe // what strict validation probes
+}
+
+// allowedGraphQLQueries is the whitelist of GraphQL operations, keyed by
+// operation name. Clients send either the exact document (whitespace is
+// ignored) or just the operationName with an empty query.
+var allowedGraphQLQueries = map[string]graphqlQuery{
+	"PullRequestStatusChecks": {
+		document: `query PullRequestStatusChecks($owner: String!, $repo: String!, $number: Int!, $after: String) {
+  repository(owner: $owner, name: $repo) {
+    pullRequest(number: $number) {
+      commits(last: 1) {
+        nodes {
+          commit {
+            oid
+            statusCheckRollup {
+              state
+              contexts(first: 100, after: $after) {
+                pageInfo { hasNextPage endCursor }
+                nodes {
+                  __typename
+                  ... on CheckRun {
+                    name status conclusion startedAt completedAt detailsUrl
+                    isRequired(pullRequestNumber: $number)
+                    checkSuite { workflowRun { event workflow { name } } }
+                  }
+                  ... on StatusContext {
+                    context state targetUrl description createdAt
+                    isRequired(pullRequestNumber: $number)
+                  }
+                }
+              }
+            }
+          }
+        }
+      }
+    }
+  }
+}`,
+		variables: map[string]string{"owner": "string", "repo": "string", "number": "number", "after": "string"},
+		required:  []string{"owner", "repo", "number"},
+		resource:  resourceProbe{groups: []string{"checks", "statuses"}},
+	},
+	"PullRequestMergeQueueEntry": {
+		document: `query PullRequestMergeQueueEntry($owner: String!, $repo: String!, $number: Int!) {
+  repository(owner: $owner, name: $repo) {
+    pullRequest(number: $number) {
+      mergeQueueEntry {
+        state position enqueuedAt estimatedTimeToMerge
+        headCommit { oid statusCheckRollup { state } }
+      }
+    }
+  }
+}`,
+		variables: map[string]string{"owner": "string", "repo": "string", "number": "number"},
+		required:  []string{"owner", "repo", "number"},
+		resource:  resourceProbe{groups: []string{"pulls"}},
+	},
+	"CommitStatusChecks": {
+		document: `query CommitStatusChecks($owner: String!, $repo: String!, $sha: GitObjectID!, $after: String) {
+  repository(owner: $owner, name: $repo) {
+    object(oid: $sha) {
+      ... on Commit {
+        oid
+        statusCheckRollup {
+          state
+          contexts(first: 100, after: $after) {
+            pageInfo { hasNextPage endCursor }
+            nodes {
+              __typename
+              ... on CheckRun {
+                name status conclusion startedAt completedAt detailsUrl
+                checkSuite { workflowRun { event workflow { name } } }
+              }
+              ... on StatusContext {
+                context state targetUrl description createdAt
+              }
+            }
+          }
+        }
+      }
+    }
+  }
+}`,
+		variables: map[string]string{"owner": "string", "repo": "string", "sha": "string", "after": "string"},
+		required:  []string{"owner", "repo", "sha"},
+		resource:  resourceProbe{groups: []string{"checks", "statuses"}},
+	},
+}
+
+type graphqlRequest struct {
+	Query         string                     `json:"query"`
+	OperationName string                     `json:"operationName,omitempty"`
+	Variables     map[string]json.RawMessage `json:"variables"`
+}
+
+// normalizeGraphQL collapses all whitespace so formatting differences don't
+// affect allowlist matching.
+func normalizeGraphQL(doc string) string {
+	return strings.Join(strings.Fields(doc), " ")
+}
+
+// matchGraphQLQuery finds the allowlisted operation for a request.
+func matchGraphQLQuery(req graphqlRequest) (string, graphqlQuery, bool) {
+	if strings.TrimSpace(req.Query) == "" {
+		q, ok := allowedGraphQLQueries[req.OperationName]
+		return req.OperationName, q, ok
+	}
+	normalized := normalizeGraphQL(req.Query)
+	for name, q := range allowedGraphQLQueries {
+		if normalizeGraphQL(q.document) == normalized {
+			return name, q, true
+		}
+	}
+	return "", graphqlQuery{}, false
+}
+
+// checkGraphQLVariables rejects unknown, missing, or mistyped variables and
+// returns the owner and repo the query targets.
+func checkGraphQLVariables(q graphqlQuery, vars map[string]json.RawMessage) (owner, repo string, err error) {
+	for name, raw := range vars {
+		kind, ok := q.variables[name]
+		if !ok {
+			return "", "", fmt.Errorf("unexpected variable %q", name)
+		}
+		var v any
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return "", "", fmt.Errorf("invalid variable %q: %w", name, err)
+		}
+		switch v.(type) {
+		case string:
+			if kind != "string" {
+				return "", "", fmt.Errorf("variable %q must be a %s", name, kind)
+			}
+		case float64:
+			if kind != "number" {
+				return "", "", fmt.Errorf("variable %q must be a %s", name, kind)
+			}
+		case nil:
+		default:
+			return "", "", fmt.Errorf("variable %q must be a %s", name, kind)
+		}
+	}
+	for _, name := range q.required {
+		if raw, ok := vars[name]; !ok || string(raw) == "null" {
+			return "", "", fmt.Errorf("missing variable %q", name)
+		}
+	}
+	_ = json.Unmarshal(vars["owner"], &owner)
+	_ = json.Unmarshal(vars["repo"], &repo)
+	if owner == "" || repo == "" {
+		return "", "", fmt.Errorf("owner and repo must be non-empty")
+	}
+	return owner, repo, nil
+}
+
+// graphQLHandler returns an http.HandlerFunc that accepts only allowlisted
+// GraphQL operations, validates the client token against the queried
+// repository, and forwards the query to GitHub using the classic token.
+func (s *server) graphQLHandler(cfg *Config) http.HandlerFunc {
+	upstreamClient := s.upstreamClient(s.upstream, s.timeouts.def)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var gqlReq graphqlRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxGraphQLBody)).Decode(&gqlReq); err != nil {
+			http.Error(w, "bad request: invalid GraphQL request body", http.StatusBadRequest)
+			return
+		}
+
+		name, q, ok := matchGraphQLQuery(gqlReq)
+		if !ok {
+			http.Error(w, "forbidden: GraphQL query not allowed", http.StatusForbidden)
+			return
+		}
+
+		owner, repo, err := checkGraphQLVariables(q, gqlReq.Variables)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("bad request: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		up, ok := s.selectUpstream(w, r, cfg, owner)
+		if !ok {
+			return
+		}
+
+		if !s.authorizeRepo(w, r, cfg, up, owner, repo, q.resource) {
+			return
+		}
+
+		// Always send our own copy of the document, never the client's text.
+		body, err := json.Marshal(graphqlRequest{
+			Query:         q.document,
+			OperationName: name,
+			Variables:     gqlReq.Variables,
+		})
+		if err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		upstreamReq, err := http.NewRequestWithContext(withTrafficRepo(r.Context(), owner, repo), http.MethodPost, graphqlURL(up.apiBase), bytes.NewReader(body))
+		if err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		setGitHubHeaders(upstreamReq, up.token)
+		upstreamReq.Header.Set("Content-Type", "application/json")
+
+		upstreamResp, err := upstreamClient.Do(upstreamReq)
+		if writeUpstreamRefused(w, err) {
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("upstream error: %v", err), http.StatusBadGateway)
+			return
+		}
+		defer upstreamResp.Body.Close()
+
+		n := copyUpstreamResponse(w, upstreamResp, nil, cfg.MaxResponseBytes)
+		s.traffic.sent(owner, repo, n)
+	}
+}
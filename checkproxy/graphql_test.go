@@ -0,0 +1,69 @@
+package checkproxy
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestMatchGraphQLQuery(t *testing.T) {
+	doc := allowedGraphQLQueries["CommitStatusChecks"].document
+	tests := []struct {
+		name string
+		req  graphqlRequest
+		want string // "" for no match
+	}{
+		{"exact document", graphqlRequest{Query: doc}, "CommitStatusChecks"},
+		{"reformatted", graphqlRequest{Query: strings.Join(strings.Fields(doc), "\n\t")}, "CommitStatusChecks"},
+		{"operation name only", graphqlRequest{OperationName: "PullRequestMergeQueueEntry"}, "PullRequestMergeQueueEntry"},
+		{"operation name with another document", graphqlRequest{Query: "query { viewer { login } }", OperationName: "CommitStatusChecks"}, ""},
+		{"extra field", graphqlRequest{Query: strings.Replace(doc, "oid", "oid author { email }", 1)}, ""},
+		{"unknown operation", graphqlRequest{OperationName: "Viewer"}, ""},
+		{"empty", graphqlRequest{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, _, ok := matchGraphQLQuery(tt.req)
+			if ok != (tt.want != "") || ok && name != tt.want {
+				t.Errorf("matchGraphQLQuery = %q, %v; want %q", name, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckGraphQLVariables(t *testing.T) {
+	q := allowedGraphQLQueries["PullRequestStatusChecks"]
+	tests := []struct {
+		name    string
+		vars    string
+		wantErr string
+	}{
+		{name: "valid", vars: `{"owner":"o","repo":"r","number":1}`},
+		{name: "optional cursor", vars: `{"owner":"o","repo":"r","number":1,"after":"Y3Vyc29y"}`},
+		{name: "null cursor", vars: `{"owner":"o","repo":"r","number":1,"after":null}`},
+		{name: "unknown variable", vars: `{"owner":"o","repo":"r","number":1,"first":100}`, wantErr: `unexpected variable "first"`},
+		{name: "number as string", vars: `{"owner":"o","repo":"r","number":"1"}`, wantErr: `variable "number" must be a number`},
+		{name: "owner as object", vars: `{"owner":{"login":"o"},"repo":"r","number":1}`, wantErr: `variable "owner" must be a string`},
+		{name: "missing number", vars: `{"owner":"o","repo":"r"}`, wantErr: `missing variable "number"`},
+		{name: "null repo", vars: `{"owner":"o","repo":null,"number":1}`, wantErr: `missing variable "repo"`},
+		{name: "empty owner", vars: `{"owner":"","repo":"r","number":1}`, wantErr: "owner and repo must be non-empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var vars map[string]json.RawMessage
+			if err := json.Unmarshal([]byte(tt.vars), &vars); err != nil {
+				t.Fatal(err)
+			}
+			owner, repo, err := checkGraphQLVariables(q, vars)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || owner != "o" || repo != "r" {
+				t.Errorf("checkGraphQLVariables = %q, %q, %v; want o, r", owner, repo, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,1154 @@
+package checkproxy
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// route is a whitelisted API path and the query parameters it accepts.
+// Anything else in the query string is rejected, so clients cannot smuggle
+// unexpected parameters upstream under the classic token.
+type route struct {
+	pattern *regexp.Regexp
+	params  []string
+}
+
+func newRoute(pattern string, params ...string) route {
+	return route{pattern: regexp.MustCompile(pattern), params: params}
+}
+
+// listRoute is a route for a paginated list endpoint.
+func listRoute(pattern string, params ...string) route {
+	return newRoute(pattern, append(params, "per_page", "page")...)
+}
+
+// allowedRoutes is the whitelist of permitted API paths. All are GET/HEAD-only.
+// Each server adds its extra_allowed_routes to a copy.
+var allowedRoutes = []route{
+	// Checks API
+	listRoute(`^/repos/[^/]+/[^/]+/commits/[^/]+/check-runs$`, "check_name", "status", "filter", "app_id", allPagesParam),
+	listRoute(`^/repos/[^/]+/[^/]+/commits/[^/]+/check-suites$`, "check_name", "app_id"),
+	newRoute(`^/repos/[^/]+/[^/]+/check-runs/[^/]+$`),
+	listRoute(`^/repos/[^/]+/[^/]+/check-runs/[^/]+/annotations$`),
+	newRoute(`^/repos/[^/]+/[^/]+/check-suites/[^/]+$`),
+	listRoute(`^/repos/[^/]+/[^/]+/check-suites/[^/]+/check-runs$`, "check_name", "status", "filter", allPagesParam),
+	// Commit Statuses API
+	listRoute(`^/repos/[^/]+/[^/]+/commits/[^/]+/status$`),
+	listRoute(`^/repos/[^/]+/[^/]+/commits/[^/]+/statuses$`),
+	listRoute(`^/repos/[^/]+/[^/]+/statuses/[^/]+$`),
+	// Pull requests (PR lookup by number or head branch)
+	listRoute(`^/repos/[^/]+/[^/]+/pulls$`, "head", "base", "state", "sort", "direction"),
+	newRoute(`^/repos/[^/]+/[^/]+/pulls/[0-9]+$`),
+	// Deployments API
+	listRoute(`^/repos/[^/]+/[^/]+/deployments$`, "sha", "ref", "task", "environment"),
+	listRoute(`^/repos/[^/]+/[^/]+/deployments/[^/]+/statuses$`),
+	// Branch protection (required status checks). Routes match the escaped
+	// path, so a branch name's slashes arrive as %2F, as GitHub expects them.
+	newRoute(`^/repos/[^/]+/[^/]+/branches/[^/]+/protection/required_status_checks$`),
+	// Repository rulesets; slashes in the branch name arrive as %2F.
+	listRoute(`^/repos/[^/]+/[^/]+/rules/branches/[^/]+$`),
+	listRoute(`^/repos/[^/]+/[^/]+/rulesets$`, "includes_parents", "targets"),
+	newRoute(`^/repos/[^/]+/[^/]+/rulesets/[^/]+$`, "includes_parents"),
+	// Actions Jobs API
+	listRoute(`^/repos/[^/]+/[^/]+/actions/runs/[^/]+/jobs$`, "filter"),
+	newRoute(`^/repos/[^/]+/[^/]+/actions/jobs/[^/]+$`),
+	newRoute(`^/repos/[^/]+/[^/]+/actions/jobs/[^/]+/logs$`),
+	// Actions Artifacts API
+	listRoute(`^/repos/[^/]+/[^/]+/actions/runs/[^/]+/artifacts$`, "name"),
+	newRoute(`^/repos/[^/]+/[^/]+/actions/artifacts/[^/]+$`),
+	newRoute(`^/repos/[^/]+/[^/]+/actions/artifacts/[^/]+/zip$`),
+}
+
+// writeRoutes are POST-only endpoints grouped by the name used in
+// Config.AllowWrites. They are rejected unless their group is enabled.
+var writeRoutes = map[string][]*regexp.Regexp{
+	"rerequest": {
+		regexp.MustCompile(`^/repos/[^/]+/[^/]+/check-runs/[^/]+/rerequest$`),
+		regexp.MustCompile(`^/repos/[^/]+/[^/]+/check-suites/[^/]+/rerequest$`),
+	},
+}
+
+// streamingRoutes (job logs, artifact archives) answer with a 302 to a
+// short-lived blob URL. The proxy follows the redirect itself and streams the
+// body back, so these get a longer timeout than the JSON endpoints.
+var streamingRoutes = []*regexp.Regexp{
+	regexp.MustCompile(`^/repos/[^/]+/[^/]+/actions/jobs/[^/]+/logs$`),
+	regexp.MustCompile(`^/repos/[^/]+/[^/]+/actions/artifacts/[^/]+/zip$`),
+}
+
+// githubAPIBase is the default upstream; see Config.APIBase for GHES.
+const githubAPIBase = "https://api.github.com"
+
+// ghesPathPrefix is the REST prefix used by GitHub Enterprise Server. Clients
+// that treat the proxy as a GHES host send it; it is stripped before matching.
+const ghesPathPrefix = "/api/v3"
+
+// upstreamHeader selects a named upstream (Config.Upstreams) for a request.
+const upstreamHeader = "X-Checkproxy-Upstream"
+
+// requestHeadersToForward are the client request headers passed through upstream.
+// Conditional headers let pollers receive 304s instead of full bodies.
+// Accept-Encoding is forwarded so compressed bodies stream through untouched.
+var requestHeadersToForward = []string{
+	"If-None-Match",
+	"If-Modified-Since",
+	"Accept-Encoding",
+}
+
+// headersToForward are the upstream response headers passed through to the client.
+var headersToForward = []string{
+	"Content-Type",
+	"Content-Length",
+	"Content-Encoding",
+	"Content-Disposition",
+	"ETag",
+	"Last-Modified",
+	"Cache-Control",
+	"Vary",
+	"Link",
+	"X-RateLimit-Limit",
+	"X-RateLimit-Remaining",
+	"X-RateLimit-Reset",
+	"X-RateLimit-Used",
+	"X-RateLimit-Resource",
+}
+
+// findRoute returns the route in routes matching path.
+func findRoute(routes []route, path string) (route, bool) {
+	for _, rt := range routes {
+		if rt.pattern.MatchString(path) {
+			return rt, true
+		}
+	}
+	return route{}, false
+}
+
+// filterQuery parses rawQuery and returns it re-encoded, rejecting any
+// parameter not in allowed. Re-encoding normalizes escaping so what is checked
+// is exactly what is sent upstream.
+func filterQuery(rawQuery string, allowed []string) (string, error) {
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", fmt.Errorf("invalid query string")
+	}
+	for key := range values {
+		if !slices.Contains(allowed, key) {
+			return "", fmt.Errorf("query parameter %q not allowed", key)
+		}
+	}
+	return values.Encode(), nil
+}
+
+// compileRoutes compiles user-supplied route patterns. Each must be anchored
+// under /repos/{owner}/{repo}/ so the token can be validated for that repo.
+// Extra routes accept only the pagination parameters.
+func compileRoutes(patterns []string) ([]route, error) {
+	for _, p := range patterns {
+		if !strings.HasPrefix(p, "^/repos/") {
+			return nil, fmt.Errorf("route %q must start with ^/repos/", p)
+		}
+	}
+	res, err := compilePatterns(patterns)
+	if err != nil {
+		return nil, err
+	}
+	var routes []route
+	for _, re := range res {
+		routes = append(routes, route{pattern: re, params: []string{"per_page", "page"}})
+	}
+	return routes, nil
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var routes []*regexp.Regexp
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("route %q: %w", p, err)
+		}
+		routes = append(routes, re)
+	}
+	return routes, nil
+}
+
+// writeGroup returns the write group a path belongs to, if any.
+func writeGroup(path string) (string, bool) {
+	for group, routes := range writeRoutes {
+		if matchesAny(routes, path) {
+			return group, true
+		}
+	}
+	return "", false
+}
+
+func matchesAny(routes []*regexp.Regexp, path string) bool {
+	for _, re := range routes {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// transport wraps base in the layers every request made with the server's
+// own tokens goes through: the response cache, coalescing of identical
+// requests, circuit breaker, rate budget pacing, token pool failover,
+// secondary rate limit backoff, and tracing.
+func (s *server) transport(base http.RoundTripper) http.RoundTripper {
+	maxBody := int64(defaultResponseCacheBody)
+	if s.responses != nil {
+		maxBody = s.responses.maxBody
+	}
+	traced := traceTransport{base, s.tracer, &s.upstreamOutcomes, s.redact}
+	failover := failoverTransport{secondaryLimitTransport{traced, s.secondaryLimits}, &s.pool, s.traffic, s.rateLimits}
+	return cacheTransport{coalesceTransport{breakerTransport{budgetTransport{failover, s.budget}, s.breakers}, s.flights, maxBody}, s.responses, s.timeouts}
+}
+
+// upstreamClient returns a client for GitHub that sends requests through
+// the server's transport layers over base.
+func (s *server) upstreamClient(base http.RoundTripper, timeout time.Duration) *http.Client {
+	return &http.Client{Transport: s.transport(base), Timeout: timeout}
+}
+
+// upstreamRedirectPolicy follows redirects only to https URLs and drops the
+// classic token when the redirect leaves the API host (e.g. log blob storage).
+func upstreamRedirectPolicy(req *http.Request, via []*http.Request) error {
+	if len(via) >= 5 {
+		return errors.New("stopped after 5 redirects")
+	}
+	if req.URL.Scheme != "https" {
+		return fmt.Errorf("refusing non-https redirect to %s", req.URL.Host)
+	}
+	if req.URL.Host != via[0].URL.Host {
+		req.Header.Del("Authorization")
+	}
+	return nil
+}
+
+// extractOwnerRepo parses the escaped path /repos/{owner}/{repo}/... and
+// returns owner and repo unescaped.
+func extractOwnerRepo(path string) (owner, repo string, ok bool) {
+	parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 4)
+	if len(parts) < 3 || parts[0] != "repos" {
+		return "", "", false
+	}
+	owner, err := url.PathUnescape(parts[1])
+	if err != nil {
+		return "", "", false
+	}
+	if repo, err = url.PathUnescape(parts[2]); err != nil {
+		return "", "", false
+	}
+	if owner == "" || repo == "" || strings.Contains(owner+repo, "/") {
+		return "", "", false
+	}
+	return owner, repo, true
+}
+
+// literalPath reports whether GitHub will take every segment of the
+// escaped path p literally. A segment that decodes to a dot segment, or
+// to several segments one of which is, could be resolved against the rest
+// of the path and reach another repository; one that decodes to something
+// with ? or # could end the path early and smuggle in a query that
+// filterQuery never saw.
+func literalPath(p string) bool {
+	for _, seg := range strings.Split(p, "/") {
+		s, err := url.PathUnescape(seg)
+		if err != nil || strings.ContainsAny(s, "?#") {
+			return false
+		}
+		for _, part := range strings.Split(s, "/") {
+			if part == "." || part == ".." {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ProxyHandler returns an http.HandlerFunc that:
+//  1. Validates the fine-grained token has access to the requested repo
+//  2. Proxies allowed GET and HEAD requests to GitHub using the classic token
+//  3. Proxies POST requests to write routes whose group is enabled in config
+//
+// It uses the built-in routes and timeouts, without the caches, breakers,
+// and token pool NewHandler sets up from cfg.
+func ProxyHandler(cfg *Config, validator *Validator) http.HandlerFunc {
+	timeouts, _ := compileTimeouts(nil)
+	listenTimeouts, _ := compileServerTimeouts(nil)
+	s := &server{
+		validator:       validator,
+		upstream:        http.DefaultTransport.(*http.Transport).Clone(),
+		rateLimits:      newRateLimitTracker(),
+		secondaryLimits: newSecondaryLimitTracker(),
+		routes:          allowedRoutes,
+		timeouts:        timeouts,
+		listenTimeouts:  listenTimeouts,
+		flights:         newFlightGroup(),
+		traffic:         newTrafficTracker(5000),
+		decisions:       newDecisionTracker(50, 1000),
+	}
+	return s.proxyHandler(cfg)
+}
+
+func (s *server) proxyHandler(cfg *Config) http.HandlerFunc {
+	// Compression is negotiated by the client, not by us: with automatic
+	// decompression off, gzip bodies are relayed as-is.
+	transport := s.upstream.Clone()
+	transport.DisableCompression = true
+	// The timeout depends on the route, so it is set per request.
+	upstreamClient := s.upstreamClient(transport, 0)
+	upstreamClient.CheckRedirect = upstreamRedirectPolicy
+	limiter := newConcurrencyLimiter(cfg.MaxConcurrentRequests, cfg.MaxClientConcurrency)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		release, ok := limiter.acquire(w)
+		if !ok {
+			return
+		}
+		defer release()
+
+		// Routes match the path as the client escaped it, and it is sent
+		// upstream the same way.
+		path := r.URL.EscapedPath()
+		if strings.HasPrefix(path, ghesPathPrefix+"/") {
+			path = strings.TrimPrefix(path, ghesPathPrefix)
+		}
+		if !literalPath(path) {
+			http.Error(w, "bad request: invalid path", http.StatusBadRequest)
+			return
+		}
+		if matchesAny(s.denied, path) {
+			http.Error(w, "forbidden: route denied by proxy policy", http.StatusForbidden)
+			return
+		}
+		var queryParams []string // write routes take no query parameters
+		if group, isWrite := writeGroup(path); isWrite {
+			if r.Method != http.MethodPost {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			if !cfg.writeAllowed(group) {
+				http.Error(w, fmt.Sprintf("forbidden: %s writes are not enabled on this proxy", group), http.StatusForbidden)
+				return
+			}
+		} else {
+			// HEAD lets pollers compare ETags without transferring bodies.
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			rt, found := findRoute(s.routes, path)
+			if !found {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+			queryParams = rt.params
+		}
+
+		query, err := filterQuery(r.URL.RawQuery, queryParams)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("bad request: %v", err), http.StatusBadRequest)
+			return
+		}
+		query, allPages, err := takeAllPages(query)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("bad request: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		owner, repo, ok := extractOwnerRepo(path)
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		up, ok := s.selectUpstream(w, r, cfg, owner)
+		if !ok {
+			return
+		}
+
+		if !s.authorizeRepo(w, r, cfg, up, owner, repo, resourceForPath(path)) {
+			return
+		}
+		releaseClient, ok := limiter.acquireClient(w, authorizedClient(r, cfg))
+		if !ok {
+			return
+		}
+		defer releaseClient()
+
+		upstreamURL := up.apiBase + path
+		if query != "" {
+			upstreamURL += "?" + query
+		}
+
+		timeout := s.timeouts.forPath(path)
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		s.listenTimeouts.extendWriteDeadline(w, timeout)
+		// Write routes take no request body, so nothing from the client is forwarded.
+		upstreamReq, err := http.NewRequestWithContext(ctx, r.Method, upstreamURL, nil)
+		if err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		setGitHubHeaders(upstreamReq, up.token)
+		// Honor the client's media type (previews, text formats); setGitHubHeaders
+		// already supplied the default when the client sent none.
+		if accept := r.Header.Get("Accept"); accept != "" {
+			upstreamReq.Header.Set("Accept", accept)
+		}
+		for _, h := range requestHeadersToForward {
+			if val := r.Header.Get(h); val != "" {
+				upstreamReq.Header.Set(h, val)
+			}
+		}
+		if cfg.RewriteBodyURLs {
+			// Bodies must arrive uncompressed to be rewritten.
+			upstreamReq.Header.Del("Accept-Encoding")
+		}
+
+		var upstreamResp *http.Response
+		if allPages {
+			upstreamResp, err = fetchAllPages(upstreamClient, upstreamReq)
+		} else {
+			upstreamResp, err = upstreamClient.Do(upstreamReq)
+		}
+		if writeUpstreamRefused(w, err) {
+			return
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			http.Error(w, fmt.Sprintf("upstream timeout: no response within %s", timeout), http.StatusGatewayTimeout)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("upstream error: %v", err), http.StatusBadGateway)
+			return
+		}
+		defer upstreamResp.Body.Close()
+		if !allPages {
+			s.prefetchPages(upstreamClient, upstreamReq, upstreamResp)
+		}
+
+		n := copyUpstreamResponse(w, upstreamResp, &upstreamRewrite{
+			upstreamBase: up.apiBase,
+			proxyBase:    proxyBaseURL(r, cfg),
+			body:         cfg.RewriteBodyURLs,
+		}, cfg.MaxResponseBytes)
+		s.traffic.sent(owner, repo, n)
+	}
+}
+
+// proxyBaseURL returns the absolute URL clients use to reach this proxy:
+// public_url when configured, else the scheme and Host of the request plus
+// the base path. The scheme honors X-Forwarded-Proto from trusted proxies
+// (see realIPMiddleware).
+func proxyBaseURL(r *http.Request, cfg *Config) string {
+	if cfg.PublicURL != "" {
+		return strings.TrimRight(cfg.PublicURL, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if r.URL.Scheme != "" {
+		scheme = r.URL.Scheme
+	}
+	return scheme + "://" + r.Host + cfg.BasePath
+}
+
+// rewriteLinks replaces the upstream API base in Link header targets (next,
+// prev, first, last) with proxyBase, so clients paginate through the proxy
+// instead of calling GitHub directly.
+func rewriteLinks(link, upstreamBase, proxyBase string) string {
+	return strings.ReplaceAll(link, "<"+upstreamBase+"/", "<"+proxyBase+"/")
+}
+
+// commitRefPattern extracts the ref from /repos/{o}/{r}/commits/{ref}/... paths.
+var commitRefPattern = regexp.MustCompile(`^/repos/[^/]+/[^/]+/commits/([^/]+)/`)
+
+// resourceForPath classifies an escaped passthrough path into its route
+// group for strict validation.
+func resourceForPath(p string) resourceProbe {
+	var ref string
+	if m := commitRefPattern.FindStringSubmatch(p); m != nil {
+		ref = m[1]
+	}
+	parts := strings.SplitN(strings.TrimPrefix(p, "/repos/"), "/", 3)
+	if len(parts) < 3 {
+		return resourceProbe{}
+	}
+	rest := parts[2]
+	if ref != "" {
+		rest = strings.TrimPrefix(rest, "commits/"+ref+"/")
+	}
+	var group string
+	switch {
+	case strings.HasPrefix(rest, "actions/"):
+		group = "actions"
+	case strings.HasPrefix(rest, "deployments"):
+		group = "deployments"
+	case strings.HasPrefix(rest, "pulls"):
+		group = "pulls"
+	case strings.HasPrefix(rest, "check-runs"), strings.HasPrefix(rest, "check-suites"):
+		group = "checks"
+	case strings.HasPrefix(rest, "status"):
+		group = "statuses"
+	default:
+		return resourceProbe{}
+	}
+	// Probes escape the ref themselves.
+	if unescaped, err := url.PathUnescape(ref); err == nil {
+		ref = unescaped
+	}
+	return resourceProbe{groups: []string{group}, ref: ref}
+}
+
+// authorizeRepo enforces the org allowlist and validates the client's token
+// against owner/repo. In strict validation mode the token must also be able
+// to read the resource class in res; with validation off only the allow and
+// deny lists apply. The client's rate limit is applied once it is known who
+// the client is. On rejection it writes the error response and returns
+// false.
+func (s *server) authorizeRepo(w http.ResponseWriter, r *http.Request, cfg *Config, up upstreamTarget, owner, repo string, res resourceProbe) (ok bool) {
+	rec := &statusRecorder{ResponseWriter: w}
+	w = rec
+	defer func() {
+		entry := auditRequest(r, owner, repo)
+		entry.Result, entry.Status = "deny", rec.status
+		if ok {
+			entry.Result = "allow"
+		}
+		if s.validator.audit != nil {
+			s.validator.audit.log(entry)
+		}
+		s.decisions.record(entry)
+		if ok {
+			s.traffic.served(owner, repo)
+		}
+	}()
+	return s.authenticateRepo(w, r, cfg, up, owner, repo, res) && s.rateLimit.admit(w, authorizedClient(r, cfg))
+}
+
+// authenticateRepo is authorizeRepo without the audit record and the rate
+// limit.
+func (s *server) authenticateRepo(w http.ResponseWriter, r *http.Request, cfg *Config, up upstreamTarget, owner, repo string, res resourceProbe) bool {
+	validator := s.validator
+	if repoMatches(cfg.DeniedRepos, owner, repo) {
+		http.Error(w, "forbidden: repository not allowed", http.StatusForbidden)
+		return false
+	}
+	if len(cfg.AllowedOrgs) > 0 && !orgAllowed(cfg.AllowedOrgs, owner) {
+		http.Error(w, "forbidden: organization not allowed", http.StatusForbidden)
+		return false
+	}
+	if len(cfg.AllowedRepos) > 0 && !repoMatches(cfg.AllowedRepos, owner, repo) {
+		http.Error(w, "forbidden: repository not allowed", http.StatusForbidden)
+		return false
+	}
+	source := requestSource(r)
+	if validator.backoff != nil {
+		if blocked, wait := validator.backoff.blocked(source); blocked {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "too many requests: repeated authentication failures, back off", http.StatusTooManyRequests)
+			return false
+		}
+	}
+
+	// Signed requests carry no token; the shared secret's scope decides. A
+	// request that claims to be signed is checked in every validation mode,
+	// so an HMAC-only listener can't be passed with a made-up key id.
+	if r.Header.Get(hmacKeyIDHeader) != "" && len(cfg.HMACClients) > 0 {
+		client, err := verifyHMAC(r, cfg.HMACClients)
+		if err != nil {
+			validator.recordFailure(source)
+			http.Error(w, "unauthorized: "+err.Error(), http.StatusUnauthorized)
+			return false
+		}
+		if validator.revoked != nil && validator.revoked.revoked(client.ID) {
+			http.Error(w, "forbidden: key has been revoked", http.StatusForbidden)
+			return false
+		}
+		if !client.allows(owner, repo) {
+			http.Error(w, "forbidden: key is not scoped to this repository", http.StatusForbidden)
+			return false
+		}
+		return true
+	}
+	if cfg.validationMode() == validationOff {
+		return true
+	}
+
+	fgToken := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if fgToken == "" {
+		http.Error(w, "unauthorized: missing Authorization header", http.StatusUnauthorized)
+		return false
+	}
+	// Revocation bypasses the validation cache: it applies from the next request.
+	if validator.revoked != nil && validator.revoked.revoked(fgToken) {
+		http.Error(w, "forbidden: token has been revoked", http.StatusForbidden)
+		return false
+	}
+
+	// Proxy-issued keys are checked against their own scope, never GitHub.
+	if strings.HasPrefix(fgToken, apiKeyPrefix) && validator.keys != nil {
+		k, ok := validator.keys.lookup(fgToken)
+		if !ok {
+			validator.recordFailure(source)
+			http.Error(w, "unauthorized: unknown API key", http.StatusUnauthorized)
+			return false
+		}
+		if !k.allows(owner, repo) {
+			http.Error(w, "forbidden: API key is not scoped to this repository", http.StatusForbidden)
+			return false
+		}
+		return true
+	}
+
+	if !cfg.clientTokenAccepted(fgToken) {
+		msg := "bad request: token type not accepted by this proxy"
+		if isClassicToken(fgToken) {
+			msg = "bad request: classic tokens are not accepted by this proxy; use a fine-grained token"
+		}
+		http.Error(w, fmt.Sprintf("%s (accepted prefixes: %s)", msg, strings.Join(cfg.ClientTokenPrefixes, ", ")), http.StatusBadRequest)
+		return false
+	}
+
+	var allowed bool
+	var err error
+	if cfg.validationMode() == validationStrict {
+		allowed, err = validator.ValidatePermissions(r.Context(), up.apiBase, fgToken, owner, repo, res.ref, cfg.requiredPermissions(res.groups))
+	} else {
+		allowed, err = validator.Validate(r.Context(), up.apiBase, fgToken, owner, repo)
+	}
+	var permErr *permissionError
+	if errors.As(err, &permErr) {
+		http.Error(w, "forbidden: "+permErr.Error(), http.StatusForbidden)
+		return false
+	}
+	if writeUpstreamRefused(w, err) {
+		return false
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error validating token: %v", err), http.StatusInternalServerError)
+		return false
+	}
+	if !allowed {
+		validator.recordFailure(source)
+		http.Error(w, "forbidden: token does not have access to this repository", http.StatusForbidden)
+		return false
+	}
+	// Installation tokens belong to an app, not a user, so there is no
+	// membership to check.
+	if cfg.RequireOrgMembership && !isInstallationToken(fgToken) {
+		orgs := cfg.AllowedOrgs
+		if len(orgs) == 0 {
+			orgs = []string{owner}
+		}
+		member, err := validator.ValidateMembership(r.Context(), up.apiBase, fgToken, up.token, orgs)
+		if writeUpstreamRefused(w, err) {
+			return false
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error validating token: %v", err), http.StatusInternalServerError)
+			return false
+		}
+		if !member {
+			http.Error(w, "forbidden: token owner is not a member of an allowed organization", http.StatusForbidden)
+			return false
+		}
+	}
+	if exp := validator.TokenExpiration(up.apiBase, fgToken, owner, repo); !exp.IsZero() && time.Until(exp) < cfg.tokenExpiryWarning() {
+		w.Header().Set(tokenExpirationHeader, exp.UTC().Format(time.RFC3339))
+	}
+	return true
+}
+
+// selectUpstream picks the upstream for a request to owner's repositories:
+// the X-Checkproxy-Upstream header if present, else the first label of the
+// Host if it names an upstream, else the default. For the default upstream
+// the credential is owner's org token if configured, else a GitHub App
+// installation token, else the next token from the pool, else the classic
+// token. An unknown header value is rejected with 400.
+func (s *server) selectUpstream(w http.ResponseWriter, r *http.Request, cfg *Config, owner string) (upstreamTarget, bool) {
+	if name := r.Header.Get(upstreamHeader); name != "" {
+		up, ok := cfg.resolveUpstream(name)
+		if !ok {
+			http.Error(w, fmt.Sprintf("bad request: unknown upstream %q", name), http.StatusBadRequest)
+			return upstreamTarget{}, false
+		}
+		return checkUpstreamToken(w, up)
+	}
+	if label, _, found := strings.Cut(r.Host, "."); found {
+		if up, ok := cfg.resolveUpstream(label); ok && label != "" {
+			return checkUpstreamToken(w, up)
+		}
+	}
+	up, _ := cfg.resolveUpstream("")
+	if token, ok := cfg.orgToken(owner); ok {
+		up.token = token
+	} else if s.app != nil {
+		token, err := s.app.token(r.Context(), owner)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("upstream error: GitHub App: %v", err), http.StatusBadGateway)
+			return upstreamTarget{}, false
+		}
+		up.token = token
+	} else if pool := s.pool.Load(); pool != nil {
+		up.token = pool.pick()
+	}
+	return checkUpstreamToken(w, up)
+}
+
+// mux routes the proxy, GraphQL, and /v1 endpoints for cfg.
+func (s *server) mux(cfg *Config) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.proxyHandler(cfg))
+	mux.HandleFunc("/graphql", s.graphQLHandler(cfg))
+	mux.HandleFunc("/readyz", s.readiness.handler)
+	dashboardRoutes(mux, cfg, s)
+	mux.Handle("/v1/", v1Handler(cfg, s))
+	return mux
+}
+
+func checkUpstreamToken(w http.ResponseWriter, up upstreamTarget) (upstreamTarget, bool) {
+	if up.token == "" {
+		http.Error(w, "proxy misconfigured: no classic token for upstream", http.StatusBadGateway)
+		return upstreamTarget{}, false
+	}
+	return up, true
+}
+
+// upstreamRewrite maps upstream API URLs in a response onto the proxy.
+type upstreamRewrite struct {
+	upstreamBase string
+	proxyBase    string
+	body         bool // also rewrite URLs embedded in JSON bodies
+}
+
+// copyUpstreamResponse writes the upstream status, forwarded headers, and body
+// to w. rw, when non-nil, rewrites upstream URLs in the Link header (and
+// optionally the JSON body). When maxBytes > 0 the body is capped: an
+// oversized Content-Length is refused with 502, and a body that overruns the
+// cap mid-stream aborts the connection so the client never mistakes a
+// truncated body for a complete one.
+func copyUpstreamResponse(w http.ResponseWriter, upstreamResp *http.Response, rw *upstreamRewrite, maxBytes int64) int64 {
+	if maxBytes > 0 && upstreamResp.ContentLength > maxBytes {
+		http.Error(w, "upstream response too large", http.StatusBadGateway)
+		return 0
+	}
+
+	for _, h := range headersToForward {
+		if val := upstreamResp.Header.Get(h); val != "" {
+			w.Header().Set(h, val)
+		}
+	}
+	if rw == nil {
+		return copyBody(w, upstreamResp, maxBytes)
+	}
+	if link := w.Header().Get("Link"); link != "" {
+		w.Header().Set("Link", rewriteLinks(link, rw.upstreamBase, rw.proxyBase))
+	}
+	if rw.body && isJSON(upstreamResp.Header.Get("Content-Type")) && upstreamResp.Header.Get("Content-Encoding") == "" {
+		return rewriteBody(w, upstreamResp, rw, maxBytes)
+	}
+	return copyBody(w, upstreamResp, maxBytes)
+}
+
+func isJSON(contentType string) bool {
+	return strings.HasPrefix(contentType, "application/json") || strings.Contains(contentType, "+json")
+}
+
+// rewriteBody buffers a JSON body and replaces upstream API URLs in it with
+// the proxy base. The body length changes, so Content-Length is recomputed.
+func rewriteBody(w http.ResponseWriter, upstreamResp *http.Response, rw *upstreamRewrite, maxBytes int64) int64 {
+	reader := io.Reader(upstreamResp.Body)
+	if maxBytes > 0 {
+		reader = io.LimitReader(upstreamResp.Body, maxBytes+1)
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("upstream error: %v", err), http.StatusBadGateway)
+		return 0
+	}
+	if maxBytes > 0 && int64(len(body)) > maxBytes {
+		w.Header().Del("Content-Length")
+		http.Error(w, "upstream response too large", http.StatusBadGateway)
+		return 0
+	}
+	body = bytes.ReplaceAll(body, []byte(rw.upstreamBase+"/"), []byte(rw.proxyBase+"/"))
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(upstreamResp.StatusCode)
+	n, _ := w.Write(body)
+	return int64(n)
+}
+
+// copyBody streams the upstream body to w, enforcing maxBytes (see copyUpstreamResponse).
+func copyBody(w http.ResponseWriter, upstreamResp *http.Response, maxBytes int64) int64 {
+	w.WriteHeader(upstreamResp.StatusCode)
+
+	if maxBytes <= 0 {
+		n, _ := io.Copy(w, upstreamResp.Body)
+		return n
+	}
+	n, err := io.CopyN(w, upstreamResp.Body, maxBytes)
+	if err == nil {
+		var probe [1]byte
+		if m, _ := upstreamResp.Body.Read(probe[:]); m > 0 {
+			panic(http.ErrAbortHandler)
+		}
+	}
+	return n
+}
+
+// orgAllowed reports whether owner is in the allowed orgs list (case-insensitive).
+func orgAllowed(allowedOrgs []string, owner string) bool {
+	for _, o := range allowedOrgs {
+		if strings.EqualFold(o, owner) {
+			return true
+		}
+	}
+	return false
+}
+
+// repoMatches reports whether owner/repo matches any of the patterns
+// (case-insensitive, path.Match wildcards).
+func repoMatches(patterns []string, owner, repo string) bool {
+	full := strings.ToLower(owner + "/" + repo)
+	for _, p := range patterns {
+		if ok, _ := path.Match(strings.ToLower(p), full); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// runServe loads config and starts the HTTP proxy server. Closing stop shuts
+// it down as SIGTERM would; the Windows service manager's stop request does
+// that.
+func runServe(args []string, stop <-chan struct{}) {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	background := fs.Bool("background", false, "Detach and serve in the background")
+	pidFile := fs.String("pid-file", "", "Write the server's PID to this file while it runs")
+	logLevel := fs.String("log-level", "info", "Log level: debug, info, warn, or error")
+	logFormat := fs.String("log-format", "text", "Log format: text or json")
+	check := fs.Bool("check", false, "Check the config, tokens, and listen addresses, then exit")
+	if err := fs.Parse(args); err != nil {
+		os.Exit(2)
+	}
+	if *check && *background {
+		fmt.Fprintf(os.Stderr, "error: --check and --background can't be combined\n")
+		os.Exit(2)
+	}
+	if err := setupLogging(*logLevel, *logFormat); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(2)
+	}
+	if *background {
+		// The child gets every flag but --background.
+		var childArgs []string
+		fs.Visit(func(f *flag.Flag) {
+			if f.Name != "background" && f.Name != "pid-file" {
+				childArgs = append(childArgs, "--"+f.Name+"="+f.Value.String())
+			}
+		})
+		if err := startBackground(childArgs); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n\nRun 'gh-checkproxy config' to set up.\n", err)
+		os.Exit(1)
+	}
+	if err := cfg.checkListeners(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: listeners: %v\n", err)
+		os.Exit(1)
+	}
+	srv, err := newServer(cfg, *check)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	validator, handler := srv.validator, srv.handler
+
+	listeners := cfg.serveListeners()
+	var tlsCert tls.Certificate
+	if slices.ContainsFunc(listeners, func(l Listener) bool { return l.TLS }) {
+		if tlsCert, err = cfg.loadTLSCertificate(); err != nil {
+			fmt.Fprintf(os.Stderr, "error: TLS: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	lns := make([]net.Listener, len(listeners))
+	for i, l := range listeners {
+		ln, activated, err := cfg.openListener(l)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		if *check {
+			// Binding proves the address is free and allowed; the server
+			// that will serve it may not be stopped yet.
+			ln.Close()
+			continue
+		}
+		lns[i] = ln
+		addr := ln.Addr().String()
+		if _, ok := l.unixPath(); ok {
+			addr = l.Address
+		}
+		if activated {
+			addr += " (systemd socket)"
+		}
+		fmt.Printf("gh-checkproxy listening on %s%s\n", addr, l.notes(len(listeners) > 1))
+	}
+	if *check {
+		printServeCheck(cfg, srv, listeners)
+		return
+	}
+	if len(cfg.AllowedOrgs) > 0 {
+		fmt.Printf("  Restricting to orgs: %s\n", strings.Join(cfg.AllowedOrgs, ", "))
+	} else {
+		fmt.Printf("  Allowed orgs: (any — set --org to restrict)\n")
+	}
+	if len(cfg.AllowedRepos) > 0 {
+		fmt.Printf("  Restricting to repos: %s\n", strings.Join(cfg.AllowedRepos, ", "))
+	}
+	if len(cfg.DeniedRepos) > 0 {
+		fmt.Printf("  Denied repos: %s\n", strings.Join(cfg.DeniedRepos, ", "))
+	}
+	fmt.Printf("  Upstream: %s\n", cfg.APIBase())
+	if res, err := srv.readiness.get(); srv.preflightOK {
+		fmt.Printf("  Preflight: ok as %s in %s", res.Login, res.Latency.Round(time.Millisecond))
+		if res.RateLimit.Limit > 0 {
+			fmt.Printf(" (%d/%d requests left)", res.RateLimit.Remaining, res.RateLimit.Limit)
+		}
+		fmt.Println()
+	} else if err != nil {
+		fmt.Printf("  Preflight: GitHub unreachable; not ready, retrying every %s\n", preflightRetryInterval)
+	}
+	if cfg.BasePath != "" {
+		fmt.Printf("  Base path: %s\n", cfg.BasePath)
+	}
+	for _, u := range cfg.Upstreams {
+		fmt.Printf("  Upstream %s: %s\n", u.Name, apiBaseURL(u.APIBaseURL))
+	}
+	if len(cfg.OrgTokens) > 0 {
+		fmt.Printf("  Org tokens: %s\n", strings.Join(slices.Sorted(maps.Keys(cfg.OrgTokens)), ", "))
+	}
+	if cfg.GitHubApp != nil {
+		fmt.Printf("  GitHub App: %s (%d; installation tokens minted per account)\n", cmp.Or(srv.appSlug, "unverified"), cfg.GitHubApp.AppID)
+	}
+	if pool := srv.pool.Load(); pool != nil {
+		fmt.Printf("  Token pool: %d classic tokens (round-robin with failover)\n", len(pool.tokens))
+	}
+	fmt.Printf("  Allowed routes: %d (+%d GraphQL queries)\n", len(srv.routes), len(allowedGraphQLQueries))
+	if len(srv.denied) > 0 {
+		fmt.Printf("  Denied routes: %d\n", len(srv.denied))
+	}
+	if len(cfg.AllowWrites) > 0 {
+		fmt.Printf("  Allowed writes: %s\n", strings.Join(cfg.AllowWrites, ", "))
+	}
+	if cfg.GetWebhookSecret() != "" {
+		fmt.Printf("  Webhook receiver: /v1/webhook\n")
+	}
+	if cfg.GetAdminToken() != "" {
+		fmt.Printf("  Admin API: /v1/admin/ (dashboard: /admin)\n")
+	}
+	if cfg.H2C {
+		fmt.Printf("  HTTP/2: h2c (prior knowledge) enabled\n")
+	}
+	if cfg.RateLimit != nil && cfg.RateLimit.RequestsPerMinute > 0 {
+		fmt.Printf("  Rate limit: %g requests/min per client\n", cfg.RateLimit.RequestsPerMinute)
+	}
+	if cfg.MaxConcurrentRequests > 0 {
+		fmt.Printf("  Concurrency: %d proxied requests in flight\n", cfg.MaxConcurrentRequests)
+	}
+	if cfg.MaxClientConcurrency > 0 {
+		fmt.Printf("  Concurrency: %d proxied requests in flight per client\n", cfg.MaxClientConcurrency)
+	}
+	if b := srv.breakers; b == nil {
+		fmt.Printf("  Circuit breaker: off\n")
+	} else if cfg.CircuitBreaker != nil {
+		fmt.Printf("  Circuit breaker: opens after %d failures for %s\n", b.failures, b.cooldown)
+	}
+	if b := srv.budget; b != nil {
+		fmt.Printf("  Rate budget: pace requests below %d remaining (cache TTLs x%d)\n", b.floor, b.ttlFactor)
+	}
+	if c := srv.responses; c == nil {
+		fmt.Printf("  Response cache: off\n")
+	} else if cfg.ResponseCache != nil {
+		fmt.Printf("  Response cache: %d responses or %s, up to %s each, revalidated with ETags (%d route TTLs)\n",
+			c.maxEntries, formatBytes(uint64(c.maxBytes)), formatBytes(uint64(c.maxBody)), len(c.routes))
+	}
+	if srv.responses != nil && srv.responses.disk != nil {
+		d := srv.responses.disk
+		fmt.Printf("  Response cache: persisted to %s (up to %s, kept %s)\n", d.dir, formatBytes(uint64(d.maxBytes)), d.ttl)
+	}
+	if validator.shared != nil {
+		fmt.Printf("  Redis: %s (validation and response caches shared)\n", validator.shared)
+	}
+	if cfg.ServerTimeouts != nil {
+		fmt.Printf("  Server timeouts: %s\n", srv.listenTimeouts)
+	}
+	if cfg.UpstreamTimeouts != nil {
+		fmt.Printf("  Upstream timeout: %s (streaming %s, %d route overrides)\n",
+			srv.timeouts.def, srv.timeouts.streaming, len(srv.timeouts.routes))
+	}
+	switch cfg.validationMode() {
+	case validationStrict:
+		fmt.Printf("  Validation: strict (per resource)\n")
+	case validationOff:
+		fmt.Printf("  Validation: off — every client is trusted; allow and deny lists still apply\n")
+	}
+	if cfg.RequireOrgMembership {
+		fmt.Printf("  Org membership: required\n")
+	}
+	if len(cfg.ClientTokenPrefixes) > 0 {
+		fmt.Printf("  Client token prefixes: %s\n", strings.Join(cfg.ClientTokenPrefixes, ", "))
+	}
+	if cfg.AuditLog != "" {
+		fmt.Printf("  Audit log: %s\n", cfg.AuditLog)
+	}
+	if srv.alerts != nil {
+		fmt.Printf("  Alerts: %s\n", srv.alerts)
+	}
+	if srv.redact != nil {
+		fmt.Printf("  Log privacy: %s (repositories, commits, and client addresses)\n", srv.redact.mode)
+	}
+	if srv.tracer != nil {
+		fmt.Printf("  Tracing: OTLP to %s\n", srv.tracer.endpoint)
+	}
+	if cfg.PersistValidationCache {
+		fmt.Printf("  Validation cache: persisted to %s\n", ValidationCachePath())
+	}
+	fmt.Printf("  Cache TTL: %s (denied: %s)\n\n", cfg.ValidationCacheTTL, srv.negativeTTL)
+
+	// HTTP/2 is always offered over TLS; cleartext HTTP/2 (h2c) is opt-in
+	// because some intermediaries mishandle it.
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(cfg.H2C)
+	servers := make([]*http.Server, len(listeners))
+	for i, l := range listeners {
+		h := handler
+		if len(cfg.Listeners) > 0 {
+			h = listenerMiddleware(l, cfg.BasePath, cfg.HMACClients, handler)
+		}
+		servers[i] = &http.Server{Handler: h, Protocols: &protocols}
+		srv.listenTimeouts.apply(servers[i])
+		if l.TLS {
+			servers[i].TLSConfig = &tls.Config{Certificates: []tls.Certificate{tlsCert}, MinVersion: tls.VersionTLS12}
+		}
+	}
+
+	// Reload on SIGHUP or an admin allowlist edit.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go srv.reloadOn(hup, cfg)
+
+	// Shut down cleanly on SIGINT/SIGTERM or a service stop so in-flight
+	// requests finish and the validation cache is saved.
+	stopped := make(chan struct{})
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		select {
+		case <-sig:
+		case <-stop:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		var wg sync.WaitGroup
+		for _, s := range servers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.Shutdown(ctx)
+			}()
+		}
+		wg.Wait()
+		close(stopped)
+	}()
+	if *pidFile != "" {
+		if err := writePIDFile(*pidFile); err != nil {
+			fmt.Fprintf(os.Stderr, "error: pid file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	errs := make(chan error, len(servers))
+	for i, s := range servers {
+		go func() {
+			if listeners[i].TLS {
+				errs <- s.ServeTLS(lns[i], "", "")
+			} else {
+				errs <- s.Serve(lns[i])
+			}
+		}()
+	}
+	for range servers {
+		if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+			fmt.Fprintf(os.Stderr, "server error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	<-stopped
+	if err := srv.close(); err != nil {
+		slog.Warn("saving validation cache failed", "error", err)
+	}
+	if *pidFile != "" {
+		os.Remove(*pidFile)
+	}
+}
+
+// printServeCheck reports what serve --check verified.
+func printServeCheck(cfg *Config, srv *server, listeners []Listener) {
+	if cfg.envOnly {
+		fmt.Printf("Config OK: environment only\n")
+	} else {
+		fmt.Printf("Config OK: %s\n", ConfigPath())
+	}
+	if res, err := srv.readiness.get(); err == nil && res.Login != "" {
+		fmt.Printf("  Classic token: ok as %s", res.Login)
+		if res.RateLimit.Limit > 0 {
+			fmt.Printf(" (%d/%d requests left)", res.RateLimit.Remaining, res.RateLimit.Limit)
+		}
+		fmt.Println()
+	}
+	if cfg.GitHubApp != nil {
+		fmt.Printf("  GitHub App: ok (%d)\n", cfg.GitHubApp.AppID)
+	}
+	fmt.Printf("  Routes: %d allowed (%d extra), %d denied\n", len(srv.routes), len(cfg.ExtraAllowedRoutes), len(srv.denied))
+	fmt.Printf("  Cache TTL: %s (denied: %s)\n", srv.ttl, srv.negativeTTL)
+	for _, l := range listeners {
+		fmt.Printf("  Listen %s: ok\n", l.Address)
+	}
+}
@@ -0,0 +1,346 @@
+package checkproxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestProxyHandlerUpstreamURL checks what reaches GitHub for paths that
+// try to escape the repository they were authorized for or to smuggle in a
+// query past filterQuery.
+func TestProxyHandlerUpstreamURL(t *testing.T) {
+	var got *http.Request
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+	}))
+	defer upstream.Close()
+	cfg := &Config{ClassicToken: "ghp_test", APIBaseURL: upstream.URL, ValidationMode: validationOff}
+	handler := ProxyHandler(cfg, NewValidator(time.Minute, time.Minute, 10))
+
+	tests := []struct {
+		name      string
+		target    string
+		status    int
+		wantPath  string // escaped, as GitHub receives it
+		wantQuery string
+	}{
+		{
+			name:     "branch with escaped slash",
+			target:   "/repos/o/r/branches/feat%2Fx/protection/required_status_checks",
+			status:   http.StatusOK,
+			wantPath: "/api/v3/repos/o/r/branches/feat%2Fx/protection/required_status_checks",
+		},
+		{
+			name:   "branch traversing to another repository",
+			target: "/repos/o/r/branches/x%2F..%2F..%2F..%2Fother%2Fsecret%2Fbranches%2Fmain/protection/required_status_checks",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "ruleset branch traversing to another repository",
+			target: "/repos/o/r/rules/branches/..%2F..%2F..%2Fother%2Fsecret%2Fpulls",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "encoded dot segment",
+			target: "/repos/o/r/check-runs/%2e%2e",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "encoded query in a segment",
+			target: "/repos/o/r/commits/abc%3Fsecret%3D1%23/check-runs",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "encoded fragment in a segment",
+			target: "/repos/o/r/check-runs/1%23x",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:      "allowed query is re-encoded",
+			target:    "/repos/o/r/commits/abc/check-runs?per_page=5&check_name=a+b",
+			status:    http.StatusOK,
+			wantPath:  "/api/v3/repos/o/r/commits/abc/check-runs",
+			wantQuery: "check_name=a+b&per_page=5",
+		},
+		{
+			name:   "query parameter not allowed",
+			target: "/repos/o/r/commits/abc/check-runs?secret=1",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:     "GHES prefix is stripped",
+			target:   "/api/v3/repos/o/r/check-runs/1",
+			status:   http.StatusOK,
+			wantPath: "/api/v3/repos/o/r/check-runs/1",
+		},
+		{
+			name:   "owner with escaped slash",
+			target: "/repos/o%2Fx/r/check-runs/1",
+			status: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = nil
+			rec := httptest.NewRecorder()
+			handler(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (%s)", rec.Code, tt.status, rec.Body)
+			}
+			if tt.wantPath == "" {
+				if got != nil {
+					t.Fatalf("request reached GitHub as %s", got.URL)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatal("request did not reach GitHub")
+			}
+			if p := got.URL.EscapedPath(); p != tt.wantPath {
+				t.Errorf("upstream path = %s, want %s", p, tt.wantPath)
+			}
+			if got.URL.RawQuery != tt.wantQuery {
+				t.Errorf("upstream query = %q, want %q", got.URL.RawQuery, tt.wantQuery)
+			}
+		})
+	}
+}
+
+// TestNewHandlerIndependent builds two handlers in one process and checks
+// that one's extra_allowed_routes don't leak into the other.
+func TestNewHandlerIndependent(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-OAuth-Scopes", "repo")
+		w.Write([]byte(`{"login":"bot"}`))
+	}))
+	defer upstream.Close()
+	newHandler := func(extra ...string) http.Handler {
+		t.Helper()
+		h, err := NewHandler(&Config{ClassicToken: "ghp_test", APIBaseURL: upstream.URL, ValidationMode: validationOff,
+			ExtraAllowedRoutes: extra})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { h.Close() })
+		return h
+	}
+	withExtra := newHandler(`^/repos/[^/]+/[^/]+/commits/[^/]+$`)
+	plain := newHandler()
+
+	for _, tt := range []struct {
+		name    string
+		handler http.Handler
+		status  int
+	}{
+		{"extra route", withExtra, http.StatusOK},
+		{"without the extra route", plain, http.StatusNotFound},
+	} {
+		rec := httptest.NewRecorder()
+		tt.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/repos/o/r/commits/abc", nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d (%s)", tt.name, rec.Code, tt.status, rec.Body)
+		}
+	}
+}
+
+func TestParseCommitStreamPath(t *testing.T) {
+	tests := []struct {
+		path string
+		ok   bool
+	}{
+		{"/v1/events/repos/o/r/commits/abc", true},
+		{"/v1/events/repos/o/r/commits/..", false},
+		{"/v1/events/repos/o/r/commits/abc?x=1", false},
+		{"/v1/events/repos/o/r/commits/a%2F..", false},
+		{"/v1/events/repos/o/r/commits/abc/extra", false},
+		{"/v1/events/repos/o//commits/abc", false},
+	}
+	for _, tt := range tests {
+		if _, _, _, ok := parseCommitStreamPath(tt.path, "/v1/events", ""); ok != tt.ok {
+			t.Errorf("parseCommitStreamPath(%q) ok = %v, want %v", tt.path, ok, tt.ok)
+		}
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-OAuth-Scopes", "repo")
+		w.Write([]byte(`{"login":"bot"}`))
+	}))
+	defer upstream.Close()
+	h, err := NewHandler(&Config{ClassicToken: "ghp_test", APIBaseURL: upstream.URL, ValidationMode: validationOff,
+		CORS: &CORSConfig{AllowedOrigins: []string{"https://dashboard.example.com"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	tests := []struct {
+		origin string
+		status int
+	}{
+		{"", http.StatusInternalServerError}, // past the checks; the recorder can't be hijacked
+		{"https://dashboard.example.com", http.StatusInternalServerError},
+		{"https://evil.example.com", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/v1/ws/repos/o/r/commits/abc/checks", nil)
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("origin %q: status = %d, want %d (%s)", tt.origin, rec.Code, tt.status, rec.Body)
+		}
+	}
+}
+
+func TestFindRoute(t *testing.T) {
+	tests := []struct {
+		path  string
+		found bool
+		param string // a parameter the matched route must accept
+	}{
+		{"/repos/o/r/commits/abc/check-runs", true, "check_name"},
+		{"/repos/o/r/commits/abc/check-suites", true, "app_id"},
+		{"/repos/o/r/check-runs/1", true, ""},
+		{"/repos/o/r/check-suites/1/check-runs", true, allPagesParam},
+		{"/repos/o/r/commits/abc/status", true, "per_page"},
+		{"/repos/o/r/pulls", true, "head"},
+		{"/repos/o/r/pulls/12", true, ""},
+		{"/repos/o/r/branches/feat%2Fx/protection/required_status_checks", true, ""},
+		{"/repos/o/r/actions/jobs/1/logs", true, ""},
+		{"/repos/o/r/pulls/abc", false, ""},
+		{"/repos/o/r/contents/README.md", false, ""},
+		{"/repos/o/r/commits/abc/check-runs/extra", false, ""},
+		{"/repos/o/r/branches/feat/x/protection/required_status_checks", false, ""},
+		{"/repos/o/r", false, ""},
+		{"/user", false, ""},
+		{"/repos/o/r/check-runs/1/rerequest", false, ""},
+	}
+	for _, tt := range tests {
+		rt, found := findRoute(allowedRoutes, tt.path)
+		if found != tt.found {
+			t.Errorf("findRoute(%q) found = %v, want %v", tt.path, found, tt.found)
+			continue
+		}
+		if tt.param != "" && !slices.Contains(rt.params, tt.param) {
+			t.Errorf("findRoute(%q) params = %q, missing %q", tt.path, rt.params, tt.param)
+		}
+	}
+}
+
+func TestFilterQuery(t *testing.T) {
+	allowed := []string{"check_name", "per_page", "page"}
+	tests := []struct {
+		query   string
+		want    string
+		wantErr bool
+	}{
+		{query: "", want: ""},
+		{query: "per_page=5&page=2", want: "page=2&per_page=5"},
+		{query: "check_name=a%20b", want: "check_name=a+b"},
+		{query: "check_name=a&check_name=b", want: "check_name=a&check_name=b"},
+		{query: "check_name=%26secret%3D1", want: "check_name=%26secret%3D1"},
+		{query: "secret=1", wantErr: true},
+		{query: "per_page=5&secret=1", wantErr: true},
+		{query: "PER_PAGE=5", wantErr: true},
+		{query: "per_page=%zz", wantErr: true},
+		{query: "per_page=5;secret=1", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := filterQuery(tt.query, allowed)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("filterQuery(%q) err = %v, want error %v", tt.query, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("filterQuery(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+// TestNewHandlerLeavesDefaultTransport checks that upstream_connections
+// tunes the server's own transport, not the embedding program's.
+func TestNewHandlerLeavesDefaultTransport(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-OAuth-Scopes", "repo")
+		w.Write([]byte(`{"login":"bot"}`))
+	}))
+	defer upstream.Close()
+	def := http.DefaultTransport.(*http.Transport)
+	before := def.MaxIdleConnsPerHost
+	h, err := NewHandler(&Config{ClassicToken: "ghp_test", APIBaseURL: upstream.URL,
+		UpstreamConnections: &UpstreamConnections{MaxIdle: 500, MaxIdlePerHost: before + 7}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if def.MaxIdleConnsPerHost != before {
+		t.Errorf("http.DefaultTransport MaxIdleConnsPerHost = %d, want %d", def.MaxIdleConnsPerHost, before)
+	}
+}
+
+// TestHandlerClose checks that Close stops every goroutine the handler
+// started and saves the persisted validation cache.
+func TestHandlerClose(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-OAuth-Scopes", "repo")
+		w.Write([]byte(`{"login":"bot"}`))
+	}))
+	defer upstream.Close()
+	h, err := NewHandler(&Config{ClassicToken: "ghp_test", APIBaseURL: upstream.URL, PersistValidationCache: true,
+		ResponseCache: &ResponseCacheConfig{Persist: true, Dir: t.TempDir()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := runningServerGoroutines(); n == 0 {
+		t.Fatal("no background goroutines before Close")
+	}
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+	if _, err := os.Stat(ValidationCachePath()); err != nil {
+		t.Errorf("validation cache not saved: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for runningServerGoroutines() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines still running after Close", runningServerGoroutines())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// runningServerGoroutines counts the goroutines blocked in a server's
+// background loops.
+func runningServerGoroutines() int {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	n := 0
+	for _, g := range bytes.Split(buf, []byte("\n\n")) {
+		if bytes.Contains(g, []byte("checkproxy.ticks")) || bytes.Contains(g, []byte("checkproxy.(*server).reloadOn")) {
+			n++
+		}
+	}
+	return n
+}
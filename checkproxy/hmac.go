@@ -0,0 +1,110 @@
+package checkproxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers carrying an HMAC-signed request. The signature is the hex
+// HMAC-SHA256, under the client's shared secret, of
+// "<METHOD>\n<request URI>\n<unix timestamp>".
+const (
+	hmacKeyIDHeader     = "X-Checkproxy-Key-Id"
+	hmacTimestampHeader = "X-Checkproxy-Timestamp"
+	hmacSignatureHeader = "X-Checkproxy-Signature"
+)
+
+// hmacMaxSkew bounds how far a signed timestamp may be from the server clock.
+const hmacMaxSkew = 5 * time.Minute
+
+// HMACClient is a shared-secret client identity, scoped to repos and orgs.
+// The secret is read from SecretEnv when set, otherwise Secret.
+type HMACClient struct {
+	ID        string   `json:"id"`
+	Secret    string   `json:"secret,omitempty"`
+	SecretEnv string   `json:"secret_env,omitempty"`
+	Repos     []string `json:"repos,omitempty"`
+	Orgs      []string `json:"orgs,omitempty"`
+}
+
+func (c HMACClient) secret() string {
+	if c.SecretEnv != "" {
+		return firstNonEmpty(strings.TrimSpace(os.Getenv(c.SecretEnv)), c.Secret)
+	}
+	return c.Secret
+}
+
+func (c HMACClient) allows(owner, repo string) bool {
+	return repoMatches(c.Repos, owner, repo) || orgAllowed(c.Orgs, owner)
+}
+
+// hmacSignature computes the signature for a request.
+func hmacSignature(secret, method, requestURI, timestamp string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + requestURI + "\n" + timestamp))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyHMAC authenticates a signed request and returns its client. The
+// signed URI is the one the client sent, before any base_path stripping.
+func verifyHMAC(r *http.Request, clients []HMACClient) (HMACClient, error) {
+	id := r.Header.Get(hmacKeyIDHeader)
+	var client HMACClient
+	found := false
+	for _, c := range clients {
+		if c.ID == id {
+			client, found = c, true
+			break
+		}
+	}
+	secret := client.secret()
+	if !found || secret == "" {
+		return HMACClient{}, errors.New("unknown key id")
+	}
+
+	ts := r.Header.Get(hmacTimestampHeader)
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return HMACClient{}, errors.New("invalid timestamp")
+	}
+	if skew := time.Since(time.Unix(unix, 0)); skew > hmacMaxSkew || skew < -hmacMaxSkew {
+		return HMACClient{}, errors.New("timestamp outside the allowed window")
+	}
+
+	got, err := hex.DecodeString(r.Header.Get(hmacSignatureHeader))
+	want, _ := hex.DecodeString(hmacSignature(secret, r.Method, r.RequestURI, ts))
+	if err != nil || !hmac.Equal(got, want) {
+		return HMACClient{}, errors.New("invalid signature")
+	}
+	return client, nil
+}
+
+// hmacTransport signs requests to the proxy host with a shared secret. It
+// drops the empty bearer header the client sends when it has no token.
+type hmacTransport struct {
+	proxyHost string
+	id        string
+	secret    string
+	next      http.RoundTripper
+}
+
+func (t *hmacTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host == t.proxyHost {
+		req = req.Clone(req.Context())
+		if strings.TrimSpace(strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer")) == "" {
+			req.Header.Del("Authorization")
+		}
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(hmacKeyIDHeader, t.id)
+		req.Header.Set(hmacTimestampHeader, ts)
+		req.Header.Set(hmacSignatureHeader, hmacSignature(t.secret, req.Method, req.URL.RequestURI(), ts))
+	}
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,62 @@
+package checkproxy
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerifyHMAC(t *testing.T) {
+	clients := []HMACClient{{ID: "ci", Secret: "s3cret"}, {ID: "nosecret"}}
+	now := time.Now()
+	tests := []struct {
+		name    string
+		id      string
+		secret  string // signing secret
+		ts      string
+		uri     string // URI signed, if not the request's
+		wantErr string
+	}{
+		{name: "valid", id: "ci", secret: "s3cret", ts: unixString(now)},
+		{name: "just inside past skew", id: "ci", secret: "s3cret", ts: unixString(now.Add(-hmacMaxSkew + 5*time.Second))},
+		{name: "just inside future skew", id: "ci", secret: "s3cret", ts: unixString(now.Add(hmacMaxSkew - 5*time.Second))},
+		{name: "too old", id: "ci", secret: "s3cret", ts: unixString(now.Add(-hmacMaxSkew - 5*time.Second)), wantErr: "timestamp outside the allowed window"},
+		{name: "too far ahead", id: "ci", secret: "s3cret", ts: unixString(now.Add(hmacMaxSkew + 5*time.Second)), wantErr: "timestamp outside the allowed window"},
+		{name: "not a number", id: "ci", secret: "s3cret", ts: "yesterday", wantErr: "invalid timestamp"},
+		{name: "unknown id", id: "other", secret: "s3cret", ts: unixString(now), wantErr: "unknown key id"},
+		{name: "client without secret", id: "nosecret", secret: "", ts: unixString(now), wantErr: "unknown key id"},
+		{name: "wrong secret", id: "ci", secret: "other", ts: unixString(now), wantErr: "invalid signature"},
+		{name: "different URI", id: "ci", secret: "s3cret", ts: unixString(now), uri: "/repos/o/other/check-runs/1", wantErr: "invalid signature"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/repos/o/r/check-runs/1", nil)
+			uri := tt.uri
+			if uri == "" {
+				uri = r.RequestURI
+			}
+			r.Header.Set(hmacKeyIDHeader, tt.id)
+			r.Header.Set(hmacTimestampHeader, tt.ts)
+			r.Header.Set(hmacSignatureHeader, hmacSignature(tt.secret, "GET", uri, tt.ts))
+
+			client, err := verifyHMAC(r, clients)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if client.ID != tt.id {
+				t.Errorf("client = %q, want %q", client.ID, tt.id)
+			}
+		})
+	}
+}
+
+func unixString(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
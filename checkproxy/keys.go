@@ -0,0 +1,209 @@
+package checkproxy
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// apiKeyPrefix marks proxy-issued keys so they're never mistaken for GitHub tokens.
+const apiKeyPrefix = "gcp_"
+
+// apiKey is a proxy-issued client credential. Only the SHA-256 of the key is
+// stored; the key itself is shown once, when it is created.
+type apiKey struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name,omitempty"`
+	Hash    string    `json:"hash"`
+	Repos   []string  `json:"repos,omitempty"` // owner/repo patterns
+	Orgs    []string  `json:"orgs,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// allows reports whether the key is scoped to owner/repo.
+func (k apiKey) allows(owner, repo string) bool {
+	return repoMatches(k.Repos, owner, repo) || orgAllowed(k.Orgs, owner)
+}
+
+// KeysPath returns the path to the API key store, next to the config file.
+func KeysPath() string {
+	return filepath.Join(filepath.Dir(ConfigPath()), "keys.json")
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// keyStore serves API key lookups for the server. It rereads the key file
+// when it changes, so keys created or deleted with `gh-checkproxy keys` take
+// effect without a restart.
+type keyStore struct {
+	file *watchedFile[map[string]apiKey]
+}
+
+func newKeyStore(path string) *keyStore {
+	return &keyStore{file: newWatchedFile(path, func(path string) (map[string]apiKey, error) {
+		keys, err := loadAPIKeys(path)
+		byHash := make(map[string]apiKey, len(keys))
+		for _, k := range keys {
+			byHash[k.Hash] = k
+		}
+		return byHash, err
+	})}
+}
+
+// lookup returns the key matching the presented credential.
+func (s *keyStore) lookup(presented string) (apiKey, bool) {
+	k, ok := s.file.get()[hashAPIKey(presented)]
+	return k, ok
+}
+
+// watchedFile caches a value loaded from a file and reloads it whenever the
+// file's modification time changes. A missing file yields the zero value; a
+// file that fails to load keeps the last good value.
+type watchedFile[T any] struct {
+	path    string
+	load    func(path string) (T, error)
+	mu      sync.Mutex
+	modTime time.Time
+	value   T
+}
+
+func newWatchedFile[T any](path string, load func(path string) (T, error)) *watchedFile[T] {
+	return &watchedFile[T]{path: path, load: load}
+}
+
+func (f *watchedFile[T]) get() T {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	info, err := os.Stat(f.path)
+	if err != nil {
+		var zero T
+		f.value, f.modTime = zero, time.Time{}
+		return f.value
+	}
+	if !info.ModTime().Equal(f.modTime) {
+		if v, err := f.load(f.path); err == nil {
+			f.value, f.modTime = v, info.ModTime()
+		}
+	}
+	return f.value
+}
+
+func loadAPIKeys(path string) ([]apiKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var keys []apiKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("invalid key store %s: %w", path, err)
+	}
+	return keys, nil
+}
+
+func saveAPIKeys(path string, keys []apiKey) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// runKeys handles `gh-checkproxy keys create|list|delete`.
+func runKeys(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: gh-checkproxy keys create|list|delete")
+	}
+	path := KeysPath()
+	keys, err := loadAPIKeys(path)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("keys create", flag.ContinueOnError)
+		repos := fs.String("repo", "", "Owner/repo patterns the key may read, comma-separated, * wildcards allowed")
+		orgs := fs.String("org", "", "Organizations the key may read, comma-separated")
+		name := fs.String("name", "", "Label to identify the key, e.g. the agent it belongs to")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		k := apiKey{Name: *name, Repos: splitComma(*repos), Orgs: splitComma(*orgs), Created: time.Now().UTC()}
+		if len(k.Repos) == 0 && len(k.Orgs) == 0 {
+			return fmt.Errorf("a key needs at least one --repo or --org")
+		}
+		for _, p := range k.Repos {
+			if err := validateRepoPattern(p); err != nil {
+				return err
+			}
+		}
+
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+		k.Hash = hashAPIKey(key)
+		k.ID = k.Hash[:12]
+		keys = append(keys, k)
+		if err := saveAPIKeys(path, keys); err != nil {
+			return err
+		}
+		fmt.Printf("Created key %s. It won't be shown again:\n\n  %s\n\n", k.ID, key)
+		fmt.Printf("Clients send it as a bearer token in place of a GitHub token.\n")
+		return nil
+
+	case "list":
+		if len(keys) == 0 {
+			fmt.Println("No API keys.")
+			return nil
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i].Created.Before(keys[j].Created) })
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tREPOS\tORGS\tCREATED")
+		for _, k := range keys {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", k.ID, k.Name, strings.Join(k.Repos, ","),
+				strings.Join(k.Orgs, ","), k.Created.Format(time.DateOnly))
+		}
+		return tw.Flush()
+
+	case "delete":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: gh-checkproxy keys delete <id>")
+		}
+		for i, k := range keys {
+			if k.ID == args[1] {
+				if err := saveAPIKeys(path, append(keys[:i], keys[i+1:]...)); err != nil {
+					return err
+				}
+				fmt.Printf("Deleted key %s.\n", k.ID)
+				return nil
+			}
+		}
+		return fmt.Errorf("no key with id %q", args[1])
+
+	default:
+		return fmt.Errorf("unknown keys command %q: use create, list, or delete", args[0])
+	}
+}
@@ -0,0 +1,84 @@
+package checkproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestAPIKeys checks that proxy-issued keys are held to their scope, never
+// checked with GitHub, and picked up when the key file changes.
+func TestAPIKeys(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	var upstreamAuth []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamAuth = append(upstreamAuth, r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-OAuth-Scopes", "repo")
+		w.Write([]byte(`{"login":"bot"}`))
+	}))
+	defer upstream.Close()
+	if err := saveAPIKeys(KeysPath(), []apiKey{
+		{ID: "k1", Hash: hashAPIKey(apiKeyPrefix + "repo"), Repos: []string{"o/r", "o/tools-*"}},
+		{ID: "k2", Hash: hashAPIKey(apiKeyPrefix + "org"), Orgs: []string{"team"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	h, err := NewHandler(&Config{ClassicToken: "ghp_test", APIBaseURL: upstream.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	get := func(key, target string) int {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.Header.Set("Authorization", "Bearer "+key)
+		r.RemoteAddr = "192.0.2.1:1000"
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	tests := []struct {
+		name   string
+		key    string
+		target string
+		status int
+	}{
+		{"scoped repository", apiKeyPrefix + "repo", "/repos/o/r/check-runs/1", http.StatusOK},
+		{"repository pattern", apiKeyPrefix + "repo", "/repos/O/tools-ci/check-runs/1", http.StatusOK},
+		{"repository out of scope", apiKeyPrefix + "repo", "/repos/o/other/check-runs/1", http.StatusForbidden},
+		{"scoped organization", apiKeyPrefix + "org", "/repos/team/anything/check-runs/1", http.StatusOK},
+		{"organization out of scope", apiKeyPrefix + "org", "/repos/o/r/check-runs/1", http.StatusForbidden},
+		{"unknown key", apiKeyPrefix + "guess", "/repos/o/r/check-runs/1", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstreamAuth = nil
+			if got := get(tt.key, tt.target); got != tt.status {
+				t.Errorf("status = %d, want %d", got, tt.status)
+			}
+			for _, auth := range upstreamAuth {
+				if auth != "Bearer ghp_test" {
+					t.Errorf("GitHub got Authorization %q, want the server token", auth)
+				}
+			}
+		})
+	}
+
+	// A key created while the server runs works from the next request.
+	if err := saveAPIKeys(KeysPath(), []apiKey{{ID: "k3", Hash: hashAPIKey(apiKeyPrefix + "new"), Repos: []string{"o/r"}}}); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(KeysPath(), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if got := get(apiKeyPrefix+"new", "/repos/o/r/check-runs/1"); got != http.StatusOK {
+		t.Errorf("new key: status = %d, want %d", got, http.StatusOK)
+	}
+	if got := get(apiKeyPrefix+"repo", "/repos/o/r/check-runs/1"); got != http.StatusUnauthorized {
+		t.Errorf("deleted key: status = %d, want %d", got, http.StatusUnauthorized)
+	}
+}
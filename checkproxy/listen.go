@@ -0,0 +1,178 @@
+package checkproxy
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first file descriptor systemd passes to a
+// socket-activated service (SD_LISTEN_FDS_START).
+const listenFDsStart = 3
+
+// systemdListener returns the socket systemd passed to this process
+// (LISTEN_PID and LISTEN_FDS, see sd_listen_fds(3)), or nil when the server
+// wasn't socket-activated. Only the first socket is used. The variables are
+// cleared so processes started from here don't take the socket for theirs.
+func systemdListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	name, _, _ := strings.Cut(os.Getenv("LISTEN_FDNAMES"), ":")
+	for _, env := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		os.Unsetenv(env)
+	}
+	if n > 1 {
+		slog.Warn("systemd passed several sockets; using only the first", "count", n)
+	}
+
+	f := os.NewFile(listenFDsStart, "systemd:"+name)
+	defer f.Close() // FileListener dups the descriptor
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("systemd socket: %w", err)
+	}
+	return ln, nil
+}
+
+// listen returns the systemd-provided socket if there is one, else a TCP
+// listener on addr.
+func listen(addr string) (ln net.Listener, activated bool, err error) {
+	if ln, err = systemdListener(); ln != nil || err != nil {
+		return ln, ln != nil, err
+	}
+	ln, err = net.Listen("tcp", addr)
+	return ln, false, err
+}
+
+// Listener is one address the server accepts connections on. When listeners
+// is set, it replaces port; each listener can serve a different audience.
+type Listener struct {
+	// Address is "host:port", or "unix:/path" for a Unix socket.
+	Address string `json:"address"`
+	// TLS serves HTTPS with tls_cert_file and tls_key_file.
+	TLS bool `json:"tls,omitempty"`
+	// Admin serves /v1/admin/ and the /admin dashboard on this listener;
+	// other listeners answer 404.
+	Admin bool `json:"admin,omitempty"`
+	// RequireHMAC refuses requests that aren't HMAC-signed, apart from
+	// health and readiness checks, webhook deliveries, and admin requests on
+	// an admin listener.
+	RequireHMAC bool `json:"require_hmac,omitempty"`
+}
+
+// unixPath returns the socket path of a "unix:" address.
+func (l Listener) unixPath() (string, bool) {
+	return strings.CutPrefix(l.Address, "unix:")
+}
+
+// serveListeners returns the configured listeners, or the single one port
+// implies.
+func (c *Config) serveListeners() []Listener {
+	if len(c.Listeners) > 0 {
+		return c.Listeners
+	}
+	return []Listener{{Address: fmt.Sprintf(":%d", c.Port), TLS: c.TLSEnabled(), Admin: true}}
+}
+
+// checkListeners validates listeners at startup.
+func (c *Config) checkListeners() error {
+	for _, l := range c.Listeners {
+		if path, ok := l.unixPath(); ok {
+			if path == "" {
+				return errors.New("unix: address needs a socket path")
+			}
+		} else if _, _, err := net.SplitHostPort(l.Address); err != nil {
+			return fmt.Errorf("address %q: %w", l.Address, err)
+		}
+		if l.TLS && (c.TLSCertFile == "" || c.TLSKeyFile == "") {
+			return fmt.Errorf("%s: tls needs tls_cert_file and tls_key_file", l.Address)
+		}
+		if l.RequireHMAC && len(c.HMACClients) == 0 {
+			return fmt.Errorf("%s: require_hmac needs hmac_clients", l.Address)
+		}
+	}
+	return nil
+}
+
+// openListener opens l. Only a server configured by port alone takes a
+// systemd socket, since there'd be no telling which listener it stands for.
+func (c *Config) openListener(l Listener) (ln net.Listener, activated bool, err error) {
+	if path, ok := l.unixPath(); ok {
+		// A socket left behind by a server that didn't exit cleanly would
+		// make the bind fail; one that still answers belongs to a live server.
+		if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			if conn, err := net.Dial("unix", path); err == nil {
+				conn.Close()
+			} else {
+				os.Remove(path)
+			}
+		}
+		ln, err = net.Listen("unix", path)
+		return ln, false, err
+	}
+	if len(c.Listeners) == 0 {
+		return listen(l.Address)
+	}
+	ln, err = net.Listen("tcp", l.Address)
+	return ln, false, err
+}
+
+// notes describes l for the startup banner and status, e.g. " (HTTPS,
+// admin)". Admin only matters when there are several listeners to tell apart.
+func (l Listener) notes(several bool) string {
+	var notes []string
+	if l.TLS {
+		notes = append(notes, "HTTPS")
+	}
+	if several && l.Admin {
+		notes = append(notes, "admin")
+	}
+	if l.RequireHMAC {
+		notes = append(notes, "HMAC-signed only")
+	}
+	if len(notes) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(notes, ", ") + ")"
+}
+
+// listenerMiddleware applies a configured listener's own requirements
+// before the shared handler. On a require_hmac listener the signature is
+// verified here, not just looked for, since endpoints outside authorizeRepo
+// (and every endpoint with validation off) would otherwise trust the header.
+func listenerMiddleware(l Listener, basePath string, clients []HMACClient, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, basePath)
+		admin := strings.HasPrefix(path, "/v1/admin/") || path == "/admin" || strings.HasPrefix(path, "/admin/")
+		if admin && !l.Admin {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if l.RequireHMAC && r.Method != http.MethodOptions && !admin {
+			switch path {
+			case "/v1/health", "/readyz", "/v1/webhook":
+			default:
+				if r.Header.Get(hmacKeyIDHeader) == "" {
+					http.Error(w, "unauthorized: this listener accepts only HMAC-signed requests", http.StatusUnauthorized)
+					return
+				}
+				if _, err := verifyHMAC(r, clients); err != nil {
+					http.Error(w, "unauthorized: "+err.Error(), http.StatusUnauthorized)
+					return
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
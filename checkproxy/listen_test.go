@@ -0,0 +1,65 @@
+package checkproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestRequireHMACForgedKeyID sends requests with a made-up key id, and with
+// a valid signature, to an HMAC-only listener with validation off.
+func TestRequireHMACForgedKeyID(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-OAuth-Scopes", "repo")
+		w.Write([]byte(`{"login":"bot"}`))
+	}))
+	defer upstream.Close()
+	clients := []HMACClient{{ID: "ci", Secret: "s3cret", Orgs: []string{"o"}}}
+	h, err := NewHandler(&Config{ClassicToken: "ghp_test", APIBaseURL: upstream.URL, ValidationMode: validationOff, HMACClients: clients})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	listener := listenerMiddleware(Listener{Address: "127.0.0.1:0", RequireHMAC: true}, "", clients, h)
+
+	sign := func(r *http.Request, id, secret string) {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		r.Header.Set(hmacKeyIDHeader, id)
+		r.Header.Set(hmacTimestampHeader, ts)
+		r.Header.Set(hmacSignatureHeader, hmacSignature(secret, r.Method, r.RequestURI, ts))
+	}
+	tests := []struct {
+		name    string
+		handler http.Handler
+		target  string
+		id      string
+		secret  string
+		status  int
+	}{
+		{"forged key id on the listener", listener, "/repos/o/r/check-runs/1", "made-up", "guess", http.StatusUnauthorized},
+		{"forged signature on the listener", listener, "/repos/o/r/check-runs/1", "ci", "guess", http.StatusUnauthorized},
+		{"forged key id on metrics", listener, "/v1/metrics", "made-up", "guess", http.StatusUnauthorized},
+		{"unsigned", listener, "/repos/o/r/check-runs/1", "", "", http.StatusUnauthorized},
+		{"health stays open", listener, "/v1/health", "", "", http.StatusOK},
+		{"signed", listener, "/repos/o/r/check-runs/1", "ci", "s3cret", http.StatusOK},
+		{"signed for a repository out of scope", listener, "/repos/other/r/check-runs/1", "ci", "s3cret", http.StatusForbidden},
+		{"forged key id past the listener", h, "/repos/o/r/check-runs/1", "made-up", "guess", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.id != "" {
+				sign(r, tt.id, tt.secret)
+			}
+			rec := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rec, r)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d (%s)", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+}
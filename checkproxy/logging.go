@@ -0,0 +1,74 @@
+package checkproxy
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// serviceLog, when set, builds the server log handler in place of stderr:
+// a Windows service logs to the event log.
+var serviceLog func(opts *slog.HandlerOptions) slog.Handler
+
+// setupLogging configures the server log on stderr from serve's --log-level
+// (debug, info, warn, error) and --log-format (text, json).
+func setupLogging(level, format string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("--log-level: %q is not debug, info, warn, or error", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	var h slog.Handler
+	switch strings.ToLower(format) {
+	case "text":
+		h = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("--log-format: %q is not text or json", format)
+	}
+	if serviceLog != nil {
+		h = serviceLog(opts)
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// traceTransport logs each upstream request at debug level, records it as
+// a client span, and counts its outcome for alerts. URLs never carry credentials here; tokens travel in the
+// Authorization header, which isn't logged.
+type traceTransport struct {
+	next     http.RoundTripper
+	tracer   *traceExporter  // nil records no spans
+	outcomes *outcomeCounter // nil counts nothing
+	redact   *logRedactor
+}
+
+func (t traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	_, s := startSpan(withTracer(req.Context(), t.tracer), req.Method, spanClient)
+	defer s.finish()
+	s.set("http.request.method", req.Method)
+	s.set("url.full", req.URL.Redacted())
+	s.set("server.address", req.URL.Hostname())
+	resp, err := t.next.RoundTrip(req)
+	if failed, counts := upstreamFailed(req, resp, err); counts {
+		t.outcomes.add(failed)
+	}
+	if err != nil {
+		s.fail(err)
+		slog.Debug("upstream request failed", "method", req.Method, "url", t.redact.url(req.URL), "error", t.redact.error(err))
+		return resp, err
+	}
+	s.set("http.response.status_code", resp.StatusCode)
+	if resp.StatusCode >= 500 {
+		s.fail(fmt.Errorf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode)))
+	}
+	slog.Debug("upstream request", "method", req.Method, "url", t.redact.url(req.URL),
+		"status", resp.StatusCode, "duration", time.Since(start).Round(time.Millisecond).String(),
+		"rate_limit_remaining", resp.Header.Get("X-RateLimit-Remaining"))
+	return resp, nil
+}
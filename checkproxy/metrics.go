@@ -0,0 +1,180 @@
+package checkproxy
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metricsHandler serves counters in the Prometheus text exposition format.
+// Like /v1/info it exposes no tokens or repository names. Traffic broken
+// down by owner names the orgs using the proxy, so it is only included for
+// scrapes that send the admin token (adminToken; with none configured it is
+// never included). See /v1/admin/traffic for repositories.
+func metricsHandler(srv *server, adminToken string) http.HandlerFunc {
+	validator, tokens := srv.validator, srv.tokens
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		s := validator.CacheStats()
+		writeMetric(w, "checkproxy_validation_cache_entries", "gauge", "Validation results currently cached.", s.Entries)
+		writeMetric(w, "checkproxy_validation_cache_capacity", "gauge", "Maximum validation results kept.", s.Capacity)
+		writeMetric(w, "checkproxy_validation_cache_hits_total", "counter", "Validations answered from the cache.", s.Hits)
+		writeMetric(w, "checkproxy_validation_cache_misses_total", "counter", "Validations that had to ask GitHub.", s.Misses)
+		writeMetric(w, "checkproxy_validation_cache_evictions_total", "counter", "Cached results evicted to stay within capacity.", s.Evictions)
+
+		if c := srv.responses; c != nil {
+			s := c.stats()
+			writeMetric(w, "checkproxy_response_cache_entries", "gauge", "Upstream responses currently cached.", s.Entries)
+			writeMetric(w, "checkproxy_response_cache_capacity", "gauge", "Maximum upstream responses kept.", s.Capacity)
+			writeMetric(w, "checkproxy_response_cache_bytes", "gauge", "Memory taken by cached upstream responses, in bytes.", s.Bytes)
+			writeMetric(w, "checkproxy_response_cache_max_bytes", "gauge", "Memory cached upstream responses may take, in bytes.", s.MaxBytes)
+			writeMetric(w, "checkproxy_response_cache_hits_total", "counter", "Cached responses served after GitHub answered 304 Not Modified.", s.Hits)
+			writeMetric(w, "checkproxy_response_cache_fresh_hits_total", "counter", "Cached responses served within their route's TTL, without asking GitHub.", s.Fresh)
+			writeMetric(w, "checkproxy_response_cache_stale_hits_total", "counter", "Expired responses served within max_stale while revalidated in the background.", s.Stale)
+			writeMetric(w, "checkproxy_response_cache_misses_total", "counter", "Upstream GETs with no cached response to revalidate.", s.Misses)
+			writeMetric(w, "checkproxy_response_cache_evictions_total", "counter", "Cached responses evicted to stay within capacity.", s.Evictions)
+			writeMetric(w, "checkproxy_response_cache_prefetched_total", "counter", "Check-run list pages fetched into the cache before a client asked for them.", s.Prefetched)
+			if c.disk != nil {
+				writeMetric(w, "checkproxy_response_cache_disk_loads_total", "counter", "Cached responses read back from disk.", s.Loaded)
+			}
+		}
+
+		if r := validator.shared; r != nil {
+			writeMetric(w, "checkproxy_redis_hits_total", "counter", "Cache lookups answered from Redis.", r.hits.Load())
+			writeMetric(w, "checkproxy_redis_misses_total", "counter", "Cache lookups Redis had no answer for.", r.misses.Load())
+			writeMetric(w, "checkproxy_redis_errors_total", "counter", "Redis commands that failed; the caches stay local meanwhile.", r.errors.Load())
+		}
+		writeMetric(w, "checkproxy_upstream_coalesced_total", "counter", "Upstream GETs answered by an identical request already in flight.", srv.flights.coalesced.Load())
+
+		// Tokens without an expiry have no series.
+		expiries := tokens.expiries()
+		maps.DeleteFunc(expiries, func(_ string, exp time.Time) bool { return exp.IsZero() })
+		if len(expiries) > 0 {
+			const name = "checkproxy_classic_token_expiry_seconds"
+			fmt.Fprintf(w, "# HELP %s Seconds until the upstream's classic token expires.\n# TYPE %s gauge\n", name, name)
+			for _, up := range slices.Sorted(maps.Keys(expiries)) {
+				fmt.Fprintf(w, "%s{upstream=%q} %.0f\n", name, cmp.Or(up, "default"), time.Until(expiries[up]).Seconds())
+			}
+		}
+
+		writeRateLimitMetrics(w, tokens)
+
+		if !isAdmin(r, adminToken) {
+			return
+		}
+		if orgs := srv.traffic.byOrg(); len(orgs) > 0 {
+			for _, m := range []struct {
+				name, help string
+				value      func(repoTraffic) uint64
+			}{
+				{"checkproxy_org_requests_total", "Authorized requests per repository owner.", func(t repoTraffic) uint64 { return t.Requests }},
+				{"checkproxy_org_response_bytes_total", "Response body bytes proxied per repository owner.", func(t repoTraffic) uint64 { return t.ResponseBytes }},
+				{"checkproxy_org_upstream_requests_total", "Requests sent to GitHub per repository owner.", func(t repoTraffic) uint64 { return t.UpstreamRequests }},
+				{"checkproxy_org_rate_limit_used_total", "Upstream requests GitHub charged against a rate limit, per repository owner.", func(t repoTraffic) uint64 { return t.RateLimitUsed }},
+			} {
+				fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", m.name, m.help, m.name)
+				for _, o := range orgs {
+					fmt.Fprintf(w, "%s{org=%q} %d\n", m.name, o.Owner, m.value(o))
+				}
+			}
+		}
+	}
+}
+
+// tokenRateLimit is the rate limit GitHub last reported for one of the
+// server's tokens and resources.
+type tokenRateLimit struct {
+	token    string // labelled like the expiry series
+	resource string
+	status   rateLimitStatus
+}
+
+// currentRateLimits returns the rate limits of the server's tokens, sorted.
+// GitHub App installation tokens, which are replaced hourly, are labelled
+// "app:" and a fingerprint prefix. Windows that have reset are left out
+// until GitHub reports the new one.
+func currentRateLimits(tokens *classicTokenMonitor) []tokenRateLimit {
+	names := tokens.tokenNames()
+	now := time.Now()
+	var all []tokenRateLimit
+	for key, s := range tokens.limits.snapshot() {
+		if s.Reset.Before(now) {
+			continue
+		}
+		label := "app:" + key.token[:8]
+		if name, ok := names[key.token]; ok {
+			label = cmp.Or(name, "default")
+		}
+		all = append(all, tokenRateLimit{label, key.resource, s})
+	}
+	slices.SortFunc(all, func(a, b tokenRateLimit) int {
+		return cmp.Or(cmp.Compare(a.token, b.token), cmp.Compare(a.resource, b.resource))
+	})
+	return all
+}
+
+// writeRateLimitMetrics writes the gauges for currentRateLimits.
+func writeRateLimitMetrics(w io.Writer, tokens *classicTokenMonitor) {
+	all := currentRateLimits(tokens)
+	if len(all) == 0 {
+		return
+	}
+	for _, m := range []struct {
+		name, help string
+		value      func(rateLimitStatus) int64
+	}{
+		{"checkproxy_rate_limit_remaining", "Requests left in the token's current GitHub rate limit window.", func(s rateLimitStatus) int64 { return int64(s.Remaining) }},
+		{"checkproxy_rate_limit_limit", "Requests the token may make per GitHub rate limit window.", func(s rateLimitStatus) int64 { return int64(s.Limit) }},
+		{"checkproxy_rate_limit_reset_timestamp_seconds", "Unix time at which the token's GitHub rate limit window resets.", func(s rateLimitStatus) int64 { return s.Reset.Unix() }},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", m.name, m.help, m.name)
+		for _, s := range all {
+			fmt.Fprintf(w, "%s{token=%q,resource=%q} %d\n", m.name, s.token, s.resource, m.value(s.status))
+		}
+	}
+}
+
+func writeMetric(w io.Writer, name, kind, help string, value any) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+}
+
+// fetchMetrics reads the metrics of a running server at baseURL into a map
+// from metric name to value, ignoring labelled series.
+func fetchMetrics(client *http.Client, baseURL string) (map[string]float64, error) {
+	resp, err := client.Get(baseURL + "/v1/metrics")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metrics returned %d", resp.StatusCode)
+	}
+
+	metrics := make(map[string]float64)
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, " ")
+		if !ok || strings.Contains(name, "{") {
+			continue
+		}
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			metrics[name] = f
+		}
+	}
+	return metrics, scanner.Err()
+}
@@ -0,0 +1,218 @@
+package checkproxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultCORSHeaders are the request headers browsers may send cross-origin.
+var defaultCORSHeaders = []string{
+	"Authorization",
+	"Accept",
+	"Content-Type",
+	"If-None-Match",
+	"If-Modified-Since",
+	upstreamHeader,
+	apiVersionHeader,
+	hmacKeyIDHeader,
+	hmacTimestampHeader,
+	hmacSignatureHeader,
+}
+
+// corsMiddleware answers preflight requests and adds CORS headers for allowed
+// origins. Requests from other origins get no CORS headers, so browsers block
+// them; non-browser clients are unaffected.
+func corsMiddleware(cors *CORSConfig, next http.Handler) (http.Handler, error) {
+	if cors == nil || len(cors.AllowedOrigins) == 0 {
+		return next, nil
+	}
+	maxAge := 10 * time.Minute
+	if cors.MaxAge != "" {
+		d, err := time.ParseDuration(cors.MaxAge)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max_age %q: %w", cors.MaxAge, err)
+		}
+		maxAge = d
+	}
+	allowHeaders := strings.Join(append(slices.Clone(defaultCORSHeaders), cors.AllowedHeaders...), ", ")
+	exposeHeaders := strings.Join(append(slices.Clone(headersToForward), apiVersionHeader, tokenExpirationHeader, "Retry-After"), ", ")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !originAllowed(cors, origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Add("Vary", "Origin")
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", "GET, HEAD, POST")
+			h.Set("Access-Control-Allow-Headers", allowHeaders)
+			h.Set("Access-Control-Max-Age", strconv.Itoa(int(maxAge.Seconds())))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.Set("Access-Control-Expose-Headers", exposeHeaders)
+		next.ServeHTTP(w, r)
+	}), nil
+}
+
+// originAllowed reports whether cors lets pages from origin call the proxy.
+// With no CORS config, no origin is allowed.
+func originAllowed(cors *CORSConfig, origin string) bool {
+	if cors == nil {
+		return false
+	}
+	return slices.Contains(cors.AllowedOrigins, "*") || slices.Contains(cors.AllowedOrigins, origin)
+}
+
+// parseCIDRs parses trusted proxy entries. Bare IPs are treated as /32 or /128.
+func parseCIDRs(entries []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, e := range entries {
+		if !strings.Contains(e, "/") {
+			ip := net.ParseIP(e)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP %q", e)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			e = fmt.Sprintf("%s/%d", e, bits)
+		}
+		_, n, err := net.ParseCIDR(e)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", e, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func ipTrusted(trusted []*net.IPNet, ip net.IP) bool {
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the real client address for r. Forwarding headers are only
+// believed when the direct peer is a trusted proxy; X-Forwarded-For is walked
+// right to left, skipping trusted hops, so a client cannot spoof its address
+// by prepending entries.
+func clientIP(r *http.Request, trusted []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !peerTrusted(r, trusted) {
+		return host
+	}
+
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				break
+			}
+			if !ipTrusted(trusted, ip) {
+				return ip.String()
+			}
+		}
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return host
+}
+
+// realIPMiddleware rewrites r.RemoteAddr to the real client address (see
+// clientIP), so logging and access controls further down see the client
+// rather than the load balancer. For trusted peers it also adopts
+// X-Forwarded-Proto (into r.URL.Scheme) and X-Forwarded-Host, which are used
+// to build the proxy's public URL.
+func realIPMiddleware(trusted []*net.IPNet, next http.Handler) http.Handler {
+	if len(trusted) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r2 := r.Clone(r.Context())
+		r2.RemoteAddr = net.JoinHostPort(clientIP(r, trusted), "0")
+		if peerTrusted(r, trusted) {
+			if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+				r2.URL.Scheme = proto
+			}
+			if host := r.Header.Get("X-Forwarded-Host"); host != "" {
+				r2.Host = host
+			}
+		}
+		next.ServeHTTP(w, r2)
+	})
+}
+
+func peerTrusted(r *http.Request, trusted []*net.IPNet) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer := net.ParseIP(host)
+	return peer != nil && ipTrusted(trusted, peer)
+}
+
+// requestIDHeader names the response header carrying the correlation ID of
+// a request that failed with a panic. The server log entry with the stack
+// trace has the same ID.
+const requestIDHeader = "X-Checkproxy-Request-Id"
+
+// recoveryMiddleware turns a panic while serving a request into a 500 that
+// carries a correlation ID, and logs the panic with its stack trace, so one
+// bad upstream payload fails one request rather than the whole proxy. If
+// the response had already started, the connection is aborted instead so
+// the client can't mistake a truncated body for a complete one. The path
+// is logged as redact allows.
+func recoveryMiddleware(redact *logRedactor, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			id := newRequestID()
+			slog.Error("panic serving request", "request_id", id, "method", r.Method, "path", redact.path(r.URL.Path),
+				"panic", fmt.Sprint(v), "stack", string(debug.Stack()))
+			if rec.status != 0 {
+				panic(http.ErrAbortHandler)
+			}
+			w.Header().Set(requestIDHeader, id)
+			http.Error(w, "internal server error (request ID "+id+")", http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// newRequestID returns a random 16-digit hex correlation ID.
+func newRequestID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,143 @@
+package checkproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := parseCIDRs([]string{"10.0.0.0/8", "192.0.2.1", "2001:db8::/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		remote string
+		xff    []string // one header line each
+		realIP string
+		want   string
+	}{
+		{name: "untrusted peer's header ignored", remote: "203.0.113.5:1234", xff: []string{"198.51.100.7"}, want: "203.0.113.5"},
+		{name: "no header", remote: "10.0.0.1:1234", want: "10.0.0.1"},
+		{name: "one hop", remote: "10.0.0.1:1234", xff: []string{"198.51.100.7"}, want: "198.51.100.7"},
+		{name: "spoofed hops left of the client", remote: "10.0.0.1:1234", xff: []string{"1.2.3.4, 198.51.100.7"}, want: "198.51.100.7"},
+		{name: "trusted proxies skipped", remote: "10.0.0.1:1234", xff: []string{"198.51.100.7, 10.1.2.3, 192.0.2.1"}, want: "198.51.100.7"},
+		{name: "several header lines", remote: "10.0.0.1:1234", xff: []string{"1.2.3.4, 198.51.100.7", "10.1.2.3"}, want: "198.51.100.7"},
+		{name: "IPv6 client", remote: "[2001:db8::1]:1234", xff: []string{"2001:0db9::0001"}, want: "2001:db9::1"},
+		{name: "garbage hop stops the walk", remote: "10.0.0.1:1234", xff: []string{"198.51.100.7, unknown"}, want: "10.0.0.1"},
+		{name: "garbage hop falls back to X-Real-IP", remote: "10.0.0.1:1234", xff: []string{"198.51.100.7, unknown"}, realIP: "198.51.100.9", want: "198.51.100.9"},
+		{name: "all hops trusted", remote: "10.0.0.1:1234", xff: []string{"10.1.2.3"}, want: "10.0.0.1"},
+		{name: "X-Real-IP alone", remote: "10.0.0.1:1234", realIP: "198.51.100.9", want: "198.51.100.9"},
+		{name: "invalid X-Real-IP", remote: "10.0.0.1:1234", realIP: "client", want: "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if got := clientIP(r, trusted); got != tt.want {
+				t.Errorf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRealIPMiddleware(t *testing.T) {
+	trusted, _ := parseCIDRs([]string{"10.0.0.0/8"})
+	var got *http.Request
+	h := realIPMiddleware(trusted, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { got = r }))
+	tests := []struct {
+		name       string
+		remote     string
+		wantAddr   string
+		wantScheme string
+		wantHost   string
+	}{
+		{"trusted peer", "10.0.0.1:1234", "198.51.100.7:0", "https", "proxy.example.com"},
+		{"untrusted peer", "203.0.113.5:1234", "203.0.113.5:0", "", "example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			r.Header.Set("X-Forwarded-For", "198.51.100.7")
+			r.Header.Set("X-Forwarded-Proto", "https")
+			r.Header.Set("X-Forwarded-Host", "proxy.example.com")
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			if got.RemoteAddr != tt.wantAddr || got.URL.Scheme != tt.wantScheme || got.Host != tt.wantHost {
+				t.Errorf("RemoteAddr, scheme, host = %q, %q, %q; want %q, %q, %q",
+					got.RemoteAddr, got.URL.Scheme, got.Host, tt.wantAddr, tt.wantScheme, tt.wantHost)
+			}
+		})
+	}
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) })
+	h, err := corsMiddleware(&CORSConfig{AllowedOrigins: []string{"https://dash.example.com"}, AllowedHeaders: []string{"X-Trace"}, MaxAge: "1m"}, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name          string
+		method        string
+		origin        string
+		preflight     bool
+		status        int
+		allowOrigin   string
+		allowHeaders  string // a header Access-Control-Allow-Headers must name
+		exposeHeaders bool
+	}{
+		{name: "allowed origin", method: http.MethodGet, origin: "https://dash.example.com", status: http.StatusTeapot,
+			allowOrigin: "https://dash.example.com", exposeHeaders: true},
+		{name: "other origin", method: http.MethodGet, origin: "https://evil.example", status: http.StatusTeapot},
+		{name: "no origin", method: http.MethodGet, status: http.StatusTeapot},
+		{name: "preflight", method: http.MethodOptions, origin: "https://dash.example.com", preflight: true, status: http.StatusNoContent,
+			allowOrigin: "https://dash.example.com", allowHeaders: "X-Trace"},
+		{name: "preflight from other origin", method: http.MethodOptions, origin: "https://evil.example", preflight: true, status: http.StatusTeapot},
+		{name: "OPTIONS without a requested method", method: http.MethodOptions, origin: "https://dash.example.com", status: http.StatusTeapot,
+			allowOrigin: "https://dash.example.com", exposeHeaders: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/repos/o/r/check-runs/1", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight {
+				r.Header.Set("Access-Control-Request-Method", http.MethodGet)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.allowOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.allowOrigin)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Headers"); !strings.Contains(got, tt.allowHeaders) || tt.allowHeaders != "" && !strings.Contains(got, "Authorization") {
+				t.Errorf("Access-Control-Allow-Headers = %q, want it to name %q and Authorization", got, tt.allowHeaders)
+			}
+			if tt.preflight && tt.allowOrigin != "" && rec.Header().Get("Access-Control-Max-Age") != "60" {
+				t.Errorf("Access-Control-Max-Age = %q, want 60", rec.Header().Get("Access-Control-Max-Age"))
+			}
+			if got := rec.Header().Get("Access-Control-Expose-Headers") != ""; got != tt.exposeHeaders {
+				t.Errorf("Access-Control-Expose-Headers set = %v, want %v", got, tt.exposeHeaders)
+			}
+		})
+	}
+
+	if _, err := corsMiddleware(&CORSConfig{AllowedOrigins: []string{"*"}, MaxAge: "soon"}, next); err == nil {
+		t.Error("invalid max_age accepted")
+	}
+	if !originAllowed(&CORSConfig{AllowedOrigins: []string{"*"}}, "https://any.example") {
+		t.Error("wildcard origin not allowed")
+	}
+}
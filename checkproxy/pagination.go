@@ -0,0 +1,203 @@
+package checkproxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+)
+
+// allPagesParam asks the proxy to follow a check-run list's pagination
+// itself and answer with every page in one response. It is accepted by
+// checkRunListRoutes and never sent upstream.
+const allPagesParam = "all_pages"
+
+// maxCollapsedPages bounds the pages followed for one all_pages request.
+// GitHub lists at most 1000 check runs per commit, 10 pages of 100.
+const maxCollapsedPages = 10
+
+// checkRunPage is one page of a check-run list, with the runs left as
+// GitHub sent them.
+type checkRunPage struct {
+	TotalCount int               `json:"total_count"`
+	CheckRuns  []json.RawMessage `json:"check_runs"`
+}
+
+// checkRunListRoutes are the paginated check-run lists that can be
+// collapsed with all_pages and whose later pages are prefetched.
+var checkRunListRoutes = []*regexp.Regexp{
+	regexp.MustCompile(`^/repos/[^/]+/[^/]+/commits/[^/]+/check-runs$`),
+	regexp.MustCompile(`^/repos/[^/]+/[^/]+/check-suites/[^/]+/check-runs$`),
+}
+
+// nextPageURL resolves the next link of the list at list. Only later pages
+// of the same list are followed, since the request carries the server's
+// token. The query is encoded as filterQuery does, so a prefetched page is
+// cached under the URL the client's request for it will have.
+func nextPageURL(list *url.URL, link string) (*url.URL, bool) {
+	u, err := list.Parse(link)
+	if err != nil || u.Host != list.Host || u.Path != list.Path {
+		return nil, false
+	}
+	u.RawQuery = u.Query().Encode()
+	return u, true
+}
+
+// prefetchPages fetches the pages after resp, the first page of a check-run
+// list that req asked for, in the background and discards them, leaving
+// them in the response cache for when the client follows the Link header.
+// At most one prefetch runs per list.
+func (s *server) prefetchPages(client *http.Client, req *http.Request, resp *http.Response) {
+	c := s.responses
+	if c == nil || !c.prefetch || req.Method != http.MethodGet || resp.StatusCode != http.StatusOK ||
+		!matchesAny(checkRunListRoutes, apiPath(req.URL.EscapedPath())) {
+		return
+	}
+	if page := req.URL.Query().Get("page"); page != "" && page != "1" {
+		return
+	}
+	link := parseNextLink(resp.Header.Get("Link"))
+	if link == "" {
+		return
+	}
+	key := responseCacheKey(req)
+	if _, busy := c.prefetching.LoadOrStore(key, struct{}{}); busy {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(req.Context()), s.timeouts.forPath(apiPath(req.URL.EscapedPath())))
+	go func() {
+		defer c.prefetching.Delete(key)
+		defer cancel()
+		for page := 2; link != "" && page <= maxCollapsedPages; page++ {
+			next, ok := nextPageURL(req.URL, link)
+			if !ok {
+				return
+			}
+			pageReq := req.Clone(ctx)
+			pageReq.URL = next
+			pageReq.Header.Del("If-None-Match")
+			pageReq.Header.Del("If-Modified-Since")
+			resp, err := client.Do(pageReq)
+			if err != nil {
+				slog.Debug("page prefetch failed", "path", req.URL.Path, "page", page, "error", err)
+				return
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return
+			}
+			c.prefetched.Add(1)
+			link = parseNextLink(resp.Header.Get("Link"))
+		}
+	}()
+}
+
+// takeAllPages removes all_pages from query and reports whether it was set.
+// When it was, the query asks for the largest pages, starting at the first.
+func takeAllPages(query string) (string, bool, error) {
+	values, err := url.ParseQuery(query)
+	if err != nil || !values.Has(allPagesParam) {
+		return query, false, nil
+	}
+	all, err := strconv.ParseBool(values.Get(allPagesParam))
+	if err != nil {
+		return "", false, fmt.Errorf("%s must be true or false", allPagesParam)
+	}
+	values.Del(allPagesParam)
+	if all {
+		values.Set("per_page", "100")
+		values.Del("page")
+	}
+	return values.Encode(), all, nil
+}
+
+// fetchAllPages follows the Link pagination of the check-run list req asks
+// for and returns one response holding every page's check runs, with the
+// first page's total_count. Its headers are the last page's, without Link,
+// and its ETag covers the whole list; a client that sent it gets a 304. A
+// page that isn't a 200 is returned as is. Each page goes through the
+// response cache, so unchanged pages cost a 304 or nothing.
+func fetchAllPages(client *http.Client, req *http.Request) (*http.Response, error) {
+	var (
+		list checkRunPage
+		last *http.Response
+	)
+	next := req.URL
+	for page := 1; next != nil; page++ {
+		if page > maxCollapsedPages {
+			return nil, fmt.Errorf("check runs span more than %d pages", maxCollapsedPages)
+		}
+		pageReq := req.Clone(req.Context())
+		pageReq.Method, pageReq.URL = http.MethodGet, next
+		// Pages are decoded here, so they must arrive whole and uncompressed.
+		for _, h := range requestHeadersToForward {
+			pageReq.Header.Del(h)
+		}
+		resp, err := client.Do(pageReq)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return resp, nil
+		}
+		var p checkRunPage
+		err = json.NewDecoder(resp.Body).Decode(&p)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("page %d: %w", page, err)
+		}
+		if page == 1 {
+			list.TotalCount = p.TotalCount
+		}
+		list.CheckRuns = append(list.CheckRuns, p.CheckRuns...)
+		last, next = resp, nil
+
+		if link := parseNextLink(resp.Header.Get("Link")); link != "" {
+			var ok bool
+			if next, ok = nextPageURL(req.URL, link); !ok {
+				return nil, fmt.Errorf("page %d links to an unexpected next page", page)
+			}
+		}
+	}
+
+	if list.CheckRuns == nil {
+		list.CheckRuns = []json.RawMessage{}
+	}
+	body, err := json.Marshal(list)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:])[:16] + `"`
+	header := last.Header.Clone()
+	for _, h := range []string{"Link", "Last-Modified", "Content-Encoding"} {
+		header.Del(h)
+	}
+	header.Set("ETag", etag)
+	resp := &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+		Request:    req,
+	}
+	if clientHasETag(req.Header.Get("If-None-Match"), etag) {
+		header.Del("Content-Length")
+		resp.Status, resp.StatusCode, resp.Body = "304 Not Modified", http.StatusNotModified, http.NoBody
+		return resp, nil
+	}
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	resp.Body, resp.ContentLength = io.NopCloser(bytes.NewReader(body)), int64(len(body))
+	return resp, nil
+}
@@ -0,0 +1,145 @@
+package checkproxy
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// rejectedTokenBench is how long a token GitHub answered 401 for sits out.
+const rejectedTokenBench = 10 * time.Minute
+
+// tokenPool spreads default-upstream requests over several classic tokens
+// when classic_token_pool is configured. It hands out tokens round-robin and benches tokens that are
+// rate-limited or rejected until they can be used again.
+type tokenPool struct {
+	tokens []string
+	next   atomic.Uint64
+
+	mu      sync.Mutex
+	benched map[int]time.Time // token index → unusable until
+}
+
+func newTokenPool(tokens []string) *tokenPool {
+	return &tokenPool{tokens: tokens, benched: make(map[int]time.Time)}
+}
+
+// pick returns the next usable token. When every token is benched it returns
+// the one that recovers first, so requests fail upstream rather than here.
+func (p *tokenPool) pick() string {
+	now := time.Now()
+	start := int(p.next.Add(1) - 1)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	soonest := -1
+	for n := range p.tokens {
+		i := (start + n) % len(p.tokens)
+		until, benched := p.benched[i]
+		if !benched || now.After(until) {
+			delete(p.benched, i)
+			return p.tokens[i]
+		}
+		if soonest < 0 || until.Before(p.benched[soonest]) {
+			soonest = i
+		}
+	}
+	return p.tokens[soonest]
+}
+
+// index returns the position of token in the pool, or -1.
+func (p *tokenPool) index(token string) int {
+	for i, t := range p.tokens {
+		if t == token {
+			return i
+		}
+	}
+	return -1
+}
+
+// bench takes token i out of rotation until the given time.
+func (p *tokenPool) bench(i int, until time.Time, reason string) {
+	p.mu.Lock()
+	p.benched[i] = until
+	p.mu.Unlock()
+	slog.Warn("pooled classic token "+reason+"; benched", "token", fmt.Sprintf("%d of %d", i+1, len(p.tokens)), "until", until.Local().Format("15:04:05"))
+}
+
+// tokenRejection reports whether resp means the token that made the request
+// can't be used for a while, and until when.
+func tokenRejection(resp *http.Response) (until time.Time, reason string, rejected bool) {
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return time.Now().Add(rejectedTokenBench), "was rejected (401)", true
+	case (resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests) &&
+		resp.Header.Get("X-RateLimit-Remaining") == "0":
+		until = time.Now().Add(time.Minute)
+		if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			until = time.Unix(reset, 0)
+		}
+		return until, "hit its rate limit", true
+	}
+	return time.Time{}, "", false
+}
+
+// failoverTransport retries upstream requests made with a pooled token on
+// the next token when GitHub rate-limits or rejects the first. Requests with
+// any other token pass through untouched. Since it wraps every client that
+// uses the server's own tokens, it also records the rate limits GitHub
+// reports for them and the upstream traffic of each repository.
+type failoverTransport struct {
+	next    http.RoundTripper
+	pool    *atomic.Pointer[tokenPool] // replaced on config reload
+	traffic *trafficTracker
+	limits  *rateLimitTracker
+}
+
+func (t failoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err == nil {
+		t.limits.record(req, resp)
+		t.traffic.upstream(req, resp)
+	}
+	pool := t.pool.Load()
+	if err != nil || pool == nil {
+		return resp, err
+	}
+	for range len(pool.tokens) - 1 {
+		i := pool.index(strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "))
+		if i < 0 {
+			return resp, nil
+		}
+		until, reason, rejected := tokenRejection(resp)
+		if !rejected {
+			return resp, nil
+		}
+		pool.bench(i, until, reason)
+		token := pool.pick()
+		if pool.index(token) == i {
+			return resp, nil // nothing better to try
+		}
+
+		retry := req.Clone(req.Context())
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return resp, nil
+			}
+			if retry.Body, err = req.GetBody(); err != nil {
+				return resp, nil
+			}
+		}
+		retry.Header.Set("Authorization", "Bearer "+token)
+		resp.Body.Close()
+		if resp, err = t.next.RoundTrip(retry); err != nil {
+			return nil, err
+		}
+		t.limits.record(retry, resp)
+		t.traffic.upstream(retry, resp)
+		req = retry
+	}
+	return resp, nil
+}
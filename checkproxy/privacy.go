@@ -0,0 +1,151 @@
+package checkproxy
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Log privacy modes (Config.LogPrivacy).
+const (
+	logPrivacyHash = "hash" // replace names and addresses with keyed hashes
+	logPrivacyOmit = "omit" // leave them out
+)
+
+// logRedactor keeps repository names, commit SHAs, branch names, and client
+// addresses out of the audit log and the server log when log_privacy is set;
+// a nil one logs them as they are. Counters (/v1/metrics, status --live and
+// --traffic, the dashboard) aren't logs and are unaffected.
+type logRedactor struct {
+	mode string
+	key  []byte // HMAC key for hash mode
+}
+
+// LogPrivacyKeyPath returns where the key for log_privacy "hash" is kept.
+func LogPrivacyKeyPath() string {
+	return filepath.Join(filepath.Dir(ConfigPath()), "log-privacy.key")
+}
+
+// newLogRedactor returns the redactor for mode, or nil for "". In hash mode
+// the key is read from keyPath, or created there on first use so the same
+// repository hashes the same way across restarts.
+func newLogRedactor(mode, keyPath string) (*logRedactor, error) {
+	switch mode = strings.ToLower(mode); mode {
+	case "":
+		return nil, nil
+	case logPrivacyOmit:
+		return &logRedactor{mode: mode}, nil
+	case logPrivacyHash:
+	default:
+		return nil, fmt.Errorf("unknown mode %q (want hash or omit)", mode)
+	}
+	key, err := os.ReadFile(keyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		key = make([]byte, 32)
+		rand.Read(key)
+		if err := os.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
+			return nil, err
+		}
+		err = os.WriteFile(keyPath, key, 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(key) < 16 {
+		return nil, fmt.Errorf("%s: key too short", keyPath)
+	}
+	return &logRedactor{mode: mode, key: key}, nil
+}
+
+// value returns what the log may show for s: a 16-hex-digit keyed hash, or
+// nothing.
+func (l *logRedactor) value(s string) string {
+	if l == nil || s == "" {
+		return s
+	}
+	if l.mode == logPrivacyOmit {
+		return ""
+	}
+	mac := hmac.New(sha256.New, l.key)
+	mac.Write([]byte(strings.ToLower(s)))
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// path returns p with the repository and everything after it reduced to
+// the route it matched, e.g. /repos/*/*/commits/*/check-runs, so commit
+// SHAs, branch names, and IDs don't reach the log either.
+func (l *logRedactor) path(p string) string {
+	if l == nil {
+		return p
+	}
+	i := strings.Index(p, "/repos/")
+	if i < 0 {
+		return p
+	}
+	prefix, rest := p[:i], p[i:]
+	for _, re := range redactionRoutes() {
+		if re.MatchString(rest) {
+			return prefix + routeTemplate.Replace(re.String())
+		}
+	}
+	if strings.Count(rest, "/") > 3 {
+		return prefix + "/repos/*/*/…"
+	}
+	return prefix + "/repos/*/*"
+}
+
+// url returns u as it may be logged: redacted of credentials and with its
+// path reduced as by path. The query string is dropped, since parameters
+// like sha and ref name commits and branches.
+func (l *logRedactor) url(u *url.URL) string {
+	if l == nil {
+		return u.Redacted()
+	}
+	return u.Scheme + "://" + u.Host + l.path(u.Path)
+}
+
+// error returns err as it may be logged. Errors from http.Client name the
+// URL, so only the underlying cause is kept.
+func (l *logRedactor) error(err error) error {
+	var urlErr *url.Error
+	if l != nil && errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
+
+// record returns rec as the audit log may show it.
+func (l *logRedactor) record(rec auditRecord) auditRecord {
+	if l == nil {
+		return rec
+	}
+	rec.Source = l.value(rec.Source)
+	rec.Owner = l.value(rec.Owner)
+	rec.Repo = l.value(rec.Repo)
+	rec.Route = l.path(rec.Route)
+	return rec
+}
+
+// routeTemplate turns a route pattern into the placeholder form logged in
+// place of a path.
+var routeTemplate = strings.NewReplacer("^", "", "$", "", "[^/]+", "*", "[0-9]+", "*", ".+", "*")
+
+// redactionRoutes returns the patterns of every route the proxy serves.
+func redactionRoutes() []*regexp.Regexp {
+	var out []*regexp.Regexp
+	for _, rt := range allowedRoutes {
+		out = append(out, rt.pattern)
+	}
+	for _, group := range writeRoutes {
+		out = append(out, group...)
+	}
+	return out
+}
@@ -0,0 +1,109 @@
+package checkproxy
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitConfig limits requests per client credential.
+type RateLimitConfig struct {
+	RequestsPerMinute float64 `json:"requests_per_minute"`
+	Burst             int     `json:"burst,omitempty"` // default: one minute's worth
+}
+
+// tokenBucket is one client's allowance.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a token-bucket limiter keyed by a credential fingerprint.
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // tokens per second
+	burst   float64
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+func newRateLimiter(cfg RateLimitConfig) *rateLimiter {
+	burst := float64(cfg.Burst)
+	if burst <= 0 {
+		burst = math.Max(1, cfg.RequestsPerMinute)
+	}
+	return &rateLimiter{
+		rate:    cfg.RequestsPerMinute / 60,
+		burst:   burst,
+		buckets: make(map[string]*tokenBucket),
+		swept:   time.Now(),
+	}
+}
+
+// allow takes one token for key. When the bucket is empty it returns the wait
+// until the next token is available.
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if now.Sub(l.swept) > time.Minute {
+		l.sweep(now)
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// sweep drops buckets that have refilled completely; they are
+// indistinguishable from new ones. It is called with l.mu held, at most once
+// a minute, as requests come in.
+func (l *rateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.swept = now
+}
+
+// authorizedClient identifies the client of r for per-client limits, once
+// authorizeRepo has let it through: by the HMAC key id or bearer token
+// (hashed) it was verified with, or with validation off, where it came from.
+// Keying on a credential before it is verified would let anyone who knows a
+// key id drain that client's allowance, and a client take a fresh one by
+// changing its token.
+func authorizedClient(r *http.Request, cfg *Config) string {
+	if id := r.Header.Get(hmacKeyIDHeader); id != "" && len(cfg.HMACClients) > 0 {
+		return "hmac:" + id
+	}
+	if token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); token != "" && cfg.validationMode() != validationOff {
+		return tokenFingerprint(token)
+	}
+	return "ip:" + requestSource(r)
+}
+
+// admit takes one token from key's bucket, answering 429 when it is empty.
+// A nil limiter admits everything.
+func (l *rateLimiter) admit(w http.ResponseWriter, key string) bool {
+	if l == nil {
+		return true
+	}
+	if ok, wait := l.allow(key); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, "too many requests: client rate limit exceeded", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
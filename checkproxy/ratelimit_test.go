@@ -0,0 +1,135 @@
+package checkproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	l := &rateLimiter{rate: 1, burst: 2, buckets: make(map[string]*tokenBucket)}
+	for i, want := range []bool{true, true, false} {
+		if ok, _ := l.allow("a"); ok != want {
+			t.Fatalf("request %d: allowed = %v, want %v", i+1, ok, want)
+		}
+	}
+	if ok, _ := l.allow("b"); !ok {
+		t.Error("another client shares the first one's bucket")
+	}
+	l.buckets["a"].last = time.Now().Add(-1500 * time.Millisecond)
+	if ok, _ := l.allow("a"); !ok {
+		t.Error("bucket did not refill")
+	}
+	if ok, wait := l.allow("a"); ok || wait <= 0 || wait > time.Second {
+		t.Errorf("allowed = %v, wait = %s; want a refusal with a wait under a second", ok, wait)
+	}
+}
+
+// TestRateLimitKeys checks that requests are limited by the identity they
+// were authorized with, so failed requests can't use up another client's
+// allowance or get a fresh one.
+func TestRateLimitKeys(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-OAuth-Scopes", "repo")
+		if r.URL.Path == "/api/v3/repos/o/r" && r.Header.Get("Authorization") != "Bearer github_pat_good" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"login":"bot"}`))
+	}))
+	defer upstream.Close()
+	newHandler := func(mode string) http.Handler {
+		t.Helper()
+		h, err := NewHandler(&Config{ClassicToken: "ghp_test", APIBaseURL: upstream.URL, ValidationMode: mode,
+			RateLimit:   &RateLimitConfig{RequestsPerMinute: 1, Burst: 1},
+			HMACClients: []HMACClient{{ID: "ci", Secret: "s3cret", Orgs: []string{"o"}}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { h.Close() })
+		return h
+	}
+	type request struct {
+		token  string // bearer token
+		keyID  string // signed with secret instead
+		secret string
+		addr   string
+		status int
+	}
+	const (
+		target = "/repos/o/r/check-runs/1"
+		// Failures from one address are backed off, so the attacker's
+		// requests come from elsewhere than the client's.
+		attacker = "198.51.100.9:1000"
+	)
+	tests := []struct {
+		name     string
+		mode     string
+		requests []request
+	}{
+		{"forged key ids", "", []request{
+			{keyID: "ci", secret: "guess", addr: attacker, status: http.StatusUnauthorized},
+			{keyID: "ci", secret: "guess", addr: attacker, status: http.StatusUnauthorized},
+			{keyID: "ci", secret: "s3cret", status: http.StatusOK},
+			{keyID: "ci", secret: "s3cret", status: http.StatusTooManyRequests},
+		}},
+		{"made-up tokens", "", []request{
+			{token: "github_pat_bad1", addr: attacker, status: http.StatusForbidden},
+			{token: "github_pat_good", status: http.StatusOK},
+			{token: "github_pat_bad2", addr: attacker, status: http.StatusForbidden},
+			{token: "github_pat_good", status: http.StatusTooManyRequests},
+		}},
+		{"validation off", validationOff, []request{
+			{token: "github_pat_one", addr: "192.0.2.1:1000", status: http.StatusOK},
+			{token: "github_pat_two", addr: "192.0.2.1:1001", status: http.StatusTooManyRequests},
+			{token: "github_pat_one", addr: "192.0.2.2:1000", status: http.StatusOK},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHandler(tt.mode)
+			for i, req := range tt.requests {
+				r := httptest.NewRequest(http.MethodGet, target, nil)
+				if req.addr != "" {
+					r.RemoteAddr = req.addr
+				}
+				if req.keyID != "" {
+					ts := strconv.FormatInt(time.Now().Unix(), 10)
+					r.Header.Set(hmacKeyIDHeader, req.keyID)
+					r.Header.Set(hmacTimestampHeader, ts)
+					r.Header.Set(hmacSignatureHeader, hmacSignature(req.secret, r.Method, r.RequestURI, ts))
+				} else {
+					r.Header.Set("Authorization", "Bearer "+req.token)
+				}
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, r)
+				if rec.Code != req.status {
+					t.Fatalf("request %d: status = %d, want %d (%s)", i+1, rec.Code, req.status, rec.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestConcurrencyLimiterPerClient(t *testing.T) {
+	l := newConcurrencyLimiter(0, 1)
+	release, ok := l.acquireClient(httptest.NewRecorder(), "a")
+	if !ok {
+		t.Fatal("first request refused")
+	}
+	rec := httptest.NewRecorder()
+	if _, ok := l.acquireClient(rec, "a"); ok || rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request: ok = %v, status %d; want a 429", ok, rec.Code)
+	}
+	if _, ok := l.acquireClient(httptest.NewRecorder(), "b"); !ok {
+		t.Fatal("another client was refused")
+	}
+	release()
+	if _, ok := l.acquireClient(httptest.NewRecorder(), "a"); !ok {
+		t.Fatal("slot not given back")
+	}
+}
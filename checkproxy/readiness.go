@@ -0,0 +1,98 @@
+package checkproxy
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// preflightRetryInterval is how often the preflight is retried while GitHub
+// can't be reached.
+const preflightRetryInterval = 15 * time.Second
+
+// readinessState gates /readyz on the startup preflight, so orchestration
+// only routes clients to an instance whose token GitHub has accepted.
+type readinessState struct {
+	mu     sync.Mutex
+	result preflightResult
+	err    error // nil once the preflight passed
+}
+
+func newReadinessState() *readinessState {
+	return &readinessState{err: errors.New("preflight not run yet")}
+}
+
+// preflightResult is what the preflight learned about the upstream.
+type preflightResult struct {
+	Login     string
+	Latency   time.Duration
+	RateLimit rateLimitStatus
+}
+
+// preflight makes the call a proxied request would, with the server's first
+// classic token, and reports who it belongs to, how fast GitHub answered,
+// and how much of its rate limit is left.
+func preflight(t upstreamTarget, limits *rateLimitTracker) (classicTokenInfo, preflightResult, error) {
+	start := time.Now()
+	info, err := checkClassicToken(t.apiBase, t.token, limits)
+	res := preflightResult{Login: info.Login, Latency: time.Since(start)}
+	res.RateLimit, _ = limits.get(rateLimitKey{token: tokenFingerprint(t.token), resource: "core"})
+	return info, res, err
+}
+
+func (s *readinessState) set(res preflightResult, err error) {
+	s.mu.Lock()
+	s.result, s.err = res, err
+	s.mu.Unlock()
+}
+
+func (s *readinessState) get() (preflightResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.result, s.err
+}
+
+// retry repeats the preflight for t until it passes or done is closed.
+func (s *readinessState) retry(done <-chan struct{}, t upstreamTarget, tokens *classicTokenMonitor, interval time.Duration) {
+	for range ticks(done, interval) {
+		info, res, err := preflight(t, tokens.limits)
+		if err != nil {
+			var urlErr *url.Error
+			if !errors.As(err, &urlErr) {
+				slog.Error("preflight: "+tokenLabel(t.name)+" rejected; staying not ready", "error", err)
+			}
+			s.set(res, err)
+			continue
+		}
+		tokens.record(t.name, info)
+		s.set(res, nil)
+		slog.Info("preflight passed; ready", "login", res.Login, "latency", res.Latency.Round(time.Millisecond).String())
+		return
+	}
+}
+
+// handler serves /readyz: 200 once the preflight has passed, else 503 with
+// the reason.
+func (s *readinessState) handler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	res, err := s.get()
+	if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "not ready", "error": err.Error()})
+		return
+	}
+	body := map[string]any{"status": "ready"}
+	if res.Login != "" {
+		body["login"] = res.Login
+		body["latency_ms"] = res.Latency.Milliseconds()
+	}
+	if res.RateLimit.Limit > 0 {
+		body["rate_limit_remaining"] = res.RateLimit.Remaining
+	}
+	writeJSON(w, http.StatusOK, body)
+}
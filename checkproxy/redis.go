@@ -0,0 +1,297 @@
+package checkproxy
+
+import (
+	"bufio"
+	"cmp"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// RedisConfig points the validation and response caches at a Redis server
+// shared by several proxy replicas, so a result one of them fetched serves
+// all of them.
+type RedisConfig struct {
+	// URL is redis://[[user]:password@]host[:port][/db], or rediss:// for TLS.
+	URL string `json:"url"`
+	// PasswordEnv names an environment variable holding the password, to
+	// keep it out of the config file.
+	PasswordEnv string `json:"password_env,omitempty"`
+	// KeyPrefix is prepended to every key (default "gh-checkproxy:").
+	KeyPrefix string `json:"key_prefix,omitempty"`
+}
+
+const (
+	defaultRedisKeyPrefix = "gh-checkproxy:"
+	redisTimeout          = time.Second
+	redisIdleConns        = 16
+	// sharedResponseTTL is how long Redis keeps a response nobody has
+	// revalidated.
+	sharedResponseTTL = 24 * time.Hour
+)
+
+// redisClient speaks just enough RESP to get, set, and delete keys. Every
+// call is bounded by redisTimeout and failures are only counted: a Redis
+// outage makes the caches local, it never fails a request.
+type redisClient struct {
+	addr     string
+	tls      *tls.Config
+	user     string
+	password string
+	db       int
+	prefix   string
+	idle     chan *redisConn
+
+	hits, misses, errors atomic.Uint64
+}
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// redisError is an error reply from the server.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+func newRedisClient(cfg *RedisConfig) (*redisClient, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Host == "" {
+		return nil, fmt.Errorf("url %q is not a redis:// or rediss:// URL", cfg.URL)
+	}
+	c := &redisClient{
+		addr:   u.Host,
+		prefix: cmp.Or(cfg.KeyPrefix, defaultRedisKeyPrefix),
+		idle:   make(chan *redisConn, redisIdleConns),
+	}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.Scheme == "rediss" {
+		c.tls = &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+	}
+	if u.User != nil {
+		c.user = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+	if cfg.PasswordEnv != "" {
+		if c.password = os.Getenv(cfg.PasswordEnv); c.password == "" {
+			return nil, fmt.Errorf("password_env: %s is not set", cfg.PasswordEnv)
+		}
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil || c.db < 0 {
+			return nil, fmt.Errorf("url: database %q is not a number", db)
+		}
+	}
+	return c, nil
+}
+
+// String describes the server without its password.
+func (c *redisClient) String() string {
+	scheme := "redis"
+	if c.tls != nil {
+		scheme = "rediss"
+	}
+	return fmt.Sprintf("%s://%s/%d", scheme, c.addr, c.db)
+}
+
+func (c *redisClient) dial() (*redisConn, error) {
+	d := &net.Dialer{Timeout: redisTimeout}
+	var conn net.Conn
+	var err error
+	if c.tls != nil {
+		conn, err = tls.DialWithDialer(d, "tcp", c.addr, c.tls)
+	} else {
+		conn, err = d.Dial("tcp", c.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rc := &redisConn{Conn: conn, r: bufio.NewReader(conn)}
+	if c.password != "" {
+		args := []string{"AUTH", c.password}
+		if c.user != "" {
+			args = []string{"AUTH", c.user, c.password}
+		}
+		if _, err := rc.do(args...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.db != 0 {
+		if _, err := rc.do("SELECT", strconv.Itoa(c.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+// do sends one command on an idle connection, or a new one, and returns the
+// reply: a string, an int64, a []any, or nil.
+func (c *redisClient) do(args ...string) (any, error) {
+	var conn *redisConn
+	select {
+	case conn = <-c.idle:
+	default:
+		var err error
+		if conn, err = c.dial(); err != nil {
+			c.errors.Add(1)
+			return nil, err
+		}
+	}
+	reply, err := conn.do(args...)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		// The connection is in an unknown state.
+		conn.Close()
+		c.errors.Add(1)
+		return nil, err
+	}
+	select {
+	case c.idle <- conn:
+	default:
+		conn.Close()
+	}
+	if err != nil {
+		c.errors.Add(1)
+	}
+	return reply, err
+}
+
+func (rc *redisConn) do(args ...string) (any, error) {
+	rc.SetDeadline(time.Now().Add(redisTimeout))
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := io.WriteString(rc, b.String()); err != nil {
+		return nil, err
+	}
+	return rc.reply()
+}
+
+// reply reads one RESP2 reply.
+func (rc *redisConn) reply() (any, error) {
+	line, err := rc.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(rc.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = rc.reply(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+// ping checks that the server is reachable and accepts the credentials.
+func (c *redisClient) ping() error {
+	_, err := c.do("PING")
+	return err
+}
+
+// get returns the value stored under key, counting the lookup.
+func (c *redisClient) get(key string) ([]byte, bool) {
+	reply, err := c.do("GET", c.prefix+key)
+	s, ok := reply.(string)
+	if err != nil || !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	return []byte(s), true
+}
+
+// set stores value under key for ttl.
+func (c *redisClient) set(key string, value []byte, ttl time.Duration) {
+	if ttl < time.Millisecond {
+		return
+	}
+	c.do("SET", c.prefix+key, string(value), "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+}
+
+// expire keeps key for ttl from now.
+func (c *redisClient) expire(key string, ttl time.Duration) {
+	c.do("PEXPIRE", c.prefix+key, strconv.FormatInt(ttl.Milliseconds(), 10))
+}
+
+func (c *redisClient) del(key string) {
+	c.do("DEL", c.prefix+key)
+}
+
+// deleteMatching deletes the keys matching the glob pattern and returns how
+// many there were.
+func (c *redisClient) deleteMatching(pattern string) (int, error) {
+	n := 0
+	cursor := "0"
+	for {
+		reply, err := c.do("SCAN", cursor, "MATCH", c.prefix+pattern, "COUNT", "500")
+		if err != nil {
+			return n, err
+		}
+		page, ok := reply.([]any)
+		if !ok || len(page) != 2 {
+			return n, errors.New("redis: unexpected SCAN reply")
+		}
+		keys, _ := page[1].([]any)
+		if len(keys) > 0 {
+			args := []string{"DEL"}
+			for _, k := range keys {
+				args = append(args, k.(string))
+			}
+			deleted, err := c.do(args...)
+			if err != nil {
+				return n, err
+			}
+			d, _ := deleted.(int64)
+			n += int(d)
+		}
+		if cursor, _ = page[0].(string); cursor == "0" || cursor == "" {
+			return n, nil
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package checkproxy
+
+import (
+	"errors"
+	"log/slog"
+	"reflect"
+	"strings"
+)
+
+// reloadConfig rereads the config file on SIGHUP and returns the config to
+// serve new requests with. Org and repository restrictions, cache TTLs,
+// classic tokens, and the admin and webhook secrets take effect; changes to
+// any other setting are reported and wait for a restart. A config that
+// fails its checks, including a classic token GitHub rejects, is refused
+// whole and the running one stays.
+func (s *server) reloadConfig(cur *Config) (*Config, error) {
+	fresh, err := LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	next := *cur
+	next.AllowedOrgs = fresh.AllowedOrgs
+	next.AllowedRepos = fresh.AllowedRepos
+	next.DeniedRepos = fresh.DeniedRepos
+	next.ValidationCacheTTL = fresh.ValidationCacheTTL
+	next.ValidationNegativeTTL = fresh.ValidationNegativeTTL
+	next.ClassicToken = fresh.ClassicToken
+	next.ClassicTokenPool = fresh.ClassicTokenPool
+	next.OrgTokens = fresh.OrgTokens
+	next.Upstreams = fresh.Upstreams
+	next.AdminToken = fresh.AdminToken
+	next.WebhookSecret = fresh.WebhookSecret
+
+	if next.GetClassicToken() == "" && next.GitHubApp == nil {
+		return nil, errors.New("no classic token")
+	}
+	ttl, negativeTTL, err := next.validationTTLs()
+	if err != nil {
+		return nil, err
+	}
+	targets, pool, err := next.tokenTargets()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.tokens.check(targets); err != nil {
+		return nil, err
+	}
+
+	s.validator.SetTTLs(ttl, negativeTTL)
+	s.tokens.setTargets(targets)
+	s.pool.Store(pool)
+	if ignored := changedSettings(&next, fresh); len(ignored) > 0 {
+		slog.Warn("reload: restart to apply the other changed settings", "settings", strings.Join(ignored, ", "))
+	}
+	return &next, nil
+}
+
+// changedSettings returns the JSON names of the settings that differ
+// between a and b.
+func changedSettings(a, b *Config) []string {
+	va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	var names []string
+	for i := range va.NumField() {
+		if !va.Type().Field(i).IsExported() {
+			continue
+		}
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			name, _, _ := strings.Cut(va.Type().Field(i).Tag.Get("json"), ",")
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,792 @@
+package checkproxy
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ResponseCacheConfig tunes the cache of upstream GET responses. It is on by
+// default.
+type ResponseCacheConfig struct {
+	Disabled bool `json:"disabled,omitempty"`
+	// MaxEntries bounds how many responses are kept, and MaxBytes how much
+	// memory they take.
+	MaxEntries int   `json:"max_entries,omitempty"`
+	MaxBytes   int64 `json:"max_bytes,omitempty"`
+	// MaxBodyBytes is the largest response body that is cached.
+	MaxBodyBytes int64 `json:"max_body_bytes,omitempty"`
+	// Routes let responses for matching API paths be served without asking
+	// GitHub for a while; the first match wins. Without any, completed check
+	// runs, check suites, workflow runs, and jobs are kept for a while.
+	Routes []RouteCacheTTL `json:"routes,omitempty"`
+	// DisablePrefetch stops the pages after the first of a check-run list
+	// from being fetched before the client asks for them.
+	DisablePrefetch bool `json:"disable_prefetch,omitempty"`
+	// MaxStale lets a response whose TTL ran out at most this long ago be
+	// served as is while it is revalidated in the background.
+	MaxStale string `json:"max_stale,omitempty"`
+	// Persist also keeps responses on disk, in Dir (default: response-cache
+	// next to the config file), so they survive restarts.
+	Persist bool   `json:"persist,omitempty"`
+	Dir     string `json:"dir,omitempty"`
+	// MaxDiskBytes bounds the files in Dir, and DiskTTL how long a response
+	// stays there after GitHub last confirmed it.
+	MaxDiskBytes int64  `json:"max_disk_bytes,omitempty"`
+	DiskTTL      string `json:"disk_ttl,omitempty"`
+}
+
+// RouteCacheTTL is how long responses for API paths matching Pattern are
+// served from the cache before being revalidated. CompletedTTL, when set,
+// applies instead once everything in the response has completed, and
+// PendingTTL (default 0) while anything in it is queued or in progress.
+type RouteCacheTTL struct {
+	Pattern      string `json:"pattern"`
+	TTL          string `json:"ttl,omitempty"`
+	CompletedTTL string `json:"completed_ttl,omitempty"`
+	PendingTTL   string `json:"pending_ttl,omitempty"`
+}
+
+type routeCacheTTL struct {
+	pattern                       *regexp.Regexp
+	ttl, completedTTL, pendingTTL time.Duration
+}
+
+// defaultCacheRoutes apply when response_cache.routes is empty. A finished
+// run or job doesn't change, so it is kept for an hour; a list can still
+// gain runs when checks are re-run, so it is kept for a minute.
+var defaultCacheRoutes = []RouteCacheTTL{
+	{Pattern: `^/repos/[^/]+/[^/]+/(check-runs|check-suites|actions/runs|actions/jobs)/[0-9]+$`, CompletedTTL: "1h"},
+	{Pattern: `^/repos/[^/]+/[^/]+/(commits/[^/]+/(check-runs|check-suites)|check-suites/[0-9]+/check-runs|actions/runs/[0-9]+/jobs)$`, CompletedTTL: "1m"},
+}
+
+const (
+	defaultResponseCacheEntries = 1000
+	defaultResponseCacheBytes   = 64 << 20
+	defaultResponseCacheBody    = 1 << 20
+)
+
+// newResponseCache returns the cache for GET responses from GitHub, keyed
+// by URL, or nil when cfg turns it off.
+func newResponseCache(cfg *ResponseCacheConfig) (*responseCache, error) {
+	c := &responseCache{
+		maxEntries: defaultResponseCacheEntries,
+		maxBytes:   defaultResponseCacheBytes,
+		maxBody:    defaultResponseCacheBody,
+		prefetch:   true,
+		order:      list.New(),
+		items:      make(map[string]*list.Element),
+	}
+	if cfg == nil {
+		c.routes, _ = compileCacheRoutes(defaultCacheRoutes)
+		return c, nil
+	}
+	if cfg.Disabled {
+		return nil, nil
+	}
+	if cfg.MaxEntries < 0 {
+		return nil, errors.New("max_entries must not be negative")
+	}
+	if cfg.MaxEntries > 0 {
+		c.maxEntries = cfg.MaxEntries
+	}
+	if cfg.MaxBodyBytes < 0 {
+		return nil, errors.New("max_body_bytes must not be negative")
+	}
+	if cfg.MaxBodyBytes > 0 {
+		c.maxBody = cfg.MaxBodyBytes
+	}
+	if cfg.MaxBytes < 0 {
+		return nil, errors.New("max_bytes must not be negative")
+	}
+	if cfg.MaxBytes > 0 {
+		c.maxBytes = cfg.MaxBytes
+	}
+	if c.maxBody > c.maxBytes {
+		return nil, errors.New("max_body_bytes must not exceed max_bytes")
+	}
+	routes := cfg.Routes
+	if len(routes) == 0 {
+		routes = defaultCacheRoutes
+	}
+	var err error
+	if c.routes, err = compileCacheRoutes(routes); err != nil {
+		return nil, err
+	}
+	c.prefetch = !cfg.DisablePrefetch
+	if c.maxStale, err = parseCacheTTL(cfg.MaxStale); err != nil {
+		return nil, fmt.Errorf("max_stale: %w", err)
+	}
+	if cfg.Persist {
+		if cfg.MaxDiskBytes < 0 {
+			return nil, errors.New("max_disk_bytes must not be negative")
+		}
+		ttl, err := parseCacheTTL(cfg.DiskTTL)
+		if err != nil {
+			return nil, fmt.Errorf("disk_ttl: %w", err)
+		}
+		c.disk, err = newResponseStore(cmp.Or(cfg.Dir, ResponseCachePath()),
+			cmp.Or(cfg.MaxDiskBytes, defaultResponseStoreBytes), cmp.Or(ttl, defaultResponseStoreTTL))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func compileCacheRoutes(rts []RouteCacheTTL) ([]routeCacheTTL, error) {
+	var routes []routeCacheTTL
+	for _, rt := range rts {
+		re, err := regexp.Compile(rt.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("route %q: %w", rt.Pattern, err)
+		}
+		r := routeCacheTTL{pattern: re}
+		if r.ttl, err = parseCacheTTL(rt.TTL); err != nil {
+			return nil, fmt.Errorf("route %q: ttl: %w", rt.Pattern, err)
+		}
+		if r.completedTTL, err = parseCacheTTL(rt.CompletedTTL); err != nil {
+			return nil, fmt.Errorf("route %q: completed_ttl: %w", rt.Pattern, err)
+		}
+		if r.pendingTTL, err = parseCacheTTL(rt.PendingTTL); err != nil {
+			return nil, fmt.Errorf("route %q: pending_ttl: %w", rt.Pattern, err)
+		}
+		routes = append(routes, r)
+	}
+	return routes, nil
+}
+
+func parseCacheTTL(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%q is not a duration", s)
+	}
+	return d, nil
+}
+
+// responseCache is an LRU of upstream responses that carry an ETag, bounded
+// in both count and bytes. A response is served as is while its route's TTL lasts; after that,
+// and on routes without one, each use revalidates it with If-None-Match,
+// which GitHub does not charge against the rate limit when it answers 304.
+type responseCache struct {
+	maxEntries  int
+	maxBytes    int64
+	maxBody     int64
+	routes      []routeCacheTTL
+	maxStale    time.Duration
+	disk        *responseStore // nil unless persisted
+	shared      *redisClient   // shared with other replicas; nil keeps responses local
+	refreshing  sync.Map       // keys with a background revalidation in flight
+	prefetch    bool
+	prefetching sync.Map      // first pages whose later pages are being prefetched
+	prefetched  atomic.Uint64 // pages fetched before the client asked
+
+	mu    sync.Mutex
+	order *list.List // front is most recently used
+	items map[string]*list.Element
+	bytes int64 // sum of the entries' sizes
+
+	hits, fresh, stale, misses, evictions, loaded uint64
+}
+
+// cachedResponse is a stored 200 response. Only expires changes once it is
+// cached, under the cache's lock.
+type cachedResponse struct {
+	key     string
+	etag    string
+	header  http.Header
+	body    []byte
+	ttl     time.Duration
+	expires time.Time
+}
+
+// size approximates the memory e takes.
+func (e *cachedResponse) size() int64 {
+	n := len(e.key) + len(e.etag) + len(e.body)
+	for k, vs := range e.header {
+		n += len(k)
+		for _, v := range vs {
+			n += len(v)
+		}
+	}
+	return int64(n)
+}
+
+// responseCacheStats is a snapshot of responseCache counters.
+type responseCacheStats struct {
+	Entries    int    `json:"entries"`
+	Capacity   int    `json:"capacity"`
+	Bytes      int64  `json:"bytes"`
+	MaxBytes   int64  `json:"max_bytes"`
+	Hits       uint64 `json:"hits"`
+	Fresh      uint64 `json:"fresh"`
+	Stale      uint64 `json:"stale"`
+	Misses     uint64 `json:"misses"`
+	Evictions  uint64 `json:"evictions"`
+	Loaded     uint64 `json:"loaded"`
+	Prefetched uint64 `json:"prefetched"`
+}
+
+// responseCacheKey identifies a response by URL and by the request headers
+// GitHub varies it on. The token is left out: every server token that may
+// send the request sees the same data.
+func responseCacheKey(req *http.Request) string {
+	return req.URL.String() + "\n" + req.Header.Get("Accept") + "\n" + req.Header.Get("Accept-Encoding")
+}
+
+// get returns the cached response for key and whether it is still fresh,
+// in which case it is counted as served. Responses not in memory are looked
+// for on disk and then in Redis.
+func (c *responseCache) get(key string) (e *cachedResponse, fresh, ok bool) {
+	c.mu.Lock()
+	el, ok := c.items[key]
+	if ok {
+		c.order.MoveToFront(el)
+		e = el.Value.(*cachedResponse)
+	}
+	c.mu.Unlock()
+	if !ok {
+		if e, ok = c.restore(key); ok {
+			c.add(e)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !ok {
+		c.misses++
+		return nil, false, false
+	}
+	if el == nil {
+		c.loaded++
+	}
+	if time.Now().Before(e.expires) {
+		c.fresh++
+		return e, true, true
+	}
+	return e, false, true
+}
+
+// restore returns the response for key from disk or Redis. It has expired:
+// it may have been invalidated while it was out of memory, or by another
+// replica, so it is revalidated before it is served.
+func (c *responseCache) restore(key string) (*cachedResponse, bool) {
+	if c.disk != nil {
+		if e, ok := c.disk.load(key); ok {
+			return e, true
+		}
+	}
+	if c.shared != nil {
+		if data, ok := c.shared.get("r:" + responseKeyHash(key)); ok {
+			return decodeResponse(key, bufio.NewReader(bytes.NewReader(data)))
+		}
+	}
+	return nil, false
+}
+
+// servesStale reports whether e, which has expired, may still be served
+// while it is revalidated, and counts it if so. Responses revalidated on
+// every use never are.
+func (c *responseCache) servesStale(e *cachedResponse) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e.ttl == 0 || !time.Now().Before(e.expires.Add(c.maxStale)) {
+		return false
+	}
+	c.stale++
+	return true
+}
+
+// revalidated counts a cached response served after GitHub answered 304
+// and starts its TTL over.
+func (c *responseCache) revalidated(e *cachedResponse) {
+	c.mu.Lock()
+	c.hits++
+	c.mu.Unlock()
+	c.confirmed(e)
+}
+
+// confirmed starts e's TTL over now that GitHub answered 304 for it.
+func (c *responseCache) confirmed(e *cachedResponse) {
+	c.mu.Lock()
+	e.expires = time.Now().Add(e.ttl)
+	c.mu.Unlock()
+	if c.disk != nil {
+		c.disk.touch(e.key)
+	}
+	if c.shared != nil {
+		c.shared.expire("r:"+responseKeyHash(e.key), sharedResponseTTL)
+	}
+}
+
+// apiPath returns the escaped path of an upstream URL as routes match it,
+// without the GHES /api/v3 prefix.
+func apiPath(p string) string {
+	if i := strings.Index(p, "/repos/"); i > 0 {
+		return p[i:]
+	}
+	return p
+}
+
+// ttl returns how long a response for the upstream path p stays fresh.
+func (c *responseCache) ttl(p string, header http.Header, body []byte) time.Duration {
+	p = apiPath(p)
+	for _, r := range c.routes {
+		if !r.pattern.MatchString(p) {
+			continue
+		}
+		switch responseProgress(header, body) {
+		case progressPending:
+			return r.pendingTTL
+		case progressCompleted:
+			if r.completedTTL > 0 {
+				return r.completedTTL
+			}
+		}
+		return r.ttl
+	}
+	return 0
+}
+
+// progress is what a response says about the runs in it.
+type progress int
+
+const (
+	progressUnknown   progress = iota // no status, or not JSON
+	progressPending                   // something is queued or in progress
+	progressCompleted                 // everything has completed
+)
+
+// runProgress is the part of a check run, check suite, workflow run, or job
+// that says whether it has finished.
+type runProgress struct {
+	Status string `json:"status"`
+}
+
+// responseProgress reports whether a JSON response is for check runs,
+// check suites, workflow runs, or jobs that have all completed, and so
+// will not change again, or for some that are still pending. A list
+// counts as completed only if it isn't empty; a combined commit status
+// counts as pending while its state is.
+func responseProgress(header http.Header, body []byte) progress {
+	if !isJSON(header.Get("Content-Type")) {
+		return progressUnknown
+	}
+	if header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return progressUnknown
+		}
+		if body, err = io.ReadAll(zr); err != nil {
+			return progressUnknown
+		}
+	} else if header.Get("Content-Encoding") != "" {
+		return progressUnknown
+	}
+	var v struct {
+		runProgress
+		State        string        `json:"state"`
+		CheckRuns    []runProgress `json:"check_runs"`
+		CheckSuites  []runProgress `json:"check_suites"`
+		WorkflowRuns []runProgress `json:"workflow_runs"`
+		Jobs         []runProgress `json:"jobs"`
+	}
+	if json.Unmarshal(body, &v) != nil {
+		return progressUnknown
+	}
+	if v.State == "pending" {
+		return progressPending
+	}
+	p := progressUnknown
+	for _, r := range slices.Concat([]runProgress{v.runProgress}, v.CheckRuns, v.CheckSuites, v.WorkflowRuns, v.Jobs) {
+		switch r.Status {
+		case "":
+		case "completed":
+			p = progressCompleted
+		default:
+			return progressPending
+		}
+	}
+	return p
+}
+
+func (c *responseCache) add(e *cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[e.key]; ok {
+		c.bytes -= el.Value.(*cachedResponse).size()
+		el.Value = e
+		c.order.MoveToFront(el)
+	} else {
+		c.items[e.key] = c.order.PushFront(e)
+	}
+	c.bytes += e.size()
+	for c.order.Len() > c.maxEntries || c.bytes > c.maxBytes {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		old := oldest.Value.(*cachedResponse)
+		delete(c.items, old.key)
+		c.bytes -= old.size()
+		c.evictions++
+	}
+}
+
+func (c *responseCache) remove(key string) {
+	c.mu.Lock()
+	if el, ok := c.items[key]; ok {
+		c.order.Remove(el)
+		delete(c.items, key)
+		c.bytes -= el.Value.(*cachedResponse).size()
+	}
+	c.mu.Unlock()
+	if c.disk != nil {
+		c.disk.remove(key)
+	}
+	if c.shared != nil {
+		c.shared.del("r:" + responseKeyHash(key))
+	}
+}
+
+// invalidate drops the cached responses whose API path match reports
+// true, here and on disk and in Redis, and returns how many there were in
+// memory.
+func (c *responseCache) invalidate(match func(path string) bool) int {
+	c.mu.Lock()
+	var keys []string
+	for key, el := range c.items {
+		if match(responseKeyPath(key)) {
+			c.order.Remove(el)
+			delete(c.items, key)
+			c.bytes -= el.Value.(*cachedResponse).size()
+			keys = append(keys, key)
+		}
+	}
+	c.mu.Unlock()
+	for _, key := range keys {
+		if c.disk != nil {
+			c.disk.remove(key)
+		}
+		if c.shared != nil {
+			c.shared.del("r:" + responseKeyHash(key))
+		}
+	}
+	return len(keys)
+}
+
+// invalidateRepo drops the cached responses for owner/repo's resources at
+// any of the paths, relative to /repos/{owner}/{repo}/, or below them.
+func (c *responseCache) invalidateRepo(owner, repo string, paths ...string) int {
+	base := strings.ToLower("/repos/" + owner + "/" + repo + "/")
+	return c.invalidate(func(p string) bool {
+		p = strings.ToLower(p)
+		for _, rel := range paths {
+			if rel = strings.ToLower(rel); p == base+rel || strings.HasPrefix(p, base+rel+"/") {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// commitSHAPattern matches a full commit SHA.
+var commitSHAPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// invalidateRefs drops owner/repo's cached responses for commits named by
+// anything but a full SHA: a branch, tag, or abbreviated SHA may have moved
+// to, or be, a commit whose checks just changed. Responses for other full
+// SHAs are kept.
+func (c *responseCache) invalidateRefs(owner, repo string) int {
+	base := strings.ToLower("/repos/" + owner + "/" + repo + "/commits/")
+	return c.invalidate(func(p string) bool {
+		rel, ok := strings.CutPrefix(strings.ToLower(p), base)
+		if !ok {
+			return false
+		}
+		ref, _, _ := strings.Cut(rel, "/")
+		return !commitSHAPattern.MatchString(ref)
+	})
+}
+
+// flush drops the cached responses for owner/repo repo, or all of them when
+// repo is empty, and returns how many there were in memory. Flushing
+// everything also empties the disk store and the responses in Redis, which
+// other replicas share. Copies of one repository's responses left there are
+// revalidated with GitHub before they are served again.
+func (c *responseCache) flush(repo string) int {
+	if repo != "" {
+		prefix := "/repos/" + strings.ToLower(repo) + "/"
+		return c.invalidate(func(p string) bool {
+			return strings.HasPrefix(strings.ToLower(p)+"/", prefix)
+		})
+	}
+	c.mu.Lock()
+	n := len(c.items)
+	c.items = make(map[string]*list.Element)
+	c.order.Init()
+	c.bytes = 0
+	c.mu.Unlock()
+	if c.disk != nil {
+		if _, err := c.disk.clear(); err != nil {
+			slog.Warn("flushing stored responses failed", "dir", c.disk.dir, "error", err)
+		}
+	}
+	if c.shared != nil {
+		if _, err := c.shared.deleteMatching("r:*"); err != nil {
+			slog.Warn("flushing shared responses failed", "error", err)
+		}
+	}
+	return n
+}
+
+// checkListPattern matches, relative to a repository, the lists a write to
+// one of its check runs or suites changes.
+var checkListPattern = regexp.MustCompile(`^commits/[^/]+/check-(runs|suites)$`)
+
+// invalidateWrite drops the cached responses a successful write to the API
+// path p made outdated: the resource it acted on, such as the check run a
+// rerequest was for, and the repository's check suites and check-run and
+// check-suite lists, which the new run shows up in.
+func (c *responseCache) invalidateWrite(p string) int {
+	parts := strings.SplitN(strings.TrimPrefix(p, "/"), "/", 4)
+	if len(parts) < 4 || parts[0] != "repos" {
+		return 0
+	}
+	base := strings.ToLower("/repos/" + parts[1] + "/" + parts[2] + "/")
+	resource := strings.ToLower(parts[3])
+	if i := strings.LastIndex(resource, "/"); i >= 0 {
+		resource = resource[:i]
+	}
+	return c.invalidate(func(p string) bool {
+		rel, ok := strings.CutPrefix(strings.ToLower(p), base)
+		return ok && (rel == resource || strings.HasPrefix(rel, resource+"/") ||
+			strings.HasPrefix(rel, "check-suites/") || checkListPattern.MatchString(rel))
+	})
+}
+
+// responseKeyPath returns the escaped API path of the URL in a response
+// cache key.
+func responseKeyPath(key string) string {
+	rawURL, _, _ := strings.Cut(key, "\n")
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return apiPath(u.EscapedPath())
+}
+
+func (c *responseCache) stats() responseCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return responseCacheStats{
+		Entries:    c.order.Len(),
+		Capacity:   c.maxEntries,
+		Bytes:      c.bytes,
+		MaxBytes:   c.maxBytes,
+		Hits:       c.hits,
+		Fresh:      c.fresh,
+		Stale:      c.stale,
+		Misses:     c.misses,
+		Evictions:  c.evictions,
+		Loaded:     c.loaded,
+		Prefetched: c.prefetched.Load(),
+	}
+}
+
+// store caches resp, the response to a GET of the API path p, under key
+// when it is a complete 200 with an ETag, and returns it with its body still
+// readable. Anything else passes through.
+func (c *responseCache) store(key, p string, resp *http.Response) *http.Response {
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag == "" || resp.ContentLength > c.maxBody ||
+		strings.Contains(resp.Header.Get("Cache-Control"), "no-store") {
+		if resp.StatusCode == http.StatusOK {
+			c.remove(key)
+		}
+		return resp
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, c.maxBody+1))
+	if err != nil || int64(len(body)) > c.maxBody {
+		// Hand the client what was read followed by the rest, or the error.
+		c.remove(key)
+		resp.Body = readCloser{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return resp
+	}
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	e := &cachedResponse{key: key, etag: etag, header: resp.Header.Clone(), body: body}
+	e.ttl = c.ttl(p, e.header, body)
+	e.expires = time.Now().Add(e.ttl)
+	c.add(e)
+	if c.disk != nil {
+		if err := c.disk.save(e); err != nil {
+			slog.Debug("response not saved to disk", "dir", c.disk.dir, "error", err)
+		}
+	}
+	if c.shared != nil {
+		if data, err := encodeResponse(e); err == nil {
+			c.shared.set("r:"+responseKeyHash(key), data, sharedResponseTTL)
+		}
+	}
+	return resp
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// response rebuilds the cached response for req. notModified, when GitHub
+// was asked, is its 304, whose headers (rate limit, Date, ETag) replace the
+// stored ones. When the client itself sent a matching validator it gets a
+// 304.
+func (e *cachedResponse) response(req *http.Request, notModified *http.Response, clientHasIt bool) *http.Response {
+	header := e.header.Clone()
+	resp := &http.Response{Proto: "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1, Header: header, Request: req}
+	if notModified != nil {
+		for k, v := range notModified.Header {
+			if k != "Content-Length" {
+				header[k] = v
+			}
+		}
+		resp.Proto, resp.ProtoMajor, resp.ProtoMinor = notModified.Proto, notModified.ProtoMajor, notModified.ProtoMinor
+	}
+	if clientHasIt {
+		header.Del("Content-Length")
+		resp.StatusCode, resp.Body = http.StatusNotModified, http.NoBody
+	} else {
+		resp.StatusCode, resp.Body, resp.ContentLength = http.StatusOK, io.NopCloser(bytes.NewReader(e.body)), int64(len(e.body))
+	}
+	resp.Status = fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	return resp
+}
+
+// clientHasResponse reports whether the client's own conditional headers
+// match the cached response.
+func (e *cachedResponse) clientHasResponse(req *http.Request) bool {
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		return clientHasETag(inm, e.etag)
+	}
+	ims := req.Header.Get("If-Modified-Since")
+	return ims != "" && ims == e.header.Get("Last-Modified")
+}
+
+// clientHasETag reports whether the If-None-Match header inm matches etag,
+// comparing weakly.
+func clientHasETag(inm, etag string) bool {
+	for _, tag := range strings.Split(inm, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || (tag != "" && strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheTransport answers GET requests from the response cache. Fresh
+// responses are served without asking GitHub, and so are those that went
+// stale within max_stale while they are revalidated in the background;
+// others are revalidated with If-None-Match so only changed data is
+// transferred and charged against the rate limit. A successful write drops
+// the responses it changed. With no cache, requests pass through.
+type cacheTransport struct {
+	next     http.RoundTripper
+	cache    *responseCache
+	timeouts timeoutPolicy // bounds background refreshes
+}
+
+func (t cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c := t.cache
+	if c == nil || req.Method == http.MethodHead {
+		return t.next.RoundTrip(req)
+	}
+	if req.Method != http.MethodGet {
+		resp, err := t.next.RoundTrip(req)
+		if err == nil && resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			c.invalidateWrite(apiPath(req.URL.EscapedPath()))
+		}
+		return resp, err
+	}
+	key := responseCacheKey(req)
+	cached, fresh, ok := c.get(key)
+	if fresh {
+		return cached.response(req, nil, cached.clientHasResponse(req)), nil
+	}
+	if !ok {
+		resp, err := t.next.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		return c.store(key, req.URL.EscapedPath(), resp), nil
+	}
+	if c.servesStale(cached) {
+		t.refresh(req, key, cached)
+		return cached.response(req, nil, cached.clientHasResponse(req)), nil
+	}
+
+	resp, err := t.next.RoundTrip(revalidation(req, cached))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusNotModified {
+		return c.store(key, req.URL.EscapedPath(), resp), nil
+	}
+	resp.Body.Close()
+	c.revalidated(cached)
+	return cached.response(req, resp, cached.clientHasResponse(req)), nil
+}
+
+// revalidation returns req asking GitHub whether cached is still current.
+func revalidation(req *http.Request, cached *cachedResponse) *http.Request {
+	revalidate := req.Clone(req.Context())
+	revalidate.Header.Set("If-None-Match", cached.etag)
+	revalidate.Header.Del("If-Modified-Since")
+	return revalidate
+}
+
+// refresh revalidates cached in the background, at most once at a time per
+// key, without waiting on the client that asked for it. On failure the
+// stale copy is kept until max_stale runs out.
+func (t cacheTransport) refresh(req *http.Request, key string, cached *cachedResponse) {
+	c := t.cache
+	if _, busy := c.refreshing.LoadOrStore(key, struct{}{}); busy {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(req.Context()), t.timeouts.forPath(apiPath(req.URL.EscapedPath())))
+	revalidate := revalidation(req.WithContext(ctx), cached)
+	go func() {
+		defer c.refreshing.Delete(key)
+		defer cancel()
+		resp, err := t.next.RoundTrip(revalidate)
+		if err != nil {
+			slog.Debug("response refresh failed", "path", req.URL.Path, "error", err)
+			return
+		}
+		if resp.StatusCode == http.StatusNotModified {
+			resp.Body.Close()
+			c.confirmed(cached)
+			return
+		}
+		resp = c.store(key, req.URL.EscapedPath(), resp)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+}
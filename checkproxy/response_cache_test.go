@@ -0,0 +1,89 @@
+package checkproxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestResponseCacheKey(t *testing.T) {
+	request := func(target string, header map[string]string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "https://api.github.com"+target, nil)
+		for k, v := range header {
+			r.Header.Set(k, v)
+		}
+		return r
+	}
+	base := request("/repos/o/r/commits/abc/check-runs?per_page=5", nil)
+	tests := []struct {
+		name string
+		req  *http.Request
+		same bool
+	}{
+		{"other token", request("/repos/o/r/commits/abc/check-runs?per_page=5", map[string]string{"Authorization": "Bearer ghp_other"}), true},
+		{"conditional headers", request("/repos/o/r/commits/abc/check-runs?per_page=5", map[string]string{"If-None-Match": `"x"`}), true},
+		{"other query", request("/repos/o/r/commits/abc/check-runs?per_page=5&page=2", nil), false},
+		{"other commit", request("/repos/o/r/commits/def/check-runs?per_page=5", nil), false},
+		{"other Accept", request("/repos/o/r/commits/abc/check-runs?per_page=5", map[string]string{"Accept": "application/vnd.github.raw+json"}), false},
+		{"gzip", request("/repos/o/r/commits/abc/check-runs?per_page=5", map[string]string{"Accept-Encoding": "gzip"}), false},
+	}
+	for _, tt := range tests {
+		if same := responseCacheKey(tt.req) == responseCacheKey(base); same != tt.same {
+			t.Errorf("%s: same key = %v, want %v", tt.name, same, tt.same)
+		}
+	}
+}
+
+func TestResponseCacheTTL(t *testing.T) {
+	defaults, err := newResponseCache(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	custom, err := newResponseCache(&ResponseCacheConfig{Routes: []RouteCacheTTL{
+		{Pattern: `^/repos/[^/]+/[^/]+/commits/[^/]+/status$`, TTL: "30s", PendingTTL: "5s"},
+		{Pattern: `^/repos/[^/]+/[^/]+/check-runs/[0-9]+$`, TTL: "10s", CompletedTTL: "2h"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gzipped bytes.Buffer
+	zw := gzip.NewWriter(&gzipped)
+	zw.Write([]byte(`{"id":1,"status":"completed"}`))
+	zw.Close()
+
+	tests := []struct {
+		name     string
+		cache    *responseCache
+		path     string
+		encoding string
+		body     string
+		want     time.Duration
+	}{
+		{"completed run", defaults, "/repos/o/r/check-runs/1", "", `{"id":1,"status":"completed"}`, time.Hour},
+		{"in-progress run", defaults, "/repos/o/r/check-runs/1", "", `{"id":1,"status":"in_progress"}`, 0},
+		{"GHES prefix", defaults, "/api/v3/repos/o/r/check-runs/1", "", `{"id":1,"status":"completed"}`, time.Hour},
+		{"gzipped completed run", defaults, "/repos/o/r/check-runs/1", "gzip", gzipped.String(), time.Hour},
+		{"other encoding", defaults, "/repos/o/r/check-runs/1", "br", `{"id":1,"status":"completed"}`, 0},
+		{"completed list", defaults, "/repos/o/r/commits/abc/check-runs", "", `{"check_runs":[{"status":"completed"},{"status":"completed"}]}`, time.Minute},
+		{"list with a queued run", defaults, "/repos/o/r/commits/abc/check-runs", "", `{"check_runs":[{"status":"completed"},{"status":"queued"}]}`, 0},
+		{"empty list", defaults, "/repos/o/r/commits/abc/check-runs", "", `{"check_runs":[]}`, 0},
+		{"route not cached", defaults, "/repos/o/r/pulls/1", "", `{"status":"completed"}`, 0},
+		{"pending combined status", custom, "/repos/o/r/commits/abc/status", "", `{"state":"pending"}`, 5 * time.Second},
+		{"settled combined status", custom, "/repos/o/r/commits/abc/status", "", `{"state":"success"}`, 30 * time.Second},
+		{"custom completed TTL", custom, "/repos/o/r/check-runs/1", "", `{"status":"completed"}`, 2 * time.Hour},
+		{"custom TTL without status", custom, "/repos/o/r/check-runs/1", "", `{}`, 10 * time.Second},
+		{"custom routes replace defaults", custom, "/repos/o/r/commits/abc/check-runs", "", `{"check_runs":[{"status":"completed"}]}`, 0},
+	}
+	for _, tt := range tests {
+		header := http.Header{"Content-Type": {"application/json; charset=utf-8"}}
+		if tt.encoding != "" {
+			header.Set("Content-Encoding", tt.encoding)
+		}
+		if got := tt.cache.ttl(tt.path, header, []byte(tt.body)); got != tt.want {
+			t.Errorf("%s: ttl = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
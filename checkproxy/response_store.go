@@ -0,0 +1,227 @@
+package checkproxy
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	defaultResponseStoreBytes = 256 << 20
+	defaultResponseStoreTTL   = 24 * time.Hour
+	responseStoreGCInterval   = 10 * time.Minute
+)
+
+// ResponseCachePath returns the directory the response cache is persisted
+// to when response_cache.persist is enabled and no dir is set.
+func ResponseCachePath() string {
+	return filepath.Join(filepath.Dir(ConfigPath()), "response-cache")
+}
+
+// responseStore keeps cached responses on disk, one file per response, so
+// a restarted server can revalidate what it had instead of fetching it all
+// again. Files GitHub hasn't confirmed for ttl are removed, then the ones
+// confirmed longest ago until the directory fits in maxBytes.
+type responseStore struct {
+	dir      string
+	maxBytes int64
+	ttl      time.Duration
+}
+
+// storedResponse is the header of a stored response; the body follows it
+// after a newline.
+type storedResponse struct {
+	Key       string        `json:"key"`
+	ETag      string        `json:"etag"`
+	Header    http.Header   `json:"header"`
+	TTL       time.Duration `json:"ttl,omitempty"`
+	Confirmed time.Time     `json:"confirmed"`
+}
+
+// encodeResponse returns e in its stored form, as confirmed by GitHub now.
+func encodeResponse(e *cachedResponse) ([]byte, error) {
+	meta, err := json.Marshal(storedResponse{Key: e.key, ETag: e.etag, Header: e.header, TTL: e.ttl, Confirmed: time.Now()})
+	if err != nil {
+		return nil, err
+	}
+	return append(append(meta, '\n'), e.body...), nil
+}
+
+// decodeResponse reads the response stored for key from r. It comes back
+// expired, to be revalidated before it is served.
+func decodeResponse(key string, r *bufio.Reader) (*cachedResponse, bool) {
+	var meta storedResponse
+	line, err := r.ReadBytes('\n')
+	if err != nil || json.Unmarshal(line, &meta) != nil || meta.Key != key {
+		return nil, false
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, false
+	}
+	return &cachedResponse{key: key, etag: meta.ETag, header: meta.Header, body: body, ttl: meta.TTL}, true
+}
+
+func newResponseStore(dir string, maxBytes int64, ttl time.Duration) (*responseStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &responseStore{dir: dir, maxBytes: maxBytes, ttl: ttl}, nil
+}
+
+// responseKeyHash names the response for key in storage, since keys are
+// URLs.
+func responseKeyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *responseStore) file(key string) string {
+	return filepath.Join(s.dir, responseKeyHash(key))
+}
+
+// save writes e, replacing any earlier copy. The modification time records
+// when GitHub last confirmed it.
+func (s *responseStore) save(e *cachedResponse) error {
+	data, err := encodeResponse(e)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.file(e.key))
+}
+
+// load reads the response saved for key, unless GitHub hasn't confirmed it
+// for the store's TTL.
+func (s *responseStore) load(key string) (*cachedResponse, bool) {
+	f, err := os.Open(s.file(key))
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || time.Since(info.ModTime()) > s.ttl {
+		return nil, false
+	}
+	return decodeResponse(key, bufio.NewReader(f))
+}
+
+// touch marks the response for key as confirmed by GitHub just now.
+func (s *responseStore) touch(key string) {
+	now := time.Now()
+	os.Chtimes(s.file(key), now, now)
+}
+
+func (s *responseStore) remove(key string) {
+	os.Remove(s.file(key))
+}
+
+// clear removes every stored response and returns how many there were.
+func (s *responseStore) clear() (int, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.dir, e.Name())); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// gc removes expired files, then the oldest until the rest fit in
+// maxBytes. It returns how many it removed.
+func (s *responseStore) gc() (int, error) {
+	type file struct {
+		path string
+		size int64
+		used time.Time
+	}
+	var files []file
+	var total int64
+	removed := 0
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != s.dir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil // removed meanwhile
+		}
+		stale := strings.HasPrefix(d.Name(), ".tmp-") && time.Since(info.ModTime()) > time.Hour
+		if stale || time.Since(info.ModTime()) > s.ttl {
+			if os.Remove(path) == nil {
+				removed++
+			}
+			return nil
+		}
+		files = append(files, file{path, info.Size(), info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return removed, err
+	}
+	slices.SortFunc(files, func(a, b file) int { return a.used.Compare(b.used) })
+	for _, f := range files {
+		if total <= s.maxBytes {
+			break
+		}
+		if err := os.Remove(f.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return removed, err
+		}
+		total -= f.size
+		removed++
+	}
+	return removed, nil
+}
+
+// gcEvery runs gc now and then every interval until done is closed.
+func (s *responseStore) gcEvery(done <-chan struct{}, interval time.Duration) {
+	s.gcLogged()
+	for range ticks(done, interval) {
+		s.gcLogged()
+	}
+}
+
+func (s *responseStore) gcLogged() {
+	if n, err := s.gc(); err != nil {
+		slog.Warn("response cache cleanup failed", "dir", s.dir, "error", err)
+	} else if n > 0 {
+		slog.Debug("response cache cleaned up", "dir", s.dir, "removed", n)
+	}
+}
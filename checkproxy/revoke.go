@@ -0,0 +1,194 @@
+package checkproxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fingerprintPattern matches a token fingerprint: the hex SHA-256 of the token.
+var fingerprintPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// revocation blocks one client credential by fingerprint.
+type revocation struct {
+	Fingerprint string    `json:"fingerprint"`
+	Reason      string    `json:"reason,omitempty"`
+	Revoked     time.Time `json:"revoked"`
+}
+
+// tokenFingerprint returns the hex SHA-256 of a client credential. For proxy
+// API keys it equals the stored key hash.
+func tokenFingerprint(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// RevocationsPath returns the path to the revocation list, next to the config file.
+func RevocationsPath() string {
+	return filepath.Join(filepath.Dir(ConfigPath()), "revoked.json")
+}
+
+func loadRevocations(path string) ([]revocation, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []revocation
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("invalid revocation list %s: %w", path, err)
+	}
+	return list, nil
+}
+
+func saveRevocations(path string, list []revocation) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write and rename so the server's watcher never reads a half-written
+	// list.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// revocationsMu serializes edits to the revocation list, so concurrent admin
+// requests don't lose each other's changes. The file is shared by every
+// server in the process, so the lock is too.
+var revocationsMu sync.Mutex
+
+// addRevocation records fp in the list at path. It reports false if fp was
+// already revoked.
+func addRevocation(path, fp, reason string) (bool, error) {
+	revocationsMu.Lock()
+	defer revocationsMu.Unlock()
+	list, err := loadRevocations(path)
+	if err != nil {
+		return false, err
+	}
+	for _, r := range list {
+		if r.Fingerprint == fp {
+			return false, nil
+		}
+	}
+	list = append(list, revocation{Fingerprint: fp, Reason: reason, Revoked: time.Now().UTC()})
+	return true, saveRevocations(path, list)
+}
+
+// removeRevocation deletes fp from the list at path. It reports false if fp
+// wasn't revoked.
+func removeRevocation(path, fp string) (bool, error) {
+	revocationsMu.Lock()
+	defer revocationsMu.Unlock()
+	list, err := loadRevocations(path)
+	if err != nil {
+		return false, err
+	}
+	for i, r := range list {
+		if r.Fingerprint == fp {
+			return true, saveRevocations(path, append(list[:i], list[i+1:]...))
+		}
+	}
+	return false, nil
+}
+
+// revocationList answers "is this credential revoked?" for the server,
+// rereading the list as soon as it changes on disk.
+type revocationList struct {
+	file *watchedFile[map[string]struct{}]
+}
+
+func newRevocationList(path string) *revocationList {
+	return &revocationList{file: newWatchedFile(path, func(path string) (map[string]struct{}, error) {
+		list, err := loadRevocations(path)
+		set := make(map[string]struct{}, len(list))
+		for _, r := range list {
+			set[r.Fingerprint] = struct{}{}
+		}
+		return set, err
+	})}
+}
+
+// revoked reports whether the fingerprint of token, a client token, proxy API
+// key, or HMAC key id, is on the list.
+func (l *revocationList) revoked(token string) bool {
+	_, ok := l.file.get()[tokenFingerprint(token)]
+	return ok
+}
+
+// runRevoke handles `gh-checkproxy revoke`.
+func runRevoke(args []string) error {
+	fs := flag.NewFlagSet("revoke", flag.ContinueOnError)
+	reason := fs.String("reason", "", "Why the credential was revoked")
+	list := fs.Bool("list", false, "List revoked fingerprints")
+	undo := fs.Bool("undo", false, "Lift a revocation")
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	path := RevocationsPath()
+
+	if *list {
+		entries, err := loadRevocations(path)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			fmt.Println("No revoked credentials.")
+			return nil
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Revoked.Before(entries[j].Revoked) })
+		for _, r := range entries {
+			fmt.Printf("%s  %s  %s\n", r.Fingerprint, r.Revoked.Format(time.RFC3339), r.Reason)
+		}
+		return nil
+	}
+
+	if len(positional) != 1 {
+		return fmt.Errorf("usage: gh-checkproxy revoke [--reason <text>] [--undo] <token-fingerprint>")
+	}
+	fp := strings.ToLower(positional[0])
+	if !fingerprintPattern.MatchString(fp) {
+		return fmt.Errorf("%q is not a fingerprint: expected the 64-character hex SHA-256 of the token", positional[0])
+	}
+
+	if *undo {
+		removed, err := removeRevocation(path, fp)
+		if err != nil {
+			return err
+		}
+		if !removed {
+			return fmt.Errorf("%s is not revoked", fp)
+		}
+		fmt.Printf("Revocation lifted for %s.\n", fp)
+		return nil
+	}
+	added, err := addRevocation(path, fp, *reason)
+	if err != nil {
+		return err
+	}
+	if !added {
+		fmt.Printf("%s was already revoked.\n", fp)
+		return nil
+	}
+	fmt.Printf("Revoked %s. A running server blocks it on its next request.\n", fp)
+	return nil
+}
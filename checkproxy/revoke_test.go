@@ -0,0 +1,108 @@
+package checkproxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestRevocationEdits adds and lifts revocations concurrently, as admin
+// requests may, and checks that none is lost.
+func TestRevocationEdits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "revoked.json")
+	fps := make([]string, 20)
+	for i := range fps {
+		fps[i] = tokenFingerprint(fmt.Sprint("token-", i))
+	}
+	var wg sync.WaitGroup
+	for _, fp := range fps {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := addRevocation(path, fp, ""); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if list, err := loadRevocations(path); err != nil || len(list) != len(fps) {
+		t.Fatalf("after adding: %d revocations, err %v; want %d", len(list), err, len(fps))
+	}
+	for _, fp := range fps[:10] {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if removed, err := removeRevocation(path, fp); err != nil || !removed {
+				t.Errorf("removeRevocation = %v, %v", removed, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if list, err := loadRevocations(path); err != nil || len(list) != 10 {
+		t.Fatalf("after removing: %d revocations, err %v; want 10", len(list), err)
+	}
+	if added, err := addRevocation(path, fps[10], ""); err != nil || added {
+		t.Errorf("revoking twice: added = %v, err %v", added, err)
+	}
+}
+
+// TestRevokedCredentials checks that revoked client tokens and HMAC key ids
+// are refused before they reach GitHub.
+func TestRevokedCredentials(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-OAuth-Scopes", "repo")
+		w.Write([]byte(`{"login":"bot"}`))
+	}))
+	defer upstream.Close()
+	for _, credential := range []string{"github_pat_leaked", "leaked-ci"} {
+		if _, err := addRevocation(RevocationsPath(), tokenFingerprint(credential), "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h, err := NewHandler(&Config{ClassicToken: "ghp_test", APIBaseURL: upstream.URL, HMACClients: []HMACClient{
+		{ID: "ci", Secret: "s3cret", Orgs: []string{"o"}},
+		{ID: "leaked-ci", Secret: "s3cret", Orgs: []string{"o"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	tests := []struct {
+		name   string
+		token  string
+		hmacID string
+		status int
+	}{
+		{name: "token", token: "github_pat_ok", status: http.StatusOK},
+		{name: "revoked token", token: "github_pat_leaked", status: http.StatusForbidden},
+		{name: "HMAC client", hmacID: "ci", status: http.StatusOK},
+		{name: "revoked HMAC client", hmacID: "leaked-ci", status: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/repos/o/r/check-runs/1", nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if tt.hmacID != "" {
+				ts := strconv.FormatInt(time.Now().Unix(), 10)
+				r.Header.Set(hmacKeyIDHeader, tt.hmacID)
+				r.Header.Set(hmacTimestampHeader, ts)
+				r.Header.Set(hmacSignatureHeader, hmacSignature("s3cret", r.Method, r.RequestURI, ts))
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d (%s)", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package checkproxy
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultSecondaryBackoff is how long to stop using a token after a secondary
+// rate limit response without Retry-After; GitHub asks for at least a minute.
+const defaultSecondaryBackoff = time.Minute
+
+// secondaryLimitTracker remembers which of a server's tokens GitHub has told
+// to slow down, and until when.
+type secondaryLimitTracker struct {
+	mu    sync.Mutex
+	until map[string]time.Time // token fingerprint → blocked until
+}
+
+func newSecondaryLimitTracker() *secondaryLimitTracker {
+	return &secondaryLimitTracker{until: make(map[string]time.Time)}
+}
+
+// blocked returns how much longer token must wait, if at all.
+func (t *secondaryLimitTracker) blocked(token string) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fp := tokenFingerprint(token)
+	until, ok := t.until[fp]
+	if !ok {
+		return 0, false
+	}
+	if wait := time.Until(until); wait > 0 {
+		return wait, true
+	}
+	delete(t.until, fp)
+	return 0, false
+}
+
+func (t *secondaryLimitTracker) block(token string, d time.Duration) {
+	t.mu.Lock()
+	t.until[tokenFingerprint(token)] = time.Now().Add(d)
+	t.mu.Unlock()
+}
+
+// secondaryLimitError is returned, wrapped in an *url.Error, for requests
+// GitHub refused with a secondary rate limit, or that weren't sent because
+// the token is still backing off from one.
+type secondaryLimitError struct {
+	retry time.Duration
+}
+
+func (e *secondaryLimitError) Error() string {
+	return "GitHub secondary rate limit; retry in " + e.retry.Round(time.Second).String()
+}
+
+func (e *secondaryLimitError) refusal() (int, string, time.Duration) {
+	return http.StatusTooManyRequests, "secondary-rate-limited", e.retry
+}
+
+// secondaryRateLimit reports whether resp is a secondary rate limit and how
+// long GitHub wants the token left alone. Those come as 403 or 429 with
+// quota to spare, usually with Retry-After, else with a message saying so.
+func secondaryRateLimit(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		return 0, false // the primary limit; see tokenRejection
+	}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs >= 0 {
+		return max(time.Duration(secs)*time.Second, time.Second), true
+	}
+	// Peek at the message without consuming it for whoever reads the body.
+	head, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), resp.Body), resp.Body}
+	if bytes.Contains(bytes.ToLower(head), []byte("secondary rate limit")) {
+		return defaultSecondaryBackoff, true
+	}
+	return 0, false
+}
+
+// secondaryLimitTransport stops sending requests with a token GitHub has
+// secondary-rate-limited until its Retry-After has passed, and turns the
+// opaque 403 into a *secondaryLimitError.
+type secondaryLimitTransport struct {
+	next   http.RoundTripper
+	limits *secondaryLimitTracker
+}
+
+func (t secondaryLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if ok {
+		if wait, blocked := t.limits.blocked(token); blocked {
+			return nil, &secondaryLimitError{retry: wait}
+		}
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || !ok {
+		return resp, err
+	}
+	if retry, limited := secondaryRateLimit(resp); limited {
+		resp.Body.Close()
+		t.limits.block(token, retry)
+		slog.Warn("GitHub secondary rate limit; backing off", "token", tokenFingerprint(token)[:16], "retry_after", retry.String())
+		return nil, &secondaryLimitError{retry: retry}
+	}
+	return resp, nil
+}
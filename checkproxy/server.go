@@ -0,0 +1,390 @@
+package checkproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// NewHandler builds the proxy's HTTP handler from cfg, for serving it from
+// another program: an internal service's mux, or a Lambda or Cloud Run HTTP
+// adapter. Listeners, TLS, and signals are left to the caller; admin
+// allowlist edits still reload the config file, as they do under serve.
+//
+// Each handler keeps its own routes, caches, token pool, and counters, so
+// several can be built in one process, each from its own config. Close the
+// handler once it is no longer served to stop its background work.
+func NewHandler(cfg *Config) (*Handler, error) {
+	srv, err := newServer(cfg, false)
+	if err != nil {
+		return nil, err
+	}
+	go srv.reloadOn(nil, cfg)
+	return &Handler{srv}, nil
+}
+
+// Handler is the proxy's HTTP handler, as built by NewHandler.
+type Handler struct {
+	srv *server
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.srv.handler.ServeHTTP(w, r)
+}
+
+// Close stops the handler's background work: token checks, preflight
+// retries, alerts, cache upkeep, and config reloads. It flushes pending
+// traces and saves the validation cache if persist_validation_cache is set,
+// returning the error from saving it. Requests still being served are not
+// waited for; stop serving the handler first.
+func (h *Handler) Close() error {
+	return h.srv.close()
+}
+
+// errServerClosed is returned for admin edits that arrive after Close.
+var errServerClosed = errors.New("server closed")
+
+// server is a configured proxy, ready to be put behind listeners. It holds
+// everything the config sets up; handlers reach it through the server, so
+// servers built from different configs share nothing but the process's
+// logger.
+type server struct {
+	handler   http.Handler
+	validator *Validator
+	tokens    *classicTokenMonitor
+	hub       *watchHub
+	stats     *serverStats
+	// current is the routing that a reload replaces.
+	current *atomic.Pointer[http.ServeMux]
+	// reloads asks reloadOn to reload the config; the result is sent back
+	// on the channel passed. Admin allowlist edits use it after saving the
+	// file.
+	reloads chan chan error
+	// done is closed by close to stop the server's background goroutines.
+	done      chan struct{}
+	closeOnce sync.Once
+	// cachePath is where the validation cache is persisted; empty unless
+	// persist_validation_cache is set.
+	cachePath string
+	// allowlistMu serializes admin allowlist edits so concurrent requests
+	// don't lose each other's changes to the config file.
+	allowlistMu sync.Mutex
+
+	// upstream is this server's own copy of http.DefaultTransport, with
+	// upstream_connections applied; every client for GitHub is built on it.
+	upstream *http.Transport
+	// rateLimits and secondaryLimits hold what GitHub reported about the
+	// server's own tokens.
+	rateLimits      *rateLimitTracker
+	secondaryLimits *secondaryLimitTracker
+
+	routes         []route          // allowedRoutes and extra_allowed_routes
+	denied         []*regexp.Regexp // denied_routes
+	timeouts       timeoutPolicy
+	listenTimeouts serverTimeoutPolicy
+	rateLimit      *rateLimiter   // nil unless rate_limit is set
+	breakers       *breakerSet    // nil when the circuit breaker is off
+	budget         *rateBudget    // nil unless rate_budget is set
+	responses      *responseCache // nil when the response cache is off
+	flights        *flightGroup
+	pool           atomic.Pointer[tokenPool] // replaced on reload; nil without classic_token_pool
+	app            *appTokenSource           // nil without github_app
+	alerts         *alerter
+	tracer         *traceExporter
+	redact         *logRedactor
+	readiness      *readinessState
+	traffic        *trafficTracker
+	decisions      *decisionTracker
+
+	// upstreamOutcomes counts requests to GitHub by whether the upstream
+	// failed them (see upstreamFailed); validationOutcomes counts token
+	// validations sent to GitHub by whether they ended in an error.
+	upstreamOutcomes, validationOutcomes outcomeCounter
+
+	ttl, negativeTTL time.Duration
+	preflightOK      bool
+	appSlug          string
+}
+
+// newServer builds the proxy for cfg and the handler serving it. With
+// check, a GitHub that can't be reached is an error rather than something to
+// retry in the background. The server's goroutines run until close.
+func newServer(cfg *Config, check bool) (_ *server, err error) {
+	if cfg.GetClassicToken() == "" && cfg.GitHubApp == nil {
+		return nil, errors.New("no classic token — set GH_CHECKPROXY_CLASSIC_TOKEN, GH_TOKEN, or re-run 'gh-checkproxy config'")
+	}
+	s := &server{
+		reloads:         make(chan chan error),
+		done:            make(chan struct{}),
+		rateLimits:      newRateLimitTracker(),
+		secondaryLimits: newSecondaryLimitTracker(),
+		flights:         newFlightGroup(),
+		readiness:       newReadinessState(),
+		traffic:         newTrafficTracker(5000),
+		decisions:       newDecisionTracker(50, 1000),
+	}
+	// Stop whatever was started if the config turns out to be bad.
+	defer func() {
+		if err != nil {
+			s.close()
+		}
+	}()
+
+	extraRoutes, err := compileRoutes(cfg.ExtraAllowedRoutes)
+	if err != nil {
+		return nil, fmt.Errorf("extra_allowed_routes: %w", err)
+	}
+	s.routes = slices.Concat(allowedRoutes, extraRoutes)
+	if s.denied, err = compilePatterns(cfg.DeniedRoutes); err != nil {
+		return nil, fmt.Errorf("denied_routes: %w", err)
+	}
+	if s.timeouts, err = compileTimeouts(cfg.UpstreamTimeouts); err != nil {
+		return nil, fmt.Errorf("upstream_timeouts: %w", err)
+	}
+	if s.listenTimeouts, err = compileServerTimeouts(cfg.ServerTimeouts); err != nil {
+		return nil, fmt.Errorf("server_timeouts: %w", err)
+	}
+	s.upstream = http.DefaultTransport.(*http.Transport).Clone()
+	if err := configureUpstreamConnections(s.upstream, cfg.UpstreamConnections); err != nil {
+		return nil, fmt.Errorf("upstream_connections: %w", err)
+	}
+	if cfg.RateLimit != nil && cfg.RateLimit.RequestsPerMinute > 0 {
+		s.rateLimit = newRateLimiter(*cfg.RateLimit)
+	}
+	if s.breakers, err = newBreakerSet(cfg.CircuitBreaker); err != nil {
+		return nil, fmt.Errorf("circuit_breaker: %w", err)
+	}
+	if s.budget, err = newRateBudget(cfg.RateBudget, s.rateLimits); err != nil {
+		return nil, fmt.Errorf("rate_budget: %w", err)
+	}
+	if s.responses, err = newResponseCache(cfg.ResponseCache); err != nil {
+		return nil, fmt.Errorf("response_cache: %w", err)
+	}
+	if s.responses != nil && s.responses.disk != nil {
+		go s.responses.disk.gcEvery(s.done, responseStoreGCInterval)
+	}
+	shared, err := newRedisClient(cfg.Redis)
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	if shared != nil {
+		if err := shared.ping(); err != nil && check {
+			return nil, fmt.Errorf("redis: %w", err)
+		} else if err != nil {
+			slog.Warn("redis unreachable; caches stay local until it is back", "redis", shared.String(), "error", err)
+		}
+		if s.responses != nil {
+			s.responses.shared = shared
+		}
+	}
+	if s.redact, err = newLogRedactor(cfg.LogPrivacy, LogPrivacyKeyPath()); err != nil {
+		return nil, fmt.Errorf("log_privacy: %w", err)
+	}
+	if err := cfg.checkValidationMode(); err != nil {
+		return nil, fmt.Errorf("validation_mode: %w", err)
+	}
+	if err := cfg.checkRequiredPermissions(); err != nil {
+		return nil, fmt.Errorf("required_permissions: %w", err)
+	}
+
+	if s.tracer, err = newTraceExporter(cfg.Tracing); err != nil {
+		return nil, fmt.Errorf("tracing: %w", err)
+	}
+	if s.tracer != nil {
+		go s.tracer.run()
+	}
+	if s.alerts, err = newAlerter(cfg.Alerts); err != nil {
+		return nil, fmt.Errorf("alerts: %w", err)
+	}
+
+	ttl, negativeTTL, err := cfg.validationTTLs()
+	if err != nil {
+		return nil, err
+	}
+
+	targets, pool, err := cfg.tokenTargets()
+	if err != nil {
+		return nil, err
+	}
+	s.pool.Store(pool)
+	var app struct {
+		Slug string `json:"slug"`
+	}
+	if cfg.GitHubApp != nil {
+		if s.app, err = newAppTokenSource(cfg.GitHubApp, cfg.APIBase()); err != nil {
+			return nil, fmt.Errorf("github_app: %w", err)
+		}
+		err := s.app.appRequest(context.Background(), "GET", cfg.APIBase()+"/app", &app)
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) && !check {
+			slog.Warn("could not verify github_app", "error", err)
+		} else if errors.As(err, &urlErr) {
+			return nil, fmt.Errorf("github_app: could not reach GitHub: %w", err)
+		} else if err != nil {
+			return nil, fmt.Errorf("github_app: GitHub rejected the app credentials: %w", err)
+		}
+	}
+	// A bad classic token would only surface as every proxied request
+	// failing, so refuse to start with one.
+	tokens := newClassicTokenMonitor(targets, s.rateLimits)
+	others := targets
+	if len(targets) > 0 {
+		// The first token stands in for the server: /readyz waits for it.
+		info, res, err := preflight(targets[0], s.rateLimits)
+		var urlErr *url.Error
+		switch {
+		case errors.As(err, &urlErr) && check:
+			return nil, fmt.Errorf("%s: could not reach GitHub: %w", tokenLabel(targets[0].name), err)
+		case errors.As(err, &urlErr):
+			slog.Warn("preflight: could not reach GitHub; /readyz reports not ready until it can", "error", err)
+			s.readiness.set(res, err)
+			go s.readiness.retry(s.done, targets[0], tokens, preflightRetryInterval)
+		case err != nil:
+			return nil, fmt.Errorf("%s: %w", tokenLabel(targets[0].name), err)
+		default:
+			tokens.record(targets[0].name, info)
+			s.readiness.set(res, nil)
+			s.preflightOK = true
+		}
+		others = targets[1:]
+	} else {
+		s.readiness.set(preflightResult{}, nil)
+	}
+	if err := tokens.check(others); err != nil {
+		return nil, err
+	}
+	go tokens.run(s.done, classicTokenCheckInterval)
+	if s.alerts != nil {
+		go s.alerts.run(s.done, tokens, &s.upstreamOutcomes, &s.validationOutcomes)
+	}
+
+	watchInterval, err := time.ParseDuration(cfg.WatchInterval)
+	if err != nil || watchInterval <= 0 {
+		watchInterval = 10 * time.Second
+	}
+
+	validator := NewValidator(ttl, negativeTTL, cfg.ValidationCacheSize)
+	validator.keys = newKeyStore(KeysPath())
+	validator.revoked = newRevocationList(RevocationsPath())
+	validator.shared = shared
+	validator.budget = s.budget
+	validator.outcomes = &s.validationOutcomes
+	validator.redact = s.redact
+	validator.httpClient.Transport = breakerTransport{traceTransport{s.upstream, s.tracer, &s.upstreamOutcomes, s.redact}, s.breakers}
+	if cfg.AuditLog != "" {
+		if validator.audit, err = openAuditLog(cfg.AuditLog, s.redact); err != nil {
+			return nil, fmt.Errorf("audit_log: %w", err)
+		}
+	}
+	if cfg.PersistValidationCache {
+		s.cachePath = ValidationCachePath()
+		n, err := validator.cache.load(s.cachePath)
+		if err != nil {
+			slog.Warn("validation cache not restored", "error", err)
+		} else if n > 0 {
+			slog.Info("restored cached validation results", "count", n, "path", s.cachePath)
+		}
+		go validator.cache.persistEvery(s.done, s.cachePath, time.Minute)
+	}
+	s.validator, s.tokens = validator, tokens
+	s.hub = newWatchHub(watchInterval, s.upstreamClient(s.upstream, s.timeouts.def))
+	s.stats = newServerStats()
+	s.ttl, s.negativeTTL, s.appSlug = ttl, negativeTTL, app.Slug
+	// Routing is rebuilt on SIGHUP; the middleware around it is not.
+	s.current = new(atomic.Pointer[http.ServeMux])
+	s.current.Store(s.mux(cfg))
+	mux := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.current.Load().ServeHTTP(w, r)
+	})
+
+	trustedProxies, err := parseCIDRs(cfg.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("trusted_proxies: %w", err)
+	}
+
+	// Behind a reverse proxy mounted at a sub-path, strip it before routing.
+	var handler http.Handler = mux
+	if cfg.BasePath != "" {
+		handler = http.StripPrefix(cfg.BasePath, mux)
+	}
+	handler, err = corsMiddleware(cfg.CORS, handler)
+	if err != nil {
+		return nil, fmt.Errorf("cors: %w", err)
+	}
+	s.handler = realIPMiddleware(trustedProxies, tracingMiddleware(s.tracer, statsMiddleware(s.stats, recoveryMiddleware(s.redact, handler))))
+	return s, nil
+}
+
+// close stops the server's background goroutines, flushes pending traces,
+// and saves the validation cache if it is persisted. Only the first call
+// does anything.
+func (s *server) close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		if s.tracer != nil {
+			s.tracer.shutdown()
+		}
+		if s.cachePath != "" && s.validator != nil {
+			err = s.validator.cache.save(s.cachePath)
+		}
+	})
+	return err
+}
+
+// ticks yields every interval until done is closed; the server's background
+// loops range over it.
+func ticks(done <-chan struct{}, interval time.Duration) iter.Seq[time.Time] {
+	return func(yield func(time.Time) bool) {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-t.C:
+				if !yield(now) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// reloadOn reloads the config each time hup fires or an admin allowlist
+// edit asks to, starting from cfg, until the server is closed. Requests
+// already in flight, including open watch streams, finish with the config
+// they started with.
+func (s *server) reloadOn(hup <-chan os.Signal, cfg *Config) {
+	live := cfg
+	for {
+		var done chan error
+		select {
+		case <-hup:
+		case done = <-s.reloads:
+		case <-s.done:
+			return
+		}
+		next, err := s.reloadConfig(live)
+		if err != nil {
+			slog.Error("reload failed; keeping the running config", "error", err)
+		} else {
+			live = next
+			s.current.Store(s.mux(live))
+			slog.Info("config reloaded", "path", ConfigPath())
+		}
+		if done != nil {
+			done <- err
+		}
+	}
+}
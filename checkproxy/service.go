@@ -0,0 +1,60 @@
+package checkproxy
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// serviceName is the Windows service and event log source the proxy
+// registers as.
+const serviceName = "gh-checkproxy"
+
+// runService implements `gh-checkproxy service install|uninstall|start|stop`.
+// Flags after install are passed to serve each time the service starts.
+func runService(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: gh-checkproxy service install [serve flags] | uninstall | start | stop")
+	}
+	switch args[0] {
+	case "install":
+		for _, a := range args[1:] {
+			if slices.Contains([]string{"background", "pid-file", "check"}, flagName(a)) {
+				return fmt.Errorf("service install: %s doesn't apply to a service", a)
+			}
+		}
+		if err := installService(args[1:]); err != nil {
+			return err
+		}
+		fmt.Printf("Installed the %s service; start it with 'gh-checkproxy service start'\n", serviceName)
+	case "uninstall":
+		if err := removeService(); err != nil {
+			return err
+		}
+		fmt.Printf("Removed the %s service\n", serviceName)
+	case "start":
+		if err := startService(); err != nil {
+			return err
+		}
+		fmt.Printf("%s service started\n", serviceName)
+	case "stop":
+		if err := stopService(); err != nil {
+			return err
+		}
+		fmt.Printf("%s service stopped\n", serviceName)
+	default:
+		return fmt.Errorf("unknown service command: %s", args[0])
+	}
+	return nil
+}
+
+// flagName returns the name in a "-name", "--name", or "--name=value"
+// argument, or "" if arg isn't a flag.
+func flagName(arg string) string {
+	if !strings.HasPrefix(arg, "-") {
+		return ""
+	}
+	name, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+	return name
+}
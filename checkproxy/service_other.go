@@ -0,0 +1,19 @@
+//go:build !windows
+
+package checkproxy
+
+import "errors"
+
+var errNoServices = errors.New("the service command is Windows only; elsewhere run serve under systemd or use serve --background")
+
+func isWindowsService() bool { return false }
+
+func runAsService(args []string) {}
+
+func installService(serveArgs []string) error { return errNoServices }
+
+func removeService() error { return errNoServices }
+
+func startService() error { return errNoServices }
+
+func stopService() error { return errNoServices }
@@ -0,0 +1,297 @@
+//go:build windows
+
+package checkproxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// isWindowsService reports whether the service manager started this process.
+func isWindowsService() bool {
+	ok, err := svc.IsWindowsService()
+	return err == nil && ok
+}
+
+// connectService opens the installed service. Managing services needs an
+// elevated prompt.
+func connectService() (*mgr.Mgr, *mgr.Service, error) {
+	m, err := mgr.Connect()
+	if err != nil {
+		return nil, nil, fmt.Errorf("connecting to the service manager: %w (run from an elevated prompt)", err)
+	}
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		m.Disconnect()
+		return nil, nil, fmt.Errorf("%s service is not installed: %w", serviceName, err)
+	}
+	return m, s, nil
+}
+
+// installService registers the service to run `serve` with serveArgs at
+// boot, as LocalSystem, and registers its event log source.
+func installService(serveArgs []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.Abs(exe); err != nil {
+		return err
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("connecting to the service manager: %w (run from an elevated prompt)", err)
+	}
+	defer m.Disconnect()
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return fmt.Errorf("%s service is already installed", serviceName)
+	}
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "gh-checkproxy",
+		Description: "GitHub Checks API proxy for fine-grained tokens",
+		StartType:   mgr.StartAutomatic,
+	}, append([]string{"serve"}, serveArgs...)...)
+	if err != nil {
+		return fmt.Errorf("creating service: %w", err)
+	}
+	defer s.Close()
+	// LocalSystem has its own profile; point it at the installing user's
+	// so the service reads the config written by `gh-checkproxy config`.
+	if home, err := os.UserHomeDir(); err == nil {
+		if err := setServiceEnv("USERPROFILE=" + home); err != nil {
+			s.Delete()
+			return fmt.Errorf("setting service environment: %w", err)
+		}
+	}
+	if err := eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		s.Delete()
+		return fmt.Errorf("registering event log source: %w", err)
+	}
+	return nil
+}
+
+// setServiceEnv sets the environment the service manager starts the
+// service with.
+func setServiceEnv(env ...string) error {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Services\`+serviceName, registry.SET_VALUE)
+	if err != nil {
+		return err
+	}
+	defer k.Close()
+	return k.SetStringsValue("Environment", env)
+}
+
+// removeService stops the service if it's running and unregisters it and
+// its event log source.
+func removeService() error {
+	m, s, err := connectService()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	defer s.Close()
+	if err := controlAndWait(s, svc.Stop, svc.Stopped); err != nil && !errors.Is(err, windows.ERROR_SERVICE_NOT_ACTIVE) {
+		return err
+	}
+	if err := s.Delete(); err != nil {
+		return fmt.Errorf("deleting service: %w", err)
+	}
+	eventlog.Remove(serviceName)
+	return nil
+}
+
+// startService starts the installed service and waits until it's running.
+func startService() error {
+	m, s, err := connectService()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	defer s.Close()
+	if err := s.Start(); err != nil {
+		return fmt.Errorf("starting service: %w", err)
+	}
+	if err := waitForState(s, svc.Running); err != nil {
+		return fmt.Errorf("%w; see the Application event log", err)
+	}
+	return nil
+}
+
+// stopService stops the service, letting in-flight requests finish.
+func stopService() error {
+	m, s, err := connectService()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	defer s.Close()
+	if err := controlAndWait(s, svc.Stop, svc.Stopped); errors.Is(err, windows.ERROR_SERVICE_NOT_ACTIVE) {
+		return fmt.Errorf("%s service is not running", serviceName)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+func controlAndWait(s *mgr.Service, c svc.Cmd, want svc.State) error {
+	if _, err := s.Control(c); err != nil {
+		return err
+	}
+	return waitForState(s, want)
+}
+
+// waitForState polls the service until it reaches want. The server finishes
+// in-flight requests for up to 5s on shutdown.
+func waitForState(s *mgr.Service, want svc.State) error {
+	for range 100 {
+		st, err := s.Query()
+		if err != nil {
+			return err
+		}
+		if st.State == want {
+			return nil
+		}
+		if st.State == svc.Stopped {
+			return fmt.Errorf("%s service stopped (exit code %d)", serviceName, st.Win32ExitCode)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("%s service did not reach the expected state within 10s", serviceName)
+}
+
+// runAsService serves under the service manager with args as serve's flags.
+// The server log and everything serve prints go to the Application event
+// log, since a service has no console.
+func runAsService(args []string) {
+	elog, err := eventlog.Open(serviceName)
+	if err != nil {
+		os.Exit(1)
+	}
+	defer elog.Close()
+	serviceLog = func(opts *slog.HandlerOptions) slog.Handler {
+		w := &eventLogWriter{elog: elog}
+		return &eventLogHandler{Handler: slog.NewTextHandler(w, opts), w: w}
+	}
+	if err := redirectToEventLog(&os.Stdout, elog.Info); err != nil {
+		elog.Error(1, err.Error())
+	}
+	if err := redirectToEventLog(&os.Stderr, elog.Error); err != nil {
+		elog.Error(1, err.Error())
+	}
+	if err := svc.Run(serviceName, proxyService{args: args}); err != nil {
+		elog.Error(1, fmt.Sprintf("%s service failed: %v", serviceName, err))
+		os.Exit(1)
+	}
+}
+
+// proxyService runs serve until the service manager asks it to stop.
+type proxyService struct {
+	args []string
+}
+
+func (p proxyService) Execute(_ []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	done, stop := make(chan struct{}), make(chan struct{})
+	go func() {
+		runServe(p.args, stop)
+		close(done)
+	}()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case <-done:
+			return false, 0
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				status <- r.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				close(stop)
+				<-done
+				return false, 0
+			}
+		}
+	}
+}
+
+// redirectToEventLog replaces *f with a pipe whose lines are written to the
+// event log with write.
+func redirectToEventLog(f **os.File, write func(uint32, string) error) error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	*f = w
+	go func() {
+		sc := bufio.NewScanner(r)
+		for sc.Scan() {
+			if line := sc.Text(); line != "" {
+				write(1, line)
+			}
+		}
+	}()
+	return nil
+}
+
+// eventLogHandler writes each log record as one event at the matching
+// event log level.
+type eventLogHandler struct {
+	slog.Handler
+	w *eventLogWriter
+}
+
+func (h *eventLogHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.w.mu.Lock()
+	defer h.w.mu.Unlock()
+	h.w.level = r.Level
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *eventLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &eventLogHandler{Handler: h.Handler.WithAttrs(attrs), w: h.w}
+}
+
+func (h *eventLogHandler) WithGroup(name string) slog.Handler {
+	return &eventLogHandler{Handler: h.Handler.WithGroup(name), w: h.w}
+}
+
+// eventLogWriter receives one formatted record per Write, at the level
+// eventLogHandler set.
+type eventLogWriter struct {
+	elog  *eventlog.Log
+	mu    sync.Mutex
+	level slog.Level
+}
+
+func (w *eventLogWriter) Write(p []byte) (int, error) {
+	msg := string(bytes.TrimRight(p, "\n"))
+	var err error
+	switch {
+	case w.level >= slog.LevelError:
+		err = w.elog.Error(1, msg)
+	case w.level >= slog.LevelWarn:
+		err = w.elog.Warning(1, msg)
+	default:
+		err = w.elog.Info(1, msg)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
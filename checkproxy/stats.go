@@ -0,0 +1,305 @@
+package checkproxy
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// serverStats counts the requests a running server has handled, for
+// status --live.
+type serverStats struct {
+	started  time.Time
+	requests atomic.Uint64
+	inFlight atomic.Int64
+	byClass  [6]atomic.Uint64 // by status code / 100
+}
+
+func newServerStats() *serverStats {
+	return &serverStats{started: time.Now()}
+}
+
+// statsMiddleware counts every request and its response status.
+func statsMiddleware(stats *serverStats, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stats.requests.Add(1)
+		stats.inFlight.Add(1)
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			stats.inFlight.Add(-1)
+			if class := cmp.Or(rec.status, http.StatusOK) / 100; class < len(stats.byClass) {
+				stats.byClass[class].Add(1)
+			}
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+type rateLimitKey struct {
+	token    string // fingerprint
+	resource string // core, graphql, search, ...
+}
+
+type rateLimitStatus struct {
+	Limit     int       `json:"limit"`
+	Remaining int       `json:"remaining"`
+	Reset     time.Time `json:"reset"`
+}
+
+// rateLimitTracker holds the rate limit GitHub last reported for each of a
+// server's own tokens.
+type rateLimitTracker struct {
+	mu   sync.Mutex
+	seen map[rateLimitKey]rateLimitStatus
+}
+
+func newRateLimitTracker() *rateLimitTracker {
+	return &rateLimitTracker{seen: make(map[rateLimitKey]rateLimitStatus)}
+}
+
+// record notes the rate limit headers of resp, the answer to req. A nil
+// tracker records nothing.
+func (t *rateLimitTracker) record(req *http.Request, resp *http.Response) {
+	if t == nil {
+		return
+	}
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return
+	}
+	limit, err1 := strconv.Atoi(resp.Header.Get("X-RateLimit-Limit"))
+	remaining, err2 := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+	reset, err3 := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return
+	}
+	key := rateLimitKey{token: tokenFingerprint(token), resource: cmp.Or(resp.Header.Get("X-RateLimit-Resource"), "core")}
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// A window that has reset says nothing any more; dropping those also
+	// forgets installation tokens that have been replaced.
+	maps.DeleteFunc(t.seen, func(_ rateLimitKey, s rateLimitStatus) bool { return s.Reset.Before(now) })
+	t.seen[key] = rateLimitStatus{Limit: limit, Remaining: remaining, Reset: time.Unix(reset, 0)}
+}
+
+// get returns the last status seen for key.
+func (t *rateLimitTracker) get(key rateLimitKey) (rateLimitStatus, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.seen[key]
+	return s, ok
+}
+
+func (t *rateLimitTracker) snapshot() map[rateLimitKey]rateLimitStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return maps.Clone(t.seen)
+}
+
+// tokenNames maps the fingerprint of each monitored token to its target name.
+func (m *classicTokenMonitor) tokenNames() map[string]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make(map[string]string, len(m.targets))
+	for _, t := range m.targets {
+		names[tokenFingerprint(t.token)] = t.name
+	}
+	return names
+}
+
+// watchCounts reports how many commits are being polled and how many
+// streams are subscribed to them.
+func (h *watchHub) watchCounts() (commits, subscribers int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, w := range h.watches {
+		subscribers += len(w.subs)
+	}
+	return len(h.watches), subscribers
+}
+
+// liveStats is the body of GET /v1/admin/stats.
+type liveStats struct {
+	Started         time.Time         `json:"started"`
+	UptimeSeconds   int64             `json:"uptime_seconds"`
+	Requests        requestStats      `json:"requests"`
+	ValidationCache cacheStats        `json:"validation_cache"`
+	RateLimits      []rateLimitReport `json:"rate_limits"`
+	Watches         watchStats        `json:"watches"`
+}
+
+type requestStats struct {
+	Total    uint64            `json:"total"`
+	InFlight int64             `json:"in_flight"`
+	ByStatus map[string]uint64 `json:"by_status"`
+}
+
+type rateLimitReport struct {
+	Token    string `json:"token"`
+	Resource string `json:"resource"`
+	rateLimitStatus
+}
+
+type watchStats struct {
+	Commits     int `json:"commits"`
+	Subscribers int `json:"subscribers"`
+}
+
+// statsHandler serves GET /v1/admin/stats.
+func statsHandler(srv *server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, collectLiveStats(srv))
+	}
+}
+
+// collectLiveStats snapshots the running server's counters.
+func collectLiveStats(srv *server) liveStats {
+	stats, tokens := srv.stats, srv.tokens
+	out := liveStats{
+		Started:         stats.started.UTC().Truncate(time.Second),
+		UptimeSeconds:   int64(time.Since(stats.started).Seconds()),
+		ValidationCache: srv.validator.CacheStats(),
+		RateLimits:      []rateLimitReport{},
+	}
+	out.Requests = requestStats{
+		Total:    stats.requests.Load(),
+		InFlight: stats.inFlight.Load(),
+		ByStatus: make(map[string]uint64),
+	}
+	for class := 1; class < len(stats.byClass); class++ {
+		if n := stats.byClass[class].Load(); n > 0 {
+			out.Requests.ByStatus[fmt.Sprintf("%dxx", class)] = n
+		}
+	}
+	names := tokens.tokenNames()
+	for key, s := range srv.rateLimits.snapshot() {
+		label := "GitHub App installation token"
+		if name, ok := names[key.token]; ok {
+			label = tokenLabel(name)
+		}
+		out.RateLimits = append(out.RateLimits, rateLimitReport{Token: label, Resource: key.resource, rateLimitStatus: s})
+	}
+	slices.SortFunc(out.RateLimits, func(a, b rateLimitReport) int {
+		return cmp.Or(cmp.Compare(a.Token, b.Token), cmp.Compare(a.Resource, b.Resource))
+	})
+	out.Watches.Commits, out.Watches.Subscribers = srv.hub.watchCounts()
+	return out
+}
+
+// printLiveStatus asks the server running on this host for its live stats.
+func printLiveStatus(cfg *Config) error {
+	var s liveStats
+	if _, err := adminRequest(cfg, "GET", "/v1/admin/stats", nil, &s); err != nil {
+		return err
+	}
+
+	fmt.Printf("Running server:\n\n")
+	fmt.Printf("  Uptime:         %s (since %s)\n", time.Duration(s.UptimeSeconds)*time.Second, s.Started.Local().Format("2006-01-02 15:04 MST"))
+	classes := slices.Sorted(maps.Keys(s.Requests.ByStatus))
+	byStatus := make([]string, 0, len(classes))
+	for _, c := range classes {
+		byStatus = append(byStatus, fmt.Sprintf("%s %d", c, s.Requests.ByStatus[c]))
+	}
+	fmt.Printf("  Requests:       %d (%d in flight)\n", s.Requests.Total, s.Requests.InFlight)
+	if len(byStatus) > 0 {
+		fmt.Printf("  By status:      %s\n", strings.Join(byStatus, ", "))
+	}
+	c := s.ValidationCache
+	rate := 0.0
+	if c.Hits+c.Misses > 0 {
+		rate = 100 * float64(c.Hits) / float64(c.Hits+c.Misses)
+	}
+	fmt.Printf("  Cache entries:  %d / %d\n", c.Entries, c.Capacity)
+	fmt.Printf("  Cache hits:     %d (%.1f%% hit rate)\n", c.Hits, rate)
+	fmt.Printf("  Cache misses:   %d\n", c.Misses)
+	fmt.Printf("  Evictions:      %d\n", c.Evictions)
+	fmt.Printf("  Watch streams:  %d on %d commits\n", s.Watches.Subscribers, s.Watches.Commits)
+	if len(s.RateLimits) == 0 {
+		fmt.Printf("  Rate limits:    none reported yet\n")
+	}
+	for _, l := range s.RateLimits {
+		fmt.Printf("  Rate limit:     %s (%s) %d/%d left, resets %s\n", l.Token, l.Resource, l.Remaining, l.Limit, l.Reset.Local().Format("15:04"))
+	}
+	return nil
+}
+
+// orgDecisions counts the authorization decisions for one owner.
+type orgDecisions struct {
+	Org     string `json:"org"`
+	Allowed uint64 `json:"allowed"`
+	Denied  uint64 `json:"denied"`
+}
+
+// decisionTracker records authorization decisions: the last few denials and
+// a count per owner. Owners come from request paths, so past maxOrgs new
+// ones are counted together under "(other)".
+type decisionTracker struct {
+	mu      sync.Mutex
+	denials []auditRecord // ring buffer; next is the oldest once full
+	next    int
+	orgs    map[string]*orgDecisions
+	maxOrgs int
+}
+
+func newDecisionTracker(denials, maxOrgs int) *decisionTracker {
+	return &decisionTracker{denials: make([]auditRecord, 0, denials), orgs: make(map[string]*orgDecisions), maxOrgs: maxOrgs}
+}
+
+func (t *decisionTracker) record(rec auditRecord) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := strings.ToLower(rec.Owner)
+	o, ok := t.orgs[key]
+	if !ok && len(t.orgs) >= t.maxOrgs {
+		key = "(other)"
+		o, ok = t.orgs[key]
+	}
+	if !ok {
+		o = &orgDecisions{Org: key}
+		t.orgs[key] = o
+	}
+	if rec.Result == "allow" {
+		o.Allowed++
+		return
+	}
+	o.Denied++
+	if len(t.denials) < cap(t.denials) {
+		t.denials = append(t.denials, rec)
+		return
+	}
+	t.denials[t.next] = rec
+	t.next = (t.next + 1) % len(t.denials)
+}
+
+// recentDenials returns the recorded denials, newest first.
+func (t *decisionTracker) recentDenials() []auditRecord {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]auditRecord, 0, len(t.denials))
+	for i := range len(t.denials) {
+		out = append(out, t.denials[(t.next+len(t.denials)-1-i)%len(t.denials)])
+	}
+	return out
+}
+
+// byOrg returns the decision counts per owner, busiest first.
+func (t *decisionTracker) byOrg() []orgDecisions {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]orgDecisions, 0, len(t.orgs))
+	for _, o := range t.orgs {
+		out = append(out, *o)
+	}
+	slices.SortFunc(out, func(a, b orgDecisions) int {
+		return cmp.Or(cmp.Compare(b.Allowed+b.Denied, a.Allowed+a.Denied), cmp.Compare(a.Org, b.Org))
+	})
+	return out
+}
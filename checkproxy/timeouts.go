@@ -0,0 +1,177 @@
+package checkproxy
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// UpstreamTimeouts bounds how long a request to GitHub may take, from
+// sending it to the end of the response body.
+type UpstreamTimeouts struct {
+	Default string `json:"default,omitempty"`
+	// Streaming applies to job log and artifact downloads.
+	Streaming string `json:"streaming,omitempty"`
+	// Routes override both for passthrough paths; the first match wins.
+	Routes []RouteTimeout `json:"routes,omitempty"`
+}
+
+// RouteTimeout is the timeout for passthrough paths matching Pattern.
+type RouteTimeout struct {
+	Pattern string `json:"pattern"`
+	Timeout string `json:"timeout"`
+}
+
+const (
+	defaultUpstreamTimeout  = 30 * time.Second
+	defaultStreamingTimeout = 5 * time.Minute
+)
+
+// timeoutPolicy is the compiled upstream_timeouts.
+type timeoutPolicy struct {
+	def, streaming time.Duration
+	routes         []routeTimeout
+}
+
+type routeTimeout struct {
+	pattern *regexp.Regexp
+	timeout time.Duration
+}
+
+// compileTimeouts parses c, filling in the defaults for what it leaves unset.
+func compileTimeouts(c *UpstreamTimeouts) (timeoutPolicy, error) {
+	p := timeoutPolicy{def: defaultUpstreamTimeout, streaming: defaultStreamingTimeout}
+	if c == nil {
+		return p, nil
+	}
+	var err error
+	if p.def, err = parseTimeout(c.Default, defaultUpstreamTimeout); err != nil {
+		return p, fmt.Errorf("default: %w", err)
+	}
+	if p.streaming, err = parseTimeout(c.Streaming, defaultStreamingTimeout); err != nil {
+		return p, fmt.Errorf("streaming: %w", err)
+	}
+	for _, rt := range c.Routes {
+		re, err := regexp.Compile(rt.Pattern)
+		if err != nil {
+			return p, fmt.Errorf("route %q: %w", rt.Pattern, err)
+		}
+		d, err := parseTimeout(rt.Timeout, 0)
+		if err != nil || d == 0 {
+			return p, fmt.Errorf("route %q: timeout must be a positive duration", rt.Pattern)
+		}
+		p.routes = append(p.routes, routeTimeout{pattern: re, timeout: d})
+	}
+	return p, nil
+}
+
+func parseTimeout(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%q is not a positive duration", s)
+	}
+	return d, nil
+}
+
+// forPath returns the timeout for a passthrough request to path.
+func (p timeoutPolicy) forPath(path string) time.Duration {
+	for _, rt := range p.routes {
+		if rt.pattern.MatchString(path) {
+			return rt.timeout
+		}
+	}
+	if matchesAny(streamingRoutes, path) {
+		return p.streaming
+	}
+	return p.def
+}
+
+// ServerTimeouts bounds how long clients may take over their side of a
+// connection, so slow or stalled ones can't hold connections open forever.
+// "0" turns one off.
+type ServerTimeouts struct {
+	// ReadHeader is the time to send the request headers (default 10s).
+	ReadHeader string `json:"read_header,omitempty"`
+	// Read is the time to send the whole request, body included (default 30s).
+	Read string `json:"read,omitempty"`
+	// Write is the time to receive the response (default 1m). Watch streams,
+	// long polls, and passthrough downloads get longer; see
+	// extendWriteDeadline.
+	Write string `json:"write,omitempty"`
+	// Idle is how long a keep-alive connection may wait for its next
+	// request (default 2m).
+	Idle string `json:"idle,omitempty"`
+}
+
+const (
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultReadTimeout       = 30 * time.Second
+	defaultWriteTimeout      = time.Minute
+	defaultIdleTimeout       = 2 * time.Minute
+)
+
+// serverTimeoutPolicy is the compiled server_timeouts.
+type serverTimeoutPolicy struct {
+	readHeader, read, write, idle time.Duration
+}
+
+// compileServerTimeouts parses c, filling in the defaults for what it leaves
+// unset.
+func compileServerTimeouts(c *ServerTimeouts) (serverTimeoutPolicy, error) {
+	p := serverTimeoutPolicy{readHeader: defaultReadHeaderTimeout, read: defaultReadTimeout,
+		write: defaultWriteTimeout, idle: defaultIdleTimeout}
+	if c == nil {
+		return p, nil
+	}
+	for _, f := range []struct {
+		name string
+		s    string
+		d    *time.Duration
+	}{
+		{"read_header", c.ReadHeader, &p.readHeader},
+		{"read", c.Read, &p.read},
+		{"write", c.Write, &p.write},
+		{"idle", c.Idle, &p.idle},
+	} {
+		if f.s == "" {
+			continue
+		}
+		d, err := time.ParseDuration(f.s)
+		if err != nil || d < 0 {
+			return p, fmt.Errorf("%s: %q is not a valid duration", f.name, f.s)
+		}
+		*f.d = d
+	}
+	return p, nil
+}
+
+// apply sets the timeouts on srv.
+func (p serverTimeoutPolicy) apply(srv *http.Server) {
+	srv.ReadHeaderTimeout = p.readHeader
+	srv.ReadTimeout = p.read
+	srv.WriteTimeout = p.write
+	srv.IdleTimeout = p.idle
+}
+
+func (p serverTimeoutPolicy) String() string {
+	show := func(d time.Duration) string {
+		if d == 0 {
+			return "none"
+		}
+		return d.String()
+	}
+	return fmt.Sprintf("headers %s, read %s, write %s, idle %s", show(p.readHeader), show(p.read), show(p.write), show(p.idle))
+}
+
+// extendWriteDeadline gives a response that legitimately runs long d more
+// than the write timeout, counted from now.
+func (p serverTimeoutPolicy) extendWriteDeadline(w http.ResponseWriter, d time.Duration) {
+	if p.write == 0 {
+		return
+	}
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(p.write + d))
+}
@@ -0,0 +1,136 @@
+package checkproxy
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Classic tokens are rechecked this often while the server runs, and warned
+// about once they are this close to expiring.
+const (
+	classicTokenCheckInterval = 6 * time.Hour
+	classicTokenWarnBefore    = 14 * 24 * time.Hour
+)
+
+// classicTokenMonitor tracks when the server's classic tokens expire so
+// operators can rotate them before every proxied request starts failing.
+type classicTokenMonitor struct {
+	mu      sync.Mutex
+	targets []upstreamTarget
+	expires map[string]time.Time // by upstream name ("" is the default, "org:x" an org token); zero means no expiry
+	limits  *rateLimitTracker    // the server's, which the checks' answers are recorded in
+}
+
+func newClassicTokenMonitor(targets []upstreamTarget, limits *rateLimitTracker) *classicTokenMonitor {
+	return &classicTokenMonitor{targets: targets, expires: make(map[string]time.Time), limits: limits}
+}
+
+// Monitored targets that aren't upstreams are named with these prefixes.
+const (
+	orgTokenPrefix  = "org:"
+	poolTokenPrefix = "pool:"
+)
+
+// tokenLabel names an upstream's or org's token in messages.
+func tokenLabel(name string) string {
+	if name == "" {
+		return "classic token"
+	}
+	if org, ok := strings.CutPrefix(name, orgTokenPrefix); ok {
+		return "org " + org + " token"
+	}
+	if n, ok := strings.CutPrefix(name, poolTokenPrefix); ok {
+		return "pooled classic token " + n
+	}
+	return "upstream " + name + " token"
+}
+
+// record stores what GitHub reported for the named upstream's token and
+// warns if it expires soon.
+func (m *classicTokenMonitor) record(name string, info classicTokenInfo) {
+	m.mu.Lock()
+	m.expires[name] = info.Expires
+	m.mu.Unlock()
+	if !info.Expires.IsZero() {
+		if left := time.Until(info.Expires); left < classicTokenWarnBefore {
+			slog.Warn(tokenLabel(name)+" expires soon; rotate it before clients get 502s",
+				"expires_in", formatLifetime(left), "expires", info.Expires.Local().Format("2006-01-02 15:04 MST"))
+		}
+	}
+}
+
+// check verifies each target's token with GitHub and records its expiry. A
+// token GitHub rejects is an error; if GitHub can't be reached the token may
+// well be fine, so that only warns.
+func (m *classicTokenMonitor) check(targets []upstreamTarget) error {
+	for _, t := range targets {
+		info, err := checkClassicToken(t.apiBase, t.token, m.limits)
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			slog.Warn("could not verify "+tokenLabel(t.name), "error", err)
+		} else if err != nil {
+			return fmt.Errorf("%s: %w", tokenLabel(t.name), err)
+		} else {
+			m.record(t.name, info)
+		}
+	}
+	return nil
+}
+
+// setTargets replaces the monitored tokens, forgetting the expiry of any
+// that are no longer configured.
+func (m *classicTokenMonitor) setTargets(targets []upstreamTarget) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.targets = targets
+	maps.DeleteFunc(m.expires, func(name string, _ time.Time) bool {
+		return !slices.ContainsFunc(targets, func(t upstreamTarget) bool { return t.name == name })
+	})
+}
+
+// run rechecks every token at each interval until done is closed, warning
+// about tokens that are close to expiry or no longer accepted.
+func (m *classicTokenMonitor) run(done <-chan struct{}, interval time.Duration) {
+	for range ticks(done, interval) {
+		m.mu.Lock()
+		targets := m.targets
+		m.mu.Unlock()
+		for _, t := range targets {
+			info, err := checkClassicToken(t.apiBase, t.token, m.limits)
+			if err != nil {
+				slog.Warn(tokenLabel(t.name)+" check failed", "error", err)
+				continue
+			}
+			m.record(t.name, info)
+		}
+	}
+}
+
+// expiries returns a copy of the known expiry times by upstream name.
+func (m *classicTokenMonitor) expiries() map[string]time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make(map[string]time.Time, len(m.expires))
+	for k, v := range m.expires {
+		out[k] = v
+	}
+	return out
+}
+
+// formatLifetime renders a remaining lifetime in days, or hours when short.
+func formatLifetime(d time.Duration) string {
+	if d <= 0 {
+		return "0h (expired)"
+	}
+	if d < 48*time.Hour {
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	}
+	return fmt.Sprintf("%d days", int(d.Hours()/24))
+}
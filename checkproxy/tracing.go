@@ -0,0 +1,379 @@
+package checkproxy
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	mrand "math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TracingConfig exports OpenTelemetry traces over OTLP/HTTP (JSON encoding),
+// which every OpenTelemetry Collector accepts on port 4318.
+type TracingConfig struct {
+	// OTLPEndpoint is the collector's base URL, e.g. http://otel-collector:4318;
+	// /v1/traces is appended unless already present.
+	OTLPEndpoint string            `json:"otlp_endpoint,omitempty"`
+	Headers      map[string]string `json:"headers,omitempty"`
+	ServiceName  string            `json:"service_name,omitempty"`
+	// SampleRatio is the share of new traces recorded (default 1). Requests
+	// arriving with a traceparent follow the caller's sampling decision.
+	SampleRatio *float64 `json:"sample_ratio,omitempty"`
+}
+
+// Span kinds, as numbered by OTLP.
+const (
+	spanInternal = 1
+	spanServer   = 2
+	spanClient   = 3
+)
+
+// Spans are exported in batches of up to traceBatchSize, at least every
+// traceFlushInterval. When the collector falls behind, spans beyond
+// traceQueueSize are dropped rather than slowing requests down.
+const (
+	traceBatchSize     = 512
+	traceFlushInterval = 5 * time.Second
+	traceQueueSize     = 4096
+)
+
+// newTraceExporter returns an exporter for cfg, with the standard
+// OTEL_EXPORTER_OTLP_ENDPOINT, OTEL_EXPORTER_OTLP_HEADERS, and
+// OTEL_SERVICE_NAME variables filling in what cfg leaves unset. It returns
+// nil when no endpoint is configured either way.
+func newTraceExporter(cfg *TracingConfig) (*traceExporter, error) {
+	var c TracingConfig
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.OTLPEndpoint == "" {
+		c.OTLPEndpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+	if c.OTLPEndpoint == "" {
+		if cfg != nil {
+			return nil, fmt.Errorf("otlp_endpoint is required")
+		}
+		return nil, nil
+	}
+	if c.Headers == nil {
+		c.Headers = make(map[string]string)
+		for _, kv := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+			if k, v, ok := strings.Cut(kv, "="); ok {
+				c.Headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+			}
+		}
+	}
+	if c.ServiceName == "" {
+		c.ServiceName = cmp.Or(os.Getenv("OTEL_SERVICE_NAME"), "gh-checkproxy")
+	}
+	ratio := 1.0
+	if c.SampleRatio != nil {
+		ratio = *c.SampleRatio
+	}
+	if ratio < 0 || ratio > 1 {
+		return nil, fmt.Errorf("sample_ratio must be between 0 and 1")
+	}
+	endpoint := strings.TrimSuffix(c.OTLPEndpoint, "/")
+	if !strings.HasSuffix(endpoint, "/v1/traces") {
+		endpoint += "/v1/traces"
+	}
+	return &traceExporter{
+		endpoint:    endpoint,
+		headers:     c.Headers,
+		serviceName: c.ServiceName,
+		sampleRatio: ratio,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		queue:       make(chan *span, traceQueueSize),
+		done:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}, nil
+}
+
+// traceExporter batches finished spans and posts them to the collector.
+type traceExporter struct {
+	endpoint    string
+	headers     map[string]string
+	serviceName string
+	sampleRatio float64
+	client      *http.Client
+
+	queue   chan *span
+	done    chan struct{} // closed by shutdown
+	stopped chan struct{} // closed once the last batch is out
+}
+
+// span is one timed operation in a trace. A nil *span is valid and records
+// nothing, so call sites don't need to check whether tracing is on.
+type span struct {
+	exporter *traceExporter
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+	end      time.Time
+
+	mu     sync.Mutex
+	attrs  []otlpAttr
+	errMsg string
+}
+
+type spanContextKey struct{}
+
+// tracerContextKey carries the exporter of the server a request came to.
+// Without one, spans are no-ops.
+type tracerContextKey struct{}
+
+// withTracer returns ctx exporting spans to t, or ctx as is when t is nil.
+func withTracer(ctx context.Context, t *traceExporter) context.Context {
+	if t == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, tracerContextKey{}, t)
+}
+
+// remoteParent is a caller's span, parsed from an incoming traceparent.
+type remoteParent struct {
+	traceID [16]byte
+	spanID  [8]byte
+	sampled bool
+}
+
+// startSpan starts a span as a child of the span in ctx, or a new trace when
+// there is none, and returns a context carrying it. It returns a nil span
+// when tracing is off or the trace isn't sampled.
+func startSpan(ctx context.Context, name string, kind int) (context.Context, *span) {
+	tracer, _ := ctx.Value(tracerContextKey{}).(*traceExporter)
+	if tracer == nil {
+		return ctx, nil
+	}
+	s := &span{exporter: tracer, name: name, kind: kind, start: time.Now()}
+	switch parent := ctx.Value(spanContextKey{}).(type) {
+	case *span:
+		s.traceID, s.parentID = parent.traceID, parent.spanID
+	case remoteParent:
+		if !parent.sampled {
+			return ctx, nil
+		}
+		s.traceID, s.parentID = parent.traceID, parent.spanID
+	case nil:
+		if tracer.sampleRatio < 1 && mrand.Float64() >= tracer.sampleRatio {
+			// Remember the decision so the trace's other spans drop too.
+			return context.WithValue(ctx, spanContextKey{}, remoteParent{}), nil
+		}
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+	return context.WithValue(ctx, spanContextKey{}, s), s
+}
+
+// set records an attribute on the span.
+func (s *span) set(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs = append(s.attrs, otlpAttr{Key: key, Value: otlpValue(value)})
+	s.mu.Unlock()
+}
+
+// fail marks the span as failed with err.
+func (s *span) fail(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.errMsg = err.Error()
+	s.mu.Unlock()
+}
+
+// finish ends the span and queues it for export.
+func (s *span) finish() {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	select {
+	case s.exporter.queue <- s:
+	default: // queue full; drop
+	}
+}
+
+// parseTraceparent reads a W3C traceparent header.
+func parseTraceparent(h string) (remoteParent, bool) {
+	parts := strings.Split(h, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return remoteParent{}, false
+	}
+	var p remoteParent
+	if _, err := hex.Decode(p.traceID[:], []byte(parts[1])); err != nil || p.traceID == [16]byte{} {
+		return remoteParent{}, false
+	}
+	if _, err := hex.Decode(p.spanID[:], []byte(parts[2])); err != nil || p.spanID == [8]byte{} {
+		return remoteParent{}, false
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return remoteParent{}, false
+	}
+	p.sampled = flags&1 == 1
+	return p, true
+}
+
+// tracingMiddleware starts a server span for each request, continuing the
+// caller's trace when it sends traceparent, and has the spans started while
+// serving it exported by tracer.
+func tracingMiddleware(tracer *traceExporter, next http.Handler) http.Handler {
+	if tracer == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := withTracer(r.Context(), tracer)
+		if parent, ok := parseTraceparent(r.Header.Get("Traceparent")); ok {
+			ctx = context.WithValue(ctx, spanContextKey{}, parent)
+		}
+		ctx, s := startSpan(ctx, r.Method, spanServer)
+		if s == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		s.set("http.request.method", r.Method)
+		s.set("url.path", r.URL.Path)
+		s.set("client.address", requestSource(r))
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			status := cmp.Or(rec.status, http.StatusOK)
+			s.set("http.response.status_code", status)
+			if status >= 500 {
+				s.fail(fmt.Errorf("%d %s", status, http.StatusText(status)))
+			}
+			s.finish()
+		}()
+		next.ServeHTTP(rec, r.WithContext(ctx))
+	})
+}
+
+// run exports queued spans until shutdown is called.
+func (e *traceExporter) run() {
+	defer close(e.stopped)
+	ticker := time.NewTicker(traceFlushInterval)
+	defer ticker.Stop()
+	var batch []*span
+	for {
+		select {
+		case s := <-e.queue:
+			batch = append(batch, s)
+			if len(batch) < traceBatchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-e.done:
+			for len(e.queue) > 0 {
+				batch = append(batch, <-e.queue)
+			}
+			e.export(batch)
+			return
+		}
+		e.export(batch)
+		batch = batch[:0]
+	}
+}
+
+// shutdown exports the spans still queued and waits for the export.
+func (e *traceExporter) shutdown() {
+	close(e.done)
+	<-e.stopped
+}
+
+type otlpAttr struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+func otlpValue(v any) map[string]any {
+	switch v := v.(type) {
+	case bool:
+		return map[string]any{"boolValue": v}
+	case int:
+		return map[string]any{"intValue": strconv.Itoa(v)}
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		return map[string]any{"doubleValue": v}
+	default:
+		return map[string]any{"stringValue": fmt.Sprint(v)}
+	}
+}
+
+func (e *traceExporter) export(batch []*span) {
+	if len(batch) == 0 {
+		return
+	}
+	spans := make([]map[string]any, 0, len(batch))
+	for _, s := range batch {
+		s.mu.Lock()
+		out := map[string]any{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.spanID[:]),
+			"name":              s.name,
+			"kind":              s.kind,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        s.attrs,
+		}
+		if s.parentID != [8]byte{} {
+			out["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+		}
+		if s.errMsg != "" {
+			out["status"] = map[string]any{"code": 2, "message": s.errMsg}
+		}
+		s.mu.Unlock()
+		spans = append(spans, out)
+	}
+	ver, _ := buildVersion()
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{"attributes": []otlpAttr{
+				{Key: "service.name", Value: otlpValue(e.serviceName)},
+				{Key: "service.version", Value: otlpValue(ver)},
+			}},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "gh-checkproxy"},
+				"spans": spans,
+			}},
+		}},
+	})
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequest("POST", e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		slog.Warn("exporting traces failed", "error", err, "spans", len(batch))
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		slog.Warn("exporting traces failed", "status", resp.StatusCode, "spans", len(batch))
+	}
+}
@@ -0,0 +1,232 @@
+package checkproxy
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// repoTraffic is the traffic of one repository, or of one owner when Repo is
+// empty.
+type repoTraffic struct {
+	Owner string `json:"owner"`
+	Repo  string `json:"repo,omitempty"`
+	// Requests counts requests that passed authorization.
+	Requests uint64 `json:"requests"`
+	// ResponseBytes counts body bytes proxied back to clients; event streams
+	// aren't included.
+	ResponseBytes uint64 `json:"response_bytes"`
+	// UpstreamRequests counts requests sent to GitHub with the server's
+	// tokens, including retries and the polling behind watches.
+	UpstreamRequests uint64 `json:"upstream_requests"`
+	// RateLimitUsed counts the upstream requests GitHub charged against a
+	// rate limit: conditional requests answered 304 are free, and a GraphQL
+	// query counts once whatever its point cost.
+	RateLimitUsed uint64 `json:"rate_limit_used"`
+}
+
+func (t *repoTraffic) add(o repoTraffic) {
+	t.Requests += o.Requests
+	t.ResponseBytes += o.ResponseBytes
+	t.UpstreamRequests += o.UpstreamRequests
+	t.RateLimitUsed += o.RateLimitUsed
+}
+
+// trafficTracker keeps a repoTraffic per repository. Only authorized
+// repositories are counted, but past maxRepos new ones are counted together
+// under "(other)/(other)" anyway.
+type trafficTracker struct {
+	mu       sync.Mutex
+	since    time.Time
+	repos    map[string]*repoTraffic
+	maxRepos int
+}
+
+func newTrafficTracker(maxRepos int) *trafficTracker {
+	return &trafficTracker{since: time.Now(), repos: make(map[string]*repoTraffic), maxRepos: maxRepos}
+}
+
+// entry returns the counters for owner/repo; t.mu must be held.
+func (t *trafficTracker) entry(owner, repo string) *repoTraffic {
+	owner, repo = strings.ToLower(owner), strings.ToLower(repo)
+	key := owner + "/" + repo
+	e, ok := t.repos[key]
+	if !ok && len(t.repos) >= t.maxRepos {
+		owner, repo, key = "(other)", "(other)", "(other)/(other)"
+		e, ok = t.repos[key]
+	}
+	if !ok {
+		e = &repoTraffic{Owner: owner, Repo: repo}
+		t.repos[key] = e
+	}
+	return e
+}
+
+// served counts an authorized request for owner/repo.
+func (t *trafficTracker) served(owner, repo string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entry(owner, repo).Requests++
+}
+
+// sent counts n response body bytes proxied for owner/repo.
+func (t *trafficTracker) sent(owner, repo string, n int64) {
+	if n <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entry(owner, repo).ResponseBytes += uint64(n)
+}
+
+// upstream counts req, sent to GitHub and answered with resp, against the
+// repository it was made for. Requests for no repository aren't counted.
+func (t *trafficTracker) upstream(req *http.Request, resp *http.Response) {
+	owner, repo, ok := trafficRepo(req)
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e := t.entry(owner, repo)
+	e.UpstreamRequests++
+	if resp.StatusCode != http.StatusNotModified && resp.Header.Get("X-RateLimit-Remaining") != "" {
+		e.RateLimitUsed++
+	}
+}
+
+// byRepo returns the traffic per repository, busiest first.
+func (t *trafficTracker) byRepo() []repoTraffic {
+	t.mu.Lock()
+	out := make([]repoTraffic, 0, len(t.repos))
+	for _, e := range t.repos {
+		out = append(out, *e)
+	}
+	t.mu.Unlock()
+	sortTraffic(out)
+	return out
+}
+
+// byOrg returns the traffic summed per owner, busiest first.
+func (t *trafficTracker) byOrg() []repoTraffic {
+	orgs := make(map[string]*repoTraffic)
+	for _, e := range t.byRepo() {
+		o, ok := orgs[e.Owner]
+		if !ok {
+			o = &repoTraffic{Owner: e.Owner}
+			orgs[e.Owner] = o
+		}
+		o.add(e)
+	}
+	out := make([]repoTraffic, 0, len(orgs))
+	for _, o := range orgs {
+		out = append(out, *o)
+	}
+	sortTraffic(out)
+	return out
+}
+
+func sortTraffic(s []repoTraffic) {
+	slices.SortFunc(s, func(a, b repoTraffic) int {
+		return cmp.Or(cmp.Compare(b.Requests, a.Requests), cmp.Compare(b.UpstreamRequests, a.UpstreamRequests),
+			cmp.Compare(a.Owner, b.Owner), cmp.Compare(a.Repo, b.Repo))
+	})
+}
+
+// trafficRepoKey is the context key under which a handler names the
+// repository an upstream request is made for when its URL doesn't, as for
+// GraphQL.
+type trafficRepoKey struct{}
+
+func withTrafficRepo(ctx context.Context, owner, repo string) context.Context {
+	return context.WithValue(ctx, trafficRepoKey{}, [2]string{owner, repo})
+}
+
+// trafficRepo returns the repository req is made for: the one named in its
+// context, else the one in its /repos/{owner}/{repo} path (after any GHES
+// /api/v3 prefix).
+func trafficRepo(req *http.Request) (owner, repo string, ok bool) {
+	if r, ok := req.Context().Value(trafficRepoKey{}).([2]string); ok {
+		return r[0], r[1], true
+	}
+	if p := req.URL.EscapedPath(); strings.Contains(p, "/repos/") {
+		return extractOwnerRepo(p[strings.Index(p, "/repos/"):])
+	}
+	return "", "", false
+}
+
+// trafficReport is the body of GET /v1/admin/traffic.
+type trafficReport struct {
+	Since time.Time     `json:"since"`
+	Orgs  []repoTraffic `json:"orgs"`
+	Repos []repoTraffic `json:"repos"`
+}
+
+func trafficHandler(traffic *trafficTracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, trafficReport{
+			Since: traffic.since,
+			Orgs:  traffic.byOrg(),
+			Repos: traffic.byRepo(),
+		})
+	}
+}
+
+// printTraffic asks the server running on this host for its traffic per org
+// and repository and prints the busiest top repositories (all when top is 0).
+func printTraffic(cfg *Config, top int) error {
+	var rep trafficReport
+	if _, err := adminRequest(cfg, "GET", "/v1/admin/traffic", nil, &rep); err != nil {
+		return err
+	}
+	fmt.Printf("Traffic since %s:\n\n", rep.Since.Local().Format("2006-01-02 15:04 MST"))
+	if len(rep.Repos) == 0 {
+		fmt.Println("  No requests yet.")
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	row := func(name string, t repoTraffic) {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%d\t%d\n", name, t.Requests, formatBytes(t.ResponseBytes), t.UpstreamRequests, t.RateLimitUsed)
+	}
+	fmt.Fprintln(tw, "ORG\tREQUESTS\tBYTES\tUPSTREAM\tRATE LIMIT")
+	for _, o := range rep.Orgs {
+		row(o.Owner, o)
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "REPOSITORY\tREQUESTS\tBYTES\tUPSTREAM\tRATE LIMIT")
+	repos := rep.Repos
+	if top > 0 && len(repos) > top {
+		repos = repos[:top]
+	}
+	for _, r := range repos {
+		row(r.Owner+"/"+r.Repo, r)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(repos) < len(rep.Repos) {
+		fmt.Printf("\n%d more repositories; pass --top 0 to list them all.\n", len(rep.Repos)-len(repos))
+	}
+	return nil
+}
+
+// formatBytes renders n with a binary unit, e.g. 1.5 MiB.
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
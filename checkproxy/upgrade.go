@@ -0,0 +1,350 @@
+package checkproxy
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// releasesURL lists the project's published releases.
+const releasesURL = "https://api.github.com/repos/bycli/gh-checkproxy/releases"
+
+// maxReleaseAssetBytes bounds a download; release archives are ~10 MB.
+const maxReleaseAssetBytes = 200 << 20
+
+// releaseSigningKey is the base64 Ed25519 public key whose private half
+// signs each release's checksums.txt (see .goreleaser.yml). It is pinned
+// here rather than fetched, so whoever can publish release assets still
+// can't get a binary of their own installed.
+const releaseSigningKey = "wr1/fIyamIb3b8gkZ1IG8yxviRO79BbQxCVbjdM6mqo="
+
+type githubRelease struct {
+	TagName string `json:"tag_name"`
+	Assets  []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// assetURL returns the download URL of the release asset called name.
+func (r githubRelease) assetURL(name string) (string, bool) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a.URL, true
+		}
+	}
+	return "", false
+}
+
+// runUpgrade implements `gh-checkproxy upgrade`: replace this binary with the
+// latest release (or --version) for this platform, after checking the
+// release's checksums.txt against its signature and the archive against
+// checksums.txt.
+func runUpgrade(args []string) error {
+	fs := flag.NewFlagSet("upgrade", flag.ContinueOnError)
+	check := fs.Bool("check", false, "Only report whether a newer release exists")
+	want := fs.String("version", "", "Install this release tag instead of the latest, e.g. v1.4.0")
+	force := fs.Bool("force", false, "Replace development and package-managed binaries too")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return errors.New("usage: gh-checkproxy upgrade [--check] [--version <tag>] [--force]")
+	}
+
+	current, _ := buildVersion()
+	client := &http.Client{Timeout: 2 * time.Minute}
+	rel, err := fetchRelease(client, *want)
+	if err != nil {
+		return err
+	}
+	latest := strings.TrimPrefix(rel.TagName, "v")
+	if *want == "" && current != "dev" && compareVersions(current, latest) >= 0 {
+		fmt.Printf("gh-checkproxy %s is up to date.\n", current)
+		return nil
+	}
+	if *check {
+		fmt.Printf("gh-checkproxy %s is available (this is %s); run 'gh-checkproxy upgrade' to install it.\n", latest, current)
+		return nil
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	if !*force {
+		if current == "dev" {
+			return errors.New("this is a development build; pass --force to replace it with a release")
+		}
+		if manager := packageManager(exe); manager != "" {
+			return fmt.Errorf("%s was installed by %s; upgrade it there (or pass --force)", exe, manager)
+		}
+	}
+
+	archive := fmt.Sprintf("gh-checkproxy_%s_%s.tar.gz", runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		archive = strings.TrimSuffix(archive, ".tar.gz") + ".zip"
+	}
+	archiveURL, ok := rel.assetURL(archive)
+	if !ok {
+		return fmt.Errorf("release %s has no build for %s/%s", rel.TagName, runtime.GOOS, runtime.GOARCH)
+	}
+	sumsURL, ok := rel.assetURL("checksums.txt")
+	if !ok {
+		return fmt.Errorf("release %s has no checksums.txt; refusing to install an unverified binary", rel.TagName)
+	}
+	sigURL, ok := rel.assetURL("checksums.txt.sig")
+	if !ok {
+		return fmt.Errorf("release %s has no checksums.txt.sig; refusing to install an unverified binary", rel.TagName)
+	}
+	sums, err := download(client, sumsURL)
+	if err != nil {
+		return err
+	}
+	sig, err := download(client, sigURL)
+	if err != nil {
+		return err
+	}
+	if err := verifyChecksums(sums, sig); err != nil {
+		return fmt.Errorf("release %s: %w", rel.TagName, err)
+	}
+	wantSum, err := findChecksum(sums, archive)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Downloading %s %s...\n", archive, rel.TagName)
+	data, err := download(client, archiveURL)
+	if err != nil {
+		return err
+	}
+	if got := sha256.Sum256(data); hex.EncodeToString(got[:]) != wantSum {
+		return fmt.Errorf("%s: checksum mismatch (got %x, checksums.txt says %s)", archive, got, wantSum)
+	}
+	bin, err := extractBinary(archive, data)
+	if err != nil {
+		return err
+	}
+	if err := replaceExecutable(exe, bin); err != nil {
+		return err
+	}
+	fmt.Printf("Upgraded %s from %s to %s.\n", exe, current, latest)
+	if p, _ := readPIDFile(ServerPIDPath()); p != nil {
+		fmt.Println("The background server keeps running the old version until 'gh-checkproxy restart'.")
+	}
+	return nil
+}
+
+// fetchRelease returns the latest release, or the one tagged tag.
+func fetchRelease(client *http.Client, tag string) (githubRelease, error) {
+	u := releasesURL + "/latest"
+	if tag != "" {
+		if !strings.HasPrefix(tag, "v") {
+			tag = "v" + tag
+		}
+		u = releasesURL + "/tags/" + tag
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return githubRelease{}, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	resp, err := client.Do(req)
+	if err != nil {
+		return githubRelease{}, fmt.Errorf("checking releases: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && tag != "" {
+		return githubRelease{}, fmt.Errorf("no release tagged %s", tag)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return githubRelease{}, fmt.Errorf("checking releases: GitHub returned %d", resp.StatusCode)
+	}
+	var rel githubRelease
+	if err := json.NewDecoder(resp.Body).Decode(&rel); err != nil {
+		return githubRelease{}, fmt.Errorf("checking releases: %w", err)
+	}
+	return rel, nil
+}
+
+func download(client *http.Client, u string) ([]byte, error) {
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, fmt.Errorf("downloading %s: %w", u, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("downloading %s: %s", u, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxReleaseAssetBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("downloading %s: %w", u, err)
+	}
+	if len(data) > maxReleaseAssetBytes {
+		return nil, fmt.Errorf("downloading %s: larger than %d MB", u, maxReleaseAssetBytes>>20)
+	}
+	return data, nil
+}
+
+// verifyChecksums checks sig, a base64 Ed25519 signature, over the
+// checksums file sums against releaseSigningKey.
+func verifyChecksums(sums, sig []byte) error {
+	key, err := base64.StdEncoding.DecodeString(releaseSigningKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return errors.New("this build has no valid release signing key")
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil || !ed25519.Verify(key, sums, raw) {
+		return errors.New("checksums.txt isn't signed with the release key; refusing to install it")
+	}
+	return nil
+}
+
+// findChecksum returns the SHA-256 that a sha256sum-format checksums file
+// lists for name.
+func findChecksum(sums []byte, name string) (string, error) {
+	sc := bufio.NewScanner(bytes.NewReader(sums))
+	for sc.Scan() {
+		sum, file, ok := strings.Cut(strings.TrimSpace(sc.Text()), "  ")
+		if ok && file == name && len(sum) == 64 {
+			return strings.ToLower(sum), nil
+		}
+	}
+	return "", fmt.Errorf("checksums.txt doesn't list %s", name)
+}
+
+// extractBinary returns the gh-checkproxy executable from a release archive.
+func extractBinary(archive string, data []byte) ([]byte, error) {
+	name := "gh-checkproxy"
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	if strings.HasSuffix(archive, ".zip") {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", archive, err)
+		}
+		for _, f := range zr.File {
+			if filepath.Base(f.Name) == name {
+				rc, err := f.Open()
+				if err != nil {
+					return nil, err
+				}
+				defer rc.Close()
+				return io.ReadAll(io.LimitReader(rc, maxReleaseAssetBytes))
+			}
+		}
+		return nil, fmt.Errorf("%s doesn't contain %s", archive, name)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", archive, err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s doesn't contain %s", archive, name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", archive, err)
+		}
+		if h.Typeflag == tar.TypeReg && filepath.Base(h.Name) == name {
+			return io.ReadAll(io.LimitReader(tr, maxReleaseAssetBytes))
+		}
+	}
+}
+
+// replaceExecutable swaps bin in for the executable at exe. The new file is
+// written next to it first so the swap is a rename; Windows can't replace a
+// running executable, so there the old one is moved aside to exe.old.
+func replaceExecutable(exe string, bin []byte) error {
+	tmp := exe + ".new"
+	if err := os.WriteFile(tmp, bin, 0755); err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			return fmt.Errorf("can't write to %s: %w (rerun with the permissions that installed it)", filepath.Dir(exe), err)
+		}
+		return err
+	}
+	if runtime.GOOS == "windows" {
+		old := exe + ".old"
+		os.Remove(old)
+		if err := os.Rename(exe, old); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := os.Rename(tmp, exe); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// packageManager names the package manager that owns exe, if it looks like
+// one does; those installs should be upgraded through it.
+func packageManager(exe string) string {
+	switch {
+	case strings.Contains(exe, "/Cellar/") || strings.Contains(exe, "/homebrew/"):
+		return "Homebrew"
+	case exe == "/usr/bin/gh-checkproxy":
+		return "apt"
+	case strings.HasPrefix(exe, "/snap/"):
+		return "snap"
+	}
+	return ""
+}
+
+// compareVersions compares dotted release versions numerically, ignoring
+// any pre-release or build suffix.
+func compareVersions(a, b string) int {
+	pa, pb := versionParts(a), versionParts(b)
+	for i := range max(len(pa), len(pb)) {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if c := cmp.Compare(x, y); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func versionParts(v string) []int {
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	var parts []int
+	for _, s := range strings.Split(v, ".") {
+		n, _ := strconv.Atoi(s)
+		parts = append(parts, n)
+	}
+	return parts
+}
@@ -0,0 +1,106 @@
+package checkproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Proxy-native endpoints live under /v1/, apart from the GitHub-shaped
+// passthrough paths, so they can evolve without colliding with GitHub routes.
+const (
+	apiVersionHeader = "X-Checkproxy-API-Version"
+	apiVersion       = "1"
+)
+
+// supportedAPIVersions lists the versions this server can answer.
+var supportedAPIVersions = []string{"1"}
+
+// v1Handler serves s's proxy-native API under /v1/.
+func v1Handler(cfg *Config, s *server) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/health", healthHandler)
+	mux.HandleFunc("/v1/info", infoHandler(cfg, s.routes))
+	mux.HandleFunc("/v1/metrics", metricsHandler(s, cfg.GetAdminToken()))
+	mux.HandleFunc("/v1/repos/", s.commitChecksHandler(cfg))
+	mux.HandleFunc("/v1/ws/", s.webSocketHandler(cfg))
+	mux.HandleFunc("/v1/events/", s.eventsHandler(cfg))
+	if secret := cfg.GetWebhookSecret(); secret != "" {
+		mux.HandleFunc("/v1/webhook", s.webhookHandler(secret))
+	}
+	adminRoutes(mux, cfg, s)
+	return apiVersionMiddleware(mux)
+}
+
+// apiVersionMiddleware negotiates the API version. Clients may send
+// X-Checkproxy-API-Version with one or more acceptable versions; the request
+// is rejected if none of them is supported. Every response names the version
+// that served it.
+func apiVersionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if want := r.Header.Get(apiVersionHeader); want != "" && !versionSupported(want) {
+			w.Header().Set(apiVersionHeader, strings.Join(supportedAPIVersions, ", "))
+			http.Error(w, fmt.Sprintf("bad request: unsupported API version %q (supported: %s)",
+				want, strings.Join(supportedAPIVersions, ", ")), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set(apiVersionHeader, apiVersion)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// versionSupported reports whether any version in a comma-separated list is supported.
+func versionSupported(list string) bool {
+	for _, v := range strings.Split(list, ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+		for _, s := range supportedAPIVersions {
+			if v == s {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// healthHandler reports that the server is up. It doesn't contact GitHub.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// infoHandler describes this server so operators and clients can verify what
+// they're talking to. It exposes only org names, never tokens or repo lists.
+func infoHandler(cfg *Config, routes []route) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		ver, rev := buildVersion()
+		orgs := cfg.AllowedOrgs
+		if orgs == nil {
+			orgs = []string{}
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"version":              ver,
+			"commit":               rev,
+			"api_versions":         supportedAPIVersions,
+			"allowed_routes":       len(routes),
+			"graphql_queries":      len(allowedGraphQLQueries),
+			"allowed_orgs":         orgs,
+			"validation_cache_ttl": cfg.ValidationCacheTTL,
+			"validation_mode":      cfg.validationMode(),
+		})
+	}
+}
+
+// writeJSON writes v as a JSON response body.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,31 @@
+package checkproxy
+
+import (
+	"runtime/debug"
+)
+
+// Passed in by the gh-checkproxy main package through Main.
+var (
+	version = ""
+	commit  = ""
+)
+
+// buildVersion returns the release version and git commit of this binary,
+// falling back to the Go build info for `go install` and source builds.
+func buildVersion() (ver, rev string) {
+	ver, rev = version, commit
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if ver == "" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			ver = info.Main.Version
+		}
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" && rev == "" {
+				rev = s.Value
+			}
+		}
+	}
+	if ver == "" {
+		ver = "dev"
+	}
+	return ver, rev
+}
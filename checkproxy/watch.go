@@ -0,0 +1,215 @@
+package checkproxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// checkState is the aggregated check state of one commit, as delivered to
+// streaming subscribers. ETag changes whenever the checks or counts do.
+type checkState struct {
+	Owner  string      `json:"owner"`
+	Repo   string      `json:"repo"`
+	SHA    string      `json:"sha"`
+	Checks []check     `json:"checks"`
+	Counts checkCounts `json:"counts"`
+	ETag   string      `json:"etag"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// newCheckState builds a checkState with a content-derived ETag.
+func newCheckState(owner, repo, sha string, checks []check, counts checkCounts, errMsg string) checkState {
+	if checks == nil {
+		checks = []check{}
+	}
+	sortChecks(checks)
+	h := sha256.New()
+	_ = json.NewEncoder(h).Encode(struct {
+		Checks []check
+		Counts checkCounts
+		Error  string
+	}{checks, counts, errMsg})
+	return checkState{
+		Owner:  owner,
+		Repo:   repo,
+		SHA:    sha,
+		Checks: checks,
+		Counts: counts,
+		ETag:   `"` + hex.EncodeToString(h.Sum(nil))[:16] + `"`,
+		Error:  errMsg,
+	}
+}
+
+// watchHub polls upstream for commits that have streaming subscribers and
+// fans out state changes. Each commit is polled once no matter how many
+// clients are watching it, and polling stops when the last one leaves.
+type watchHub struct {
+	mu         sync.Mutex
+	watches    map[string]*commitWatch
+	interval   time.Duration
+	httpClient *http.Client
+}
+
+type commitWatch struct {
+	up               upstreamTarget
+	owner, repo, sha string
+	subs             map[chan checkState]struct{}
+	last             *checkState
+	wake             chan struct{}
+	cancel           context.CancelFunc
+}
+
+// newWatchHub polls every interval with client.
+func newWatchHub(interval time.Duration, client *http.Client) *watchHub {
+	return &watchHub{
+		watches:    make(map[string]*commitWatch),
+		interval:   interval,
+		httpClient: client,
+	}
+}
+
+func watchKey(apiBase, owner, repo, sha string) string {
+	return apiBase + "|" + strings.ToLower(owner+"/"+repo) + "@" + sha
+}
+
+// subscribe registers for state changes of owner/repo@sha. The latest known
+// state, if any, is delivered immediately. The channel holds only the newest
+// state: a slow subscriber skips intermediate updates rather than blocking
+// the hub. Call the returned function to unsubscribe.
+func (h *watchHub) subscribe(up upstreamTarget, owner, repo, sha string) (<-chan checkState, func()) {
+	key := watchKey(up.apiBase, owner, repo, sha)
+	ch := make(chan checkState, 1)
+
+	h.mu.Lock()
+	w, ok := h.watches[key]
+	if !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		w = &commitWatch{
+			up: up, owner: owner, repo: repo, sha: sha,
+			subs:   make(map[chan checkState]struct{}),
+			wake:   make(chan struct{}, 1),
+			cancel: cancel,
+		}
+		h.watches[key] = w
+		go h.run(ctx, w)
+	}
+	w.subs[ch] = struct{}{}
+	if w.last != nil {
+		ch <- *w.last
+	}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(w.subs, ch)
+		if len(w.subs) == 0 && h.watches[key] == w {
+			w.cancel()
+			delete(h.watches, key)
+		}
+	}
+}
+
+// run polls until ctx is cancelled (no subscribers left).
+func (h *watchHub) run(ctx context.Context, w *commitWatch) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		h.publish(w, h.poll(w))
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-w.wake:
+		}
+	}
+}
+
+// notify triggers an immediate refresh of every watch on owner/repo@sha,
+// whichever upstream it belongs to, and returns how many there were.
+func (h *watchHub) notify(owner, repo, sha string) int {
+	suffix := "|" + strings.ToLower(owner+"/"+repo) + "@" + sha
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := 0
+	for key, w := range h.watches {
+		if !strings.HasSuffix(key, suffix) {
+			continue
+		}
+		select {
+		case w.wake <- struct{}{}:
+		default: // a refresh is already pending
+		}
+		n++
+	}
+	return n
+}
+
+// poll aggregates checks straight from upstream with the classic token; the
+// aggregation code is shared with the pr checks client, which runs the same
+// requests through the proxy.
+func (h *watchHub) poll(w *commitWatch) checkState {
+	checks, counts, err := fetchUpstreamChecks(h.httpClient, w.up, w.owner, w.repo, w.sha)
+	var refused upstreamRefusal
+	if errors.As(err, &refused) {
+		// Keep serving the last good state until GitHub is back.
+		h.mu.Lock()
+		last := w.last
+		h.mu.Unlock()
+		if last != nil && last.Error == "" {
+			return *last
+		}
+	}
+	if err != nil {
+		return newCheckState(w.owner, w.repo, w.sha, nil, checkCounts{}, err.Error())
+	}
+	return newCheckState(w.owner, w.repo, w.sha, checks, counts, "")
+}
+
+// publish delivers state to every subscriber if it differs from the last one.
+func (h *watchHub) publish(w *commitWatch, state checkState) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if w.last != nil && w.last.ETag == state.ETag {
+		return
+	}
+	w.last = &state
+	for ch := range w.subs {
+		select {
+		case <-ch: // drop the stale state the subscriber hasn't read yet
+		default:
+		}
+		ch <- state
+	}
+}
+
+// parseCommitStreamPath parses {prefix}/repos/{owner}/{repo}/commits/{sha}{suffix}.
+func parseCommitStreamPath(path, prefix, suffix string) (owner, repo, sha string, ok bool) {
+	rest, found := strings.CutPrefix(path, prefix+"/repos/")
+	if !found {
+		return "", "", "", false
+	}
+	rest, found = strings.CutSuffix(rest, suffix)
+	if !found {
+		return "", "", "", false
+	}
+	parts := strings.Split(rest, "/")
+	if len(parts) != 4 || parts[2] != "commits" {
+		return "", "", "", false
+	}
+	// The parts end up unescaped in upstream URLs, so anything that could
+	// change what those URLs address is refused.
+	for _, p := range parts {
+		if p == "" || p == "." || p == ".." || strings.ContainsAny(p, "?#%") {
+			return "", "", "", false
+		}
+	}
+	return parts[0], parts[1], parts[3], true
+}
@@ -0,0 +1,129 @@
+package checkproxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxWebhookBody matches GitHub's own cap on webhook payloads.
+const maxWebhookBody = 25 << 20
+
+// webhookPayload holds the fields of check_run, check_suite, and status
+// events needed to find the commit, check run, and check suite they refer
+// to.
+type webhookPayload struct {
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+	CheckRun *struct {
+		ID         int64  `json:"id"`
+		HeadSHA    string `json:"head_sha"`
+		CheckSuite struct {
+			ID int64 `json:"id"`
+		} `json:"check_suite"`
+	} `json:"check_run"`
+	CheckSuite *struct {
+		ID      int64  `json:"id"`
+		HeadSHA string `json:"head_sha"`
+	} `json:"check_suite"`
+	SHA string `json:"sha"` // status events
+}
+
+// cachedPaths returns the paths, relative to the repository, of the cached
+// responses the event makes outdated.
+func (p webhookPayload) cachedPaths(sha string) []string {
+	paths := []string{"commits/" + sha}
+	if p.CheckRun != nil {
+		paths = append(paths, fmt.Sprintf("check-runs/%d", p.CheckRun.ID))
+		if id := p.CheckRun.CheckSuite.ID; id != 0 {
+			paths = append(paths, fmt.Sprintf("check-suites/%d", id))
+		}
+	}
+	if p.CheckSuite != nil {
+		paths = append(paths, fmt.Sprintf("check-suites/%d", p.CheckSuite.ID))
+	}
+	return paths
+}
+
+// webhookHandler receives GitHub webhook deliveries. check_run, check_suite,
+// and status events drop the cached responses for the affected commit, run,
+// and suite, and for any commit named by a ref, and refresh any streaming watch on the commit right away, so
+// watchers see changes, including re-runs, without waiting for the next poll
+// or a cache TTL. Deliveries must carry a valid X-Hub-Signature-256 for the
+// configured secret.
+func (s *server) webhookHandler(secret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody+1))
+		if err != nil || len(body) > maxWebhookBody {
+			http.Error(w, "bad request: unreadable or oversized payload", http.StatusBadRequest)
+			return
+		}
+		if !validWebhookSignature(secret, body, r.Header.Get("X-Hub-Signature-256")) {
+			http.Error(w, "unauthorized: invalid webhook signature", http.StatusUnauthorized)
+			return
+		}
+
+		event := r.Header.Get("X-GitHub-Event")
+		switch event {
+		case "ping":
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case "check_run", "check_suite", "status":
+		default:
+			w.WriteHeader(http.StatusAccepted) // not an event we act on
+			return
+		}
+
+		var p webhookPayload
+		if err := json.Unmarshal(body, &p); err != nil {
+			http.Error(w, "bad request: invalid JSON payload", http.StatusBadRequest)
+			return
+		}
+		sha := p.SHA
+		if p.CheckRun != nil {
+			sha = p.CheckRun.HeadSHA
+		} else if p.CheckSuite != nil {
+			sha = p.CheckSuite.HeadSHA
+		}
+		owner, repo, ok := strings.Cut(p.Repository.FullName, "/")
+		if !ok || sha == "" {
+			http.Error(w, "bad request: payload has no repository or commit", http.StatusBadRequest)
+			return
+		}
+
+		// The watch polls through the response cache, so drop what it
+		// would be answered from first. Which refs point at the commit
+		// isn't known, so responses looked up by ref go too.
+		if c := s.responses; c != nil {
+			c.invalidateRepo(owner, repo, p.cachedPaths(sha)...)
+			c.invalidateRefs(owner, repo)
+		}
+		s.hub.notify(owner, repo, sha)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// validWebhookSignature checks a "sha256=<hex>" HMAC of body in constant time.
+func validWebhookSignature(secret string, body []byte, header string) bool {
+	sig, ok := strings.CutPrefix(header, "sha256=")
+	if !ok {
+		return false
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
@@ -0,0 +1,91 @@
+package checkproxy
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidWebhookSignature(t *testing.T) {
+	body := []byte(`{"action":"completed"}`)
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(body)
+	sig := hex.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		name   string
+		secret string
+		body   []byte
+		header string
+		want   bool
+	}{
+		{"valid", "s3cret", body, "sha256=" + sig, true},
+		{"uppercase hex", "s3cret", body, "sha256=" + strings.ToUpper(sig), true},
+		{"wrong secret", "other", body, "sha256=" + sig, false},
+		{"changed body", "s3cret", []byte(`{"action":"created"}`), "sha256=" + sig, false},
+		{"missing prefix", "s3cret", body, sig, false},
+		{"sha1 prefix", "s3cret", body, "sha1=" + sig, false},
+		{"truncated", "s3cret", body, "sha256=" + sig[:32], false},
+		{"not hex", "s3cret", body, "sha256=zz" + sig[2:], false},
+		{"empty", "s3cret", body, "", false},
+	}
+	for _, tt := range tests {
+		if got := validWebhookSignature(tt.secret, tt.body, tt.header); got != tt.want {
+			t.Errorf("%s: validWebhookSignature = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestWebhookInvalidation sends a check_run event and checks which cached
+// responses it drops: the commit's, the run's and suite's, and any commit
+// looked up by ref, but not other commits'.
+func TestWebhookInvalidation(t *testing.T) {
+	const sha = "0123456789abcdef0123456789abcdef01234567"
+	const other = "89abcdef0123456789abcdef0123456789abcdef"
+	c, err := newResponseCache(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{responses: c, hub: newWatchHub(time.Minute, http.DefaultClient)}
+	tests := []struct {
+		path    string
+		dropped bool
+	}{
+		{"/repos/o/r/commits/" + sha + "/check-runs", true},
+		{"/repos/o/r/check-runs/7", true},
+		{"/repos/o/r/check-suites/9", true},
+		{"/repos/o/r/commits/main/check-runs", true},
+		{"/repos/o/r/commits/refs/heads/feature/x/status", true},
+		{"/repos/o/r/commits/0123456/check-suites", true},
+		{"/repos/o/r/commits/" + other + "/check-runs", false},
+		{"/repos/o/other/commits/main/check-runs", false},
+		{"/repos/o/r/pulls/1", false},
+	}
+	for _, tt := range tests {
+		c.add(&cachedResponse{key: "https://api.github.com" + tt.path + "\n\n", ttl: time.Minute, expires: time.Now().Add(time.Minute)})
+	}
+
+	body := []byte(`{"repository":{"full_name":"o/r"},"check_run":{"id":7,"head_sha":"` + sha + `","check_suite":{"id":9}}}`)
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(body)
+	r := httptest.NewRequest(http.MethodPost, "/v1/webhook", bytes.NewReader(body))
+	r.Header.Set("X-GitHub-Event", "check_run")
+	r.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	rec := httptest.NewRecorder()
+	s.webhookHandler("s3cret")(rec, r)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d (%s)", rec.Code, http.StatusNoContent, rec.Body)
+	}
+	for _, tt := range tests {
+		_, _, cached := c.get("https://api.github.com" + tt.path + "\n\n")
+		if cached == tt.dropped {
+			t.Errorf("%s: cached = %v, want %v", tt.path, cached, !tt.dropped)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package checkproxy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// websocketGUID is the fixed key suffix from RFC 6455 §1.3.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes (RFC 6455 §5.2).
+const (
+	wsOpText  = 0x1
+	wsOpClose = 0x8
+	wsOpPing  = 0x9
+	wsOpPong  = 0xA
+)
+
+// maxWSClientFrame bounds frames read from clients; they only send control frames.
+const maxWSClientFrame = 4 << 10
+
+const wsPingInterval = 30 * time.Second
+
+// wsReadTimeout closes a connection that sent nothing, not even the pong to
+// one of our pings, for this long.
+const wsReadTimeout = 2 * wsPingInterval
+
+// webSocketHandler serves /v1/ws/repos/{owner}/{repo}/commits/{sha}/checks:
+// after the usual org/repo/token checks it upgrades the connection and pushes
+// a JSON checkState message every time the commit's aggregated checks change.
+// Browsers don't apply CORS to WebSocket handshakes, so a handshake carrying
+// an Origin is refused unless the origin is in the CORS allowlist.
+func (s *server) webSocketHandler(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		owner, repo, sha, ok := parseCommitStreamPath(r.URL.Path, "/v1/ws", "/checks")
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") ||
+			r.Header.Get("Sec-WebSocket-Version") != "13" || r.Header.Get("Sec-WebSocket-Key") == "" {
+			http.Error(w, "bad request: expected a WebSocket upgrade (version 13)", http.StatusBadRequest)
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" && !originAllowed(cfg.CORS, origin) {
+			http.Error(w, "forbidden: origin not allowed", http.StatusForbidden)
+			return
+		}
+
+		up, ok := s.selectUpstream(w, r, cfg, owner)
+		if !ok {
+			return
+		}
+		if !s.authorizeRepo(w, r, cfg, up, owner, repo, resourceProbe{groups: []string{"checks", "statuses"}, ref: sha}) {
+			return
+		}
+
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			http.Error(w, "websocket not supported", http.StatusInternalServerError)
+			return
+		}
+		defer conn.Close()
+		// The hijacked conn keeps the server's read deadline; the read loop
+		// sets its own, tied to the pings.
+		_ = conn.SetReadDeadline(time.Time{})
+
+		accept := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + websocketGUID))
+		fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+			base64.StdEncoding.EncodeToString(accept[:]))
+		if err := rw.Flush(); err != nil {
+			return
+		}
+
+		states, unsubscribe := s.hub.subscribe(up, owner, repo, sha)
+		defer unsubscribe()
+
+		// The read loop only exists to answer pings and notice the close.
+		closed := make(chan struct{})
+		pongs := make(chan []byte, 1)
+		go func() {
+			defer close(closed)
+			for {
+				_ = conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+				op, payload, err := wsReadFrame(rw.Reader)
+				if err != nil || op == wsOpClose {
+					return
+				}
+				if op == wsOpPing {
+					select {
+					case pongs <- payload:
+					default:
+					}
+				}
+			}
+		}()
+
+		ping := time.NewTicker(wsPingInterval)
+		defer ping.Stop()
+		for {
+			var err error
+			select {
+			case <-closed:
+				_ = wsWriteFrame(conn, wsOpClose, nil)
+				return
+			case payload := <-pongs:
+				err = wsWriteFrame(conn, wsOpPong, payload)
+			case <-ping.C:
+				err = wsWriteFrame(conn, wsOpPing, nil)
+			case state := <-states:
+				msg, _ := json.Marshal(state)
+				err = wsWriteFrame(conn, wsOpText, msg)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// wsWriteFrame writes a single unmasked, unfragmented frame (server → client).
+func wsWriteFrame(conn net.Conn, opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126, byte(n>>8), byte(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	_ = conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// wsReadFrame reads one client frame. Client frames must be masked.
+func wsReadFrame(r *bufio.Reader) (opcode byte, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode = hdr[0] & 0x0F
+	if hdr[1]&0x80 == 0 {
+		return 0, nil, errors.New("unmasked client frame")
+	}
+	n := uint64(hdr[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > maxWSClientFrame {
+		return 0, nil, errors.New("client frame too large")
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(r, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
@@ -1,7 +1,7 @@
-module gh-checkproxy
+module github.com/bycli/gh-checkproxy
 
-go 1.22.0
+go 1.24.0
 
 require golang.org/x/term v0.29.0
 
-require golang.org/x/sys v0.30.0 // indirect
+require golang.org/x/sys v0.30.0